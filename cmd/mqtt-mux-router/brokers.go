@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"mqtt-mux-router/config"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/broker/kafka"
+	"mqtt-mux-router/internal/broker/mqtt"
+	"mqtt-mux-router/internal/broker/nats"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/rule"
+)
+
+// addBrokers creates one broker per configured connection and registers it
+// with the router, along with the targets of http, file and log actions.
+// flows holds the flow controller of each connection by name; flows,
+// deadLetters and lookups are optional.
+func addBrokers(router *broker.Router, cfg *config.Config, log *logger.Logger, metricsService *metrics.Metrics, flows map[string]*broker.FlowController, deadLetters broker.DeadLetterSink, lookups *rule.Lookups) error {
+	// Validation guarantees the durations parse
+	batchLinger, _ := time.ParseDuration(cfg.Processing.BatchLinger)
+	initialBackoff, _ := time.ParseDuration(cfg.Processing.Retry.InitialBackoff)
+	maxBackoff, _ := time.ParseDuration(cfg.Processing.Retry.MaxBackoff)
+	retryPolicy := broker.RetryPolicy{
+		MaxAttempts:    cfg.Processing.Retry.MaxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+		Jitter:         cfg.Processing.Retry.Jitter,
+	}
+
+	for _, connCfg := range cfg.Connections {
+		var connBroker broker.Broker
+
+		state, err := openStateStore(cfg.State, connCfg.Name, log)
+		if err != nil {
+			return fmt.Errorf("connection %s: %w", connCfg.Name, err)
+		}
+
+		switch connCfg.Type {
+		case "mqtt":
+			log.Info("creating MQTT broker", "connection", connCfg.Name)
+			connBroker, err = mqtt.NewBroker(cfg, log, mqtt.BrokerConfig{
+				ProcessorWorkers:  cfg.Processing.Workers,
+				QueueSize:         cfg.Processing.QueueSize,
+				BatchSize:         cfg.Processing.BatchSize,
+				BatchLinger:       batchLinger,
+				OverflowPolicy:    cfg.Processing.OverflowPolicy,
+				OrderingKey:       cfg.Processing.Ordering.Key,
+				OrderingField:     cfg.Processing.Ordering.Field,
+				Connection:        connCfg,
+				Router:            router,
+				OutboundQueueSize: cfg.Processing.Outbound.QueueSize,
+				Flow:              flows[connCfg.Name],
+				Retry:             retryPolicy,
+				DeadLetter:        deadLetters,
+				State:             state,
+				Lookups:           lookups,
+			}, metricsService)
+		case "nats":
+			log.Info("creating NATS broker", "connection", connCfg.Name)
+			connBroker, err = nats.NewBroker(cfg, log, nats.BrokerConfig{
+				ProcessorWorkers:  cfg.Processing.Workers,
+				QueueSize:         cfg.Processing.QueueSize,
+				BatchSize:         cfg.Processing.BatchSize,
+				BatchLinger:       batchLinger,
+				OverflowPolicy:    cfg.Processing.OverflowPolicy,
+				OrderingKey:       cfg.Processing.Ordering.Key,
+				OrderingField:     cfg.Processing.Ordering.Field,
+				Connection:        connCfg,
+				Router:            router,
+				OutboundQueueSize: cfg.Processing.Outbound.QueueSize,
+				Flow:              flows[connCfg.Name],
+				Retry:             retryPolicy,
+				DeadLetter:        deadLetters,
+				State:             state,
+				Lookups:           lookups,
+			}, metricsService)
+		case "kafka":
+			log.Info("creating Kafka broker", "connection", connCfg.Name)
+			connBroker, err = kafka.NewBroker(cfg, log, kafka.BrokerConfig{
+				ProcessorWorkers:  cfg.Processing.Workers,
+				QueueSize:         cfg.Processing.QueueSize,
+				BatchSize:         cfg.Processing.BatchSize,
+				BatchLinger:       batchLinger,
+				OverflowPolicy:    cfg.Processing.OverflowPolicy,
+				OrderingKey:       cfg.Processing.Ordering.Key,
+				OrderingField:     cfg.Processing.Ordering.Field,
+				Connection:        connCfg,
+				Router:            router,
+				OutboundQueueSize: cfg.Processing.Outbound.QueueSize,
+				Flow:              flows[connCfg.Name],
+				Retry:             retryPolicy,
+				DeadLetter:        deadLetters,
+				State:             state,
+				Lookups:           lookups,
+			}, metricsService)
+		default:
+			return fmt.Errorf("connection %s: unsupported broker type: %s", connCfg.Name, connCfg.Type)
+		}
+
+		if err != nil {
+			return fmt.Errorf("connection %s: failed to create broker: %w", connCfg.Name, err)
+		}
+
+		if err := router.Add(connCfg.Name, connBroker); err != nil {
+			return err
+		}
+	}
+
+	httpTarget := broker.NewHTTPTarget(broker.HTTPTargetConfig{
+		QueueSize:  cfg.Processing.Outbound.QueueSize,
+		Retry:      retryPolicy,
+		DeadLetter: deadLetters,
+	}, log, metricsService)
+	if err := router.AddTarget(rule.ActionHTTP, httpTarget); err != nil {
+		return err
+	}
+
+	fileTarget := broker.NewFileTarget(broker.FileTargetConfig{
+		Dir:      cfg.FileActions.Dir,
+		MaxSize:  int64(cfg.FileActions.MaxSizeMB) * 1024 * 1024,
+		MaxFiles: cfg.FileActions.MaxFiles,
+	}, log, metricsService)
+	if err := router.AddTarget(rule.ActionFile, fileTarget); err != nil {
+		return err
+	}
+
+	return router.AddTarget(rule.ActionLog, broker.NewLogTarget(log, metricsService))
+}
+
+// openStateStore opens the state store of a connection. The memory backend
+// without a snapshot keeps nothing on disk.
+func openStateStore(stateCfg config.StateConfig, connection string, log *logger.Logger) (rule.StateStore, error) {
+	switch {
+	case stateCfg.Backend == "bolt":
+		return rule.OpenBoltStateStore(filepath.Join(stateCfg.Dir, connection+".db"), log)
+	case stateCfg.Snapshot:
+		return rule.OpenMemoryStateStore(filepath.Join(stateCfg.Dir, connection+".snapshot"))
+	default:
+		return rule.NewMemoryStateStore(), nil
+	}
+}
+
+// newDeadLetterSink creates the configured dead-letter sinks. The returned
+// function closes them on shutdown, flushing queued topic dead letters; the
+// sink is nil when dead-lettering is disabled.
+func newDeadLetterSink(cfg *config.Config, router *broker.Router, log *logger.Logger, metricsService *metrics.Metrics) (broker.DeadLetterSink, func(), error) {
+	var sinks []broker.DeadLetterSink
+	var topicSink *broker.TopicDeadLetterSink
+	var fileSink *broker.FileDeadLetterSink
+
+	// Topic dead letters go through the router so that they can target any connection
+	if cfg.DeadLetter.Enabled {
+		topicSink = broker.NewTopicDeadLetterSink(cfg.DeadLetter.Topic, cfg.DeadLetter.Broker, router, log, metricsService)
+		sinks = append(sinks, topicSink)
+	}
+
+	if fileCfg := cfg.DeadLetter.File; fileCfg.Enabled {
+		var err error
+		fileSink, err = broker.NewFileDeadLetterSink(fileCfg.Path, int64(fileCfg.MaxSizeMB)*1024*1024, fileCfg.MaxFiles, log)
+		if err != nil {
+			if topicSink != nil {
+				topicSink.Close()
+			}
+			return nil, nil, err
+		}
+		sinks = append(sinks, fileSink)
+	}
+
+	closeSinks := func() {
+		if topicSink != nil {
+			topicSink.Close()
+		}
+		if fileSink != nil {
+			if err := fileSink.Close(); err != nil {
+				log.Error("failed to close dead-letter file", "error", err)
+			}
+		}
+	}
+
+	if len(sinks) == 0 {
+		return nil, closeSinks, nil
+	}
+
+	return broker.NewDeadLetterFanout(sinks, metricsService), closeSinks, nil
+}
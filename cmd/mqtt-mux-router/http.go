@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"mqtt-mux-router/internal/logger"
+)
+
+// httpServers runs one HTTP server per address, so that endpoints configured
+// on the same address share a server
+type httpServers struct {
+	logger  *logger.Logger
+	muxes   map[string]*http.ServeMux
+	servers []*http.Server
+}
+
+func newHTTPServers(log *logger.Logger) *httpServers {
+	return &httpServers{
+		logger: log,
+		muxes:  make(map[string]*http.ServeMux),
+	}
+}
+
+// handle registers a handler on the server for address
+func (s *httpServers) handle(address, path string, handler http.Handler) {
+	mux, exists := s.muxes[address]
+	if !exists {
+		mux = http.NewServeMux()
+		s.muxes[address] = mux
+	}
+	mux.Handle(path, handler)
+
+	s.logger.Info("registered http endpoint",
+		"address", address,
+		"path", path)
+}
+
+// start launches a server for every address with registered handlers
+func (s *httpServers) start() {
+	for address, mux := range s.muxes {
+		server := &http.Server{
+			Addr:    address,
+			Handler: mux,
+		}
+		s.servers = append(s.servers, server)
+
+		go func() {
+			s.logger.Info("starting http server", "address", server.Addr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("http server error",
+					"address", server.Addr,
+					"error", err)
+			}
+		}()
+	}
+}
+
+// shutdown stops every server
+func (s *httpServers) shutdown(ctx context.Context) {
+	for _, server := range s.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			s.logger.Error("failed to shutdown http server",
+				"address", server.Addr,
+				"error", err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -15,17 +16,24 @@ import (
 
 	"mqtt-mux-router/config"
 	"mqtt-mux-router/internal/broker"
-	"mqtt-mux-router/internal/broker/mqtt"
-	"mqtt-mux-router/internal/broker/nats"
+	"mqtt-mux-router/internal/health"
 	"mqtt-mux-router/internal/logger"
 	"mqtt-mux-router/internal/metrics"
 	"mqtt-mux-router/internal/rule"
+	"mqtt-mux-router/internal/stats"
+	"mqtt-mux-router/internal/tracing"
 )
 
 func main() {
+	// Subcommands are selected by the first argument
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	// Command line flags for config and rules
 	configPath := flag.String("config", "config/config.yaml", "path to config file (YAML or JSON)")
 	rulesPath := flag.String("rules", "rules", "path to rules directory")
+	lookupsPath := flag.String("lookups", "", "path to lookup tables directory (empty = lookups next to the rules directory)")
 
 	// Add broker type flag
 	brokerTypeFlag := flag.String("broker-type", "", "broker type (mqtt or nats)")
@@ -64,10 +72,12 @@ func main() {
 	}
 	defer logger.Sync()
 
+	// Metrics, health and stats endpoints on the same address share a server
+	servers := newHTTPServers(logger)
+
 	// Setup metrics if enabled
 	var metricsService *metrics.Metrics
 	var metricsCollector *metrics.MetricsCollector
-	var metricsServer *http.Server
 
 	if cfg.Metrics.Enabled {
 		// Initialize metrics
@@ -76,6 +86,7 @@ func main() {
 		if err != nil {
 			logger.Fatal("failed to create metrics service", "error", err)
 		}
+		metricsService.SetMaxRuleLabels(cfg.Metrics.MaxRuleLabels)
 
 		// Parse metrics update interval
 		updateInterval, err := time.ParseDuration(cfg.Metrics.UpdateInterval)
@@ -88,68 +99,87 @@ func main() {
 		metricsCollector.Start()
 		defer metricsCollector.Stop()
 
-		// Setup metrics HTTP server
-		mux := http.NewServeMux()
-		mux.Handle(cfg.Metrics.Path, promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+		// Setup metrics endpoint
+		servers.handle(cfg.Metrics.Address, cfg.Metrics.Path, promhttp.HandlerFor(reg, promhttp.HandlerOpts{
 			Registry:          reg,
 			EnableOpenMetrics: true,
 		}))
+	}
 
-		metricsServer = &http.Server{
-			Addr:    cfg.Metrics.Address,
-			Handler: mux,
+	// Setup tracing if enabled; without it spans are no-ops
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err = tracing.Setup(context.Background(), tracing.Options{
+			Endpoint:    cfg.Tracing.Endpoint,
+			Insecure:    cfg.Tracing.Insecure,
+			ServiceName: cfg.Tracing.ServiceName,
+			SampleRatio: *cfg.Tracing.SampleRatio,
+		})
+		if err != nil {
+			logger.Fatal("failed to setup tracing", "error", err)
 		}
-
-		// Start metrics server
-		go func() {
-			logger.Info("starting metrics server",
-				"address", cfg.Metrics.Address,
-				"path", cfg.Metrics.Path)
-			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logger.Error("metrics server error", "error", err)
-			}
-		}()
 	}
 
 	// Setup signal handlers
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// Create broker with processing configuration
-	brokerCfg := mqtt.BrokerConfig{
-		ProcessorWorkers: cfg.Processing.Workers,
-		QueueSize:        cfg.Processing.QueueSize,
-		BatchSize:        cfg.Processing.BatchSize,
+	// Create one broker per configured connection; the router starts each
+	// with the rules bound to it and routes actions between them
+	router := broker.NewRouter(logger)
+
+	// Each connection pauses its own consumption while its outbound queue is
+	// backed up; actions routed to a backed-up connection block its producers
+	outbound := cfg.Processing.Outbound
+	flows := make(map[string]*broker.FlowController, len(cfg.Connections))
+	for _, connCfg := range cfg.Connections {
+		flows[connCfg.Name] = broker.NewFlowController(connCfg.Name,
+			outbound.HighWatermark, outbound.LowWatermark, logger, metricsService)
 	}
 
-	// NATS broker uses same config structure
-	natsBrokerCfg := nats.BrokerConfig{
-		ProcessorWorkers: cfg.Processing.Workers,
-		QueueSize:        cfg.Processing.QueueSize,
-		BatchSize:        cfg.Processing.BatchSize,
+	deadLetters, closeDeadLetters, err := newDeadLetterSink(cfg, router, logger, metricsService)
+	if err != nil {
+		logger.Fatal("failed to create dead-letter sink", "error", err)
 	}
 
-	// Create the broker based on configuration
-	var messageBroker broker.Broker
-	
-	switch cfg.BrokerType {
-	case "mqtt":
-		logger.Info("creating MQTT broker")
-		messageBroker, err = mqtt.NewBroker(cfg, logger, brokerCfg, metricsService)
-	case "nats":
-		logger.Info("creating NATS broker")
-		messageBroker, err = nats.NewBroker(cfg, logger, natsBrokerCfg, metricsService)
-	default:
-		logger.Fatal("unsupported broker type", "type", cfg.BrokerType)
+	// Lookup tables are reloaded when their files change
+	lookups := rule.NewLookups(lookupsDir(*lookupsPath, *rulesPath), logger)
+	if err := lookups.Load(); err != nil {
+		logger.Fatal("failed to load lookup tables", "error", err)
 	}
-	
-	if err != nil {
-		logger.Fatal("failed to create broker", "error", err)
+
+	if err := addBrokers(router, cfg, logger, metricsService, flows, deadLetters, lookups); err != nil {
+		logger.Fatal("failed to create brokers", "error", err)
+	}
+
+	var messageBroker broker.Broker = router
+
+	// Health endpoints work with metrics disabled
+	if cfg.Health.Enabled {
+		checker := health.NewChecker(router, cfg.Health.QueueThreshold(cfg.Processing.QueueSize))
+		servers.handle(cfg.Health.Address, health.LivenessPath, http.HandlerFunc(checker.ServeLiveness))
+		servers.handle(cfg.Health.Address, health.ReadinessPath, http.HandlerFunc(checker.ServeReadiness))
+	}
+
+	// Live statistics with windowed rates
+	if cfg.Stats.Enabled {
+		// Validated at config load
+		statsInterval, _ := time.ParseDuration(cfg.Stats.Interval)
+		statsReporter := stats.NewReporter(router, statsInterval)
+		statsReporter.Start()
+		defer statsReporter.Stop()
+		servers.handle(cfg.Stats.Address, cfg.Stats.Path, statsReporter)
 	}
 
+	servers.start()
+
+	// Validated at config load
+	drainTimeout, _ := time.ParseDuration(cfg.Processing.DrainTimeout)
+
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	go lookups.Watch(ctx, rule.LookupReloadInterval)
 
 	// Load rules from directory
 	rulesLoader := rule.NewRulesLoader(logger)
@@ -164,12 +194,23 @@ func main() {
 	}
 
 	logger.Info("message router started",
-		"brokerType", cfg.BrokerType,
+		"connections", len(cfg.Connections),
 		"workers", cfg.Processing.Workers,
 		"queueSize", cfg.Processing.QueueSize,
 		"batchSize", cfg.Processing.BatchSize,
+		"batchLinger", cfg.Processing.BatchLinger,
+		"overflowPolicy", cfg.Processing.OverflowPolicy,
+		"orderingKey", cfg.Processing.Ordering.Key,
+		"outboundQueueSize", outbound.QueueSize,
+		"retryMaxAttempts", cfg.Processing.Retry.MaxAttempts,
+		"drainTimeout", drainTimeout,
+		"deadLetterEnabled", cfg.DeadLetter.Enabled,
+		"deadLetterFileEnabled", cfg.DeadLetter.File.Enabled,
 		"rulesCount", len(rules),
-		"metricsEnabled", cfg.Metrics.Enabled)
+		"metricsEnabled", cfg.Metrics.Enabled,
+		"healthEnabled", cfg.Health.Enabled,
+		"statsEnabled", cfg.Stats.Enabled,
+		"tracingEnabled", cfg.Tracing.Enabled)
 
 	// Handle signals
 	for {
@@ -181,21 +222,41 @@ func main() {
 		case syscall.SIGINT, syscall.SIGTERM:
 			logger.Info("shutting down...")
 
-			// Graceful shutdown
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer shutdownCancel()
-
-			// Shutdown metrics server if enabled
-			if cfg.Metrics.Enabled && metricsServer != nil {
-				if err := metricsServer.Shutdown(shutdownCtx); err != nil {
-					logger.Error("failed to shutdown metrics server", "error", err)
-				}
+			// Release paused consumers so that subscriptions can shut down
+			for _, flow := range flows {
+				flow.Close()
 			}
 
-			// Shutdown other components
+			// Stop consuming, then drain queued messages and actions before
+			// disconnecting
+			drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+			drainStats := router.Shutdown(drainCtx)
+			drainCancel()
 			cancel()
-			messageBroker.Close()
+
+			closeDeadLetters()
+
+			logger.Info("shutdown complete",
+				"drained", drainStats.Drained,
+				"abandoned", drainStats.Abandoned)
+
+			// Flush traces and shut down HTTP servers last so the drain can be observed
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer shutdownCancel()
+			if err := shutdownTracing(shutdownCtx); err != nil {
+				logger.Error("failed to flush traces", "error", err)
+			}
+			servers.shutdown(shutdownCtx)
 			return
 		}
 	}
 }
+
+// lookupsDir returns the lookup tables directory: the configured path, or
+// lookups next to the rules directory
+func lookupsDir(path, rulesPath string) string {
+	if path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(rulesPath)), "lookups")
+}
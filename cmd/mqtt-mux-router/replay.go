@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"mqtt-mux-router/config"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+// runReplay implements the replay subcommand, which re-injects dead letters
+// from a local dead-letter file through the rules and publishers. It returns
+// the process exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := fs.String("config", "config/config.yaml", "path to config file (YAML or JSON)")
+	rulesPath := fs.String("rules", "rules", "path to rules directory")
+	lookupsPath := fs.String("lookups", "", "path to lookup tables directory (empty = lookups next to the rules directory)")
+	from := fs.String("from", "", "dead-letter file to replay (required)")
+	ruleID := fs.String("rule", "", "only replay dead letters for this rule ID")
+	stage := fs.String("stage", "", "only replay dead letters from this stage (decode, template or publish)")
+	since := fs.String("since", "", "only replay dead letters recorded at or after this RFC 3339 time")
+	until := fs.String("until", "", "only replay dead letters recorded before this RFC 3339 time")
+	dryRun := fs.Bool("dry-run", false, "print matching dead letters without publishing them")
+	fs.Parse(args)
+
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "replay: --from is required")
+		fs.Usage()
+		return 2
+	}
+
+	filter, err := parseReplayFilter(*ruleID, *stage, *since, *until)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: failed to load config: %v\n", err)
+		return 1
+	}
+
+	log, err := logger.NewLogger(&cfg.Logging)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: failed to initialize logger: %v\n", err)
+		return 1
+	}
+	defer log.Sync()
+
+	if *dryRun {
+		return printDeadLetters(*from, filter)
+	}
+
+	rules, err := rule.NewRulesLoader(log).LoadFromDirectory(*rulesPath)
+	if err != nil {
+		log.Error("failed to load rules", "error", err)
+		return 1
+	}
+	lookups := rule.NewLookups(lookupsDir(*lookupsPath, *rulesPath), log)
+	if err := lookups.Load(); err != nil {
+		log.Error("failed to load lookup tables", "error", err)
+		return 1
+	}
+
+	// Distinct client IDs keep the replay from taking over the session of a
+	// running router
+	for i := range cfg.Connections {
+		if cfg.Connections[i].Type == "mqtt" {
+			cfg.Connections[i].MQTT.ClientID += "-replay"
+		}
+	}
+
+	// Rule state stays in memory: the state files belong to the running
+	// router, which holds the bolt database open
+	cfg.State = config.StateConfig{Backend: "memory"}
+
+	// No dead-letter sink: failures during replay are reported, not recorded
+	// again in the file being replayed
+	router := broker.NewRouter(log)
+	if err := addBrokers(router, cfg, log, nil, nil, nil, nil); err != nil {
+		log.Error("failed to create brokers", "error", err)
+		return 1
+	}
+
+	r := newReplayer(router, cfg.Connections[0].Name, rules, lookups, log)
+	err = broker.ReadDeadLetters(*from, func(letter *broker.DeadLetter) error {
+		if !filter.Match(letter) {
+			r.skipped++
+			return nil
+		}
+		r.replay(letter)
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to read dead letters", "error", err)
+	}
+
+	// Wait for queued actions and retries, bounded by the drain timeout
+	r.close()
+	drainTimeout, _ := time.ParseDuration(cfg.Processing.DrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	router.Shutdown(ctx)
+	cancel()
+
+	var publishFailures uint64
+	for _, conn := range router.GetStats().Connections {
+		publishFailures += conn.PublishFailures
+	}
+	if target, ok := router.Target(rule.ActionHTTP); ok {
+		if httpTarget, ok := target.(*broker.HTTPTarget); ok {
+			publishFailures += httpTarget.Stats().Failures
+		}
+	}
+
+	log.Info("replay complete",
+		"from", *from,
+		"replayed", r.replayed,
+		"actions", r.actions,
+		"skipped", r.skipped,
+		"failed", r.failed,
+		"publishFailures", publishFailures)
+
+	if err != nil || r.failed > 0 || publishFailures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseReplayFilter builds the dead-letter filter from the command line flags
+func parseReplayFilter(ruleID, stage, since, until string) (broker.DeadLetterFilter, error) {
+	filter := broker.DeadLetterFilter{
+		RuleID: ruleID,
+		Stage:  stage,
+	}
+
+	switch stage {
+	case "", rule.StageDecode, rule.StageTemplate, rule.StagePublish:
+	default:
+		return filter, fmt.Errorf("invalid stage: %s", stage)
+	}
+
+	var err error
+	if since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("invalid --until: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// printDeadLetters writes the matching dead letters to stdout as JSON lines
+func printDeadLetters(path string, filter broker.DeadLetterFilter) int {
+	encoder := json.NewEncoder(os.Stdout)
+	err := broker.ReadDeadLetters(path, func(letter *broker.DeadLetter) error {
+		if !filter.Match(letter) {
+			return nil
+		}
+		return encoder.Encode(letter)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// replayer re-injects dead letters. Messages that failed before publishing are
+// processed again; actions that failed to publish are republished as recorded.
+type replayer struct {
+	router      *broker.Router
+	defaultConn string
+	rules       []rule.Rule
+	lookups     *rule.Lookups
+	logger      *logger.Logger
+
+	// processors holds the processors built for each replay rule set
+	processors map[string]*rule.Processor
+
+	replayed int
+	actions  int
+	skipped  int
+	failed   int
+}
+
+func newReplayer(router *broker.Router, defaultConn string, rules []rule.Rule, lookups *rule.Lookups, log *logger.Logger) *replayer {
+	return &replayer{
+		router:      router,
+		defaultConn: defaultConn,
+		rules:       rules,
+		lookups:     lookups,
+		logger:      log,
+		processors:  make(map[string]*rule.Processor),
+	}
+}
+
+// replay re-injects a single dead letter
+func (r *replayer) replay(letter *broker.DeadLetter) {
+	payload, err := letter.DecodePayload()
+	if err != nil {
+		r.fail(letter, fmt.Errorf("invalid payload encoding: %w", err))
+		return
+	}
+
+	conn := letter.Connection
+	if conn == "" {
+		conn = r.defaultConn
+	}
+
+	var actions []*rule.Action
+	switch letter.Stage {
+	case rule.StagePublish:
+		action := &rule.Action{
+			Topic:   letter.Topic,
+			Payload: string(payload),
+			Broker:  conn,
+			RuleID:  letter.RuleID,
+		}
+		// HTTP actions are rebuilt from their recorded request options
+		if letter.HTTP != nil {
+			action = &rule.Action{
+				Payload: string(payload),
+				RuleID:  letter.RuleID,
+				HTTP:    letter.HTTP,
+			}
+		} else if letter.Connection == broker.HTTPTargetName {
+			r.fail(letter, fmt.Errorf("http dead letter has no request options and cannot be replayed"))
+			return
+		}
+		actions = []*rule.Action{action}
+	case rule.StageDecode, rule.StageTemplate:
+		processor, err := r.processorFor(conn, letter)
+		if err != nil {
+			r.fail(letter, err)
+			return
+		}
+		if actions, err = processor.Process(letter.Topic, payload); err != nil {
+			r.fail(letter, err)
+			return
+		}
+	default:
+		r.fail(letter, fmt.Errorf("unknown stage: %s", letter.Stage))
+		return
+	}
+
+	for _, action := range actions {
+		// Actions without a target publish on the message's source connection
+		if action.Broker == "" {
+			action.Broker = conn
+		}
+		if err := r.router.RouteAction(action); err != nil {
+			r.fail(letter, err)
+			return
+		}
+		r.actions++
+	}
+
+	r.replayed++
+}
+
+// processorFor returns a processor holding the rules a dead letter is replayed
+// against: only the failed rule for template failures, since the other rules
+// matching the message already published, or every rule bound to the source
+// connection for decode failures
+func (r *replayer) processorFor(conn string, letter *broker.DeadLetter) (*rule.Processor, error) {
+	byRule := letter.Stage == rule.StageTemplate && letter.RuleID != ""
+	key := "connection:" + conn
+	if byRule {
+		key = "rule:" + letter.RuleID
+	}
+
+	if processor, exists := r.processors[key]; exists {
+		return processor, nil
+	}
+
+	var selected []rule.Rule
+	for _, rl := range r.rules {
+		if byRule {
+			if rl.ID == letter.RuleID {
+				selected = append(selected, rl)
+			}
+			continue
+		}
+
+		source := rl.Broker
+		if source == "" {
+			source = r.defaultConn
+		}
+		if source == conn {
+			selected = append(selected, rl)
+		}
+	}
+
+	if byRule && len(selected) == 0 {
+		return nil, fmt.Errorf("rule %s not found", letter.RuleID)
+	}
+
+	processor := rule.NewProcessor(rule.ProcessorConfig{Workers: 1, QueueSize: 1, Lookups: r.lookups}, r.logger, nil)
+	if err := processor.LoadRules(selected); err != nil {
+		processor.Close()
+		return nil, err
+	}
+
+	r.processors[key] = processor
+	return processor, nil
+}
+
+// fail records a dead letter that could not be replayed
+func (r *replayer) fail(letter *broker.DeadLetter, err error) {
+	r.failed++
+	r.logger.Error("failed to replay dead letter",
+		"topic", letter.Topic,
+		"stage", letter.Stage,
+		"ruleId", letter.RuleID,
+		"timestamp", letter.Timestamp,
+		"error", err)
+}
+
+// close releases the replay processors
+func (r *replayer) close() {
+	for _, processor := range r.processors {
+		processor.Close()
+	}
+}
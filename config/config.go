@@ -6,19 +6,43 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultConnectionName is the name given to the connection built from the
+// top-level mqtt/nats sections when no connections list is configured
+const DefaultConnectionName = "default"
+
 type Config struct {
-	BrokerType string        `json:"brokerType" yaml:"brokerType"` // "mqtt" or "nats"
-	MQTT       MQTTConfig    `json:"mqtt" yaml:"mqtt"`
-	NATS       NATSConfig    `json:"nats" yaml:"nats"`
-	Logging    LogConfig     `json:"logging" yaml:"logging"`
-	Metrics    MetricsConfig `json:"metrics" yaml:"metrics"`
-	Processing ProcConfig    `json:"processing" yaml:"processing"`
+	BrokerType  string             `json:"brokerType" yaml:"brokerType"` // "mqtt" or "nats"
+	MQTT        MQTTConfig         `json:"mqtt" yaml:"mqtt"`
+	NATS        NATSConfig         `json:"nats" yaml:"nats"`
+	Connections []ConnectionConfig `json:"connections" yaml:"connections"`
+	Logging     LogConfig          `json:"logging" yaml:"logging"`
+	Metrics     MetricsConfig      `json:"metrics" yaml:"metrics"`
+	Processing  ProcConfig         `json:"processing" yaml:"processing"`
+	DeadLetter  DeadLetterConfig   `json:"deadLetter" yaml:"deadLetter"`
+	FileActions FileActionsConfig  `json:"fileActions" yaml:"fileActions"`
+	Health      HealthConfig       `json:"health" yaml:"health"`
+	Stats       StatsConfig        `json:"stats" yaml:"stats"`
+	Tracing     TracingConfig      `json:"tracing" yaml:"tracing"`
+	State       StateConfig        `json:"state" yaml:"state"`
+
+	// legacyConnection is set when Connections was derived from BrokerType
+	legacyConnection bool
+}
+
+// ConnectionConfig describes a single named broker connection
+type ConnectionConfig struct {
+	Name  string      `json:"name" yaml:"name"`
+	Type  string      `json:"type" yaml:"type"` // "mqtt", "nats" or "kafka"
+	MQTT  MQTTConfig  `json:"mqtt" yaml:"mqtt"`
+	NATS  NATSConfig  `json:"nats" yaml:"nats"`
+	Kafka KafkaConfig `json:"kafka" yaml:"kafka"`
 }
 
 type MQTTConfig struct {
@@ -26,7 +50,9 @@ type MQTTConfig struct {
 	ClientID string `json:"clientId" yaml:"clientId"`
 	Username string `json:"username" yaml:"username"`
 	Password string `json:"password" yaml:"password"`
-	TLS      struct {
+	// ProtocolVersion is 4 for MQTT 3.1.1, the default, or 5 for MQTT 5
+	ProtocolVersion int `json:"protocolVersion" yaml:"protocolVersion"`
+	TLS             struct {
 		Enable   bool   `json:"enable" yaml:"enable"`
 		CertFile string `json:"certFile" yaml:"certFile"`
 		KeyFile  string `json:"keyFile" yaml:"keyFile"`
@@ -45,8 +71,41 @@ type NATSConfig struct {
 		KeyFile  string `json:"keyFile" yaml:"keyFile"`
 		CAFile   string `json:"caFile" yaml:"caFile"`
 	} `json:"tls" yaml:"tls"`
+	JetStream JetStreamConfig `json:"jetstream" yaml:"jetstream"`
+}
+
+// JetStreamConfig enables consuming rule topics through JetStream pull consumers
+type JetStreamConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	Durable    string `json:"durable" yaml:"durable"`       // Consumer name prefix
+	FetchBatch int    `json:"fetchBatch" yaml:"fetchBatch"` // Messages per fetch
+	FetchWait  string `json:"fetchWait" yaml:"fetchWait"`   // Duration string
+}
+
+// KafkaConfig connects to a Kafka cluster. Rule topics are consumed by a
+// consumer group and actions are produced as records.
+type KafkaConfig struct {
+	Brokers     []string          `json:"brokers" yaml:"brokers"` // Seed brokers, host:port
+	ClientID    string            `json:"clientId" yaml:"clientId"`
+	Group       string            `json:"group" yaml:"group"`             // Consumer group of the rule topics
+	StartOffset string            `json:"startOffset" yaml:"startOffset"` // "latest" or "earliest", where a group without offsets starts
+	Acks        string            `json:"acks" yaml:"acks"`               // "all", "leader" or "none"
+	Idempotent  bool              `json:"idempotent" yaml:"idempotent"`   // Retried produces are written once, requires acks "all"
+	Headers     map[string]string `json:"headers" yaml:"headers"`         // Record header name per action metadata field
+	Username    string            `json:"username" yaml:"username"`       // SASL/PLAIN credentials
+	Password    string            `json:"password" yaml:"password"`
+	TLS         struct {
+		Enable   bool   `json:"enable" yaml:"enable"`
+		CertFile string `json:"certFile" yaml:"certFile"`
+		KeyFile  string `json:"keyFile" yaml:"keyFile"`
+		CAFile   string `json:"caFile" yaml:"caFile"`
+	} `json:"tls" yaml:"tls"`
 }
 
+// KafkaMetadataFields are the action metadata fields that can be mapped to
+// Kafka record headers
+var KafkaMetadataFields = []string{"topic", "connection", "rule", "receivedAt"}
+
 type LogConfig struct {
 	Level      string `json:"level" yaml:"level"`           // debug, info, warn, error
 	OutputPath string `json:"outputPath" yaml:"outputPath"` // file path or "stdout"
@@ -58,12 +117,114 @@ type MetricsConfig struct {
 	Address        string `json:"address" yaml:"address"`
 	Path           string `json:"path" yaml:"path"`
 	UpdateInterval string `json:"updateInterval" yaml:"updateInterval"` // Duration string
+	MaxRuleLabels  int    `json:"maxRuleLabels" yaml:"maxRuleLabels"`   // Distinct rule IDs with their own per-rule series
+}
+
+// HealthConfig serves the /healthz and /readyz endpoints. Endpoints configured
+// on the same address as metrics or stats share one HTTP server.
+type HealthConfig struct {
+	Enabled       bool   `json:"enabled" yaml:"enabled"`
+	Address       string `json:"address" yaml:"address"`
+	MaxQueueDepth int    `json:"maxQueueDepth" yaml:"maxQueueDepth"` // Not ready at this queue depth; 0 uses 80% of queueSize
+}
+
+// QueueThreshold returns the processing queue depth at which a connection
+// stops being ready
+func (h HealthConfig) QueueThreshold(queueSize int) int {
+	if h.MaxQueueDepth > 0 {
+		return h.MaxQueueDepth
+	}
+	if threshold := queueSize * 8 / 10; threshold > 0 {
+		return threshold
+	}
+	return 1
+}
+
+// StatsConfig serves live statistics as JSON
+type StatsConfig struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+	Address  string `json:"address" yaml:"address"`
+	Path     string `json:"path" yaml:"path"`
+	Interval string `json:"interval" yaml:"interval"` // Duration string, sampling interval for rates
+}
+
+// TracingConfig exports OpenTelemetry traces over OTLP/HTTP
+type TracingConfig struct {
+	Enabled     bool     `json:"enabled" yaml:"enabled"`
+	Endpoint    string   `json:"endpoint" yaml:"endpoint"`       // Collector host:port
+	Insecure    bool     `json:"insecure" yaml:"insecure"`       // Use plain HTTP
+	ServiceName string   `json:"serviceName" yaml:"serviceName"` // service.name resource attribute
+	SampleRatio *float64 `json:"sampleRatio" yaml:"sampleRatio"` // Fraction of new traces sampled, 0 to 1
+}
+
+// StateConfig selects where stateful rules keep their state. Each
+// connection has its own store, named after the connection in Dir.
+type StateConfig struct {
+	Backend  string `json:"backend" yaml:"backend"`   // "memory" or "bolt"
+	Dir      string `json:"dir" yaml:"dir"`           // Directory of the state files
+	Snapshot bool   `json:"snapshot" yaml:"snapshot"` // Memory backend: save state on shutdown and restore it on start
+}
+
+// DeadLetterConfig routes unprocessable messages and undeliverable actions
+// to a dead-letter topic
+type DeadLetterConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Topic   string `json:"topic" yaml:"topic"`   // MQTT-style topic, translated for NATS connections
+	Broker  string `json:"broker" yaml:"broker"` // Connection to publish on, empty for the first
+
+	// File keeps dead letters in local JSONL files, independent of Enabled
+	File DeadLetterFileConfig `json:"file" yaml:"file"`
+}
+
+// DeadLetterFileConfig configures the rotating local dead-letter store
+type DeadLetterFileConfig struct {
+	Enabled   bool   `json:"enabled" yaml:"enabled"`
+	Path      string `json:"path" yaml:"path"`
+	MaxSizeMB int    `json:"maxSizeMB" yaml:"maxSizeMB"` // Rotate once the file reaches this size
+	MaxFiles  int    `json:"maxFiles" yaml:"maxFiles"`   // Rotated files kept
+}
+
+// FileActionsConfig configures the rotating files file actions append to
+type FileActionsConfig struct {
+	Dir       string `json:"dir" yaml:"dir"`             // Relative action paths are resolved against Dir
+	MaxSizeMB int    `json:"maxSizeMB" yaml:"maxSizeMB"` // Rotate once a file reaches this size
+	MaxFiles  int    `json:"maxFiles" yaml:"maxFiles"`   // Rotated files kept per path
 }
 
 type ProcConfig struct {
-	Workers    int `json:"workers" yaml:"workers"`
-	QueueSize  int `json:"queueSize" yaml:"queueSize"`
-	BatchSize  int `json:"batchSize" yaml:"batchSize"`
+	Workers        int            `json:"workers" yaml:"workers"`
+	QueueSize      int            `json:"queueSize" yaml:"queueSize"`
+	BatchSize      int            `json:"batchSize" yaml:"batchSize"`
+	BatchLinger    string         `json:"batchLinger" yaml:"batchLinger"`       // Duration string
+	OverflowPolicy string         `json:"overflowPolicy" yaml:"overflowPolicy"` // block, drop_newest or drop_oldest
+	Ordering       OrderingConfig `json:"ordering" yaml:"ordering"`
+	Outbound       OutboundConfig `json:"outbound" yaml:"outbound"`
+	Retry          RetryConfig    `json:"retry" yaml:"retry"`
+	DrainTimeout   string         `json:"drainTimeout" yaml:"drainTimeout"` // Duration string, bounds the shutdown drain
+}
+
+// RetryConfig is the default retry policy for failed action publishes.
+// Actions can override any field with their own retry block.
+type RetryConfig struct {
+	MaxAttempts    int     `json:"maxAttempts" yaml:"maxAttempts"`       // Total attempts including the first; 1 disables retries
+	InitialBackoff string  `json:"initialBackoff" yaml:"initialBackoff"` // Duration string
+	MaxBackoff     string  `json:"maxBackoff" yaml:"maxBackoff"`         // Duration string
+	Jitter         float64 `json:"jitter" yaml:"jitter"`                 // Fraction of the backoff, 0 to 1
+}
+
+// OutboundConfig bounds the per-connection queue of actions awaiting publication.
+// Consumption pauses when a queue reaches the high watermark and resumes once
+// it drains to the low watermark.
+type OutboundConfig struct {
+	QueueSize     int `json:"queueSize" yaml:"queueSize"`
+	HighWatermark int `json:"highWatermark" yaml:"highWatermark"`
+	LowWatermark  int `json:"lowWatermark" yaml:"lowWatermark"`
+}
+
+// OrderingConfig controls per-key ordering across the worker pool
+type OrderingConfig struct {
+	Key   string `json:"key" yaml:"key"`     // none, topic or field
+	Field string `json:"field" yaml:"field"` // payload field when key is "field", e.g. deviceId
 }
 
 // Load reads and parses the configuration file
@@ -106,6 +267,17 @@ func Load(path string) (*Config, error) {
 		config.BrokerType = "mqtt" // Default to MQTT for backward compatibility
 	}
 
+	// Defaults are applied before the legacy connection is derived so a
+	// -broker-type override rebuilds it with the same values
+	setJetStreamDefaults(&config.NATS.JetStream)
+
+	// Without a connections list, the top-level broker settings form a
+	// single connection so the rest of the application sees one shape
+	if len(config.Connections) == 0 {
+		config.Connections = []ConnectionConfig{config.legacyConnectionConfig()}
+		config.legacyConnection = true
+	}
+
 	// Set defaults for logging
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
@@ -127,6 +299,9 @@ func Load(path string) (*Config, error) {
 	if config.Metrics.UpdateInterval == "" {
 		config.Metrics.UpdateInterval = "15s"
 	}
+	if config.Metrics.MaxRuleLabels == 0 {
+		config.Metrics.MaxRuleLabels = 100
+	}
 
 	// Set defaults for processing
 	if config.Processing.Workers <= 0 {
@@ -135,8 +310,112 @@ func Load(path string) (*Config, error) {
 	if config.Processing.QueueSize <= 0 {
 		config.Processing.QueueSize = 1000
 	}
+	// Batching is opt-in
 	if config.Processing.BatchSize <= 0 {
-		config.Processing.BatchSize = 100
+		config.Processing.BatchSize = 1
+	}
+	if config.Processing.BatchLinger == "" {
+		config.Processing.BatchLinger = "5ms"
+	}
+	if config.Processing.OverflowPolicy == "" {
+		config.Processing.OverflowPolicy = "block"
+	}
+	if config.Processing.Ordering.Key == "" {
+		config.Processing.Ordering.Key = "none"
+	}
+
+	// Set defaults for the outbound queue
+	if config.Processing.Outbound.QueueSize <= 0 {
+		config.Processing.Outbound.QueueSize = 10000
+	}
+	if config.Processing.Outbound.HighWatermark <= 0 {
+		config.Processing.Outbound.HighWatermark = config.Processing.Outbound.QueueSize * 8 / 10
+	}
+	if config.Processing.Outbound.LowWatermark <= 0 {
+		config.Processing.Outbound.LowWatermark = config.Processing.Outbound.QueueSize * 2 / 10
+	}
+
+	// Set defaults for publish retries
+	if config.Processing.Retry.MaxAttempts <= 0 {
+		config.Processing.Retry.MaxAttempts = 3
+	}
+	if config.Processing.Retry.InitialBackoff == "" {
+		config.Processing.Retry.InitialBackoff = "100ms"
+	}
+	if config.Processing.Retry.MaxBackoff == "" {
+		config.Processing.Retry.MaxBackoff = "10s"
+	}
+
+	// Set default health endpoint address
+	if config.Health.Address == "" {
+		config.Health.Address = ":8081"
+	}
+
+	// Set defaults for the stats endpoint
+	if config.Stats.Address == "" {
+		config.Stats.Address = ":8081"
+	}
+	if config.Stats.Path == "" {
+		config.Stats.Path = "/stats"
+	}
+	if config.Stats.Interval == "" {
+		config.Stats.Interval = "5s"
+	}
+
+	// Set defaults for tracing
+	if config.Tracing.Endpoint == "" {
+		config.Tracing.Endpoint = "localhost:4318"
+	}
+	if config.Tracing.ServiceName == "" {
+		config.Tracing.ServiceName = "mqtt-mux-router"
+	}
+	if config.Tracing.SampleRatio == nil {
+		ratio := 1.0
+		config.Tracing.SampleRatio = &ratio
+	}
+
+	// Set default shutdown drain timeout
+	if config.Processing.DrainTimeout == "" {
+		config.Processing.DrainTimeout = "30s"
+	}
+
+	// Set defaults for dead-lettering
+	if config.DeadLetter.Topic == "" {
+		config.DeadLetter.Topic = "mqtt-mux-router/deadletter"
+	}
+	if config.DeadLetter.File.Path == "" {
+		config.DeadLetter.File.Path = "deadletter/deadletter.jsonl"
+	}
+	if config.DeadLetter.File.MaxSizeMB <= 0 {
+		config.DeadLetter.File.MaxSizeMB = 100
+	}
+	if config.DeadLetter.File.MaxFiles <= 0 {
+		config.DeadLetter.File.MaxFiles = 5
+	}
+
+	// Set defaults for file actions
+	if config.FileActions.Dir == "" {
+		config.FileActions.Dir = "actions"
+	}
+	if config.FileActions.MaxSizeMB <= 0 {
+		config.FileActions.MaxSizeMB = 100
+	}
+	if config.FileActions.MaxFiles <= 0 {
+		config.FileActions.MaxFiles = 5
+	}
+
+	// Set defaults for rule state
+	if config.State.Backend == "" {
+		config.State.Backend = "memory"
+	}
+	if config.State.Dir == "" {
+		config.State.Dir = "state"
+	}
+
+	// Set defaults for JetStream consumers and Kafka clients
+	for i := range config.Connections {
+		setJetStreamDefaults(&config.Connections[i].NATS.JetStream)
+		setKafkaDefaults(&config.Connections[i].Kafka)
 	}
 
 	// Validate the configuration
@@ -147,88 +426,316 @@ func Load(path string) (*Config, error) {
 	return &config, nil
 }
 
+// setJetStreamDefaults fills in unset JetStream consumer settings
+func setJetStreamDefaults(js *JetStreamConfig) {
+	if js.Durable == "" {
+		js.Durable = "mqtt-mux-router"
+	}
+	if js.FetchBatch <= 0 {
+		js.FetchBatch = 100
+	}
+	if js.FetchWait == "" {
+		js.FetchWait = "1s"
+	}
+}
+
+// setKafkaDefaults fills in unset Kafka client settings
+func setKafkaDefaults(kafka *KafkaConfig) {
+	if kafka.ClientID == "" {
+		kafka.ClientID = "mqtt-mux-router"
+	}
+	if kafka.Group == "" {
+		kafka.Group = "mqtt-mux-router"
+	}
+	if kafka.StartOffset == "" {
+		kafka.StartOffset = "latest"
+	}
+	if kafka.Acks == "" {
+		kafka.Acks = "all"
+	}
+}
+
+// validateRetry checks the default publish retry policy
+func validateRetry(retry RetryConfig) error {
+	initial, err := time.ParseDuration(retry.InitialBackoff)
+	if err != nil || initial <= 0 {
+		return fmt.Errorf("invalid retry initial backoff: %s", retry.InitialBackoff)
+	}
+	max, err := time.ParseDuration(retry.MaxBackoff)
+	if err != nil || max < initial {
+		return fmt.Errorf("invalid retry max backoff: %s", retry.MaxBackoff)
+	}
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
 // validateConfig performs validation of all configuration values
 func validateConfig(cfg *Config) error {
-	// Validate based on broker type
-	switch cfg.BrokerType {
+	// Validate broker connections
+	if err := validateConnections(cfg.Connections); err != nil {
+		return err
+	}
+
+	// Validate logging config
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid log level: %s", cfg.Logging.Level)
+	}
+
+	switch cfg.Logging.Encoding {
+	case "json", "console":
+	default:
+		return fmt.Errorf("invalid log encoding: %s", cfg.Logging.Encoding)
+	}
+
+	// Validate dead-letter config
+	if cfg.DeadLetter.Enabled && cfg.DeadLetter.Broker != "" {
+		found := false
+		for _, conn := range cfg.Connections {
+			found = found || conn.Name == cfg.DeadLetter.Broker
+		}
+		if !found {
+			return fmt.Errorf("dead letter broker %s is not a configured connection", cfg.DeadLetter.Broker)
+		}
+	}
+
+	switch cfg.State.Backend {
+	case "memory", "bolt":
+	default:
+		return fmt.Errorf("invalid state backend: %s", cfg.State.Backend)
+	}
+
+	if cfg.Health.MaxQueueDepth < 0 {
+		return fmt.Errorf("health max queue depth must not be negative")
+	}
+
+	if cfg.Stats.Enabled {
+		if d, err := time.ParseDuration(cfg.Stats.Interval); err != nil {
+			return fmt.Errorf("invalid stats interval: %w", err)
+		} else if d <= 0 {
+			return fmt.Errorf("stats interval must be greater than 0")
+		}
+	}
+
+	if cfg.Tracing.Enabled {
+		if ratio := *cfg.Tracing.SampleRatio; ratio < 0 || ratio > 1 {
+			return fmt.Errorf("tracing sampleRatio must be between 0 and 1")
+		}
+	}
+
+	// Validate metrics config
+	if cfg.Metrics.Enabled {
+		if _, err := time.ParseDuration(cfg.Metrics.UpdateInterval); err != nil {
+			return fmt.Errorf("invalid metrics update interval: %w", err)
+		}
+		if cfg.Metrics.MaxRuleLabels < 0 {
+			return fmt.Errorf("metrics maxRuleLabels cannot be negative")
+		}
+	}
+
+	// Validate processing config
+	if cfg.Processing.Workers < 1 {
+		return fmt.Errorf("workers must be greater than 0")
+	}
+	if cfg.Processing.QueueSize < 1 {
+		return fmt.Errorf("queue size must be greater than 0")
+	}
+	if cfg.Processing.BatchSize < 1 {
+		return fmt.Errorf("batch size must be greater than 0")
+	}
+	if _, err := time.ParseDuration(cfg.Processing.BatchLinger); err != nil {
+		return fmt.Errorf("invalid batch linger: %w", err)
+	}
+	switch cfg.Processing.OverflowPolicy {
+	case "block", "drop_newest", "drop_oldest":
+	default:
+		return fmt.Errorf("invalid overflow policy: %s", cfg.Processing.OverflowPolicy)
+	}
+	outbound := cfg.Processing.Outbound
+	if outbound.HighWatermark > outbound.QueueSize {
+		return fmt.Errorf("outbound high watermark must not exceed the outbound queue size")
+	}
+	if outbound.LowWatermark >= outbound.HighWatermark {
+		return fmt.Errorf("outbound low watermark must be below the high watermark")
+	}
+	if err := validateRetry(cfg.Processing.Retry); err != nil {
+		return err
+	}
+	if d, err := time.ParseDuration(cfg.Processing.DrainTimeout); err != nil {
+		return fmt.Errorf("invalid drain timeout: %w", err)
+	} else if d <= 0 {
+		return fmt.Errorf("drain timeout must be greater than 0")
+	}
+	switch cfg.Processing.Ordering.Key {
+	case "none", "topic":
+	case "field":
+		if cfg.Processing.Ordering.Field == "" {
+			return fmt.Errorf("ordering field is required when ordering key is field")
+		}
+	default:
+		return fmt.Errorf("invalid ordering key: %s", cfg.Processing.Ordering.Key)
+	}
+
+	return nil
+}
+
+// validateConnections checks every connection and ensures names are unique
+func validateConnections(conns []ConnectionConfig) error {
+	if len(conns) == 0 {
+		return fmt.Errorf("at least one broker connection is required")
+	}
+
+	seen := make(map[string]struct{}, len(conns))
+	mqttClients := make(map[string]string, len(conns))
+	for i := range conns {
+		conn := &conns[i]
+		if conn.Name == "" {
+			return fmt.Errorf("connection at index %d has no name", i)
+		}
+		if _, exists := seen[conn.Name]; exists {
+			return fmt.Errorf("duplicate connection name: %s", conn.Name)
+		}
+		seen[conn.Name] = struct{}{}
+
+		if err := validateConnection(conn); err != nil {
+			return fmt.Errorf("connection %s: %w", conn.Name, err)
+		}
+
+		// Two sessions with the same client ID on one MQTT broker would
+		// keep disconnecting each other
+		if conn.Type == "mqtt" {
+			clientKey := conn.MQTT.Broker + "|" + conn.MQTT.ClientID
+			if other, exists := mqttClients[clientKey]; exists {
+				return fmt.Errorf("connections %s and %s use the same mqtt client id %q on %s",
+					other, conn.Name, conn.MQTT.ClientID, conn.MQTT.Broker)
+			}
+			mqttClients[clientKey] = conn.Name
+		}
+	}
+
+	return nil
+}
+
+// validateConnection validates the settings for a connection's broker type
+func validateConnection(conn *ConnectionConfig) error {
+	switch conn.Type {
 	case "mqtt":
 		// Validate MQTT config
-		if cfg.MQTT.Broker == "" {
+		if conn.MQTT.Broker == "" {
 			return fmt.Errorf("mqtt broker address is required")
 		}
+		if v := conn.MQTT.ProtocolVersion; v != 0 && v != 4 && v != 5 {
+			return fmt.Errorf("mqtt protocol version must be 4 or 5, got %d", v)
+		}
 
 		// Validate MQTT TLS config if enabled
-		if cfg.MQTT.TLS.Enable {
-			if cfg.MQTT.TLS.CertFile == "" {
+		if conn.MQTT.TLS.Enable {
+			if conn.MQTT.TLS.CertFile == "" {
 				return fmt.Errorf("tls cert file is required when tls is enabled")
 			}
-			if cfg.MQTT.TLS.KeyFile == "" {
+			if conn.MQTT.TLS.KeyFile == "" {
 				return fmt.Errorf("tls key file is required when tls is enabled")
 			}
-			if cfg.MQTT.TLS.CAFile == "" {
+			if conn.MQTT.TLS.CAFile == "" {
 				return fmt.Errorf("tls ca file is required when tls is enabled")
 			}
 		}
 	case "nats":
 		// Validate NATS config
-		if len(cfg.NATS.URLs) == 0 {
+		if len(conn.NATS.URLs) == 0 {
 			return fmt.Errorf("at least one nats server URL is required")
 		}
 
+		// Validate JetStream consumer config if enabled
+		if conn.NATS.JetStream.Enabled {
+			if _, err := time.ParseDuration(conn.NATS.JetStream.FetchWait); err != nil {
+				return fmt.Errorf("invalid jetstream fetch wait: %w", err)
+			}
+		}
+
 		// Validate NATS TLS config if enabled
-		if cfg.NATS.TLS.Enable {
-			if cfg.NATS.TLS.CertFile == "" {
+		if conn.NATS.TLS.Enable {
+			if conn.NATS.TLS.CertFile == "" {
 				return fmt.Errorf("tls cert file is required when tls is enabled")
 			}
-			if cfg.NATS.TLS.KeyFile == "" {
+			if conn.NATS.TLS.KeyFile == "" {
 				return fmt.Errorf("tls key file is required when tls is enabled")
 			}
-			if cfg.NATS.TLS.CAFile == "" {
+			if conn.NATS.TLS.CAFile == "" {
 				return fmt.Errorf("tls ca file is required when tls is enabled")
 			}
 		}
+	case "kafka":
+		if err := validateKafka(&conn.Kafka); err != nil {
+			return err
+		}
 	default:
-		return fmt.Errorf("unsupported broker type: %s", cfg.BrokerType)
+		return fmt.Errorf("unsupported broker type: %s", conn.Type)
 	}
 
-	// Validate logging config
-	switch cfg.Logging.Level {
-	case "debug", "info", "warn", "error":
+	return nil
+}
+
+// validateKafka checks the settings of a Kafka connection
+func validateKafka(kafka *KafkaConfig) error {
+	if len(kafka.Brokers) == 0 {
+		return fmt.Errorf("at least one kafka broker address is required")
+	}
+
+	switch kafka.StartOffset {
+	case "latest", "earliest":
 	default:
-		return fmt.Errorf("invalid log level: %s", cfg.Logging.Level)
+		return fmt.Errorf("invalid kafka start offset: %s", kafka.StartOffset)
 	}
 
-	switch cfg.Logging.Encoding {
-	case "json", "console":
+	switch kafka.Acks {
+	case "all", "leader", "none":
 	default:
-		return fmt.Errorf("invalid log encoding: %s", cfg.Logging.Encoding)
+		return fmt.Errorf("invalid kafka acks: %s", kafka.Acks)
+	}
+	if kafka.Idempotent && kafka.Acks != "all" {
+		return fmt.Errorf("idempotent kafka produce requires acks all")
 	}
 
-	// Validate metrics config
-	if cfg.Metrics.Enabled {
-		if _, err := time.ParseDuration(cfg.Metrics.UpdateInterval); err != nil {
-			return fmt.Errorf("invalid metrics update interval: %w", err)
+	for header, field := range kafka.Headers {
+		if header == "" {
+			return fmt.Errorf("kafka header name cannot be empty")
+		}
+		if !slices.Contains(KafkaMetadataFields, field) {
+			return fmt.Errorf("unknown kafka header field %q, expected one of %v", field, KafkaMetadataFields)
 		}
 	}
 
-	// Validate processing config
-	if cfg.Processing.Workers < 1 {
-		return fmt.Errorf("workers must be greater than 0")
-	}
-	if cfg.Processing.QueueSize < 1 {
-		return fmt.Errorf("queue size must be greater than 0")
-	}
-	if cfg.Processing.BatchSize < 1 {
-		return fmt.Errorf("batch size must be greater than 0")
+	// Validate Kafka TLS config if enabled
+	if kafka.TLS.Enable && (kafka.TLS.CertFile == "") != (kafka.TLS.KeyFile == "") {
+		return fmt.Errorf("tls cert file and key file must be set together")
 	}
 
 	return nil
 }
 
+// legacyConnectionConfig builds a connection from the top-level broker settings
+func (c *Config) legacyConnectionConfig() ConnectionConfig {
+	return ConnectionConfig{
+		Name: DefaultConnectionName,
+		Type: c.BrokerType,
+		MQTT: c.MQTT,
+		NATS: c.NATS,
+	}
+}
+
 // ApplyOverrides applies command line flag overrides to the configuration
 func (c *Config) ApplyOverrides(brokerType string, workers, queueSize, batchSize int, metricsAddr, metricsPath string, metricsInterval time.Duration) {
 	if brokerType != "" {
 		c.BrokerType = brokerType
+		// Only the connection derived from the top-level settings follows
+		// the flag; explicitly named connections keep their own type
+		if c.legacyConnection {
+			c.Connections = []ConnectionConfig{c.legacyConnectionConfig()}
+		}
 	}
 	
 	if workers > 0 {
@@ -0,0 +1,166 @@
+package broker
+
+import (
+    "sync"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// BatchFlushFunc publishes a batch of actions. Implementations account for
+// per-action success and failure themselves and report failed actions as
+// ActionErrors joined into the returned error.
+type BatchFlushFunc func(actions []*rule.Action) error
+
+// FailureHandler takes ownership of an action that failed to publish
+type FailureHandler func(action *rule.Action, err error)
+
+// Batcher buffers outbound actions and flushes them in batches once the batch
+// size is reached or the oldest buffered action has waited for the linger time
+type Batcher struct {
+    name    string
+    size    int
+    linger  time.Duration
+    flushFn BatchFlushFunc
+    onFail  FailureHandler
+    logger  *logger.Logger
+    metrics *metrics.Metrics
+
+    pending []*rule.Action
+    timer   *time.Timer
+    closed  bool
+    mu      sync.Mutex
+
+    // flushMu serializes flushes so batches are published in order
+    flushMu sync.Mutex
+}
+
+// NewBatcher creates a batcher for the named connection
+func NewBatcher(name string, size int, linger time.Duration, flushFn BatchFlushFunc, log *logger.Logger, metricsService *metrics.Metrics) *Batcher {
+    if size < 1 {
+        size = 1
+    }
+
+    return &Batcher{
+        name:    name,
+        size:    size,
+        linger:  linger,
+        flushFn: flushFn,
+        logger:  log,
+        metrics: metricsService,
+        pending: make([]*rule.Action, 0, size),
+    }
+}
+
+// SetFailureHandler sets the handler for actions that fail to publish,
+// typically a Retrier. Without one, failures are only logged.
+func (b *Batcher) SetFailureHandler(handler FailureHandler) {
+    b.onFail = handler
+}
+
+// Add buffers an action, flushing when the batch is full. After Close the
+// action is published immediately.
+func (b *Batcher) Add(action *rule.Action) {
+    b.mu.Lock()
+    if b.closed {
+        b.mu.Unlock()
+        b.publish([]*rule.Action{action})
+        return
+    }
+
+    b.pending = append(b.pending, action)
+    full := len(b.pending) >= b.size
+    if !full && len(b.pending) == 1 && b.linger > 0 {
+        b.startTimer()
+    }
+    b.mu.Unlock()
+
+    if full || b.linger <= 0 {
+        b.Flush()
+    }
+}
+
+// Flush publishes all buffered actions
+func (b *Batcher) Flush() {
+    b.flush()
+}
+
+// flush publishes all buffered actions and returns how many were published
+func (b *Batcher) flush() int {
+    b.flushMu.Lock()
+    defer b.flushMu.Unlock()
+
+    b.mu.Lock()
+    if b.timer != nil {
+        b.timer.Stop()
+        b.timer = nil
+    }
+    batch := b.pending
+    b.pending = make([]*rule.Action, 0, b.size)
+    b.mu.Unlock()
+
+    if len(batch) == 0 {
+        return 0
+    }
+    return b.publish(batch)
+}
+
+// Pending returns the number of buffered actions
+func (b *Batcher) Pending() int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return len(b.pending)
+}
+
+// Close flushes buffered actions and returns how many were published; later
+// actions are published unbatched
+func (b *Batcher) Close() int {
+    b.mu.Lock()
+    b.closed = true
+    b.mu.Unlock()
+
+    return b.flush()
+}
+
+// startTimer schedules a linger flush; callers must hold b.mu
+func (b *Batcher) startTimer() {
+    if b.timer != nil {
+        b.timer.Stop()
+    }
+    b.timer = time.AfterFunc(b.linger, b.Flush)
+}
+
+// publish hands a batch to the flush function and records batch metrics. It
+// returns how many actions of the batch were published.
+func (b *Batcher) publish(batch []*rule.Action) int {
+    start := time.Now()
+    err := b.flushFn(batch)
+    elapsed := time.Since(start)
+
+    if b.metrics != nil {
+        b.metrics.ObservePublishBatch(b.name, len(batch), elapsed)
+    }
+
+    if err != nil {
+        b.logger.Error("failed to publish batch",
+            "connection", b.name,
+            "batchSize", len(batch),
+            "error", err)
+
+        failed := FailedActions(batch, err)
+        if b.onFail != nil {
+            for _, action := range failed {
+                b.onFail(action, err)
+            }
+        }
+        return len(batch) - len(failed)
+    }
+
+    b.logger.Debug("published batch",
+        "connection", b.name,
+        "batchSize", len(batch),
+        "duration", elapsed)
+    return len(batch)
+}
@@ -0,0 +1,106 @@
+package broker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+// batchRecorder collects the batches handed to a flush function
+type batchRecorder struct {
+	batches [][]*rule.Action
+	mu      sync.Mutex
+}
+
+func (r *batchRecorder) flush(actions []*rule.Action) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, actions)
+	return nil
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.batches))
+	for i, batch := range r.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func newTestBatcher(t *testing.T, size int, linger time.Duration) (*Batcher, *batchRecorder) {
+	t.Helper()
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	rec := &batchRecorder{}
+	return NewBatcher("test", size, linger, rec.flush, &logger.Logger{Logger: zapLogger}, nil), rec
+}
+
+func TestBatcher_FlushOnSize(t *testing.T) {
+	b, rec := newTestBatcher(t, 3, time.Hour)
+
+	for i := 0; i < 7; i++ {
+		b.Add(&rule.Action{Topic: "out"})
+	}
+
+	assert.Equal(t, []int{3, 3}, rec.sizes())
+	assert.Equal(t, 1, b.Pending())
+
+	b.Close()
+	assert.Equal(t, []int{3, 3, 1}, rec.sizes())
+}
+
+func TestBatcher_FlushOnLinger(t *testing.T) {
+	b, rec := newTestBatcher(t, 100, 20*time.Millisecond)
+
+	b.Add(&rule.Action{Topic: "out/1"})
+	b.Add(&rule.Action{Topic: "out/2"})
+	assert.Empty(t, rec.sizes())
+
+	assert.Eventually(t, func() bool {
+		return len(rec.sizes()) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{2}, rec.sizes())
+	assert.Equal(t, "out/1", rec.batches[0][0].Topic)
+	assert.Equal(t, "out/2", rec.batches[0][1].Topic)
+}
+
+func TestBatcher_AddAfterClose(t *testing.T) {
+	b, rec := newTestBatcher(t, 10, time.Hour)
+
+	b.Close()
+	b.Add(&rule.Action{Topic: "late"})
+
+	assert.Equal(t, []int{1}, rec.sizes())
+	assert.Equal(t, 0, b.Pending())
+}
+
+func TestBatcher_FailureHandler(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	flush := func(actions []*rule.Action) error {
+		return &ActionError{Action: actions[1], Err: assert.AnError}
+	}
+	b := NewBatcher("test", 3, time.Hour, flush, &logger.Logger{Logger: zapLogger}, nil)
+
+	var failed []string
+	b.SetFailureHandler(func(action *rule.Action, err error) {
+		failed = append(failed, action.Topic)
+	})
+
+	b.Add(&rule.Action{Topic: "a"})
+	b.Add(&rule.Action{Topic: "b"})
+	b.Add(&rule.Action{Topic: "c"})
+
+	assert.Equal(t, []string{"b"}, failed)
+}
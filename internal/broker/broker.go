@@ -14,12 +14,83 @@ type Broker interface {
     Close()
     // GetStats returns current broker statistics
     GetStats() BrokerStats
+    // PublishAction publishes a processed rule action
+    PublishAction(action *rule.Action) error
+}
+
+// ActionRouter dispatches actions to the connection named by Action.Broker
+type ActionRouter interface {
+    RouteAction(action *rule.Action) error
+}
+
+// ActionTarget delivers actions that are not published on a broker
+// connection, such as HTTP requests, registered with the router by action kind
+type ActionTarget interface {
+    // Deliver queues an action for delivery; failures are retried by the target
+    Deliver(action *rule.Action) error
+    // Drain delivers queued actions and waits for pending retries until ctx is done
+    Drain(ctx context.Context) DrainStats
+    // Close stops accepting actions and abandons pending retries
+    Close()
 }
 
 // BrokerStats contains statistics about broker operation
 type BrokerStats struct {
-    MessagesReceived  uint64
-    MessagesPublished uint64
-    LastReconnect     time.Time
-    Errors            uint64
+    MessagesReceived  uint64    `json:"messagesReceived"`
+    MessagesPublished uint64    `json:"messagesPublished"`
+    LastReconnect     time.Time `json:"lastReconnect"`
+    Errors            uint64    `json:"errors"`
+
+    // Connections breaks the totals down per named connection
+    Connections map[string]ConnectionStats `json:"connections"`
+}
+
+// ConnectionStats contains statistics for a single named broker connection
+type ConnectionStats struct {
+    Type              string    `json:"type"`
+    Connected         bool      `json:"connected"`
+    Subscribed        bool      `json:"subscribed"`
+    Topics            int       `json:"topics"`
+    RulesLoaded       bool      `json:"rulesLoaded"` // Start has loaded the connection's rules
+    Rules             int       `json:"rules"`
+    QueueDepth        int       `json:"queueDepth"`    // Messages waiting for a worker
+    OutboundDepth     int       `json:"outboundDepth"` // Actions waiting to be published
+    MessagesReceived  uint64    `json:"messagesReceived"`
+    MessagesPublished uint64    `json:"messagesPublished"`
+    LastReconnect     time.Time `json:"lastReconnect"`
+    Errors            uint64    `json:"errors"`
+    PublishRetries    uint64    `json:"publishRetries"`  // Retry attempts for failed publishes
+    PublishFailures   uint64    `json:"publishFailures"` // Actions given up on after all attempts
+}
+
+// ProcessorReporter is implemented by brokers that evaluate rules with a
+// rule.Processor
+type ProcessorReporter interface {
+    ProcessorReport() rule.ProcessorReport
+}
+
+// Drainer is implemented by brokers that shut down in phases, so that a
+// router can stop input on every connection before draining any of them.
+// Actions routed between connections are then still published.
+type Drainer interface {
+    // StopConsuming unsubscribes so that no new messages arrive
+    StopConsuming()
+    // DrainProcessing waits for queued messages to be processed and their
+    // actions dispatched
+    DrainProcessing(ctx context.Context) DrainStats
+    // DrainOutbound publishes queued and batched actions and waits for
+    // pending retries and publish acknowledgements
+    DrainOutbound(ctx context.Context) DrainStats
+}
+
+// DrainStats counts the work found queued during a graceful drain
+type DrainStats struct {
+    Drained   int // Completed before the drain deadline
+    Abandoned int // Given up on at the drain deadline
+}
+
+// Add accumulates the counts of another drain phase
+func (s *DrainStats) Add(other DrainStats) {
+    s.Drained += other.Drained
+    s.Abandoned += other.Abandoned
 }
@@ -0,0 +1,209 @@
+package broker
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "sync"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// DeadLetter is the envelope recorded for a message that could not be
+// processed or an action that could not be published. For the publish stage
+// Topic and Payload are those of the rendered action and Connection is its
+// target; otherwise they are the original message and its source connection.
+// A failed http action also records its rendered request options so that it
+// can be replayed.
+type DeadLetter struct {
+    Topic      string           `json:"topic"`
+    Payload    string           `json:"payload"` // Base64 encoded
+    Error      string           `json:"error"`
+    RuleID     string           `json:"ruleId,omitempty"`
+    Connection string           `json:"connection,omitempty"`
+    Stage      string           `json:"stage"`
+    HTTP       *rule.HTTPAction `json:"http,omitempty"`
+    Timestamp  time.Time        `json:"timestamp"`
+}
+
+// NewDeadLetter builds the envelope for a failure on the named connection
+func NewDeadLetter(connection string, failure *rule.Failure) *DeadLetter {
+    letter := &DeadLetter{
+        Topic:      failure.Topic,
+        Payload:    base64.StdEncoding.EncodeToString(failure.Payload),
+        RuleID:     failure.RuleID,
+        Connection: connection,
+        Stage:      failure.Stage,
+        Timestamp:  failure.Time.UTC(),
+    }
+    if failure.Err != nil {
+        letter.Error = failure.Err.Error()
+    }
+    return letter
+}
+
+// ActionFailure describes an action that could not be published. The topic
+// of an http action is its URL.
+func ActionFailure(action *rule.Action, err error) *rule.Failure {
+    topic := action.Topic
+    if action.HTTP != nil {
+        topic = action.HTTP.URL
+    }
+    return &rule.Failure{
+        Topic:   topic,
+        Payload: []byte(action.Payload),
+        RuleID:  action.RuleID,
+        Stage:   rule.StagePublish,
+        Err:     err,
+        Time:    time.Now(),
+    }
+}
+
+// DecodePayload returns the original payload bytes
+func (d *DeadLetter) DecodePayload() ([]byte, error) {
+    return base64.StdEncoding.DecodeString(d.Payload)
+}
+
+// DeadLetterSink receives dead letters. Implementations must not block for
+// long since they are called from worker and retry goroutines.
+type DeadLetterSink interface {
+    DeadLetter(letter *DeadLetter)
+}
+
+// DeadLetterFanout counts dead letters and hands each one to every sink
+type DeadLetterFanout struct {
+    sinks   []DeadLetterSink
+    metrics *metrics.Metrics
+}
+
+// NewDeadLetterFanout creates a fanout over the given sinks
+func NewDeadLetterFanout(sinks []DeadLetterSink, metricsService *metrics.Metrics) *DeadLetterFanout {
+    return &DeadLetterFanout{
+        sinks:   sinks,
+        metrics: metricsService,
+    }
+}
+
+// DeadLetter implements DeadLetterSink
+func (f *DeadLetterFanout) DeadLetter(letter *DeadLetter) {
+    if f.metrics != nil {
+        f.metrics.IncDeadLetters(letter.Stage)
+    }
+    for _, sink := range f.sinks {
+        sink.DeadLetter(letter)
+    }
+}
+
+// deadLetterQueueSize bounds the dead letters waiting to be published to the
+// dead-letter topic
+const deadLetterQueueSize = 1000
+
+// TopicDeadLetterSink publishes dead letters as JSON to a topic on one of the
+// router's connections. Letters are published from the sink's own goroutine,
+// since routing them may block on a full outbound queue whose sender is the
+// goroutine giving up on an action; letters are dropped while the queue is
+// full.
+type TopicDeadLetterSink struct {
+    topic      string
+    connection string
+    router     ActionRouter
+    logger     *logger.Logger
+    metrics    *metrics.Metrics
+
+    letters chan *DeadLetter
+    stopped chan struct{}
+    closed  bool
+    mu      sync.RWMutex
+}
+
+// NewTopicDeadLetterSink creates a sink publishing to topic on the named
+// connection; an empty connection uses the router's default
+func NewTopicDeadLetterSink(topic, connection string, router ActionRouter, log *logger.Logger, metricsService *metrics.Metrics) *TopicDeadLetterSink {
+    s := &TopicDeadLetterSink{
+        topic:      topic,
+        connection: connection,
+        router:     router,
+        logger:     log,
+        metrics:    metricsService,
+        letters:    make(chan *DeadLetter, deadLetterQueueSize),
+        stopped:    make(chan struct{}),
+    }
+    go s.run()
+    return s
+}
+
+// DeadLetter implements DeadLetterSink by queueing the letter for publication
+func (s *TopicDeadLetterSink) DeadLetter(letter *DeadLetter) {
+    // A dead letter that cannot be published must not be dead-lettered again
+    if letter.Stage == rule.StagePublish && letter.Topic == s.topic {
+        s.logger.Error("dropping undeliverable dead letter",
+            "topic", s.topic,
+            "error", letter.Error)
+        return
+    }
+
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if !s.closed {
+        select {
+        case s.letters <- letter:
+            return
+        default:
+        }
+    }
+
+    s.logger.Error("dropping dead letter, dead-letter queue is full or closed",
+        "topic", s.topic,
+        "stage", letter.Stage,
+        "error", letter.Error)
+    if s.metrics != nil {
+        s.metrics.IncDeadLettersDropped()
+    }
+}
+
+// Close stops accepting dead letters and returns once the queued ones have
+// been handed to the router
+func (s *TopicDeadLetterSink) Close() error {
+    s.mu.Lock()
+    if !s.closed {
+        s.closed = true
+        close(s.letters)
+    }
+    s.mu.Unlock()
+
+    <-s.stopped
+    return nil
+}
+
+// run publishes queued dead letters until the sink is closed
+func (s *TopicDeadLetterSink) run() {
+    defer close(s.stopped)
+
+    for letter := range s.letters {
+        s.publish(letter)
+    }
+}
+
+// publish routes a single dead letter to the dead-letter topic
+func (s *TopicDeadLetterSink) publish(letter *DeadLetter) {
+    data, err := json.Marshal(letter)
+    if err != nil {
+        s.logger.Error("failed to encode dead letter", "error", err)
+        return
+    }
+
+    action := &rule.Action{
+        Topic:   s.topic,
+        Payload: string(data),
+        Broker:  s.connection,
+    }
+    if err := s.router.RouteAction(action); err != nil {
+        s.logger.Error("failed to publish dead letter",
+            "topic", s.topic,
+            "stage", letter.Stage,
+            "error", err)
+    }
+}
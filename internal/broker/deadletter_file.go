@@ -0,0 +1,114 @@
+package broker
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+)
+
+// maxDeadLetterLine bounds a single JSONL record when reading a store
+const maxDeadLetterLine = 16 * 1024 * 1024
+
+// FileDeadLetterSink appends dead letters as JSON lines to a local file so
+// failures are kept even while the brokers are unreachable. The file is
+// rotated to path.1, path.2, ... once it reaches maxSize, keeping at most
+// maxFiles rotated files.
+type FileDeadLetterSink struct {
+    file   *rotatingFile
+    logger *logger.Logger
+}
+
+// NewFileDeadLetterSink opens, or creates, the dead-letter file at path. A
+// maxSize of 0 disables rotation.
+func NewFileDeadLetterSink(path string, maxSize int64, maxFiles int, log *logger.Logger) (*FileDeadLetterSink, error) {
+    file, err := openRotatingFile(path, "dead-letter", maxSize, maxFiles, log)
+    if err != nil {
+        return nil, err
+    }
+
+    return &FileDeadLetterSink{file: file, logger: log}, nil
+}
+
+// DeadLetter implements DeadLetterSink
+func (s *FileDeadLetterSink) DeadLetter(letter *DeadLetter) {
+    data, err := json.Marshal(letter)
+    if err != nil {
+        s.logger.Error("failed to encode dead letter", "error", err)
+        return
+    }
+    data = append(data, '\n')
+
+    if err := s.file.Write(data); errors.Is(err, errFileClosed) {
+        s.logger.Error("dropping dead letter, file is closed",
+            "path", s.file.path,
+            "stage", letter.Stage)
+    } else if err != nil {
+        s.logger.Error("failed to write dead letter",
+            "path", s.file.path,
+            "error", err)
+    }
+}
+
+// Close closes the file; later dead letters are dropped
+func (s *FileDeadLetterSink) Close() error {
+    return s.file.Close()
+}
+
+// DeadLetterFilter selects dead letters for replay. Zero fields match everything.
+type DeadLetterFilter struct {
+    RuleID string
+    Stage  string
+    Since  time.Time
+    Until  time.Time
+}
+
+// Match reports whether a dead letter passes the filter
+func (f DeadLetterFilter) Match(letter *DeadLetter) bool {
+    if f.RuleID != "" && letter.RuleID != f.RuleID {
+        return false
+    }
+    if f.Stage != "" && letter.Stage != f.Stage {
+        return false
+    }
+    if !f.Since.IsZero() && letter.Timestamp.Before(f.Since) {
+        return false
+    }
+    if !f.Until.IsZero() && !letter.Timestamp.Before(f.Until) {
+        return false
+    }
+    return true
+}
+
+// ReadDeadLetters calls fn for every dead letter in a JSONL file, in order.
+// Reading stops at the first error returned by fn.
+func ReadDeadLetters(path string, fn func(letter *DeadLetter) error) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("failed to open dead-letter file: %w", err)
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64*1024), maxDeadLetterLine)
+
+    for line := 1; scanner.Scan(); line++ {
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+
+        var letter DeadLetter
+        if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+            return fmt.Errorf("invalid dead letter at %s:%d: %w", path, line, err)
+        }
+        if err := fn(&letter); err != nil {
+            return err
+        }
+    }
+
+    return scanner.Err()
+}
@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+func newTestFileSink(t *testing.T, path string, maxSize int64, maxFiles int) *FileDeadLetterSink {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	sink, err := NewFileDeadLetterSink(path, maxSize, maxFiles, &logger.Logger{Logger: zapLogger})
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func testLetter(topic, ruleID, stage string, at time.Time) *DeadLetter {
+	return NewDeadLetter("default", &rule.Failure{
+		Topic:   topic,
+		Payload: []byte(`{"temperature":31}`),
+		RuleID:  ruleID,
+		Stage:   stage,
+		Err:     errors.New("failed"),
+		Time:    at,
+	})
+}
+
+func readAll(t *testing.T, path string) []*DeadLetter {
+	t.Helper()
+	var letters []*DeadLetter
+	require.NoError(t, ReadDeadLetters(path, func(letter *DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}))
+	return letters
+}
+
+func TestFileDeadLetterSink_AppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq", "deadletter.jsonl")
+	sink := newTestFileSink(t, path, 0, 0)
+
+	at := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	sink.DeadLetter(testLetter("sensors/1", "", rule.StageDecode, at))
+	sink.DeadLetter(testLetter("alerts/1", "r1", rule.StagePublish, at.Add(time.Minute)))
+	require.NoError(t, sink.Close())
+
+	letters := readAll(t, path)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "sensors/1", letters[0].Topic)
+	assert.Equal(t, rule.StageDecode, letters[0].Stage)
+	assert.Equal(t, "r1", letters[1].RuleID)
+	assert.Equal(t, at.Add(time.Minute), letters[1].Timestamp)
+
+	payload, err := letters[1].DecodePayload()
+	require.NoError(t, err)
+	assert.Equal(t, `{"temperature":31}`, string(payload))
+
+	// Reopening appends to the existing file
+	sink = newTestFileSink(t, path, 0, 0)
+	sink.DeadLetter(testLetter("sensors/2", "", rule.StageDecode, at))
+	require.NoError(t, sink.Close())
+	assert.Len(t, readAll(t, path), 3)
+}
+
+func TestFileDeadLetterSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	sink := newTestFileSink(t, path, 300, 2)
+
+	for i := 0; i < 10; i++ {
+		sink.DeadLetter(testLetter(fmt.Sprintf("sensors/%d", i), "", rule.StageDecode, time.Now()))
+	}
+	require.NoError(t, sink.Close())
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		require.NoError(t, err, name)
+		assert.LessOrEqual(t, info.Size(), int64(300), name)
+	}
+	_, err := os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "only maxFiles rotated files are kept")
+
+	// The newest letter is in the current file and the oldest kept ones in .2
+	current := readAll(t, path)
+	assert.Equal(t, "sensors/9", current[len(current)-1].Topic)
+	assert.NotEmpty(t, readAll(t, path+".2"))
+}
+
+func TestDeadLetterFilter_Match(t *testing.T) {
+	at := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	letter := testLetter("alerts/1", "r1", rule.StagePublish, at)
+
+	tests := []struct {
+		name   string
+		filter DeadLetterFilter
+		want   bool
+	}{
+		{"empty filter", DeadLetterFilter{}, true},
+		{"matching rule", DeadLetterFilter{RuleID: "r1"}, true},
+		{"other rule", DeadLetterFilter{RuleID: "r2"}, false},
+		{"matching stage", DeadLetterFilter{Stage: rule.StagePublish}, true},
+		{"other stage", DeadLetterFilter{Stage: rule.StageDecode}, false},
+		{"since inclusive", DeadLetterFilter{Since: at}, true},
+		{"since later", DeadLetterFilter{Since: at.Add(time.Second)}, false},
+		{"until exclusive", DeadLetterFilter{Until: at}, false},
+		{"within range", DeadLetterFilter{Since: at.Add(-time.Hour), Until: at.Add(time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(letter))
+		})
+	}
+}
+
+func TestReadDeadLetters_InvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"topic\":\"a\"}\n\nnot json\n"), 0644))
+
+	var count int
+	err := ReadDeadLetters(path, func(letter *DeadLetter) error {
+		count++
+		return nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ":3")
+	assert.Equal(t, 1, count)
+}
+
+func TestDeadLetterFanout(t *testing.T) {
+	first, second := &recordingSink{}, &recordingSink{}
+	fanout := NewDeadLetterFanout([]DeadLetterSink{first, second}, nil)
+
+	fanout.DeadLetter(testLetter("a", "", rule.StageDecode, time.Now()))
+	assert.Len(t, first.letters, 1)
+	assert.Len(t, second.letters, 1)
+}
+
+// recordingSink collects dead letters
+type recordingSink struct {
+	letters []*DeadLetter
+}
+
+func (s *recordingSink) DeadLetter(letter *DeadLetter) {
+	s.letters = append(s.letters, letter)
+}
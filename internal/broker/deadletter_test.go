@@ -0,0 +1,172 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+// recordingRouter collects routed actions
+type recordingRouter struct {
+	actions []*rule.Action
+	mu      sync.Mutex
+}
+
+func (r *recordingRouter) RouteAction(action *rule.Action) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, action)
+	return nil
+}
+
+// queueRouter routes every action to an outbound queue
+type queueRouter struct {
+	queue *OutboundQueue
+}
+
+func (r *queueRouter) RouteAction(action *rule.Action) error {
+	return r.queue.Enqueue(action)
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	failedAt := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	letter := NewDeadLetter("field-mqtt", &rule.Failure{
+		Topic:   "sensors/temperature",
+		Payload: []byte{0xff, 'x'},
+		Stage:   rule.StageDecode,
+		Err:     errors.New("invalid character"),
+		Time:    failedAt,
+	})
+
+	assert.Equal(t, "sensors/temperature", letter.Topic)
+	assert.Equal(t, "/3g=", letter.Payload)
+	assert.Equal(t, "invalid character", letter.Error)
+	assert.Equal(t, "field-mqtt", letter.Connection)
+	assert.Equal(t, rule.StageDecode, letter.Stage)
+	assert.Equal(t, failedAt, letter.Timestamp)
+
+	payload, err := letter.DecodePayload()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 'x'}, payload)
+}
+
+func TestActionFailure(t *testing.T) {
+	failure := ActionFailure(&rule.Action{Topic: "alerts/1", Payload: "{}", RuleID: "r1"}, errors.New("timeout"))
+
+	assert.Equal(t, "alerts/1", failure.Topic)
+	assert.Equal(t, []byte("{}"), failure.Payload)
+	assert.Equal(t, "r1", failure.RuleID)
+	assert.Equal(t, rule.StagePublish, failure.Stage)
+}
+
+func TestTopicDeadLetterSink(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	router := &recordingRouter{}
+	sink := NewTopicDeadLetterSink("router/deadletter", "nats-core", router, &logger.Logger{Logger: zapLogger}, nil)
+
+	sink.DeadLetter(NewDeadLetter("default", ActionFailure(
+		&rule.Action{Topic: "alerts/1", Payload: "hot", RuleID: "r1"}, errors.New("timeout"))))
+
+	// A dead letter that failed to publish is not dead-lettered again
+	sink.DeadLetter(NewDeadLetter("nats-core", ActionFailure(
+		&rule.Action{Topic: "router/deadletter", Payload: "{}"}, errors.New("timeout"))))
+
+	// Close publishes the queued dead letters
+	require.NoError(t, sink.Close())
+
+	require.Len(t, router.actions, 1)
+	action := router.actions[0]
+	assert.Equal(t, "router/deadletter", action.Topic)
+	assert.Equal(t, "nats-core", action.Broker)
+
+	var envelope map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(action.Payload), &envelope))
+	assert.Equal(t, "alerts/1", envelope["topic"])
+	assert.Equal(t, "aG90", envelope["payload"])
+	assert.Equal(t, "timeout", envelope["error"])
+	assert.Equal(t, "r1", envelope["ruleId"])
+	assert.Equal(t, "publish", envelope["stage"])
+	assert.Contains(t, envelope, "timestamp")
+
+	// Dead letters after close are dropped
+	sink.DeadLetter(NewDeadLetter("default", ActionFailure(&rule.Action{Topic: "alerts/2"}, errors.New("timeout"))))
+	assert.Len(t, router.actions, 1)
+}
+
+func TestTopicDeadLetterSink_SameConnectionDoesNotDeadlock(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	log := &logger.Logger{Logger: zapLogger}
+
+	var deadLetters atomic.Int32
+	var retrier *Retrier
+	publish := func(action *rule.Action) error {
+		if action.Topic == "router/deadletter" {
+			deadLetters.Add(1)
+			return nil
+		}
+		// The sender hands failures to the retrier, which gives up at once
+		retrier.Retry(action, errors.New("unavailable"))
+		return nil
+	}
+
+	// A single slot, so that giving up happens while the queue is full
+	queue := NewOutboundQueue("test", 1, publish, alwaysConnected, nil, log, nil)
+	queue.Start()
+
+	sink := NewTopicDeadLetterSink("router/deadletter", "", &queueRouter{queue: queue}, log, nil)
+	retrier = NewRetrier("test", RetryPolicy{MaxAttempts: 1}, publish, log, nil)
+	retrier.SetGiveUpHandler(func(action *rule.Action, err error) {
+		sink.DeadLetter(NewDeadLetter("test", ActionFailure(action, err)))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, queue.Enqueue(&rule.Action{Topic: "alerts/1"}))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueueing deadlocked")
+	}
+
+	assert.Eventually(t, func() bool { return deadLetters.Load() == 20 },
+		5*time.Second, 10*time.Millisecond, "every failure is dead-lettered")
+
+	require.NoError(t, sink.Close())
+	retrier.Close()
+	queue.Close()
+}
+
+func TestRetrier_GiveUpHandler(t *testing.T) {
+	r := newTestRetrier(t, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+		func(action *rule.Action) error { return nil })
+
+	var gaveUp []string
+	r.SetGiveUpHandler(func(action *rule.Action, err error) {
+		gaveUp = append(gaveUp, action.Topic)
+	})
+
+	r.Retry(&rule.Action{Topic: "a", Retry: &rule.RetryPolicy{MaxAttempts: 1}}, errors.New("unavailable"))
+	r.Retry(&rule.Action{Topic: "b"}, errors.New("unavailable"))
+	assert.Equal(t, []string{"a"}, gaveUp)
+
+	// Retries abandoned at close are handed over as well
+	r.Close()
+	assert.Equal(t, []string{"a", "b"}, gaveUp)
+}
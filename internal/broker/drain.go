@@ -0,0 +1,29 @@
+package broker
+
+import (
+    "context"
+)
+
+// DrainPublishing drains the outbound side of a connection in order: the
+// outbound queue, then the batcher, then pending retries. The queue and
+// batcher are optional. Failed publishes during the drain are still retried
+// until ctx is done.
+func DrainPublishing(ctx context.Context, outbound *OutboundQueue, batcher *Batcher, retrier *Retrier) DrainStats {
+    var stats DrainStats
+
+    if outbound != nil {
+        drained, abandoned := outbound.Drain(ctx)
+        stats.Add(DrainStats{Drained: drained, Abandoned: abandoned})
+    }
+
+    // Flushing a batch is bounded by the publisher's own timeouts. Actions
+    // that fail the flush go to the retrier and are counted by its drain.
+    if batcher != nil {
+        stats.Drained += batcher.Close()
+    }
+
+    drained, abandoned := retrier.Drain(ctx)
+    stats.Add(DrainStats{Drained: drained, Abandoned: abandoned})
+
+    return stats
+}
@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+func TestDrainPublishing_CountsFailedFlushOnce(t *testing.T) {
+	log := &logger.Logger{Logger: zap.NewNop()}
+	failed := &rule.Action{Topic: "alerts/2"}
+
+	retrier := newTestRetrier(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Second},
+		func(action *rule.Action) error { return nil })
+	batcher := NewBatcher("test", 10, time.Hour, func(actions []*rule.Action) error {
+		return &ActionError{Action: failed, Err: errors.New("message too large")}
+	}, log, nil)
+	batcher.SetFailureHandler(retrier.Retry)
+
+	batcher.Add(&rule.Action{Topic: "alerts/1"})
+	batcher.Add(failed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stats := DrainPublishing(ctx, nil, batcher, retrier)
+
+	// The failed action is drained by its retry, not by the flush
+	assert.Equal(t, DrainStats{Drained: 2}, stats)
+}
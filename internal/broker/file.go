@@ -0,0 +1,152 @@
+package broker
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// FileTargetConfig configures the file target
+type FileTargetConfig struct {
+    Dir      string // Relative action paths are resolved against Dir
+    MaxSize  int64  // Rotate once a file reaches this size, 0 disables rotation
+    MaxFiles int    // Rotated files kept per path
+}
+
+// FileRecord is the JSON line a file action appends
+type FileRecord struct {
+    Timestamp  time.Time       `json:"timestamp"`
+    Topic      string          `json:"topic,omitempty"`
+    RuleID     string          `json:"ruleId,omitempty"`
+    Connection string          `json:"connection,omitempty"` // Source connection of the rule
+    Payload    json.RawMessage `json:"payload"`              // Embedded when valid JSON, otherwise a string
+}
+
+// FileTarget delivers file actions by appending them as JSON lines to
+// rotating local files. Files are opened on first use and writes are
+// synchronous, so there is nothing to drain.
+type FileTarget struct {
+    cfg     FileTargetConfig
+    logger  *logger.Logger
+    metrics *metrics.Metrics
+
+    files  map[string]*rotatingFile
+    closed bool
+    mu     sync.Mutex
+}
+
+// NewFileTarget creates a file target
+func NewFileTarget(cfg FileTargetConfig, log *logger.Logger, metricsService *metrics.Metrics) *FileTarget {
+    return &FileTarget{
+        cfg:     cfg,
+        logger:  log,
+        metrics: metricsService,
+        files:   make(map[string]*rotatingFile),
+    }
+}
+
+// Deliver implements ActionTarget by appending the action to its file
+func (t *FileTarget) Deliver(action *rule.Action) error {
+    if action.File == nil {
+        return fmt.Errorf("action is not a file action")
+    }
+
+    data, err := json.Marshal(NewFileRecord(action, time.Now()))
+    if err != nil {
+        return fmt.Errorf("failed to encode file record: %w", err)
+    }
+    data = append(data, '\n')
+
+    file, err := t.file(action.File.Path)
+    if err == nil {
+        err = file.Write(data)
+    }
+    t.recordResult(err)
+    if err != nil {
+        return fmt.Errorf("failed to write file action: %w", err)
+    }
+    return nil
+}
+
+// recordResult counts a delivered or failed action
+func (t *FileTarget) recordResult(err error) {
+    if t.metrics == nil {
+        return
+    }
+    if err != nil {
+        t.metrics.IncActionsTotal("error")
+        return
+    }
+    t.metrics.IncActionsTotal("success")
+}
+
+// NewFileRecord builds the record of an action written at the given time
+func NewFileRecord(action *rule.Action, at time.Time) *FileRecord {
+    record := &FileRecord{
+        Timestamp:  at.UTC(),
+        Topic:      action.Topic,
+        RuleID:     action.RuleID,
+        Connection: action.SourceConnection,
+    }
+    if json.Valid([]byte(action.Payload)) {
+        record.Payload = json.RawMessage(action.Payload)
+    } else {
+        // Strings always encode
+        record.Payload, _ = json.Marshal(action.Payload)
+    }
+    return record
+}
+
+// file returns the open file for an action path, opening it on first use
+func (t *FileTarget) file(path string) (*rotatingFile, error) {
+    if !filepath.IsAbs(path) {
+        path = filepath.Join(t.cfg.Dir, path)
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if t.closed {
+        return nil, ErrOutboundClosed
+    }
+    if file, ok := t.files[path]; ok {
+        return file, nil
+    }
+
+    file, err := openRotatingFile(path, "action", t.cfg.MaxSize, t.cfg.MaxFiles, t.logger)
+    if err != nil {
+        return nil, err
+    }
+    t.files[path] = file
+    t.logger.Info("opened action file", "path", path)
+    return file, nil
+}
+
+// Drain implements ActionTarget. Actions are written as they are delivered,
+// so there is nothing left to deliver.
+func (t *FileTarget) Drain(ctx context.Context) DrainStats {
+    return DrainStats{}
+}
+
+// Close implements ActionTarget by closing every file
+func (t *FileTarget) Close() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.closed = true
+    for path, file := range t.files {
+        if err := file.Close(); err != nil {
+            t.logger.Error("failed to close action file",
+                "path", path,
+                "error", err)
+        }
+    }
+    t.files = make(map[string]*rotatingFile)
+}
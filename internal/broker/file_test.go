@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+func newTestFileTarget(t *testing.T, maxSize int64, maxFiles int) (*FileTarget, string) {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	target := NewFileTarget(FileTargetConfig{Dir: dir, MaxSize: maxSize, MaxFiles: maxFiles}, &logger.Logger{Logger: zapLogger}, nil)
+	t.Cleanup(target.Close)
+	return target, dir
+}
+
+// readFileRecords reads the JSON lines of an action file
+func readFileRecords(t *testing.T, path string) []FileRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []FileRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record FileRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestFileTarget_AppendsRecords(t *testing.T) {
+	target, dir := newTestFileTarget(t, 0, 0)
+
+	require.NoError(t, target.Deliver(&rule.Action{
+		Topic:            "alerts/dev-1",
+		Payload:          `{"temp":30}`,
+		RuleID:           "alerts#0",
+		SourceConnection: "field",
+		File:             &rule.FileAction{Path: "audit/alerts.jsonl"},
+	}))
+	require.NoError(t, target.Deliver(&rule.Action{
+		Payload: "plain text",
+		File:    &rule.FileAction{Path: "audit/alerts.jsonl"},
+	}))
+
+	records := readFileRecords(t, filepath.Join(dir, "audit", "alerts.jsonl"))
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "alerts/dev-1", records[0].Topic)
+	assert.Equal(t, "alerts#0", records[0].RuleID)
+	assert.Equal(t, "field", records[0].Connection)
+	assert.JSONEq(t, `{"temp":30}`, string(records[0].Payload), "JSON payloads are embedded")
+	assert.WithinDuration(t, time.Now(), records[0].Timestamp, time.Minute)
+
+	assert.Empty(t, records[1].Topic)
+	assert.Equal(t, `"plain text"`, string(records[1].Payload), "other payloads are strings")
+}
+
+func TestFileTarget_AbsolutePath(t *testing.T) {
+	target, _ := newTestFileTarget(t, 0, 0)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	require.NoError(t, target.Deliver(&rule.Action{Topic: "a", Payload: "1", File: &rule.FileAction{Path: path}}))
+	assert.Len(t, readFileRecords(t, path), 1)
+}
+
+func TestFileTarget_Rotation(t *testing.T) {
+	target, dir := newTestFileTarget(t, 200, 1)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, target.Deliver(&rule.Action{
+			Topic:   "alerts/dev-1",
+			Payload: `{"temp":30}`,
+			File:    &rule.FileAction{Path: "alerts.jsonl"},
+		}))
+	}
+
+	path := filepath.Join(dir, "alerts.jsonl")
+	assert.NotEmpty(t, readFileRecords(t, path))
+	assert.NotEmpty(t, readFileRecords(t, path+".1"))
+	_, err := os.Stat(path + ".2")
+	assert.True(t, os.IsNotExist(err), "only maxFiles rotated files are kept")
+}
+
+func TestFileTarget_RotationFailure(t *testing.T) {
+	target, dir := newTestFileTarget(t, 200, 1)
+	path := filepath.Join(dir, "alerts.jsonl")
+
+	// A non-empty directory in the way of path.1 makes the rename fail
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocked"), 0755))
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, target.Deliver(&rule.Action{
+			Topic:   "alerts/dev-1",
+			Payload: `{"temp":30}`,
+			File:    &rule.FileAction{Path: "alerts.jsonl"},
+		}), "writes keep appending to the current file")
+	}
+
+	assert.Len(t, readFileRecords(t, path), 10)
+}
+
+func TestFileTarget_RejectsAfterClose(t *testing.T) {
+	target, _ := newTestFileTarget(t, 0, 0)
+	target.Close()
+
+	err := target.Deliver(&rule.Action{Topic: "a", Payload: "1", File: &rule.FileAction{Path: "alerts.jsonl"}})
+	assert.ErrorIs(t, err, ErrOutboundClosed)
+}
@@ -0,0 +1,147 @@
+package broker
+
+import (
+    "sync"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+)
+
+// FlowController pauses message consumption on a connection while any of the
+// outbound queues it watches is above its high watermark and resumes it once
+// every such queue has drained to the low watermark. Each connection has its
+// own controller, so a backed-up connection does not pause the others.
+type FlowController struct {
+    name    string // Connection whose consumption is controlled
+    high    int
+    low     int
+    logger  *logger.Logger
+    metrics *metrics.Metrics
+
+    // over holds the queues that crossed the high watermark
+    over   map[string]struct{}
+    resume chan struct{}
+    closed bool
+    mu     sync.Mutex
+}
+
+// NewFlowController creates a flow controller for the named connection with
+// the given watermarks
+func NewFlowController(name string, high, low int, log *logger.Logger, metricsService *metrics.Metrics) *FlowController {
+    return &FlowController{
+        name:    name,
+        high:    high,
+        low:     low,
+        logger:  log,
+        metrics: metricsService,
+        over:    make(map[string]struct{}),
+        resume:  make(chan struct{}),
+    }
+}
+
+// Update records the current depth of the named queue
+func (f *FlowController) Update(name string, depth int) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.closed {
+        return
+    }
+
+    wasPaused := len(f.over) > 0
+
+    if depth >= f.high {
+        f.over[name] = struct{}{}
+    } else if depth <= f.low {
+        delete(f.over, name)
+    }
+
+    paused := len(f.over) > 0
+    if paused == wasPaused {
+        return
+    }
+
+    if paused {
+        f.logger.Info("pausing consumption, outbound queue above high watermark",
+            "connection", f.name,
+            "queue", name,
+            "depth", depth,
+            "highWatermark", f.high)
+    } else {
+        f.logger.Info("resuming consumption, outbound queues below low watermark",
+            "connection", f.name,
+            "queue", name,
+            "depth", depth,
+            "lowWatermark", f.low)
+        close(f.resume)
+        f.resume = make(chan struct{})
+    }
+
+    if f.metrics != nil {
+        f.metrics.SetConsumptionPaused(f.name, paused)
+    }
+}
+
+// Paused reports whether consumption is currently paused
+func (f *FlowController) Paused() bool {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return !f.closed && len(f.over) > 0
+}
+
+// Wait blocks while consumption is paused
+func (f *FlowController) Wait() {
+    f.wait(nil)
+}
+
+// WaitTimeout blocks while consumption is paused, for at most timeout. It
+// reports whether consumption resumed; a timed out wait is counted.
+func (f *FlowController) WaitTimeout(timeout time.Duration) bool {
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+
+    if f.wait(timer.C) {
+        return true
+    }
+    if f.metrics != nil {
+        f.metrics.IncBackpressureWaitTimeouts(f.name)
+    }
+    return false
+}
+
+// wait blocks while consumption is paused until timeout fires, reporting
+// whether consumption resumed. A nil timeout never fires.
+func (f *FlowController) wait(timeout <-chan time.Time) bool {
+    for {
+        f.mu.Lock()
+        if f.closed || len(f.over) == 0 {
+            f.mu.Unlock()
+            return true
+        }
+        resume := f.resume
+        f.mu.Unlock()
+
+        select {
+        case <-resume:
+        case <-timeout:
+            return false
+        }
+    }
+}
+
+// Close releases all waiters and disables pausing, used during shutdown
+func (f *FlowController) Close() {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.closed {
+        return
+    }
+    f.closed = true
+    close(f.resume)
+
+    if f.metrics != nil {
+        f.metrics.SetConsumptionPaused(f.name, false)
+    }
+}
@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+)
+
+func newTestFlowController(t *testing.T, high, low int) *FlowController {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	return NewFlowController("test", high, low, &logger.Logger{Logger: zapLogger}, nil)
+}
+
+// waitReturned reports whether Wait returns within the timeout
+func waitReturned(f *FlowController, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		f.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestFlowController_Watermarks(t *testing.T) {
+	f := newTestFlowController(t, 8, 2)
+
+	f.Update("a", 5)
+	assert.False(t, f.Paused())
+
+	f.Update("a", 8)
+	assert.True(t, f.Paused())
+
+	// Between the watermarks the state is kept (hysteresis)
+	f.Update("a", 5)
+	assert.True(t, f.Paused())
+
+	f.Update("a", 2)
+	assert.False(t, f.Paused())
+}
+
+func TestFlowController_MultipleQueues(t *testing.T) {
+	f := newTestFlowController(t, 8, 2)
+
+	f.Update("a", 9)
+	f.Update("b", 10)
+	f.Update("a", 0)
+	assert.True(t, f.Paused(), "still paused while b is above its low watermark")
+
+	f.Update("b", 1)
+	assert.False(t, f.Paused())
+}
+
+func TestFlowController_Wait(t *testing.T) {
+	f := newTestFlowController(t, 8, 2)
+
+	assert.True(t, waitReturned(f, time.Second), "Wait should not block when not paused")
+
+	f.Update("a", 8)
+
+	done := make(chan struct{})
+	go func() {
+		f.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Wait returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.Update("a", 0)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after resume")
+	}
+}
+
+func TestFlowController_WaitTimeout(t *testing.T) {
+	f := newTestFlowController(t, 8, 2)
+	assert.True(t, f.WaitTimeout(time.Second), "WaitTimeout should not block when not paused")
+
+	f.Update("a", 8)
+	start := time.Now()
+	assert.False(t, f.WaitTimeout(20*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f.Update("a", 0)
+	}()
+	assert.True(t, f.WaitTimeout(time.Second), "WaitTimeout should return once resumed")
+}
+
+func TestFlowController_Close(t *testing.T) {
+	f := newTestFlowController(t, 8, 2)
+	f.Update("a", 10)
+
+	f.Close()
+	assert.False(t, f.Paused())
+	assert.True(t, waitReturned(f, time.Second), "Close should release waiters")
+
+	// Further updates are harmless after close
+	f.Update("a", 0)
+	f.Update("a", 10)
+	assert.True(t, waitReturned(f, time.Second))
+}
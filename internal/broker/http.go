@@ -0,0 +1,333 @@
+package broker
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// HTTPTargetName is the connection name http actions are reported under in
+// retry metrics and dead letters
+const HTTPTargetName = "http"
+
+// Defaults of the HTTP target
+const (
+    defaultHTTPTimeout   = 10 * time.Second
+    defaultHTTPWorkers   = 4
+    defaultHTTPQueueSize = 1000
+)
+
+// maxHTTPResponseBody bounds how much of a response is read so that the
+// connection can be reused
+const maxHTTPResponseBody = 64 * 1024
+
+// HTTPTargetConfig configures the HTTP target
+type HTTPTargetConfig struct {
+    Workers    int // Concurrent requests, default 4
+    QueueSize  int // Actions waiting for a worker, default 1000
+    Retry      RetryPolicy
+    DeadLetter DeadLetterSink // Receives actions given up on (optional)
+}
+
+// HTTPTarget delivers http actions as HTTP requests. Requests are sent by a
+// pool of workers; failed requests and unexpected statuses are retried with
+// the retry policy, overridden per action, and then dead-lettered.
+type HTTPTarget struct {
+    logger  *logger.Logger
+    metrics *metrics.Metrics
+    retrier *Retrier
+
+    queue     chan *rule.Action
+    done      chan struct{}
+    wg        sync.WaitGroup
+    closeOnce sync.Once
+
+    // clients holds one client per TLS configuration
+    clients map[rule.HTTPTLS]*http.Client
+    mu      sync.Mutex
+}
+
+// NewHTTPTarget creates an HTTP target and starts its workers
+func NewHTTPTarget(cfg HTTPTargetConfig, log *logger.Logger, metricsService *metrics.Metrics) *HTTPTarget {
+    if cfg.Workers < 1 {
+        cfg.Workers = defaultHTTPWorkers
+    }
+    if cfg.QueueSize < 1 {
+        cfg.QueueSize = defaultHTTPQueueSize
+    }
+
+    t := &HTTPTarget{
+        logger:  log,
+        metrics: metricsService,
+        queue:   make(chan *rule.Action, cfg.QueueSize),
+        done:    make(chan struct{}),
+        clients: make(map[rule.HTTPTLS]*http.Client),
+    }
+
+    t.retrier = NewRetrier(HTTPTargetName, cfg.Retry, t.send, log, metricsService)
+    if sink := cfg.DeadLetter; sink != nil {
+        t.retrier.SetGiveUpHandler(func(action *rule.Action, err error) {
+            letter := NewDeadLetter(HTTPTargetName, ActionFailure(action, err))
+            letter.HTTP = action.HTTP
+            sink.DeadLetter(letter)
+        })
+    }
+
+    for i := 0; i < cfg.Workers; i++ {
+        t.wg.Add(1)
+        go t.run()
+    }
+    return t
+}
+
+// Deliver implements ActionTarget by queueing the action, blocking while the
+// queue is full
+func (t *HTTPTarget) Deliver(action *rule.Action) error {
+    if action.HTTP == nil {
+        return fmt.Errorf("action is not an http action")
+    }
+
+    select {
+    case <-t.done:
+        return ErrOutboundClosed
+    default:
+    }
+
+    select {
+    case t.queue <- action:
+        return nil
+    case <-t.done:
+        return ErrOutboundClosed
+    }
+}
+
+// Drain implements ActionTarget. It stops accepting actions, sends the queued
+// ones and waits for pending retries until ctx is done.
+func (t *HTTPTarget) Drain(ctx context.Context) DrainStats {
+    queued := len(t.queue)
+    t.closeOnce.Do(func() {
+        close(t.done)
+    })
+
+    stopped := make(chan struct{})
+    go func() {
+        t.wg.Wait()
+        close(stopped)
+    }()
+
+    var stats DrainStats
+    select {
+    case <-stopped:
+        stats.Drained = queued
+    case <-ctx.Done():
+        abandoned := len(t.queue)
+        stats = DrainStats{Drained: queued - abandoned, Abandoned: abandoned}
+    }
+
+    drained, abandoned := t.retrier.Drain(ctx)
+    stats.Add(DrainStats{Drained: drained, Abandoned: abandoned})
+    return stats
+}
+
+// Close implements ActionTarget
+func (t *HTTPTarget) Close() {
+    t.closeOnce.Do(func() {
+        close(t.done)
+    })
+    t.retrier.Close()
+}
+
+// Stats returns the retry counters of the target
+func (t *HTTPTarget) Stats() RetryStats {
+    return t.retrier.Stats()
+}
+
+// run sends queued actions until the target closes, then sends the rest of
+// the queue unless the target was closed without a drain
+func (t *HTTPTarget) run() {
+    defer t.wg.Done()
+
+    for {
+        select {
+        case action := <-t.queue:
+            t.deliver(action)
+        case <-t.done:
+            for {
+                select {
+                case action := <-t.queue:
+                    t.deliver(action)
+                default:
+                    return
+                }
+            }
+        }
+    }
+}
+
+// deliver sends an action and hands it to the retrier when the request fails
+func (t *HTTPTarget) deliver(action *rule.Action) {
+    if err := t.send(action); err != nil {
+        t.retrier.Retry(action, err)
+    }
+}
+
+// send makes a single request for an action and checks its response status
+func (t *HTTPTarget) send(action *rule.Action) error {
+    spec := action.HTTP
+    start := time.Now()
+    endpoint := httpEndpoint(spec.URL)
+
+    status, err := t.do(spec, action.Payload)
+    if t.metrics != nil {
+        class := "error"
+        if status > 0 {
+            class = fmt.Sprintf("%dxx", status/100)
+        }
+        t.metrics.ObserveHTTPRequest(endpoint, class, time.Since(start))
+    }
+    if err != nil {
+        t.logger.Debug("http action failed",
+            "endpoint", endpoint,
+            "rule", action.RuleID,
+            "status", status,
+            "error", err)
+        return err
+    }
+    return nil
+}
+
+// do makes the request and returns the response status, or 0 when no
+// response was received
+func (t *HTTPTarget) do(spec *rule.HTTPAction, body string) (int, error) {
+    client, err := t.client(spec.TLS)
+    if err != nil {
+        return 0, err
+    }
+
+    // Timeouts are validated when rules are loaded
+    timeout := defaultHTTPTimeout
+    if d, err := time.ParseDuration(spec.Timeout); err == nil {
+        timeout = d
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    method := strings.ToUpper(spec.Method)
+    if method == "" {
+        method = http.MethodPost
+    }
+    req, err := http.NewRequestWithContext(ctx, method, spec.URL, bytes.NewReader([]byte(body)))
+    if err != nil {
+        return 0, fmt.Errorf("invalid request: %w", err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for name, value := range spec.Headers {
+        req.Header.Set(name, value)
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, maxHTTPResponseBody))
+
+    if !expectedStatus(spec.ExpectStatus, resp.StatusCode) {
+        return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// client returns the client for a TLS configuration, creating it on first use
+func (t *HTTPTarget) client(tlsCfg *rule.HTTPTLS) (*http.Client, error) {
+    var key rule.HTTPTLS
+    if tlsCfg != nil {
+        key = *tlsCfg
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if client, ok := t.clients[key]; ok {
+        return client, nil
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    if tlsCfg != nil {
+        config, err := newHTTPTLSConfig(key)
+        if err != nil {
+            return nil, err
+        }
+        transport.TLSClientConfig = config
+    }
+
+    client := &http.Client{Transport: transport}
+    t.clients[key] = client
+    return client, nil
+}
+
+// newHTTPTLSConfig loads the CA and client certificate of a TLS configuration
+func newHTTPTLSConfig(cfg rule.HTTPTLS) (*tls.Config, error) {
+    config := &tls.Config{
+        ServerName:         cfg.ServerName,
+        InsecureSkipVerify: cfg.InsecureSkipVerify,
+    }
+
+    if cfg.CAFile != "" {
+        ca, err := os.ReadFile(cfg.CAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read CA file: %w", err)
+        }
+        config.RootCAs = x509.NewCertPool()
+        if !config.RootCAs.AppendCertsFromPEM(ca) {
+            return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+        }
+    }
+
+    if cfg.CertFile != "" {
+        cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load client certificate: %w", err)
+        }
+        config.Certificates = []tls.Certificate{cert}
+    }
+
+    return config, nil
+}
+
+// expectedStatus reports whether a status is one of the expected ones, or
+// any 2xx status when none are set
+func expectedStatus(expected []int, status int) bool {
+    if len(expected) == 0 {
+        return status >= 200 && status < 300
+    }
+    for _, s := range expected {
+        if s == status {
+            return true
+        }
+    }
+    return false
+}
+
+// httpEndpoint returns the scheme and host of a URL, which labels its
+// metrics without the unbounded paths and query strings of rendered URLs
+func httpEndpoint(rawURL string) string {
+    u, err := url.Parse(rawURL)
+    if err != nil || u.Host == "" {
+        return "invalid"
+    }
+    return u.Scheme + "://" + u.Host
+}
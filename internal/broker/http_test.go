@@ -0,0 +1,243 @@
+package broker
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+// letterChan is a dead-letter sink safe to use from retry goroutines
+type letterChan chan *DeadLetter
+
+func (c letterChan) DeadLetter(letter *DeadLetter) { c <- letter }
+
+// receivedRequest is a request recorded by a test server
+type receivedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func newTestHTTPTarget(t *testing.T, policy RetryPolicy, sink DeadLetterSink) *HTTPTarget {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	target := NewHTTPTarget(HTTPTargetConfig{Retry: policy, DeadLetter: sink}, &logger.Logger{Logger: zapLogger}, nil)
+	t.Cleanup(target.Close)
+	return target
+}
+
+// recordingHandler records every request and answers with the given statuses
+// in turn, repeating the last one
+func recordingHandler(requests chan<- receivedRequest, statuses ...int) http.HandlerFunc {
+	var calls int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- receivedRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)}
+
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[i])
+	}
+}
+
+func receive(t *testing.T, requests <-chan receivedRequest) receivedRequest {
+	t.Helper()
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for request")
+		return receivedRequest{}
+	}
+}
+
+func TestHTTPTarget_Deliver(t *testing.T) {
+	requests := make(chan receivedRequest, 1)
+	server := httptest.NewServer(recordingHandler(requests, http.StatusOK))
+	defer server.Close()
+
+	target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 1}, nil)
+	require.NoError(t, target.Deliver(&rule.Action{
+		Payload: `{"alert":"high"}`,
+		HTTP: &rule.HTTPAction{
+			Method:  "put",
+			URL:     server.URL + "/hooks/device-1",
+			Headers: map[string]string{"Authorization": "Bearer token"},
+		},
+	}))
+
+	req := receive(t, requests)
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "/hooks/device-1", req.path)
+	assert.Equal(t, "Bearer token", req.header.Get("Authorization"))
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, `{"alert":"high"}`, req.body)
+
+	assert.Error(t, target.Deliver(&rule.Action{Topic: "alerts"}))
+}
+
+func TestHTTPTarget_RetriesUnexpectedStatus(t *testing.T) {
+	requests := make(chan receivedRequest, 3)
+	server := httptest.NewServer(recordingHandler(requests, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK))
+	defer server.Close()
+
+	target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}, nil)
+	require.NoError(t, target.Deliver(&rule.Action{Payload: "{}", HTTP: &rule.HTTPAction{URL: server.URL}}))
+
+	for i := 0; i < 3; i++ {
+		receive(t, requests)
+	}
+	assert.Eventually(t, func() bool { return target.Stats().Pending == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(2), target.Stats().Retries)
+	assert.Zero(t, target.Stats().Failures)
+}
+
+func TestHTTPTarget_GivesUp(t *testing.T) {
+	requests := make(chan receivedRequest, 2)
+	server := httptest.NewServer(recordingHandler(requests, http.StatusOK))
+	defer server.Close()
+
+	letters := make(letterChan, 1)
+	target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond}, letters)
+	require.NoError(t, target.Deliver(&rule.Action{
+		Payload: "{}",
+		RuleID:  "webhook",
+		Retry:   &rule.RetryPolicy{InitialBackoff: "1ms"},
+		HTTP:    &rule.HTTPAction{URL: server.URL + "/hook", ExpectStatus: []int{http.StatusAccepted}},
+	}))
+
+	select {
+	case letter := <-letters:
+		assert.Equal(t, server.URL+"/hook", letter.Topic)
+		assert.Equal(t, HTTPTargetName, letter.Connection)
+		assert.Equal(t, "webhook", letter.RuleID)
+		assert.Equal(t, rule.StagePublish, letter.Stage)
+		assert.Contains(t, letter.Error, "unexpected status 200")
+		require.NotNil(t, letter.HTTP, "the request options are kept for replay")
+		assert.Equal(t, []int{http.StatusAccepted}, letter.HTTP.ExpectStatus)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for dead letter")
+	}
+	assert.Len(t, requests, 2)
+}
+
+func TestHTTPTarget_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	letters := make(letterChan, 1)
+	target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 1}, letters)
+	require.NoError(t, target.Deliver(&rule.Action{HTTP: &rule.HTTPAction{URL: server.URL, Timeout: "50ms"}}))
+
+	select {
+	case letter := <-letters:
+		assert.Contains(t, letter.Error, "deadline exceeded")
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for dead letter")
+	}
+}
+
+func TestHTTPTarget_TLS(t *testing.T) {
+	requests := make(chan receivedRequest, 1)
+	server := httptest.NewTLSServer(recordingHandler(requests, http.StatusOK))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, ca, 0o600))
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		letters := make(letterChan, 1)
+		target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 1}, letters)
+		require.NoError(t, target.Deliver(&rule.Action{HTTP: &rule.HTTPAction{URL: server.URL}}))
+
+		select {
+		case letter := <-letters:
+			assert.Contains(t, letter.Error, "certificate")
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for dead letter")
+		}
+	})
+
+	t.Run("trusted CA", func(t *testing.T) {
+		target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 1}, nil)
+		require.NoError(t, target.Deliver(&rule.Action{HTTP: &rule.HTTPAction{
+			URL: server.URL,
+			TLS: &rule.HTTPTLS{CAFile: caFile},
+		}}))
+		receive(t, requests)
+	})
+
+	t.Run("missing CA file", func(t *testing.T) {
+		letters := make(letterChan, 1)
+		target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 1}, letters)
+		require.NoError(t, target.Deliver(&rule.Action{HTTP: &rule.HTTPAction{
+			URL: server.URL,
+			TLS: &rule.HTTPTLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		}}))
+
+		select {
+		case letter := <-letters:
+			assert.Contains(t, letter.Error, "failed to read CA file")
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for dead letter")
+		}
+	})
+}
+
+func TestHTTPTarget_Drain(t *testing.T) {
+	requests := make(chan receivedRequest, 5)
+	server := httptest.NewServer(recordingHandler(requests, http.StatusNoContent))
+	defer server.Close()
+
+	target := newTestHTTPTarget(t, RetryPolicy{MaxAttempts: 1}, nil)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, target.Deliver(&rule.Action{HTTP: &rule.HTTPAction{URL: server.URL}}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stats := target.Drain(ctx)
+	assert.Zero(t, stats.Abandoned)
+	assert.Len(t, requests, 5)
+
+	assert.ErrorIs(t, target.Deliver(&rule.Action{HTTP: &rule.HTTPAction{URL: server.URL}}), ErrOutboundClosed)
+}
+
+func TestExpectedStatus(t *testing.T) {
+	assert.True(t, expectedStatus(nil, http.StatusOK))
+	assert.True(t, expectedStatus(nil, http.StatusNoContent))
+	assert.False(t, expectedStatus(nil, http.StatusFound))
+	assert.False(t, expectedStatus(nil, http.StatusInternalServerError))
+	assert.True(t, expectedStatus([]int{http.StatusOK, http.StatusConflict}, http.StatusConflict))
+	assert.False(t, expectedStatus([]int{http.StatusAccepted}, http.StatusOK))
+}
+
+func TestHTTPEndpoint(t *testing.T) {
+	assert.Equal(t, "https://hooks.example.com", httpEndpoint("https://hooks.example.com/devices/1?token=x"))
+	assert.Equal(t, "http://localhost:8080", httpEndpoint("http://localhost:8080"))
+	assert.Equal(t, "invalid", httpEndpoint("not a url"))
+}
@@ -0,0 +1,491 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mqtt-mux-router/config"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/rule"
+)
+
+// Timeouts of the Kafka client
+const (
+	// pingInterval is how often the connection to the cluster is checked
+	pingInterval = 5 * time.Second
+	// pingTimeout bounds a single connection check
+	pingTimeout = 5 * time.Second
+	// produceTimeout bounds how long a produce waits for acknowledgement
+	// before it is handed to the retrier
+	produceTimeout = 10 * time.Second
+)
+
+// KafkaBroker implements the broker.Broker interface for Kafka. Rule topics
+// are consumed by a consumer group and actions are produced as records.
+type KafkaBroker struct {
+	logger    *logger.Logger
+	config    *config.Config
+	processor *rule.Processor
+	metrics   *metrics.Metrics
+	stats     broker.BrokerStats
+
+	// Named connection this broker serves and the router for actions
+	// targeting other connections
+	name       string
+	connConfig config.KafkaConfig
+	router     broker.ActionRouter
+
+	// batcher buffers outbound actions; nil when batching is disabled
+	batcher *broker.Batcher
+	// retrier republishes failed actions with backoff
+	retrier *broker.Retrier
+	// outbound queues actions for the sender; nil publishes synchronously
+	outbound *broker.OutboundQueue
+	// flow pauses consumption under backpressure (optional)
+	flow *broker.FlowController
+
+	client    Client
+	pub       Publisher
+	consumer  *consumer
+	connected atomic.Bool
+
+	// Store rules for reconnection
+	rules []rule.Rule
+
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+}
+
+// BrokerConfig contains Kafka broker configuration
+type BrokerConfig struct {
+	ProcessorWorkers int
+	QueueSize        int
+	BatchSize        int
+	BatchLinger      time.Duration
+	OverflowPolicy   string
+	OrderingKey      string
+	OrderingField    string
+
+	// Connection holds the named connection settings
+	Connection config.ConnectionConfig
+	// Router dispatches actions that target other connections (optional)
+	Router broker.ActionRouter
+
+	// OutboundQueueSize bounds the queue of actions awaiting publication;
+	// 0 publishes from the worker goroutines instead
+	OutboundQueueSize int
+	// Flow pauses consumption while outbound queues are backed up (optional)
+	Flow *broker.FlowController
+
+	// Retry is the default policy for failed publishes; actions may override it
+	Retry broker.RetryPolicy
+
+	// DeadLetter receives unprocessable messages and undeliverable actions (optional)
+	DeadLetter broker.DeadLetterSink
+
+	// State holds the state of stateful rules (optional, in memory by
+	// default); the processor closes it on shutdown
+	State rule.StateStore
+
+	// Lookups holds the reference tables rules read with lookup() (optional)
+	Lookups *rule.Lookups
+}
+
+// NewBroker creates a new Kafka broker instance
+func NewBroker(cfg *config.Config, log *logger.Logger, brokerCfg BrokerConfig, metricsService *metrics.Metrics) (broker.Broker, error) {
+	client, err := newClient(brokerCfg.Connection.Kafka)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBroker(cfg, log, brokerCfg, client, metricsService)
+
+	log.Info("connecting to Kafka cluster",
+		"connection", b.name,
+		"brokers", b.connConfig.Brokers)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to connect to Kafka cluster: %w", err)
+	}
+	b.setConnected(true)
+
+	b.wg.Add(1)
+	go b.monitor()
+
+	return b, nil
+}
+
+// newBroker creates a broker using the given client without connecting
+func newBroker(cfg *config.Config, log *logger.Logger, brokerCfg BrokerConfig, client Client, metricsService *metrics.Metrics) *KafkaBroker {
+	processorCfg := rule.ProcessorConfig{
+		Name:           brokerCfg.Connection.Name,
+		Workers:        brokerCfg.ProcessorWorkers,
+		QueueSize:      brokerCfg.QueueSize,
+		BatchSize:      brokerCfg.BatchSize,
+		OverflowPolicy: brokerCfg.OverflowPolicy,
+		OrderingKey:    brokerCfg.OrderingKey,
+		OrderingField:  brokerCfg.OrderingField,
+		State:          brokerCfg.State,
+		Lookups:        brokerCfg.Lookups,
+	}
+
+	processor := rule.NewProcessor(processorCfg, log, metricsService)
+
+	b := &KafkaBroker{
+		logger:     log,
+		config:     cfg,
+		processor:  processor,
+		metrics:    metricsService,
+		name:       brokerCfg.Connection.Name,
+		connConfig: brokerCfg.Connection.Kafka,
+		router:     brokerCfg.Router,
+		flow:       brokerCfg.Flow,
+		client:     client,
+		done:       make(chan struct{}),
+		stats: broker.BrokerStats{
+			LastReconnect: time.Now(),
+		},
+	}
+
+	b.pub = NewPublisher(b, client)
+	b.consumer = newConsumer(b, client)
+
+	// Retries bypass the batcher and outbound queue so that they never wait
+	// behind new actions
+	b.retrier = broker.NewRetrier(b.name, brokerCfg.Retry, b.pub.PublishAction, log, metricsService)
+
+	if sink := brokerCfg.DeadLetter; sink != nil {
+		b.processor.SetFailureHandler(func(failure *rule.Failure) {
+			sink.DeadLetter(broker.NewDeadLetter(b.name, failure))
+		})
+		b.retrier.SetGiveUpHandler(func(action *rule.Action, err error) {
+			sink.DeadLetter(broker.NewDeadLetter(b.name, broker.ActionFailure(action, err)))
+		})
+	}
+
+	// Batch outbound actions when more than one action fits in a batch
+	if brokerCfg.BatchSize > 1 {
+		b.batcher = broker.NewBatcher(b.name, brokerCfg.BatchSize, brokerCfg.BatchLinger,
+			b.pub.PublishBatch, log, metricsService)
+		b.batcher.SetFailureHandler(b.retrier.Retry)
+	}
+
+	if brokerCfg.OutboundQueueSize > 0 {
+		b.outbound = broker.NewOutboundQueue(b.name, brokerCfg.OutboundQueueSize,
+			b.publishNow, b.IsConnected, b.flow, log, metricsService)
+		b.outbound.Start()
+	}
+
+	// Workers and timers hand their results back to the broker for publishing
+	processor.SetResultHandler(b.handleResult)
+	processor.SetActionHandler(b.handleAction)
+
+	return b
+}
+
+// Start implements broker.Broker interface
+func (b *KafkaBroker) Start(ctx context.Context, rules []rule.Rule) error {
+	b.mu.Lock()
+	// Store rules for reconnection
+	b.rules = make([]rule.Rule, len(rules))
+	copy(b.rules, rules)
+	b.mu.Unlock()
+
+	if err := b.processor.LoadRules(rules); err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	// Topics of the rules and of their state sources
+	b.consumer.Subscribe(b.processor.GetTopics())
+
+	if b.metrics != nil {
+		b.metrics.SetConnectionRulesActive(b.name, float64(len(rules)))
+	}
+
+	// Start a goroutine to monitor context cancellation
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		select {
+		case <-ctx.Done():
+			b.logger.Info("context done, stopping kafka consumer")
+			b.StopConsuming()
+		case <-b.done:
+		}
+	}()
+
+	return nil
+}
+
+// Close implements broker.Broker interface
+func (b *KafkaBroker) Close() {
+	b.closeOnce.Do(func() {
+		b.logger.Info("shutting down Kafka broker")
+
+		// Stop fetching records
+		b.StopConsuming()
+
+		// Process queued messages, then flush queued and buffered actions while
+		// still connected
+		b.processor.Close()
+		if b.outbound != nil {
+			b.outbound.Close()
+		}
+		if b.batcher != nil {
+			b.batcher.Close()
+		}
+		b.retrier.Close()
+
+		// Commit the offsets of processed records and leave the group
+		close(b.done)
+		b.client.Close()
+		b.setConnected(false)
+
+		// Wait for all goroutines to complete
+		b.wg.Wait()
+	})
+}
+
+// StopConsuming implements broker.Drainer by stopping the consumer. Records
+// fetched but not yet queued are not committed, so the group receives them
+// again.
+func (b *KafkaBroker) StopConsuming() {
+	b.consumer.Stop()
+}
+
+// DrainProcessing implements broker.Drainer
+func (b *KafkaBroker) DrainProcessing(ctx context.Context) broker.DrainStats {
+	drained, abandoned := b.processor.Drain(ctx)
+	return broker.DrainStats{Drained: drained, Abandoned: abandoned}
+}
+
+// DrainOutbound implements broker.Drainer. Produces wait for their
+// acknowledgement, so there is nothing left to flush once the queues drain.
+func (b *KafkaBroker) DrainOutbound(ctx context.Context) broker.DrainStats {
+	stats := broker.DrainPublishing(ctx, b.outbound, b.batcher, b.retrier)
+
+	b.logger.Info("drained outbound actions",
+		"connection", b.name,
+		"drained", stats.Drained,
+		"abandoned", stats.Abandoned)
+
+	return stats
+}
+
+// IsConnected reports whether the last check reached the cluster
+func (b *KafkaBroker) IsConnected() bool {
+	return b.connected.Load()
+}
+
+// monitor checks the connection to the cluster until the broker closes
+func (b *KafkaBroker) monitor() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.checkConnection()
+		}
+	}
+}
+
+// checkConnection pings the cluster and records the result
+func (b *KafkaBroker) checkConnection() {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	err := b.client.Ping(ctx)
+	if err != nil && b.IsConnected() {
+		b.logger.Error("lost connection to Kafka cluster",
+			"connection", b.name,
+			"error", err)
+	}
+	b.setConnected(err == nil)
+}
+
+// setConnected records the connection status, counting a reconnect when a
+// lost connection comes back
+func (b *KafkaBroker) setConnected(connected bool) {
+	was := b.connected.Swap(connected)
+	if was == connected {
+		return
+	}
+
+	b.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.SetConnectionStatus("kafka", b.name, connected)
+	})
+	if !connected {
+		return
+	}
+
+	b.logger.Info("connected to Kafka cluster", "connection", b.name)
+
+	// The first connection is made before Start stores the rules
+	b.mu.RLock()
+	started := b.rules != nil
+	b.mu.RUnlock()
+	if started {
+		b.stats.LastReconnect = time.Now()
+		b.safeMetricsUpdate(func(m *metrics.Metrics) {
+			m.IncReconnects("kafka", b.name)
+		})
+	}
+}
+
+// GetStats implements broker.Broker interface
+func (b *KafkaBroker) GetStats() broker.BrokerStats {
+	connStats := broker.ConnectionStats{
+		Type:              "kafka",
+		Connected:         b.IsConnected(),
+		Subscribed:        b.consumer.IsSubscribed(),
+		Topics:            len(b.consumer.Topics()),
+		MessagesReceived:  atomic.LoadUint64(&b.stats.MessagesReceived),
+		MessagesPublished: atomic.LoadUint64(&b.stats.MessagesPublished),
+		LastReconnect:     b.stats.LastReconnect,
+		Errors:            atomic.LoadUint64(&b.stats.Errors),
+	}
+	retryStats := b.retrier.Stats()
+	connStats.PublishRetries = retryStats.Retries
+	connStats.PublishFailures = retryStats.Failures
+	connStats.QueueDepth = b.processor.QueueDepth()
+	if b.outbound != nil {
+		connStats.OutboundDepth = b.outbound.Depth()
+	}
+
+	b.mu.RLock()
+	// Rules are stored by Start, even when none are bound to the connection
+	connStats.RulesLoaded = b.rules != nil
+	connStats.Rules = len(b.rules)
+	b.mu.RUnlock()
+
+	return broker.BrokerStats{
+		MessagesReceived:  connStats.MessagesReceived,
+		MessagesPublished: connStats.MessagesPublished,
+		LastReconnect:     connStats.LastReconnect,
+		Errors:            connStats.Errors,
+		Connections: map[string]broker.ConnectionStats{
+			b.name: connStats,
+		},
+	}
+}
+
+// ProcessorReport implements broker.ProcessorReporter
+func (b *KafkaBroker) ProcessorReport() rule.ProcessorReport {
+	return b.processor.Report()
+}
+
+// PublishAction implements broker.Broker interface by producing on this connection
+func (b *KafkaBroker) PublishAction(action *rule.Action) error {
+	if action == nil {
+		return fmt.Errorf("action cannot be nil")
+	}
+	if b.outbound != nil {
+		return b.outbound.Enqueue(action)
+	}
+	return b.publishNow(action)
+}
+
+// publishNow produces an action without queueing, through the batcher when
+// enabled. Failed produces are handed to the retrier, which owns them from
+// then on, so no error is returned.
+func (b *KafkaBroker) publishNow(action *rule.Action) error {
+	if b.batcher != nil {
+		b.batcher.Add(action)
+		return nil
+	}
+	if err := b.pub.PublishAction(action); err != nil {
+		b.retrier.Retry(action, err)
+	}
+	return nil
+}
+
+// handleResult publishes the actions for a message processed by the worker pool
+func (b *KafkaBroker) handleResult(result *rule.ProcessingResult) {
+	msg := result.Message
+
+	if result.Error != nil {
+		atomic.AddUint64(&b.stats.Errors, 1)
+		b.safeMetricsUpdate(func(m *metrics.Metrics) {
+			m.IncMessagesTotal("error")
+			m.IncConnectionMessages(b.name, "error")
+		})
+		b.logger.Error("failed to process message",
+			"error", result.Error,
+			"topic", msg.Topic)
+		return
+	}
+
+	b.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.IncMessagesTotal("processed")
+	})
+
+	// Publish resulting actions
+	for _, action := range msg.Actions {
+		if err := b.dispatchAction(action); err != nil {
+			b.logger.Error("failed to publish action",
+				"error", err,
+				"topic", action.Topic)
+		}
+	}
+
+	b.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.SetProcessingBacklog(b.name, float64(b.backlog()))
+	})
+}
+
+// handleAction publishes an action fired by a processor timer
+func (b *KafkaBroker) handleAction(action *rule.Action) {
+	if err := b.dispatchAction(action); err != nil {
+		b.logger.Error("failed to publish action",
+			"error", err,
+			"rule", action.RuleID,
+			"topic", action.Topic)
+	}
+}
+
+// backlog returns the number of messages waiting to be processed and of
+// actions waiting to be published on the connection
+func (b *KafkaBroker) backlog() int {
+	backlog := b.processor.QueueDepth()
+	if b.outbound != nil {
+		backlog += b.outbound.Depth()
+	}
+	if b.batcher != nil {
+		backlog += b.batcher.Pending()
+	}
+	return backlog
+}
+
+// dispatchAction publishes an action on this connection, or hands it to the
+// router when it targets another connection or is not a publish
+func (b *KafkaBroker) dispatchAction(action *rule.Action) error {
+	if action.SourceConnection == "" {
+		action.SourceConnection = b.name
+	}
+	if b.router != nil && (action.Kind() != rule.ActionPublish || (action.Broker != "" && action.Broker != b.name)) {
+		return b.router.RouteAction(action)
+	}
+	return b.PublishAction(action)
+}
+
+// safeMetricsUpdate safely updates metrics if they are enabled
+func (b *KafkaBroker) safeMetricsUpdate(fn func(*metrics.Metrics)) {
+	if b.metrics != nil {
+		fn(b.metrics)
+	}
+}
@@ -0,0 +1,197 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/config"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+func newTestBroker(t *testing.T, client *fakeClient, brokerCfg BrokerConfig) *KafkaBroker {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	brokerCfg.ProcessorWorkers = 1
+	brokerCfg.QueueSize = 16
+	brokerCfg.Connection.Name = "events"
+	brokerCfg.Connection.Type = "kafka"
+
+	b := newBroker(&config.Config{}, &logger.Logger{Logger: zapLogger}, brokerCfg, client, nil)
+	t.Cleanup(b.Close)
+	return b
+}
+
+// header returns the value of a record header
+func header(record *Record, key string) string {
+	for _, h := range record.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestBroker_ConsumesAndProduces(t *testing.T) {
+	client := newFakeClient()
+	b := newTestBroker(t, client, BrokerConfig{
+		Connection: config.ConnectionConfig{Kafka: config.KafkaConfig{
+			Headers: map[string]string{
+				"x-source-topic": "topic",
+				"x-connection":   "connection",
+				"x-rule":         "rule",
+			},
+		}},
+	})
+
+	require.NoError(t, b.Start(context.Background(), []rule.Rule{{
+		ID:    "alerts",
+		Topic: "sensors/temperature",
+		Action: &rule.Action{
+			Topic:   "alerts/${deviceId}",
+			Payload: `{"temp":${temperature}}`,
+			Kafka: &rule.KafkaAction{
+				Key:     "${deviceId}",
+				Headers: map[string]string{"device": "${deviceId}"},
+			},
+		},
+	}}))
+	assert.Equal(t, []string{"sensors.temperature"}, client.Topics())
+
+	record := &Record{
+		Topic:  "sensors.temperature",
+		Value:  []byte(`{"deviceId": "dev-1", "temperature": 30}`),
+		Offset: 42,
+	}
+	client.input <- record
+
+	require.Eventually(t, func() bool { return len(client.Produced()) == 1 }, time.Second, time.Millisecond)
+	produced := client.Produced()[0]
+	assert.Equal(t, "alerts.dev-1", produced.Topic)
+	assert.Equal(t, []byte("dev-1"), produced.Key)
+	assert.Equal(t, `{"temp":30}`, string(produced.Value))
+	assert.Equal(t, "dev-1", header(produced, "device"))
+	assert.Equal(t, "sensors/temperature", header(produced, "x-source-topic"))
+	assert.Equal(t, "events", header(produced, "x-connection"))
+	assert.Equal(t, "alerts", header(produced, "x-rule"))
+
+	// The offset is committed once the processor has handled the record
+	require.Eventually(t, func() bool { return len(client.Committed()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []*Record{record}, client.Committed())
+
+	stats := b.GetStats().Connections["events"]
+	assert.Equal(t, "kafka", stats.Type)
+	assert.True(t, stats.Subscribed)
+	assert.Equal(t, uint64(1), stats.MessagesReceived)
+	assert.Equal(t, uint64(1), stats.MessagesPublished)
+}
+
+func TestBroker_RetriesFailedProduce(t *testing.T) {
+	client := newFakeClient()
+	var attempts int32
+	client.produceErr = func(record *Record) error {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return fmt.Errorf("not enough replicas")
+		}
+		return nil
+	}
+	b := newTestBroker(t, client, BrokerConfig{
+		Retry: broker.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+		},
+	})
+
+	assert.NoError(t, b.PublishAction(&rule.Action{Topic: "alerts/1", Payload: "a"}))
+
+	assert.Eventually(t, func() bool { return len(client.Produced()) == 1 }, time.Second, time.Millisecond)
+	stats := b.retrier.Stats()
+	assert.Equal(t, uint64(2), stats.Retries)
+	assert.Zero(t, stats.Failures)
+}
+
+func TestBroker_PublishBatchReportsFailedRecords(t *testing.T) {
+	client := newFakeClient()
+	client.produceErr = func(record *Record) error {
+		if record.Topic == "alerts.2" {
+			return fmt.Errorf("message too large")
+		}
+		return nil
+	}
+	b := newTestBroker(t, client, BrokerConfig{})
+
+	failed := &rule.Action{Topic: "alerts/2", Payload: "b"}
+	err := b.pub.PublishBatch([]*rule.Action{
+		{Topic: "alerts/1", Payload: "a"},
+		failed,
+	})
+
+	var actionErr *broker.ActionError
+	require.ErrorAs(t, err, &actionErr)
+	assert.Same(t, failed, actionErr.Action)
+	require.Len(t, client.Produced(), 1)
+	assert.Equal(t, "alerts.1", client.Produced()[0].Topic)
+}
+
+func TestBroker_LeavesUnqueuedRecordsUncommitted(t *testing.T) {
+	client := newFakeClient()
+	b := newTestBroker(t, client, BrokerConfig{})
+
+	require.NoError(t, b.Start(context.Background(), []rule.Rule{{
+		Topic:  "sensors/temperature",
+		Action: &rule.Action{Topic: "alerts", Payload: "a"},
+	}}))
+
+	// Records polled after the processor closes are left for the group
+	b.processor.Close()
+	client.input <- &Record{Topic: "sensors.temperature", Value: []byte(`{}`)}
+
+	assert.Eventually(t, func() bool { return len(client.input) == 0 }, time.Second, time.Millisecond)
+	b.StopConsuming()
+	assert.Empty(t, client.Committed())
+}
+
+func TestBroker_ConnectionStatus(t *testing.T) {
+	client := newFakeClient()
+	b := newTestBroker(t, client, BrokerConfig{})
+
+	b.checkConnection()
+	assert.True(t, b.IsConnected())
+
+	client.setPingErr(fmt.Errorf("connection refused"))
+	b.checkConnection()
+	assert.False(t, b.IsConnected())
+	assert.False(t, b.GetStats().Connections["events"].Connected)
+
+	client.setPingErr(nil)
+	b.checkConnection()
+	assert.True(t, b.IsConnected())
+}
+
+func TestToKafkaTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  string
+	}{
+		{"sensors/temperature", "sensors.temperature"},
+		{"alerts/dev-1/high_temp", "alerts.dev-1.high_temp"},
+		{"building 1/floor:2", "building_1.floor_2"},
+		{"already.dotted", "already.dotted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.want, ToKafkaTopic(tt.topic))
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"mqtt-mux-router/config"
+)
+
+// franzClient implements Client with franz-go
+type franzClient struct {
+	client *kgo.Client
+
+	topics map[string]struct{}
+	mu     sync.Mutex
+}
+
+// newClient creates the franz-go client of a connection. Offsets are
+// committed only for records marked as processed.
+func newClient(cfg config.KafkaConfig) (Client, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ClientID(cfg.ClientID),
+		kgo.ConsumerGroup(cfg.Group),
+		kgo.AutoCommitMarks(),
+	}
+
+	if cfg.StartOffset == "earliest" {
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	} else {
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
+	}
+
+	switch cfg.Acks {
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	if !cfg.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	if cfg.Username != "" {
+		opts = append(opts, kgo.SASL(plain.Auth{User: cfg.Username, Pass: cfg.Password}.AsMechanism()))
+	}
+
+	if cfg.TLS.Enable {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+
+	return &franzClient{
+		client: client,
+		topics: make(map[string]struct{}),
+	}, nil
+}
+
+// newTLSConfig loads the CA and client certificate of a connection
+func newTLSConfig(cfg config.KafkaConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if cfg.TLS.CAFile != "" {
+		ca, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.TLS.CAFile)
+		}
+	}
+
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Ping implements Client
+func (c *franzClient) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx)
+}
+
+// Produce implements Client
+func (c *franzClient) Produce(ctx context.Context, records []*Record) []error {
+	krecords := make([]*kgo.Record, len(records))
+	for i, record := range records {
+		krecord := &kgo.Record{
+			Topic: record.Topic,
+			Key:   record.Key,
+			Value: record.Value,
+		}
+		for _, header := range record.Headers {
+			krecord.Headers = append(krecord.Headers, kgo.RecordHeader{Key: header.Key, Value: header.Value})
+		}
+		krecords[i] = krecord
+	}
+
+	results := c.client.ProduceSync(ctx, krecords...)
+	errs := make([]error, len(records))
+	for i, result := range results {
+		errs[i] = result.Err
+	}
+	return errs
+}
+
+// SetTopics implements Client
+func (c *franzClient) SetTopics(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wanted := make(map[string]struct{}, len(topics))
+	var added []string
+	for _, topic := range topics {
+		wanted[topic] = struct{}{}
+		if _, ok := c.topics[topic]; !ok {
+			added = append(added, topic)
+		}
+	}
+	var removed []string
+	for topic := range c.topics {
+		if _, ok := wanted[topic]; !ok {
+			removed = append(removed, topic)
+		}
+	}
+
+	c.client.PurgeTopicsFromConsuming(removed...)
+	c.client.AddConsumeTopics(added...)
+	c.topics = wanted
+}
+
+// Poll implements Client
+func (c *franzClient) Poll(ctx context.Context) ([]*Record, error) {
+	fetches := c.client.PollFetches(ctx)
+	if fetches.IsClientClosed() {
+		return nil, ErrClientClosed
+	}
+
+	var errs []error
+	for _, fetchErr := range fetches.Errors() {
+		errs = append(errs, fmt.Errorf("topic %s partition %d: %w", fetchErr.Topic, fetchErr.Partition, fetchErr.Err))
+	}
+
+	var records []*Record
+	fetches.EachRecord(func(krecord *kgo.Record) {
+		record := &Record{
+			Topic:       krecord.Topic,
+			Key:         krecord.Key,
+			Value:       krecord.Value,
+			Timestamp:   krecord.Timestamp,
+			Partition:   krecord.Partition,
+			Offset:      krecord.Offset,
+			LeaderEpoch: krecord.LeaderEpoch,
+		}
+		for _, header := range krecord.Headers {
+			record.Headers = append(record.Headers, Header{Key: header.Key, Value: header.Value})
+		}
+		records = append(records, record)
+	})
+
+	return records, errors.Join(errs...)
+}
+
+// MarkCommit implements Client
+func (c *franzClient) MarkCommit(records ...*Record) {
+	krecords := make([]*kgo.Record, len(records))
+	for i, record := range records {
+		krecords[i] = &kgo.Record{
+			Topic:       record.Topic,
+			Partition:   record.Partition,
+			Offset:      record.Offset,
+			LeaderEpoch: record.LeaderEpoch,
+		}
+	}
+	c.client.MarkCommitRecords(krecords...)
+}
+
+// Close implements Client
+func (c *franzClient) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"sync"
+
+	"mqtt-mux-router/internal/logger"
+)
+
+// commitTracker marks consumed records for commit in offset order, once a
+// record and every record before it in its partition have been handled. A
+// dropped record holds back the commits of its partition, so that it is
+// consumed again after a restart or once the partition is reassigned.
+type commitTracker struct {
+	client Client
+	logger *logger.Logger
+	name   string
+
+	partitions map[partitionKey]*partitionCommits
+	mu         sync.Mutex
+}
+
+// partitionKey identifies a partition of a topic
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionCommits holds the records of a partition that are not marked yet
+type partitionCommits struct {
+	// pending holds the unmarked records in offset order
+	pending []*trackedRecord
+	// last is the offset of the last tracked record
+	last int64
+	// held is set once a record was dropped; later records are not marked
+	held bool
+}
+
+// trackedRecord is a consumed record waiting to be marked
+type trackedRecord struct {
+	record  *Record
+	handled bool
+}
+
+// newCommitTracker creates the commit tracker of a connection's consumer
+func newCommitTracker(client Client, log *logger.Logger, name string) *commitTracker {
+	return &commitTracker{
+		client:     client,
+		logger:     log,
+		name:       name,
+		partitions: make(map[partitionKey]*partitionCommits),
+	}
+}
+
+// Track starts tracking a consumed record. The returned function reports
+// whether the record was handled and must be called once.
+func (t *commitTracker) Track(record *Record) func(handled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey{topic: record.Topic, partition: record.Partition}
+	partition, ok := t.partitions[key]
+	// A record at or before the last one means that the partition is being
+	// consumed again from its committed offset
+	if !ok || record.Offset <= partition.last {
+		partition = &partitionCommits{}
+		t.partitions[key] = partition
+	}
+	partition.last = record.Offset
+
+	if partition.held {
+		return func(bool) {}
+	}
+
+	tracked := &trackedRecord{record: record}
+	partition.pending = append(partition.pending, tracked)
+	return func(handled bool) {
+		t.done(partition, tracked, handled)
+	}
+}
+
+// done records the outcome of a tracked record and marks the records of its
+// partition that are handled along with every record before them
+func (t *commitTracker) done(partition *partitionCommits, tracked *trackedRecord, handled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !handled {
+		// Records from the dropped one on are left uncommitted
+		for i, pending := range partition.pending {
+			if pending == tracked {
+				partition.pending = partition.pending[:i]
+				break
+			}
+		}
+		if !partition.held {
+			partition.held = true
+			t.logger.Error("kafka record dropped, holding back offset commits of its partition",
+				"connection", t.name,
+				"kafkaTopic", tracked.record.Topic,
+				"partition", tracked.record.Partition,
+				"offset", tracked.record.Offset)
+		}
+		return
+	}
+
+	tracked.handled = true
+	n := 0
+	for n < len(partition.pending) && partition.pending[n].handled {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	last := partition.pending[n-1].record
+	partition.pending = partition.pending[n:]
+	t.client.MarkCommit(last)
+}
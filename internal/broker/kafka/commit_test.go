@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+)
+
+func newTestTracker(t *testing.T) (*commitTracker, *fakeClient) {
+	t.Helper()
+	client := newFakeClient()
+	return newCommitTracker(client, &logger.Logger{Logger: zap.NewNop()}, "events"), client
+}
+
+// offsets returns the offsets of records
+func offsets(records []*Record) []int64 {
+	var result []int64
+	for _, record := range records {
+		result = append(result, record.Offset)
+	}
+	return result
+}
+
+func TestCommitTracker_MarksInOffsetOrder(t *testing.T) {
+	tracker, client := newTestTracker(t)
+
+	done := make([]func(bool), 3)
+	for i := range done {
+		done[i] = tracker.Track(&Record{Topic: "sensors", Partition: 0, Offset: int64(i)})
+	}
+	other := tracker.Track(&Record{Topic: "sensors", Partition: 1, Offset: 7})
+
+	// Records handled out of order wait for the records before them
+	done[2](true)
+	done[1](true)
+	assert.Empty(t, client.Committed())
+
+	done[0](true)
+	assert.Equal(t, []int64{2}, offsets(client.Committed()))
+
+	// Partitions are committed independently
+	other(true)
+	assert.Equal(t, []int64{2, 7}, offsets(client.Committed()))
+}
+
+func TestCommitTracker_DroppedRecordHoldsPartition(t *testing.T) {
+	tracker, client := newTestTracker(t)
+
+	first := tracker.Track(&Record{Topic: "sensors", Offset: 10})
+	dropped := tracker.Track(&Record{Topic: "sensors", Offset: 11})
+	later := tracker.Track(&Record{Topic: "sensors", Offset: 12})
+
+	dropped(false)
+	later(true)
+	tracker.Track(&Record{Topic: "sensors", Offset: 13})(true)
+	assert.Empty(t, client.Committed())
+
+	// Records before the dropped one are still committed
+	first(true)
+	assert.Equal(t, []int64{10}, offsets(client.Committed()))
+
+	// Consuming the partition again from its committed offset starts over
+	tracker.Track(&Record{Topic: "sensors", Offset: 11})(true)
+	assert.Equal(t, []int64{10, 11}, offsets(client.Committed()))
+}
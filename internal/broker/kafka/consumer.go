@@ -0,0 +1,203 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/rule"
+	"mqtt-mux-router/internal/tracing"
+)
+
+// pollBackoff is how long the consumer waits after a failed poll
+const pollBackoff = time.Second
+
+// consumer polls the topics of a connection's rules as a consumer group and
+// queues the records for the processor
+type consumer struct {
+	broker  *KafkaBroker
+	client  Client
+	commits *commitTracker
+
+	// topics maps Kafka topics to the rule topics they were derived from
+	topics map[string]string
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.RWMutex
+}
+
+// newConsumer creates a consumer that is started by Subscribe
+func newConsumer(broker *KafkaBroker, client Client) *consumer {
+	return &consumer{
+		broker:  broker,
+		client:  client,
+		commits: newCommitTracker(client, broker.logger, broker.name),
+		topics:  make(map[string]string),
+	}
+}
+
+// Subscribe joins the consumer group for the given rule topics and starts
+// polling. Wildcard topics cannot be mapped to Kafka topics and are skipped.
+func (c *consumer) Subscribe(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.topics = make(map[string]string, len(topics))
+	kafkaTopics := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if strings.ContainsAny(topic, "+#") {
+			c.broker.logger.Error("wildcard topics are not supported on kafka connections",
+				"connection", c.broker.name,
+				"topic", topic)
+			continue
+		}
+		kafkaTopic := ToKafkaTopic(topic)
+		if existing, ok := c.topics[kafkaTopic]; ok {
+			c.broker.logger.Error("topics map to the same kafka topic",
+				"connection", c.broker.name,
+				"topic", topic,
+				"existing", existing,
+				"kafkaTopic", kafkaTopic)
+			continue
+		}
+		c.topics[kafkaTopic] = topic
+		kafkaTopics = append(kafkaTopics, kafkaTopic)
+	}
+
+	c.broker.logger.Info("subscribing to topics", "count", len(kafkaTopics))
+	c.client.SetTopics(kafkaTopics)
+
+	if c.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		c.done = make(chan struct{})
+		go c.pollLoop(ctx, c.done)
+	}
+}
+
+// Stop stops polling and waits for the records being queued
+func (c *consumer) Stop() {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Topics returns the Kafka topics being consumed
+func (c *consumer) Topics() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// IsSubscribed returns whether the consumer is polling
+func (c *consumer) IsSubscribed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cancel != nil
+}
+
+// ruleTopic returns the rule topic of a Kafka topic
+func (c *consumer) ruleTopic(kafkaTopic string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	topic, ok := c.topics[kafkaTopic]
+	return topic, ok
+}
+
+// pollLoop fetches records until ctx is cancelled. No poll is issued while
+// consumption is paused, so records stay in Kafka instead of piling up in
+// the router.
+func (c *consumer) pollLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for ctx.Err() == nil {
+		if c.broker.flow != nil {
+			c.broker.flow.Wait()
+		}
+
+		records, err := c.client.Poll(ctx)
+		if errors.Is(err, ErrClientClosed) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			atomic.AddUint64(&c.broker.stats.Errors, 1)
+			c.broker.logger.Error("kafka poll failed",
+				"connection", c.broker.name,
+				"error", err)
+		}
+
+		for _, record := range records {
+			// Records fetched as the router shuts down are left uncommitted,
+			// so the group receives them again
+			if err := c.handleRecord(record); errors.Is(err, rule.ErrProcessorClosed) {
+				return
+			}
+		}
+
+		if err != nil && len(records) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollBackoff):
+			}
+		}
+	}
+}
+
+// handleRecord queues a consumed record for the processor worker pool and
+// returns the error of records that were not queued. The record's offset is
+// marked for commit once the processor has handled it.
+func (c *consumer) handleRecord(record *Record) error {
+	done := c.commits.Track(record)
+
+	topic, ok := c.ruleTopic(record.Topic)
+	if !ok {
+		// Left over from topics consumed before the last Subscribe
+		done(true)
+		return nil
+	}
+
+	atomic.AddUint64(&c.broker.stats.MessagesReceived, 1)
+	c.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.IncMessagesTotal("received")
+		m.IncConnectionMessages(c.broker.name, "received")
+	})
+
+	c.broker.logger.Debug("queueing message",
+		"topic", topic,
+		"kafkaTopic", record.Topic,
+		"partition", record.Partition,
+		"offset", record.Offset,
+		"payloadSize", len(record.Value))
+
+	// Continue the producer's trace when the record carries trace context
+	headers := record.Headers
+	ctx := tracing.Extract(context.Background(), headerCarrier{headers: &headers})
+	ctx, span := tracing.StartReceive(ctx, "kafka", c.broker.name, topic, len(record.Value))
+
+	// Rules are indexed by the original MQTT-format topic
+	err := c.broker.processor.SubmitWith(ctx, topic, record.Value, rule.SubmitOptions{Done: done})
+	tracing.End(span, err)
+	if err != nil {
+		c.broker.logger.Debug("message not queued",
+			"error", err,
+			"topic", topic)
+		return err
+	}
+	return nil
+}
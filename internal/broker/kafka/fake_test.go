@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+)
+
+// fakeClient is an in-memory Client. Records sent on input are returned by
+// Poll one at a time, and produced and committed records are recorded.
+type fakeClient struct {
+	input  chan *Record
+	closed chan struct{}
+
+	// produceErr returns the error of a produced record, nil accepts it
+	produceErr func(record *Record) error
+	pingErr    error
+
+	produced  []*Record
+	committed []*Record
+	topics    []string
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		input:  make(chan *Record, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *fakeClient) Ping(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pingErr
+}
+
+// setPingErr makes Ping fail with err, or succeed again when err is nil
+func (c *fakeClient) setPingErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pingErr = err
+}
+
+func (c *fakeClient) Produce(ctx context.Context, records []*Record) []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := make([]error, len(records))
+	for i, record := range records {
+		if c.produceErr != nil {
+			errs[i] = c.produceErr(record)
+		}
+		if errs[i] == nil {
+			c.produced = append(c.produced, record)
+		}
+	}
+	return errs
+}
+
+func (c *fakeClient) SetTopics(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics = append([]string(nil), topics...)
+}
+
+func (c *fakeClient) Poll(ctx context.Context) ([]*Record, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClientClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case record := <-c.input:
+		return []*Record{record}, nil
+	}
+}
+
+func (c *fakeClient) MarkCommit(records ...*Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed = append(c.committed, records...)
+}
+
+func (c *fakeClient) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+func (c *fakeClient) Produced() []*Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Record(nil), c.produced...)
+}
+
+func (c *fakeClient) Committed() []*Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Record(nil), c.committed...)
+}
+
+func (c *fakeClient) Topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.topics...)
+}
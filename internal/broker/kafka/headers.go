@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"strings"
+)
+
+// headerCarrier adapts Kafka record headers for trace context propagation.
+// Keys are written as given, lowercase for W3C trace context, and read
+// case-insensitively since producers may canonicalize them.
+type headerCarrier struct {
+	headers *[]Header
+}
+
+// Get returns the first value of a header
+func (c headerCarrier) Get(key string) string {
+	for _, header := range *c.headers {
+		if strings.EqualFold(header.Key, key) {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the values of a header
+func (c headerCarrier) Set(key, value string) {
+	headers := (*c.headers)[:0]
+	for _, header := range *c.headers {
+		if header.Key != key {
+			headers = append(headers, header)
+		}
+	}
+	*c.headers = append(headers, Header{Key: key, Value: []byte(value)})
+}
+
+// Keys lists the header names
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, header := range *c.headers {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
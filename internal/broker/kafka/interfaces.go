@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"mqtt-mux-router/internal/rule"
+)
+
+// ErrClientClosed is returned by Poll once the client is closed
+var ErrClientClosed = errors.New("kafka client is closed")
+
+// Record is a Kafka record consumed from or produced to a topic
+type Record struct {
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Timestamp time.Time
+
+	// Position of a consumed record, used to commit its offset
+	Partition   int32
+	Offset      int64
+	LeaderEpoch int32
+}
+
+// Header is a Kafka record header
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Client produces and consumes Kafka records for one connection. It is
+// implemented with franz-go.
+type Client interface {
+	// Ping checks that a broker of the cluster can be reached
+	Ping(ctx context.Context) error
+	// Produce writes records and waits for their acknowledgement. It returns
+	// one error per record, nil for the records that were written.
+	Produce(ctx context.Context, records []*Record) []error
+	// SetTopics replaces the topics the consumer group consumes
+	SetTopics(topics []string)
+	// Poll waits for records of the consumed topics until ctx is done
+	Poll(ctx context.Context) ([]*Record, error)
+	// MarkCommit marks consumed records as processed so that their offsets
+	// are committed
+	MarkCommit(records ...*Record)
+	// Close commits the marked offsets, leaves the group and disconnects
+	Close()
+}
+
+// Publisher handles record production
+type Publisher interface {
+	PublishAction(action *rule.Action) error
+	PublishBatch(actions []*rule.Action) error
+}
@@ -0,0 +1,185 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/rule"
+	"mqtt-mux-router/internal/tracing"
+)
+
+// PublisherImpl implements the Publisher interface for Kafka
+type PublisherImpl struct {
+	broker *KafkaBroker
+	client Client
+}
+
+// NewPublisher creates a new Kafka publisher
+func NewPublisher(broker *KafkaBroker, client Client) Publisher {
+	return &PublisherImpl{
+		broker: broker,
+		client: client,
+	}
+}
+
+// newActionRecord builds the record for an action and starts its produce
+// span, injecting the span's trace context into the headers so consumers of
+// the record continue the trace
+func (p *PublisherImpl) newActionRecord(action *rule.Action) (*Record, trace.Span) {
+	ctx, span := tracing.StartPublish(action.SpanContext, "kafka", p.broker.name, action.Topic, len(action.Payload))
+
+	record := &Record{
+		Topic: ToKafkaTopic(action.Topic),
+		Value: []byte(action.Payload),
+	}
+	if action.Kafka != nil {
+		if action.Kafka.Key != "" {
+			record.Key = []byte(action.Kafka.Key)
+		}
+		record.Headers = append(record.Headers, sortedHeaders(action.Kafka.Headers)...)
+	}
+	record.Headers = append(record.Headers, p.metadataHeaders(action)...)
+
+	if span.SpanContext().IsValid() {
+		tracing.Inject(ctx, headerCarrier{headers: &record.Headers})
+	}
+	return record, span
+}
+
+// metadataHeaders returns the headers the connection maps from action
+// metadata. Fields without a value are left out.
+func (p *PublisherImpl) metadataHeaders(action *rule.Action) []Header {
+	values := make(map[string]string, len(p.broker.connConfig.Headers))
+	for name, field := range p.broker.connConfig.Headers {
+		var value string
+		switch field {
+		case "topic":
+			value = action.SourceTopic
+		case "connection":
+			value = action.SourceConnection
+		case "rule":
+			value = action.RuleID
+		case "receivedAt":
+			if !action.ReceivedAt.IsZero() {
+				value = action.ReceivedAt.UTC().Format(time.RFC3339Nano)
+			}
+		}
+		if value != "" {
+			values[name] = value
+		}
+	}
+	return sortedHeaders(values)
+}
+
+// sortedHeaders converts a header map to headers ordered by name, so that
+// records are written the same way every time
+func sortedHeaders(values map[string]string) []Header {
+	headers := make([]Header, 0, len(values))
+	for name, value := range values {
+		headers = append(headers, Header{Key: name, Value: []byte(value)})
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].Key < headers[j].Key
+	})
+	return headers
+}
+
+// PublishAction produces a rule action and waits for its acknowledgement
+func (p *PublisherImpl) PublishAction(action *rule.Action) error {
+	if action == nil {
+		return fmt.Errorf("action cannot be nil")
+	}
+
+	record, span := p.newActionRecord(action)
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), produceTimeout)
+	err := p.client.Produce(ctx, []*Record{record})[0]
+	cancel()
+
+	tracing.End(span, err)
+	if err != nil {
+		p.recordFailure(action.Topic, record.Topic, err)
+		return fmt.Errorf("failed to publish action: %w", err)
+	}
+
+	p.recordSuccess(action.Topic, record.Topic, len(record.Value))
+	p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.ObservePublish(p.broker.name, time.Since(start))
+		m.ObserveReceiveToPublish(p.broker.name, action.ReceivedAt)
+	})
+
+	p.broker.logger.Debug("published action",
+		"topic", action.Topic,
+		"payload", action.Payload)
+
+	return nil
+}
+
+// PublishBatch produces a batch of actions in one call and waits for all of
+// them to be acknowledged. Failed actions are reported as broker.ActionErrors.
+func (p *PublisherImpl) PublishBatch(actions []*rule.Action) error {
+	records := make([]*Record, len(actions))
+	spans := make([]trace.Span, len(actions))
+	for i, action := range actions {
+		records[i], spans[i] = p.newActionRecord(action)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), produceTimeout)
+	results := p.client.Produce(ctx, records)
+	cancel()
+	elapsed := time.Since(start)
+
+	var errs []error
+	for i, action := range actions {
+		err := results[i]
+		tracing.End(spans[i], err)
+		if err != nil {
+			p.recordFailure(action.Topic, records[i].Topic, err)
+			errs = append(errs, &broker.ActionError{Action: action, Err: err})
+			continue
+		}
+		p.recordSuccess(action.Topic, records[i].Topic, len(records[i].Value))
+		p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+			m.ObservePublish(p.broker.name, elapsed)
+			m.ObserveReceiveToPublish(p.broker.name, action.ReceivedAt)
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// recordSuccess updates stats and metrics for a produced record
+func (p *PublisherImpl) recordSuccess(topic, kafkaTopic string, payloadSize int) {
+	atomic.AddUint64(&p.broker.stats.MessagesPublished, 1)
+	p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.IncActionsTotal("success")
+		m.IncConnectionMessages(p.broker.name, "published")
+	})
+
+	p.broker.logger.Debug("published message",
+		"topic", topic,
+		"kafkaTopic", kafkaTopic,
+		"payloadSize", payloadSize)
+}
+
+// recordFailure updates stats and metrics for a failed produce
+func (p *PublisherImpl) recordFailure(topic, kafkaTopic string, err error) {
+	atomic.AddUint64(&p.broker.stats.Errors, 1)
+	p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.IncActionsTotal("error")
+		m.IncConnectionMessages(p.broker.name, "error")
+	})
+	p.broker.logger.Error("failed to publish message",
+		"error", err,
+		"topic", topic,
+		"kafkaTopic", kafkaTopic)
+}
@@ -0,0 +1,27 @@
+package kafka
+
+import (
+	"strings"
+)
+
+// ToKafkaTopic converts an MQTT topic to a Kafka topic name.
+// MQTT uses / as separators, which Kafka does not allow, so they become
+// dots. Any other character outside [a-zA-Z0-9._-] is replaced with _.
+func ToKafkaTopic(mqttTopic string) string {
+	var sb strings.Builder
+	sb.Grow(len(mqttTopic))
+
+	for _, r := range mqttTopic {
+		switch {
+		case r == '/':
+			sb.WriteByte('.')
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '_', r == '-':
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+
+	return sb.String()
+}
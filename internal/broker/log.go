@@ -0,0 +1,87 @@
+package broker
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// defaultLogMessage is logged for log actions without a message
+const defaultLogMessage = "rule action"
+
+// LogTarget delivers log actions by writing them to the router's structured
+// logger. Writes are synchronous, so there is nothing to drain.
+type LogTarget struct {
+    logger  *logger.Logger
+    metrics *metrics.Metrics
+}
+
+// NewLogTarget creates a log target writing to log
+func NewLogTarget(log *logger.Logger, metricsService *metrics.Metrics) *LogTarget {
+    return &LogTarget{
+        logger:  log,
+        metrics: metricsService,
+    }
+}
+
+// Deliver implements ActionTarget by logging the action at its level. JSON
+// payloads are logged as structured fields.
+func (t *LogTarget) Deliver(action *rule.Action) error {
+    if action.Log == nil {
+        return fmt.Errorf("action is not a log action")
+    }
+
+    message := action.Log.Message
+    if message == "" {
+        message = defaultLogMessage
+    }
+
+    args := []interface{}{"rule", action.RuleID}
+    if action.Topic != "" {
+        args = append(args, "topic", action.Topic)
+    }
+    if action.SourceConnection != "" {
+        args = append(args, "connection", action.SourceConnection)
+    }
+    args = append(args, "payload", logPayload(action.Payload))
+
+    switch action.Log.Level {
+    case "debug":
+        t.logger.Debug(message, args...)
+    case "error":
+        t.logger.Error(message, args...)
+    default:
+        t.logger.Info(message, args...)
+    }
+
+    if t.metrics != nil {
+        t.metrics.IncActionsTotal("success")
+    }
+    return nil
+}
+
+// logPayload returns a JSON payload decoded, so that it is logged as a
+// structured field, and any other payload as is
+func logPayload(payload string) interface{} {
+    decoder := json.NewDecoder(strings.NewReader(payload))
+    decoder.UseNumber()
+
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil || decoder.More() {
+        return payload
+    }
+    return value
+}
+
+// Drain implements ActionTarget; actions are logged as they are delivered
+func (t *LogTarget) Drain(ctx context.Context) DrainStats {
+    return DrainStats{}
+}
+
+// Close implements ActionTarget
+func (t *LogTarget) Close() {}
@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+func TestLogTarget_LogsAtLevel(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	target := NewLogTarget(&logger.Logger{Logger: zap.New(core)}, nil)
+
+	require.NoError(t, target.Deliver(&rule.Action{
+		Topic:   "alerts/dev-1",
+		Payload: `{"temp":30}`,
+		RuleID:  "alerts#0",
+		Log:     &rule.LogAction{Level: "error", Message: "temperature alert"},
+	}))
+	require.NoError(t, target.Deliver(&rule.Action{
+		Payload: "plain text",
+		Log:     &rule.LogAction{},
+	}))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+	assert.Equal(t, "temperature alert", entries[0].Message)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "alerts#0", fields["rule"])
+	assert.Equal(t, "alerts/dev-1", fields["topic"])
+	assert.Equal(t, map[string]interface{}{"temp": json.Number("30")}, fields["payload"], "JSON payloads are structured")
+
+	assert.Equal(t, zapcore.InfoLevel, entries[1].Level)
+	assert.Equal(t, defaultLogMessage, entries[1].Message)
+	assert.Equal(t, "plain text", entries[1].ContextMap()["payload"])
+	assert.NotContains(t, entries[1].ContextMap(), "topic")
+}
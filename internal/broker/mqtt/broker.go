@@ -4,6 +4,7 @@ import (
     "context"
     "fmt"
     "sync"
+    "sync/atomic"
     "time"
 
     "mqtt-mux-router/config"
@@ -21,6 +22,21 @@ type MQTTBroker struct {
     metrics   *metrics.Metrics
     stats     broker.BrokerStats
 
+    // Named connection this broker serves and the router for actions
+    // targeting other connections
+    name       string
+    connConfig config.MQTTConfig
+    router     broker.ActionRouter
+
+    // batcher buffers outbound actions; nil when batching is disabled
+    batcher *broker.Batcher
+    // retrier republishes failed actions with backoff
+    retrier *broker.Retrier
+    // outbound queues actions for the sender; nil publishes synchronously
+    outbound *broker.OutboundQueue
+    // flow pauses consumption under backpressure (optional)
+    flow *broker.FlowController
+
     conn ConnectionManager
     sub  SubscriptionManager
     pub  Publisher
@@ -37,23 +53,71 @@ type BrokerConfig struct {
     ProcessorWorkers int
     QueueSize       int
     BatchSize       int
+    BatchLinger     time.Duration
+    OverflowPolicy  string
+    OrderingKey     string
+    OrderingField   string
+
+    // Connection holds the named connection settings; when empty the
+    // top-level mqtt section is used under the default connection name
+    Connection config.ConnectionConfig
+    // Router dispatches actions that target other connections (optional)
+    Router broker.ActionRouter
+
+    // OutboundQueueSize bounds the queue of actions awaiting publication;
+    // 0 publishes from the worker goroutines instead
+    OutboundQueueSize int
+    // Flow pauses consumption while outbound queues are backed up (optional)
+    Flow *broker.FlowController
+
+    // Retry is the default policy for failed publishes; actions may override it
+    Retry broker.RetryPolicy
+
+    // DeadLetter receives unprocessable messages and undeliverable actions (optional)
+    DeadLetter broker.DeadLetterSink
+
+    // State holds the state of stateful rules (optional, in memory by
+    // default); the processor closes it on shutdown
+    State rule.StateStore
+
+    // Lookups holds the reference tables rules read with lookup() (optional)
+    Lookups *rule.Lookups
 }
 
 // NewBroker creates a new MQTT broker instance
 func NewBroker(cfg *config.Config, log *logger.Logger, brokerCfg BrokerConfig, metricsService *metrics.Metrics) (broker.Broker, error) {
+    connCfg := brokerCfg.Connection
+    if connCfg.Name == "" {
+        connCfg = config.ConnectionConfig{
+            Name: config.DefaultConnectionName,
+            Type: "mqtt",
+            MQTT: cfg.MQTT,
+        }
+    }
+
     processorCfg := rule.ProcessorConfig{
-        Workers:   brokerCfg.ProcessorWorkers,
-        QueueSize: brokerCfg.QueueSize,
-        BatchSize: brokerCfg.BatchSize,
+        Name:           connCfg.Name,
+        Workers:        brokerCfg.ProcessorWorkers,
+        QueueSize:      brokerCfg.QueueSize,
+        BatchSize:      brokerCfg.BatchSize,
+        OverflowPolicy: brokerCfg.OverflowPolicy,
+        OrderingKey:    brokerCfg.OrderingKey,
+        OrderingField:  brokerCfg.OrderingField,
+        State:          brokerCfg.State,
+        Lookups:        brokerCfg.Lookups,
     }
 
     processor := rule.NewProcessor(processorCfg, log, metricsService)
 
     b := &MQTTBroker{
-        logger:    log,
-        config:    cfg,
-        processor: processor,
-        metrics:   metricsService,
+        logger:     log,
+        config:     cfg,
+        processor:  processor,
+        metrics:    metricsService,
+        name:       connCfg.Name,
+        connConfig: connCfg.MQTT,
+        router:     brokerCfg.Router,
+        flow:       brokerCfg.Flow,
         stats: broker.BrokerStats{
             LastReconnect: time.Now(),
         },
@@ -69,9 +133,39 @@ func NewBroker(cfg *config.Config, log *logger.Logger, brokerCfg BrokerConfig, m
     // Initialize publisher before subscription manager since it's needed for message handling
     b.pub = NewPublisher(b)
 
+    // Retries bypass the batcher and outbound queue so that they never wait
+    // behind new actions
+    b.retrier = broker.NewRetrier(b.name, brokerCfg.Retry, b.pub.PublishAction, log, metricsService)
+
+    if sink := brokerCfg.DeadLetter; sink != nil {
+        b.processor.SetFailureHandler(func(failure *rule.Failure) {
+            sink.DeadLetter(broker.NewDeadLetter(b.name, failure))
+        })
+        b.retrier.SetGiveUpHandler(func(action *rule.Action, err error) {
+            sink.DeadLetter(broker.NewDeadLetter(b.name, broker.ActionFailure(action, err)))
+        })
+    }
+
+    // Batch outbound actions when more than one action fits in a batch
+    if brokerCfg.BatchSize > 1 {
+        b.batcher = broker.NewBatcher(b.name, brokerCfg.BatchSize, brokerCfg.BatchLinger,
+            b.pub.PublishBatch, log, metricsService)
+        b.batcher.SetFailureHandler(b.retrier.Retry)
+    }
+
+    if brokerCfg.OutboundQueueSize > 0 {
+        b.outbound = broker.NewOutboundQueue(b.name, brokerCfg.OutboundQueueSize,
+            b.publishNow, b.conn.IsConnected, b.flow, log, metricsService)
+        b.outbound.Start()
+    }
+
     // Initialize subscription manager last since it depends on both connection and publisher
     b.sub = NewSubscriptionManager(b)
 
+    // Workers and timers hand their results back to the broker for publishing
+    processor.SetResultHandler(b.handleResult)
+    processor.SetActionHandler(b.handleAction)
+
     return b, nil
 }
 
@@ -87,23 +181,15 @@ func (b *MQTTBroker) Start(ctx context.Context, rules []rule.Rule) error {
         return fmt.Errorf("failed to load rules: %w", err)
     }
 
-    // Extract unique topics from rules
-    topics := make(map[string]struct{})
-    for _, rule := range rules {
-        topics[rule.Topic] = struct{}{}
-    }
-
-    topicList := make([]string, 0, len(topics))
-    for topic := range topics {
-        topicList = append(topicList, topic)
-    }
+    // Topics of the rules and of their state sources
+    topicList := b.processor.GetTopics()
 
     if err := b.sub.Subscribe(topicList); err != nil {
         return fmt.Errorf("failed to subscribe to topics: %w", err)
     }
 
     if b.metrics != nil {
-        b.metrics.SetRulesActive(float64(len(rules)))
+        b.metrics.SetConnectionRulesActive(b.name, float64(len(rules)))
     }
 
     return nil
@@ -126,22 +212,196 @@ func (b *MQTTBroker) RestoreRules() error {
     }
 
     if b.metrics != nil {
-        b.metrics.SetRulesActive(float64(len(rules)))
+        b.metrics.SetConnectionRulesActive(b.name, float64(len(rules)))
     }
 
     return nil
 }
 
-// Close implements broker.Broker interface
+// Close implements broker.Broker interface. Queued messages and actions are
+// still processed and published, but pending retries are abandoned; use a
+// Router's Shutdown for a drain bounded by a deadline.
 func (b *MQTTBroker) Close() {
     b.logger.Info("shutting down mqtt broker")
-    b.conn.Disconnect()
+    b.StopConsuming()
     b.processor.Close()
+    if b.outbound != nil {
+        b.outbound.Close()
+    }
+    if b.batcher != nil {
+        b.batcher.Close()
+    }
+    b.retrier.Close()
+    b.conn.Disconnect()
+}
+
+// StopConsuming implements broker.Drainer by unsubscribing from every topic
+func (b *MQTTBroker) StopConsuming() {
+    topics := b.sub.GetSubscribedTopics()
+    if len(topics) == 0 {
+        return
+    }
+
+    if err := b.sub.Unsubscribe(topics); err != nil {
+        b.logger.Error("failed to unsubscribe before shutdown",
+            "connection", b.name,
+            "error", err)
+    }
+}
+
+// DrainProcessing implements broker.Drainer
+func (b *MQTTBroker) DrainProcessing(ctx context.Context) broker.DrainStats {
+    drained, abandoned := b.processor.Drain(ctx)
+    return broker.DrainStats{Drained: drained, Abandoned: abandoned}
+}
+
+// DrainOutbound implements broker.Drainer. Publishes wait for their delivery
+// tokens, so every drained action has been acknowledged.
+func (b *MQTTBroker) DrainOutbound(ctx context.Context) broker.DrainStats {
+    stats := broker.DrainPublishing(ctx, b.outbound, b.batcher, b.retrier)
+
+    b.logger.Info("drained outbound actions",
+        "connection", b.name,
+        "drained", stats.Drained,
+        "abandoned", stats.Abandoned)
+
+    return stats
 }
 
 // GetStats implements broker.Broker interface
 func (b *MQTTBroker) GetStats() broker.BrokerStats {
-    return b.stats
+    connStats := broker.ConnectionStats{
+        Type:              "mqtt",
+        Connected:         b.conn.IsConnected(),
+        Subscribed:        b.sub.IsSubscribed(),
+        Topics:            len(b.sub.GetSubscribedTopics()),
+        MessagesReceived:  atomic.LoadUint64(&b.stats.MessagesReceived),
+        MessagesPublished: atomic.LoadUint64(&b.stats.MessagesPublished),
+        LastReconnect:     b.stats.LastReconnect,
+        Errors:            atomic.LoadUint64(&b.stats.Errors),
+    }
+    retryStats := b.retrier.Stats()
+    connStats.PublishRetries = retryStats.Retries
+    connStats.PublishFailures = retryStats.Failures
+    connStats.QueueDepth = b.processor.QueueDepth()
+    if b.outbound != nil {
+        connStats.OutboundDepth = b.outbound.Depth()
+    }
+
+    b.mu.RLock()
+    // Rules are stored by Start, even when none are bound to the connection
+    connStats.RulesLoaded = b.rules != nil
+    connStats.Rules = len(b.rules)
+    b.mu.RUnlock()
+
+    return broker.BrokerStats{
+        MessagesReceived:  connStats.MessagesReceived,
+        MessagesPublished: connStats.MessagesPublished,
+        LastReconnect:     connStats.LastReconnect,
+        Errors:            connStats.Errors,
+        Connections: map[string]broker.ConnectionStats{
+            b.name: connStats,
+        },
+    }
+}
+
+// ProcessorReport implements broker.ProcessorReporter
+func (b *MQTTBroker) ProcessorReport() rule.ProcessorReport {
+    return b.processor.Report()
+}
+
+// PublishAction implements broker.Broker interface by publishing on this connection
+func (b *MQTTBroker) PublishAction(action *rule.Action) error {
+    if action == nil {
+        return fmt.Errorf("action cannot be nil")
+    }
+    if b.outbound != nil {
+        return b.outbound.Enqueue(action)
+    }
+    return b.publishNow(action)
+}
+
+// publishNow publishes an action without queueing, through the batcher when
+// enabled. Failed publishes are handed to the retrier, which owns them from
+// then on, so no error is returned.
+func (b *MQTTBroker) publishNow(action *rule.Action) error {
+    if b.batcher != nil {
+        b.batcher.Add(action)
+        return nil
+    }
+    if err := b.pub.PublishAction(action); err != nil {
+        b.retrier.Retry(action, err)
+    }
+    return nil
+}
+
+// handleResult publishes the actions for a message processed by the worker pool
+func (b *MQTTBroker) handleResult(result *rule.ProcessingResult) {
+    msg := result.Message
+
+    if result.Error != nil {
+        atomic.AddUint64(&b.stats.Errors, 1)
+        b.safeMetricsUpdate(func(m *metrics.Metrics) {
+            m.IncMessagesTotal("error")
+            m.IncConnectionMessages(b.name, "error")
+        })
+        b.logger.Error("failed to process message",
+            "error", result.Error,
+            "topic", msg.Topic)
+        return
+    }
+
+    b.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.IncMessagesTotal("processed")
+    })
+
+    // Publish resulting actions
+    for _, action := range msg.Actions {
+        if err := b.dispatchAction(action); err != nil {
+            b.logger.Error("failed to publish action",
+                "error", err,
+                "topic", action.Topic)
+        }
+    }
+
+    b.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.SetProcessingBacklog(b.name, float64(b.backlog()))
+    })
+}
+
+// handleAction publishes an action fired by a processor timer
+func (b *MQTTBroker) handleAction(action *rule.Action) {
+    if err := b.dispatchAction(action); err != nil {
+        b.logger.Error("failed to publish action",
+            "error", err,
+            "rule", action.RuleID,
+            "topic", action.Topic)
+    }
+}
+
+// backlog returns the number of messages waiting to be processed and of
+// actions waiting to be published on the connection
+func (b *MQTTBroker) backlog() int {
+    backlog := b.processor.QueueDepth()
+    if b.outbound != nil {
+        backlog += b.outbound.Depth()
+    }
+    if b.batcher != nil {
+        backlog += b.batcher.Pending()
+    }
+    return backlog
+}
+
+// dispatchAction publishes an action on this connection, or hands it to the
+// router when it targets another connection or is not a publish
+func (b *MQTTBroker) dispatchAction(action *rule.Action) error {
+    if action.SourceConnection == "" {
+        action.SourceConnection = b.name
+    }
+    if b.router != nil && (action.Kind() != rule.ActionPublish || (action.Broker != "" && action.Broker != b.name)) {
+        return b.router.RouteAction(action)
+    }
+    return b.PublishAction(action)
 }
 
 // safeMetricsUpdate safely updates metrics if they are enabled
@@ -0,0 +1,105 @@
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/rule"
+)
+
+// flakyClient returns a mock client that rejects the first failures publishes
+// to each topic and records the topics that were accepted
+func flakyClient(failures int32) (*MockClient, func() []string) {
+	client := NewMockClient()
+	attempts := make(map[string]int32)
+	var accepted []string
+	var mu sync.Mutex
+
+	client.publishFunc = func(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+		mu.Lock()
+		defer mu.Unlock()
+
+		token := NewMockToken()
+		attempts[topic]++
+		if attempts[topic] <= failures {
+			token.err = fmt.Errorf("publish rejected")
+			return token
+		}
+		accepted = append(accepted, topic)
+		return token
+	}
+
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), accepted...)
+	}
+}
+
+func newRetryingBroker(client *MockClient, maxAttempts int) *MQTTBroker {
+	b := newTestBroker(client)
+	b.retrier = broker.NewRetrier(b.name, broker.RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}, b.pub.PublishAction, b.logger, nil)
+	return b
+}
+
+func TestBroker_RetriesFailedPublish(t *testing.T) {
+	client, accepted := flakyClient(2)
+	b := newRetryingBroker(client, 3)
+
+	assert.NoError(t, b.PublishAction(&rule.Action{Topic: "alerts/1", Payload: "a"}))
+
+	assert.Eventually(t, func() bool { return len(accepted()) == 1 }, time.Second, time.Millisecond)
+	stats := b.retrier.Stats()
+	assert.Equal(t, uint64(2), stats.Retries)
+	assert.Zero(t, stats.Failures)
+}
+
+func TestBroker_CountsExhaustedRetries(t *testing.T) {
+	client, accepted := flakyClient(5)
+	b := newRetryingBroker(client, 3)
+
+	assert.NoError(t, b.PublishAction(&rule.Action{Topic: "alerts/1", Payload: "a"}))
+
+	assert.Eventually(t, func() bool {
+		return b.retrier.Stats().Failures == 1
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, accepted())
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&b.stats.Errors), "every attempt is counted as an error")
+}
+
+func TestBroker_RetriesFailedBatchActions(t *testing.T) {
+	client, accepted := flakyClient(0)
+	rejectOnce := client.publishFunc
+	var rejected atomic.Bool
+	client.publishFunc = func(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+		if topic == "alerts/2" && rejected.CompareAndSwap(false, true) {
+			token := NewMockToken()
+			token.err = fmt.Errorf("publish rejected")
+			return token
+		}
+		return rejectOnce(topic, qos, retained, payload)
+	}
+
+	b := newRetryingBroker(client, 3)
+	b.batcher = broker.NewBatcher(b.name, 3, time.Hour, b.pub.PublishBatch, b.logger, nil)
+	b.batcher.SetFailureHandler(b.retrier.Retry)
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, b.PublishAction(&rule.Action{Topic: fmt.Sprintf("alerts/%d", i)}))
+	}
+
+	// Only the rejected action is republished
+	assert.Eventually(t, func() bool { return len(accepted()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"alerts/1", "alerts/3", "alerts/2"}, accepted())
+	assert.Equal(t, uint64(1), b.retrier.Stats().Retries)
+}
@@ -0,0 +1,428 @@
+package mqtt
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/eclipse/paho.golang/autopaho"
+    "github.com/eclipse/paho.golang/paho"
+    mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// v5ConnectTimeout bounds the initial connection and every publish,
+// subscribe and unsubscribe of an MQTT 5 client
+const v5ConnectTimeout = 10 * time.Second
+
+// propertyPublisher is implemented by clients that can attach user
+// properties to published messages
+type propertyPublisher interface {
+    PublishWithProperties(topic string, qos byte, retained bool, payload []byte, props paho.UserProperties) mqtt.Token
+}
+
+// propertyMessage is implemented by received messages that carry user properties
+type propertyMessage interface {
+    UserProperties() paho.UserProperties
+}
+
+// v5Handlers are the connection callbacks of an MQTT 5 client, matching the
+// callbacks of the MQTT 3.1.1 client options
+type v5Handlers struct {
+    onConnect        func(client mqtt.Client)
+    onConnectionLost func(client mqtt.Client, err error)
+    onReconnecting   func(client mqtt.Client, err error)
+}
+
+// v5Client adapts the MQTT 5 autopaho connection manager to the mqtt.Client
+// interface of the MQTT 3.1.1 client, so the publisher and subscription
+// manager work with either protocol version. autopaho reconnects on its own
+// and the client only connects once.
+type v5Client struct {
+    config   autopaho.ClientConfig
+    handlers v5Handlers
+
+    conn   *autopaho.ConnectionManager
+    cancel context.CancelFunc
+    up     bool
+    // connected is set once the first connection is made; later connection
+    // attempts are reconnects
+    connected bool
+    routes    []v5Route
+    mu        sync.RWMutex
+}
+
+// v5Route is the handler of a subscribed topic filter
+type v5Route struct {
+    filter  string
+    handler mqtt.MessageHandler
+}
+
+// newV5Client creates an MQTT 5 client for the broker URL. The client does
+// not connect until Connect is called.
+func newV5Client(broker, clientID, username, password string, tlsConfig *tls.Config, handlers v5Handlers) (*v5Client, error) {
+    serverURL, err := url.Parse(broker)
+    if err != nil {
+        return nil, fmt.Errorf("invalid broker url: %w", err)
+    }
+
+    c := &v5Client{handlers: handlers}
+    c.config = autopaho.ClientConfig{
+        ServerUrls:                    []*url.URL{serverURL},
+        TlsCfg:                        tlsConfig,
+        KeepAlive:                     30,
+        CleanStartOnInitialConnection: true,
+        ReconnectBackoff:              autopaho.NewExponentialBackoff(time.Second, time.Minute, time.Second, 2),
+        ConnectTimeout:                v5ConnectTimeout,
+        ConnectUsername:               username,
+        ConnectPassword:               []byte(password),
+        OnConnectionUp:                c.handleConnectionUp,
+        OnConnectError:                c.handleConnectError,
+        ClientConfig: paho.ClientConfig{
+            ClientID:           clientID,
+            OnClientError:      c.handleClientError,
+            OnServerDisconnect: c.handleServerDisconnect,
+        },
+    }
+
+    return c, nil
+}
+
+// Connect starts the connection manager and waits for the first connection
+func (c *v5Client) Connect() mqtt.Token {
+    return newV5Token(func() error {
+        c.mu.Lock()
+        if c.conn == nil {
+            ctx, cancel := context.WithCancel(context.Background())
+            conn, err := autopaho.NewConnection(ctx, c.config)
+            if err != nil {
+                cancel()
+                c.mu.Unlock()
+                return err
+            }
+            c.conn, c.cancel = conn, cancel
+            conn.AddOnPublishReceived(c.handlePublish)
+        }
+        conn := c.conn
+        c.mu.Unlock()
+
+        ctx, cancel := context.WithTimeout(context.Background(), v5ConnectTimeout)
+        defer cancel()
+        if err := conn.AwaitConnection(ctx); err != nil {
+            // Stop the connection manager from retrying in the background
+            c.Disconnect(0)
+            return err
+        }
+        return nil
+    })
+}
+
+// Disconnect waits up to quiesce milliseconds for a clean disconnect and
+// stops reconnecting
+func (c *v5Client) Disconnect(quiesce uint) {
+    c.mu.Lock()
+    conn, cancel := c.conn, c.cancel
+    c.conn, c.cancel, c.up, c.connected = nil, nil, false, false
+    c.mu.Unlock()
+
+    if conn == nil {
+        return
+    }
+
+    ctx, stop := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond)
+    defer stop()
+    _ = conn.Disconnect(ctx)
+    cancel()
+}
+
+// Publish publishes a message without properties
+func (c *v5Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+    var data []byte
+    switch p := payload.(type) {
+    case []byte:
+        data = p
+    case string:
+        data = []byte(p)
+    default:
+        return newV5Token(func() error {
+            return fmt.Errorf("unsupported payload type %T", payload)
+        })
+    }
+    return c.PublishWithProperties(topic, qos, retained, data, nil)
+}
+
+// PublishWithProperties publishes a message with user properties
+func (c *v5Client) PublishWithProperties(topic string, qos byte, retained bool, payload []byte, props paho.UserProperties) mqtt.Token {
+    conn := c.connection()
+    return newV5Token(func() error {
+        if conn == nil {
+            return mqtt.ErrNotConnected
+        }
+
+        msg := &paho.Publish{QoS: qos, Retain: retained, Topic: topic, Payload: payload}
+        if len(props) > 0 {
+            msg.Properties = &paho.PublishProperties{User: props}
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), v5ConnectTimeout)
+        defer cancel()
+        _, err := conn.Publish(ctx, msg)
+        return err
+    })
+}
+
+// Subscribe subscribes to a topic filter and routes its messages to callback
+func (c *v5Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+    return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+// SubscribeMultiple subscribes to several topic filters with one callback
+func (c *v5Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+    sub := &paho.Subscribe{}
+    for filter, qos := range filters {
+        sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{Topic: filter, QoS: qos})
+        c.AddRoute(filter, callback)
+    }
+
+    conn := c.connection()
+    return newV5Token(func() error {
+        if conn == nil {
+            return mqtt.ErrNotConnected
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), v5ConnectTimeout)
+        defer cancel()
+        _, err := conn.Subscribe(ctx, sub)
+        return err
+    })
+}
+
+// Unsubscribe unsubscribes from topic filters and removes their routes
+func (c *v5Client) Unsubscribe(topics ...string) mqtt.Token {
+    c.mu.Lock()
+    routes := c.routes[:0]
+    for _, route := range c.routes {
+        if !containsTopic(topics, route.filter) {
+            routes = append(routes, route)
+        }
+    }
+    c.routes = routes
+    c.mu.Unlock()
+
+    conn := c.connection()
+    return newV5Token(func() error {
+        if conn == nil {
+            return mqtt.ErrNotConnected
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), v5ConnectTimeout)
+        defer cancel()
+        _, err := conn.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics})
+        return err
+    })
+}
+
+// AddRoute routes messages matching a topic filter to callback, replacing
+// the filter's previous callback
+func (c *v5Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for i := range c.routes {
+        if c.routes[i].filter == topic {
+            c.routes[i].handler = callback
+            return
+        }
+    }
+    c.routes = append(c.routes, v5Route{filter: topic, handler: callback})
+}
+
+// IsConnected reports whether the client is connected
+func (c *v5Client) IsConnected() bool {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.up
+}
+
+// IsConnectionOpen reports whether the client is connected
+func (c *v5Client) IsConnectionOpen() bool {
+    return c.IsConnected()
+}
+
+// OptionsReader returns empty options; the MQTT 5 client is configured
+// through autopaho
+func (c *v5Client) OptionsReader() mqtt.ClientOptionsReader {
+    return mqtt.ClientOptionsReader{}
+}
+
+// connection returns the connection manager, or nil before Connect
+func (c *v5Client) connection() *autopaho.ConnectionManager {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.conn
+}
+
+// handlePublish delivers a received message to the handler of the first
+// subscribed filter that matches its topic
+func (c *v5Client) handlePublish(received autopaho.PublishReceived) (bool, error) {
+    msg := &v5Message{packet: received.Packet}
+
+    c.mu.RLock()
+    var handler mqtt.MessageHandler
+    for _, route := range c.routes {
+        if topicMatches(route.filter, msg.Topic()) {
+            handler = route.handler
+            break
+        }
+    }
+    c.mu.RUnlock()
+
+    if handler == nil {
+        return false, nil
+    }
+    handler(c, msg)
+    return true, nil
+}
+
+// handleConnectionUp marks the client connected
+func (c *v5Client) handleConnectionUp(_ *autopaho.ConnectionManager, _ *paho.Connack) {
+    c.mu.Lock()
+    c.up, c.connected = true, true
+    c.mu.Unlock()
+
+    if c.handlers.onConnect != nil {
+        c.handlers.onConnect(c)
+    }
+}
+
+// handleConnectError reports a failed reconnection attempt; failures of the
+// first connection are returned by Connect
+func (c *v5Client) handleConnectError(err error) {
+    c.mu.RLock()
+    reconnecting := c.connected
+    c.mu.RUnlock()
+
+    if reconnecting && c.handlers.onReconnecting != nil {
+        c.handlers.onReconnecting(c, err)
+    }
+}
+
+// handleClientError reports a connection lost to a client side error
+func (c *v5Client) handleClientError(err error) {
+    c.connectionLost(err)
+}
+
+// handleServerDisconnect reports a connection closed by the server
+func (c *v5Client) handleServerDisconnect(d *paho.Disconnect) {
+    c.connectionLost(fmt.Errorf("server disconnected with reason code %d", d.ReasonCode))
+}
+
+// connectionLost marks the client disconnected once per lost connection
+func (c *v5Client) connectionLost(err error) {
+    c.mu.Lock()
+    wasUp := c.up
+    c.up = false
+    c.mu.Unlock()
+
+    if wasUp && c.handlers.onConnectionLost != nil {
+        c.handlers.onConnectionLost(c, err)
+    }
+}
+
+// containsTopic reports whether topics contains topic
+func containsTopic(topics []string, topic string) bool {
+    for _, t := range topics {
+        if t == topic {
+            return true
+        }
+    }
+    return false
+}
+
+// topicMatches reports whether an MQTT topic matches a topic filter with
+// + and # wildcards
+func topicMatches(filter, topic string) bool {
+    filterLevels := strings.Split(filter, "/")
+    topicLevels := strings.Split(topic, "/")
+
+    for i, level := range filterLevels {
+        if level == "#" {
+            return true
+        }
+        if i >= len(topicLevels) {
+            return false
+        }
+        if level != "+" && level != topicLevels[i] {
+            return false
+        }
+    }
+
+    return len(filterLevels) == len(topicLevels)
+}
+
+// v5Token is a token completed by a function run in the background
+type v5Token struct {
+    err  error
+    done chan struct{}
+}
+
+// newV5Token runs fn in the background and completes the token with its error
+func newV5Token(fn func() error) *v5Token {
+    t := &v5Token{done: make(chan struct{})}
+    go func() {
+        t.err = fn()
+        close(t.done)
+    }()
+    return t
+}
+
+func (t *v5Token) Wait() bool {
+    <-t.done
+    return true
+}
+
+func (t *v5Token) WaitTimeout(d time.Duration) bool {
+    select {
+    case <-t.done:
+        return true
+    case <-time.After(d):
+        return false
+    }
+}
+
+func (t *v5Token) Done() <-chan struct{} { return t.done }
+
+func (t *v5Token) Error() error {
+    select {
+    case <-t.done:
+        return t.err
+    default:
+        return nil
+    }
+}
+
+// v5Message adapts a received MQTT 5 publish to mqtt.Message
+type v5Message struct {
+    packet *paho.Publish
+}
+
+func (m *v5Message) Duplicate() bool   { return false }
+func (m *v5Message) Qos() byte         { return m.packet.QoS }
+func (m *v5Message) Retained() bool    { return m.packet.Retain }
+func (m *v5Message) Topic() string     { return m.packet.Topic }
+func (m *v5Message) MessageID() uint16 { return m.packet.PacketID }
+func (m *v5Message) Payload() []byte   { return m.packet.Payload }
+
+// Ack is a no-op; autopaho acknowledges messages once the handler returns
+func (m *v5Message) Ack() {}
+
+// UserProperties returns the user properties of the message
+func (m *v5Message) UserProperties() paho.UserProperties {
+    if m.packet.Properties == nil {
+        return nil
+    }
+    return m.packet.Properties.User
+}
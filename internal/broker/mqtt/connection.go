@@ -25,35 +25,60 @@ func NewConnectionManager(broker *MQTTBroker) (ConnectionManager, error) {
         broker: broker,
     }
 
-    // Create client options
-    opts := mqtt.NewClientOptions().
-        AddBroker(broker.config.MQTT.Broker).
-        SetClientID(broker.config.MQTT.ClientID).
-        SetUsername(broker.config.MQTT.Username).
-        SetPassword(broker.config.MQTT.Password).
-        SetCleanSession(true).
-        SetAutoReconnect(true).
-        SetMaxReconnectInterval(time.Minute) // Prevent exponential backoff from growing too large
-
-    // Set up connection handlers
-    opts.OnConnect = cm.handleConnect
-    opts.OnConnectionLost = cm.handleDisconnect
-    opts.OnReconnecting = cm.handleReconnecting
-
     // Configure TLS if enabled
-    if broker.config.MQTT.TLS.Enable {
-        tlsConfig, err := cm.newTLSConfig(
-            broker.config.MQTT.TLS.CertFile,
-            broker.config.MQTT.TLS.KeyFile,
-            broker.config.MQTT.TLS.CAFile,
+    var tlsConfig *tls.Config
+    if broker.connConfig.TLS.Enable {
+        var err error
+        tlsConfig, err = cm.newTLSConfig(
+            broker.connConfig.TLS.CertFile,
+            broker.connConfig.TLS.KeyFile,
+            broker.connConfig.TLS.CAFile,
         )
         if err != nil {
             return nil, fmt.Errorf("failed to create TLS config: %w", err)
         }
-        opts.SetTLSConfig(tlsConfig)
     }
 
-    cm.client = mqtt.NewClient(opts)
+    // MQTT 5 connections carry trace context in user properties
+    if broker.connConfig.ProtocolVersion == 5 {
+        client, err := newV5Client(
+            broker.connConfig.Broker,
+            broker.connConfig.ClientID,
+            broker.connConfig.Username,
+            broker.connConfig.Password,
+            tlsConfig,
+            v5Handlers{
+                onConnect:        cm.handleConnect,
+                onConnectionLost: cm.handleDisconnect,
+                onReconnecting:   cm.handleConnectError,
+            },
+        )
+        if err != nil {
+            return nil, fmt.Errorf("failed to create mqtt 5 client: %w", err)
+        }
+        cm.client = client
+    } else {
+        // Create client options
+        opts := mqtt.NewClientOptions().
+            AddBroker(broker.connConfig.Broker).
+            SetClientID(broker.connConfig.ClientID).
+            SetUsername(broker.connConfig.Username).
+            SetPassword(broker.connConfig.Password).
+            SetCleanSession(true).
+            SetAutoReconnect(true).
+            SetMaxReconnectInterval(time.Minute) // Prevent exponential backoff from growing too large
+
+        // Set up connection handlers
+        opts.OnConnect = cm.handleConnect
+        opts.OnConnectionLost = cm.handleDisconnect
+        opts.OnReconnecting = cm.handleReconnecting
+
+        if tlsConfig != nil {
+            opts.SetTLSConfig(tlsConfig)
+        }
+
+        cm.client = mqtt.NewClient(opts)
+    }
     
     // Establish initial connection
     if token := cm.client.Connect(); token.Wait() && token.Error() != nil {
@@ -107,12 +132,14 @@ func (cm *ConnectionManagerImpl) GetClient() mqtt.Client {
 
 // handleConnect processes successful connections and resubscribes to topics
 func (cm *ConnectionManagerImpl) handleConnect(client mqtt.Client) {
-    cm.broker.logger.Info("mqtt client connected", "broker", cm.broker.config.MQTT.Broker)
+    cm.broker.logger.Info("mqtt client connected",
+        "connection", cm.broker.name,
+        "broker", cm.broker.connConfig.Broker)
     cm.connected.Store(true)
     cm.broker.stats.LastReconnect = time.Now()
 
     cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-        m.SetMQTTConnectionStatus(true)
+        m.SetConnectionStatus("mqtt", cm.broker.name, true)
     })
 
     // Restore rules first
@@ -120,7 +147,7 @@ func (cm *ConnectionManagerImpl) handleConnect(client mqtt.Client) {
         cm.broker.logger.Error("failed to restore rules after reconnect",
             "error", err)
         cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-            m.IncMQTTReconnects() // Track restoration failures
+            m.IncReconnects("mqtt", cm.broker.name) // Track restoration failures
         })
         return
     }
@@ -131,7 +158,7 @@ func (cm *ConnectionManagerImpl) handleConnect(client mqtt.Client) {
             cm.broker.logger.Error("failed to resubscribe to topics after reconnect",
                 "error", err)
             cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-                m.IncMQTTReconnects() // Track resubscription failures
+                m.IncReconnects("mqtt", cm.broker.name) // Track resubscription failures
             })
             return
         }
@@ -146,7 +173,7 @@ func (cm *ConnectionManagerImpl) handleDisconnect(client mqtt.Client, err error)
     cm.connected.Store(false)
 
     cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-        m.SetMQTTConnectionStatus(false)
+        m.SetConnectionStatus("mqtt", cm.broker.name, false)
     })
 }
 
@@ -157,7 +184,19 @@ func (cm *ConnectionManagerImpl) handleReconnecting(client mqtt.Client, opts *mq
         "attempt", time.Since(cm.broker.stats.LastReconnect))
     
     cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-        m.IncMQTTReconnects()
+        m.IncReconnects("mqtt", cm.broker.name)
+    })
+}
+
+// handleConnectError processes failed connection attempts of an MQTT 5
+// client, which retries on its own
+func (cm *ConnectionManagerImpl) handleConnectError(client mqtt.Client, err error) {
+    cm.broker.logger.Info("mqtt client reconnecting",
+        "broker", cm.broker.connConfig.Broker,
+        "error", err)
+
+    cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.IncReconnects("mqtt", cm.broker.name)
     })
 }
 
@@ -0,0 +1,35 @@
+package mqtt
+
+import (
+    "github.com/eclipse/paho.golang/paho"
+)
+
+// propertyCarrier adapts MQTT 5 user properties for trace context propagation
+type propertyCarrier struct {
+    props paho.UserProperties
+}
+
+// Get returns the first value of a user property
+func (c *propertyCarrier) Get(key string) string {
+    return c.props.Get(key)
+}
+
+// Set replaces the values of a user property
+func (c *propertyCarrier) Set(key, value string) {
+    props := c.props[:0]
+    for _, p := range c.props {
+        if p.Key != key {
+            props = append(props, p)
+        }
+    }
+    c.props = append(props, paho.UserProperty{Key: key, Value: value})
+}
+
+// Keys lists the user property names
+func (c *propertyCarrier) Keys() []string {
+    keys := make([]string, 0, len(c.props))
+    for _, p := range c.props {
+        keys = append(keys, p.Key)
+    }
+    return keys
+}
@@ -1,11 +1,18 @@
 package mqtt
 
 import (
+    "context"
+    "errors"
     "fmt"
     "sync/atomic"
+    "time"
 
+    mqtt "github.com/eclipse/paho.mqtt.golang"
+    "go.opentelemetry.io/otel/trace"
+    "mqtt-mux-router/internal/broker"
     "mqtt-mux-router/internal/metrics"
     "mqtt-mux-router/internal/rule"
+    "mqtt-mux-router/internal/tracing"
 )
 
 // PublisherImpl handles MQTT message publishing
@@ -24,32 +31,103 @@ func NewPublisher(broker *MQTTBroker) Publisher {
 
 // Publish sends a message to a specific topic
 func (p *PublisherImpl) Publish(topic string, payload []byte) error {
+    return p.publishContext(context.Background(), topic, payload)
+}
+
+// publishContext sends a message carrying the trace context of ctx
+func (p *PublisherImpl) publishContext(ctx context.Context, topic string, payload []byte) error {
     if !p.conn.IsConnected() {
         return fmt.Errorf("not connected to broker")
     }
 
-    token := p.conn.GetClient().Publish(topic, 0, false, payload)
+    token := p.send(ctx, p.conn.GetClient(), topic, payload)
     if token.Wait() && token.Error() != nil {
-        atomic.AddUint64(&p.broker.stats.Errors, 1)
+        p.recordFailure(topic, token.Error())
+        return token.Error()
+    }
+
+    p.recordSuccess(topic, len(payload))
+    return nil
+}
+
+// PublishBatch publishes a batch of actions, sending every message before
+// waiting on their tokens so the broker round trips overlap. The publish
+// latency of an action runs from the start of the batch to its
+// acknowledgement. Failed actions are reported as broker.ActionErrors.
+func (p *PublisherImpl) PublishBatch(actions []*rule.Action) error {
+    if !p.conn.IsConnected() {
+        return fmt.Errorf("not connected to broker")
+    }
+
+    client := p.conn.GetClient()
+    tokens := make([]mqtt.Token, len(actions))
+    spans := make([]trace.Span, len(actions))
+    start := time.Now()
+    for i, action := range actions {
+        var ctx context.Context
+        ctx, spans[i] = p.startPublish(action)
+        tokens[i] = p.send(ctx, client, action.Topic, []byte(action.Payload))
+    }
+
+    var errs []error
+    for i, token := range tokens {
+        if token.Wait() && token.Error() != nil {
+            tracing.End(spans[i], token.Error())
+            p.recordFailure(actions[i].Topic, token.Error())
+            errs = append(errs, &broker.ActionError{Action: actions[i], Err: token.Error()})
+            continue
+        }
+        spans[i].End()
+        p.recordSuccess(actions[i].Topic, len(actions[i].Payload))
         p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-            m.IncActionsTotal("error")
+            m.ObservePublish(p.broker.name, time.Since(start))
+            m.ObserveReceiveToPublish(p.broker.name, actions[i].ReceivedAt)
         })
-        p.broker.logger.Error("failed to publish message",
-            "error", token.Error(),
-            "topic", topic)
-        return token.Error()
     }
 
+    return errors.Join(errs...)
+}
+
+// startPublish starts the publish span of an action
+func (p *PublisherImpl) startPublish(action *rule.Action) (context.Context, trace.Span) {
+    return tracing.StartPublish(action.SpanContext, "mqtt", p.broker.name, action.Topic, len(action.Payload))
+}
+
+// send publishes a message. MQTT 5 clients carry the trace context of ctx to
+// consumers in user properties; MQTT 3.1.1 has no user properties, so the
+// trace ends at the publish span.
+func (p *PublisherImpl) send(ctx context.Context, client mqtt.Client, topic string, payload []byte) mqtt.Token {
+    if pub, ok := client.(propertyPublisher); ok {
+        carrier := &propertyCarrier{}
+        tracing.Inject(ctx, carrier)
+        return pub.PublishWithProperties(topic, 0, false, payload, carrier.props)
+    }
+    return client.Publish(topic, 0, false, payload)
+}
+
+// recordSuccess updates stats and metrics for a published message
+func (p *PublisherImpl) recordSuccess(topic string, payloadSize int) {
     atomic.AddUint64(&p.broker.stats.MessagesPublished, 1)
     p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
         m.IncActionsTotal("success")
+        m.IncConnectionMessages(p.broker.name, "published")
     })
 
     p.broker.logger.Debug("published message",
         "topic", topic,
-        "payloadSize", len(payload))
+        "payloadSize", payloadSize)
+}
 
-    return nil
+// recordFailure updates stats and metrics for a failed publish
+func (p *PublisherImpl) recordFailure(topic string, err error) {
+    atomic.AddUint64(&p.broker.stats.Errors, 1)
+    p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.IncActionsTotal("error")
+        m.IncConnectionMessages(p.broker.name, "error")
+    })
+    p.broker.logger.Error("failed to publish message",
+        "error", err,
+        "topic", topic)
 }
 
 // PublishAction publishes a rule action
@@ -58,7 +136,10 @@ func (p *PublisherImpl) PublishAction(action *rule.Action) error {
         return fmt.Errorf("action cannot be nil")
     }
 
-    err := p.Publish(action.Topic, []byte(action.Payload))
+    ctx, span := p.startPublish(action)
+    start := time.Now()
+    err := p.publishContext(ctx, action.Topic, []byte(action.Payload))
+    tracing.End(span, err)
     if err != nil {
         p.broker.logger.Error("failed to publish action",
             "error", err,
@@ -66,6 +147,11 @@ func (p *PublisherImpl) PublishAction(action *rule.Action) error {
         return fmt.Errorf("failed to publish action: %w", err)
     }
 
+    p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.ObservePublish(p.broker.name, time.Since(start))
+        m.ObserveReceiveToPublish(p.broker.name, action.ReceivedAt)
+    })
+
     p.broker.logger.Debug("published action",
         "topic", action.Topic,
         "payload", action.Payload)
@@ -0,0 +1,163 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/rule"
+)
+
+// ackToken simulates a publish that is acknowledged after a network round trip
+type ackToken struct {
+	MockToken
+	ackedAt time.Time
+}
+
+func (t *ackToken) Wait() bool {
+	time.Sleep(time.Until(t.ackedAt))
+	return true
+}
+
+// newTestBroker creates an MQTT broker wired to a mock client
+func newTestBroker(client *MockClient) *MQTTBroker {
+	b := &MQTTBroker{
+		logger: NewMockLogger(),
+		name:   "test",
+	}
+	b.conn = NewConnectionManagerWithClient(b, client)
+	b.pub = NewPublisher(b)
+	return b
+}
+
+// newAckClient returns a mock client whose publishes take latency to be acknowledged
+func newAckClient(latency time.Duration) *MockClient {
+	client := NewMockClient()
+	client.publishFunc = func(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+		return &ackToken{MockToken: *NewMockToken(), ackedAt: time.Now().Add(latency)}
+	}
+	return client
+}
+
+func TestPublisher_PublishBatch(t *testing.T) {
+	client := NewMockClient()
+	var topics []string
+	client.publishFunc = func(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+		topics = append(topics, topic)
+		token := NewMockToken()
+		if topic == "fail" {
+			token.err = fmt.Errorf("publish rejected")
+		}
+		return token
+	}
+
+	b := newTestBroker(client)
+	err := b.pub.PublishBatch([]*rule.Action{
+		{Topic: "alerts/1", Payload: "a"},
+		{Topic: "fail", Payload: "b"},
+		{Topic: "alerts/2", Payload: "c"},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "publish rejected")
+	assert.Equal(t, []string{"alerts/1", "fail", "alerts/2"}, topics)
+	assert.Equal(t, uint64(2), b.stats.MessagesPublished)
+	assert.Equal(t, uint64(1), b.stats.Errors)
+}
+
+func TestPublisher_PublishBatchObservesPublishes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewMetrics(reg)
+	require.NoError(t, err)
+
+	b := newTestBroker(newAckClient(time.Millisecond))
+	b.metrics = m
+	require.NoError(t, b.pub.PublishBatch([]*rule.Action{
+		{Topic: "alerts/1", Payload: "a"},
+		{Topic: "alerts/2", Payload: "b"},
+	}))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	var observed uint64
+	for _, family := range families {
+		if family.GetName() == "publish_duration_seconds" {
+			for _, metric := range family.GetMetric() {
+				observed += metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	assert.Equal(t, uint64(2), observed)
+}
+
+// propertyClient is a mock MQTT 5 client that records published user properties
+type propertyClient struct {
+	*MockClient
+	props []paho.UserProperties
+}
+
+func (c *propertyClient) PublishWithProperties(topic string, qos byte, retained bool, payload []byte, props paho.UserProperties) mqtt.Token {
+	c.props = append(c.props, props)
+	return NewMockToken()
+}
+
+func TestPublisher_InjectsTraceContextIntoUserProperties(t *testing.T) {
+	client := &propertyClient{MockClient: NewMockClient()}
+	b := &MQTTBroker{logger: NewMockLogger(), name: "test"}
+	b.conn = NewConnectionManagerWithClient(b, client)
+	b.pub = NewPublisher(b)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	require.NoError(t, b.pub.PublishAction(&rule.Action{Topic: "alerts/1", Payload: "a", SpanContext: spanContext}))
+	require.NoError(t, b.pub.PublishBatch([]*rule.Action{{Topic: "alerts/2", Payload: "b", SpanContext: spanContext}}))
+
+	require.Len(t, client.props, 2)
+	for _, props := range client.props {
+		assert.Contains(t, props.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+	}
+}
+
+func BenchmarkPublishAction(b *testing.B) {
+	broker := newTestBroker(newAckClient(50 * time.Microsecond))
+	action := &rule.Action{Topic: "alerts/temperature", Payload: `{"alert":true}`}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := broker.pub.PublishAction(action); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublishBatch(b *testing.B) {
+	for _, size := range []int{10, 100} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			broker := newTestBroker(newAckClient(50 * time.Microsecond))
+			batch := make([]*rule.Action, size)
+			for i := range batch {
+				batch[i] = &rule.Action{Topic: "alerts/temperature", Payload: `{"alert":true}`}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i += size {
+				if err := broker.pub.PublishBatch(batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,14 +1,21 @@
 package mqtt
 
 import (
+    "context"
     "fmt"
     "sync"
     "sync/atomic"
+    "time"
 
     mqtt "github.com/eclipse/paho.mqtt.golang"
     "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/tracing"
 )
 
+// flowWaitTimeout bounds how long the message callback is held while
+// consumption is paused, well within the client's keepalive ping timeout
+const flowWaitTimeout = 5 * time.Second
+
 // SubscriptionManagerImpl implements the SubscriptionManager interface
 type SubscriptionManagerImpl struct {
     broker     *MQTTBroker
@@ -104,50 +111,44 @@ func (s *SubscriptionManagerImpl) Unsubscribe(topics []string) error {
     return nil
 }
 
-// HandleMessage processes received MQTT messages
+// HandleMessage queues received MQTT messages for the processor worker pool
 func (s *SubscriptionManagerImpl) HandleMessage(client mqtt.Client, msg mqtt.Message) {
     atomic.AddUint64(&s.broker.stats.MessagesReceived, 1)
 
     s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
         m.IncMessagesTotal("received")
+        m.IncConnectionMessages(s.broker.name, "received")
     })
 
-    s.broker.logger.Debug("processing message",
+    s.broker.logger.Debug("queueing message",
         "topic", msg.Topic(),
         "payloadSize", len(msg.Payload()))
 
-    actions, err := s.broker.processor.Process(msg.Topic(), msg.Payload())
-    if err != nil {
-        atomic.AddUint64(&s.broker.stats.Errors, 1)
-        s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-            m.IncMessagesTotal("error")
-        })
-        s.broker.logger.Error("failed to process message",
-            "error", err,
+    // Holding the callback while paused stops paho from reading further
+    // messages, which throttles the broker until the outbound side drains.
+    // The hold is bounded so that keepalives are still answered; the message
+    // is then queued anyway and the processor's overflow policy applies.
+    if s.broker.flow != nil && !s.broker.flow.WaitTimeout(flowWaitTimeout) {
+        s.broker.logger.Debug("consumption still paused, queueing message",
+            "connection", s.broker.name,
             "topic", msg.Topic())
-        return
     }
 
-    s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-        m.IncMessagesTotal("processed")
-    })
-
-    // Publish resulting actions
-    for _, action := range actions {
-        if err := s.pub.PublishAction(action); err != nil {
-            s.broker.logger.Error("failed to publish action",
-                "error", err,
-                "topic", action.Topic)
-        }
+    // Continue the sender's trace when an MQTT 5 message carries trace
+    // context in its user properties; MQTT 3.1.1 messages start a new trace
+    ctx := context.Background()
+    if props, ok := msg.(propertyMessage); ok {
+        ctx = tracing.Extract(ctx, &propertyCarrier{props: props.UserProperties()})
     }
+    ctx, span := tracing.StartReceive(ctx, "mqtt", s.broker.name, msg.Topic(), len(msg.Payload()))
 
-    // Update queue metrics if enabled
-    s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-        m.SetMessageQueueDepth(float64(len(s.broker.processor.GetJobChannel())))
-        m.SetProcessingBacklog(float64(
-            atomic.LoadUint64(&s.broker.stats.MessagesReceived) -
-            atomic.LoadUint64(&s.broker.stats.MessagesPublished)))
-    })
+    err := s.broker.processor.SubmitContext(ctx, msg.Topic(), msg.Payload())
+    tracing.End(span, err)
+    if err != nil {
+        s.broker.logger.Debug("message not queued",
+            "error", err,
+            "topic", msg.Topic())
+    }
 }
 
 // ResubscribeAll resubscribes to all topics after a reconnection
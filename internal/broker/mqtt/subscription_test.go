@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"mqtt-mux-router/internal/rule"
+)
+
+// newProcessingBroker creates a test broker with a processor that reports the
+// span context of each processed message
+func newProcessingBroker(t *testing.T) (*MQTTBroker, <-chan trace.SpanContext) {
+	b := newTestBroker(NewMockClient())
+	b.processor = rule.NewProcessor(rule.ProcessorConfig{Name: b.name, Workers: 1, QueueSize: 10}, b.logger, nil)
+	t.Cleanup(b.processor.Close)
+
+	spans := make(chan trace.SpanContext, 1)
+	b.processor.SetResultHandler(func(result *rule.ProcessingResult) {
+		spans <- result.Message.SpanContext
+	})
+	require.NoError(t, b.processor.LoadRules([]rule.Rule{{
+		Topic:  "sensors/temperature",
+		Action: &rule.Action{Topic: "alerts", Payload: "a"},
+	}}))
+
+	b.sub = NewSubscriptionManager(b)
+	return b, spans
+}
+
+func TestSubscription_ContinuesTraceFromUserProperties(t *testing.T) {
+	b, spans := newProcessingBroker(t)
+
+	b.sub.HandleMessage(nil, &v5Message{packet: &paho.Publish{
+		Topic:   "sensors/temperature",
+		Payload: []byte(`{}`),
+		Properties: &paho.PublishProperties{User: paho.UserProperties{
+			{Key: "traceparent", Value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		}},
+	}})
+
+	select {
+	case spanContext := <-spans:
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID().String())
+	case <-time.After(time.Second):
+		t.Fatal("message not processed")
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"sensors/temperature", "sensors/temperature", true},
+		{"sensors/+/temperature", "sensors/a/temperature", true},
+		{"sensors/+", "sensors/a/temperature", false},
+		{"sensors/#", "sensors/a/temperature", true},
+		{"sensors/#", "sensors", true},
+		{"sensors/temperature", "sensors", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, topicMatches(tt.filter, tt.topic), "%s matches %s", tt.filter, tt.topic)
+	}
+}
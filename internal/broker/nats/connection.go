@@ -32,13 +32,13 @@ func NewConnectionManager(broker *NATSBroker) (ConnectionManager, error) {
 
 // Connect establishes connection to the NATS server
 func (cm *ConnectionManagerImpl) Connect() error {
-	if len(cm.broker.config.NATS.URLs) == 0 {
+	if len(cm.broker.connConfig.URLs) == 0 {
 		return fmt.Errorf("no NATS server URLs provided")
 	}
 
 	// Create connection options
 	opts := []nats.Option{
-		nats.Name(cm.broker.config.NATS.ClientID),
+		nats.Name(cm.broker.connConfig.ClientID),
 		nats.ReconnectWait(time.Second * 2),
 		nats.MaxReconnects(-1), // Unlimited reconnects
 		nats.DisconnectErrHandler(cm.handleDisconnect),
@@ -47,33 +47,35 @@ func (cm *ConnectionManagerImpl) Connect() error {
 	}
 
 	// Add authentication if configured
-	if cm.broker.config.NATS.Username != "" {
+	if cm.broker.connConfig.Username != "" {
 		opts = append(opts, nats.UserInfo(
-			cm.broker.config.NATS.Username, 
-			cm.broker.config.NATS.Password))
+			cm.broker.connConfig.Username, 
+			cm.broker.connConfig.Password))
 	}
 
 	// Configure TLS if enabled
-	if cm.broker.config.NATS.TLS.Enable {
+	if cm.broker.connConfig.TLS.Enable {
 		// ClientCert function only returns a single Option, not an error
 		certOpt := nats.ClientCert(
-			cm.broker.config.NATS.TLS.CertFile,
-			cm.broker.config.NATS.TLS.KeyFile,
+			cm.broker.connConfig.TLS.CertFile,
+			cm.broker.connConfig.TLS.KeyFile,
 		)
 		opts = append(opts, certOpt)
 
 		// Add CA certificates if provided
-		if cm.broker.config.NATS.TLS.CAFile != "" {
-			rootCAOpt := nats.RootCAs(cm.broker.config.NATS.TLS.CAFile)
+		if cm.broker.connConfig.TLS.CAFile != "" {
+			rootCAOpt := nats.RootCAs(cm.broker.connConfig.TLS.CAFile)
 			opts = append(opts, rootCAOpt)
 		}
 	}
 
 	// Connect to the NATS server
-	cm.broker.logger.Info("connecting to NATS server", "urls", cm.broker.config.NATS.URLs)
+	cm.broker.logger.Info("connecting to NATS server",
+		"connection", cm.broker.name,
+		"urls", cm.broker.connConfig.URLs)
 
 	var err error
-	cm.conn, err = nats.Connect(cm.broker.config.NATS.URLs[0], opts...)
+	cm.conn, err = nats.Connect(cm.broker.connConfig.URLs[0], opts...)
 	if err != nil {
 		return fmt.Errorf("failed to connect to NATS server: %w", err)
 	}
@@ -83,7 +85,7 @@ func (cm *ConnectionManagerImpl) Connect() error {
 
 	// Update metrics
 	cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-		m.SetMQTTConnectionStatus(true)
+		m.SetConnectionStatus("nats", cm.broker.name, true)
 	})
 
 	cm.broker.logger.Info("connected to NATS server", "url", cm.conn.ConnectedUrl())
@@ -117,7 +119,7 @@ func (cm *ConnectionManagerImpl) handleDisconnect(conn *nats.Conn, err error) {
 	cm.connected.Store(false)
 
 	cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-		m.SetMQTTConnectionStatus(false)
+		m.SetConnectionStatus("nats", cm.broker.name, false)
 	})
 }
 
@@ -127,8 +129,8 @@ func (cm *ConnectionManagerImpl) handleReconnect(conn *nats.Conn) {
 	cm.broker.stats.LastReconnect = time.Now()
 
 	cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-		m.SetMQTTConnectionStatus(true)
-		m.IncMQTTReconnects()
+		m.SetConnectionStatus("nats", cm.broker.name, true)
+		m.IncReconnects("nats", cm.broker.name)
 	})
 
 	// Restore rules and subscriptions
@@ -140,6 +142,6 @@ func (cm *ConnectionManagerImpl) handleClosed(conn *nats.Conn) {
 	cm.connected.Store(false)
 
 	cm.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-		m.SetMQTTConnectionStatus(false)
+		m.SetConnectionStatus("nats", cm.broker.name, false)
 	})
 }
@@ -0,0 +1,39 @@
+package nats
+
+import (
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// headerCarrier adapts NATS message headers for trace context propagation.
+// Keys are written as given, lowercase for W3C trace context, and read
+// case-insensitively since publishers may canonicalize them.
+type headerCarrier nats.Header
+
+// Get returns the first value of a header
+func (c headerCarrier) Get(key string) string {
+	if values, ok := c[key]; ok && len(values) > 0 {
+		return values[0]
+	}
+	for k, values := range c {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Set replaces the values of a header
+func (c headerCarrier) Set(key, value string) {
+	c[key] = []string{value}
+}
+
+// Keys lists the header names
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
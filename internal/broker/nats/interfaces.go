@@ -28,4 +28,5 @@ type SubscriptionManager interface {
 type Publisher interface {
 	Publish(topic string, payload []byte) error
 	PublishAction(action *rule.Action) error
+	PublishBatch(actions []*rule.Action) error
 }
@@ -1,13 +1,22 @@
 package nats
 
 import (
+	"errors"
 	"fmt"
 	"sync/atomic"
+	"time"
 
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
+	"mqtt-mux-router/internal/broker"
 	"mqtt-mux-router/internal/metrics"
 	"mqtt-mux-router/internal/rule"
+	"mqtt-mux-router/internal/tracing"
 )
 
+// batchFlushTimeout bounds how long a batch waits for the server to confirm it
+const batchFlushTimeout = 5 * time.Second
+
 // PublisherImpl implements the Publisher interface for NATS
 type PublisherImpl struct {
 	broker *NATSBroker
@@ -24,38 +33,114 @@ func NewPublisher(broker *NATSBroker, conn ConnectionManager) Publisher {
 
 // Publish sends a message to a specific topic
 func (p *PublisherImpl) Publish(topic string, payload []byte) error {
+	// Convert MQTT topic to NATS subject
+	return p.publishMsg(topic, &nats.Msg{Subject: ToNATSSubject(topic), Data: payload})
+}
+
+// publishMsg sends a prepared message for a topic
+func (p *PublisherImpl) publishMsg(topic string, msg *nats.Msg) error {
 	if !p.conn.IsConnected() {
 		return fmt.Errorf("not connected to NATS server")
 	}
 
-	// Convert MQTT topic to NATS subject
-	subject := ToNATSSubject(topic)
+	if err := p.conn.GetConnection().PublishMsg(msg); err != nil {
+		p.recordFailure(topic, msg.Subject, err)
+		return err
+	}
 
-	// Publish to NATS
-	err := p.conn.GetConnection().Publish(subject, payload)
-	if err != nil {
-		atomic.AddUint64(&p.broker.stats.Errors, 1)
+	p.recordSuccess(topic, msg.Subject, len(msg.Data))
+	return nil
+}
+
+// newActionMsg builds the message for an action and starts its publish span,
+// injecting the span's trace context into the headers so consumers of the
+// action continue the trace
+func (p *PublisherImpl) newActionMsg(action *rule.Action) (*nats.Msg, trace.Span) {
+	ctx, span := tracing.StartPublish(action.SpanContext, "nats", p.broker.name, action.Topic, len(action.Payload))
+
+	msg := &nats.Msg{Subject: ToNATSSubject(action.Topic), Data: []byte(action.Payload)}
+	if span.SpanContext().IsValid() {
+		msg.Header = nats.Header{}
+		tracing.Inject(ctx, headerCarrier(msg.Header))
+	}
+	return msg, span
+}
+
+// PublishBatch publishes a batch of actions and flushes the connection once
+// for the whole batch, so the batch is confirmed by the server in one round trip.
+// Failed actions are reported as broker.ActionErrors; a failed flush fails the
+// whole batch.
+func (p *PublisherImpl) PublishBatch(actions []*rule.Action) error {
+	if !p.conn.IsConnected() {
+		return fmt.Errorf("not connected to NATS server")
+	}
+
+	natsConn := p.conn.GetConnection()
+	published := make([]*rule.Action, 0, len(actions))
+	spans := make([]trace.Span, 0, len(actions))
+	start := time.Now()
+
+	var errs []error
+	for _, action := range actions {
+		msg, span := p.newActionMsg(action)
+		if err := natsConn.PublishMsg(msg); err != nil {
+			tracing.End(span, err)
+			p.recordFailure(action.Topic, msg.Subject, err)
+			errs = append(errs, &broker.ActionError{Action: action, Err: err})
+			continue
+		}
+		published = append(published, action)
+		spans = append(spans, span)
+	}
+
+	if err := natsConn.FlushTimeout(batchFlushTimeout); err != nil {
+		for i, action := range published {
+			tracing.End(spans[i], err)
+			p.recordFailure(action.Topic, ToNATSSubject(action.Topic), err)
+		}
+		return errors.Join(append(errs, fmt.Errorf("failed to flush batch: %w", err))...)
+	}
+
+	// Every action of the batch is confirmed by the flush
+	elapsed := time.Since(start)
+
+	for i, action := range published {
+		spans[i].End()
+		p.recordSuccess(action.Topic, ToNATSSubject(action.Topic), len(action.Payload))
 		p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-			m.IncActionsTotal("error")
+			m.ObservePublish(p.broker.name, elapsed)
+			m.ObserveReceiveToPublish(p.broker.name, action.ReceivedAt)
 		})
-		p.broker.logger.Error("failed to publish message",
-			"error", err,
-			"topic", topic,
-			"subject", subject)
-		return err
 	}
 
+	return errors.Join(errs...)
+}
+
+// recordSuccess updates stats and metrics for a published message
+func (p *PublisherImpl) recordSuccess(topic, subject string, payloadSize int) {
 	atomic.AddUint64(&p.broker.stats.MessagesPublished, 1)
 	p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
 		m.IncActionsTotal("success")
+		m.IncConnectionMessages(p.broker.name, "published")
 	})
 
 	p.broker.logger.Debug("published message",
 		"topic", topic,
 		"subject", subject,
-		"payloadSize", len(payload))
+		"payloadSize", payloadSize)
+}
 
-	return nil
+// recordFailure updates stats and metrics for a failed publish
+func (p *PublisherImpl) recordFailure(topic, subject string, err error) {
+	atomic.AddUint64(&p.broker.stats.Errors, 1)
+	p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.IncActionsTotal("error")
+		m.IncConnectionMessages(p.broker.name, "error")
+	})
+	p.broker.logger.Error("failed to publish message",
+		"error", err,
+		"topic", topic,
+		"subject", subject)
 }
 
 // PublishAction publishes a rule action
@@ -64,7 +149,10 @@ func (p *PublisherImpl) PublishAction(action *rule.Action) error {
 		return fmt.Errorf("action cannot be nil")
 	}
 
-	err := p.Publish(action.Topic, []byte(action.Payload))
+	msg, span := p.newActionMsg(action)
+	start := time.Now()
+	err := p.publishMsg(action.Topic, msg)
+	tracing.End(span, err)
 	if err != nil {
 		p.broker.logger.Error("failed to publish action",
 			"error", err,
@@ -72,6 +160,11 @@ func (p *PublisherImpl) PublishAction(action *rule.Action) error {
 		return fmt.Errorf("failed to publish action: %w", err)
 	}
 
+	p.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
+		m.ObservePublish(p.broker.name, time.Since(start))
+		m.ObserveReceiveToPublish(p.broker.name, action.ReceivedAt)
+	})
+
 	p.broker.logger.Debug("published action",
 		"topic", action.Topic,
 		"payload", action.Payload)
@@ -1,12 +1,17 @@
 package nats
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/rule"
+	"mqtt-mux-router/internal/tracing"
 )
 
 // SubscriptionManagerImpl implements SubscriptionManager for NATS
@@ -61,12 +66,21 @@ func (s *SubscriptionManagerImpl) Subscribe(topics []string) error {
 
 // subscribeTopic handles subscription to a single topic
 func (s *SubscriptionManagerImpl) subscribeTopic(topic string) error {
+	if s.broker.connConfig.JetStream.Enabled {
+		return s.subscribePull(topic)
+	}
+
 	// Convert MQTT topic to NATS subject
 	subject := ToNATSSubject(topic)
 
 	// Subscribe to the NATS subject
 	natConn := s.conn.GetConnection()
 	sub, err := natConn.Subscribe(subject, func(msg *nats.Msg) {
+		// Holding the callback while paused lets the subscription's pending
+		// buffer absorb the backlog until the outbound side drains
+		if s.broker.flow != nil {
+			s.broker.flow.Wait()
+		}
 		s.handleMessage(topic, msg)
 	})
 
@@ -80,6 +94,101 @@ func (s *SubscriptionManagerImpl) subscribeTopic(topic string) error {
 	return nil
 }
 
+// subscribePull binds a durable JetStream pull consumer to a topic and
+// starts fetching from it. The consumer is created outside the subscription
+// so that unsubscribing does not delete it and its position survives restarts
+func (s *SubscriptionManagerImpl) subscribePull(topic string) error {
+	jsCfg := s.broker.connConfig.JetStream
+	subject := ToNATSSubject(topic)
+
+	js, err := s.conn.GetConnection().JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to get jetstream context: %w", err)
+	}
+
+	stream, err := js.StreamNameBySubject(subject)
+	if err != nil {
+		return fmt.Errorf("no stream for subject %s: %w", subject, err)
+	}
+
+	name := ConsumerName(jsCfg.Durable, subject)
+	if _, err := js.ConsumerInfo(stream, name); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:       name,
+			FilterSubject: subject,
+			AckPolicy:     nats.AckExplicitPolicy,
+			DeliverPolicy: nats.DeliverNewPolicy,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create consumer %s: %w", name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to look up consumer %s: %w", name, err)
+	}
+
+	sub, err := js.PullSubscribe(subject, name, nats.Bind(stream, name))
+	if err != nil {
+		return err
+	}
+
+	s.subs[topic] = sub
+	go s.fetchLoop(topic, sub)
+
+	s.broker.logger.Debug("bound jetstream consumer",
+		"topic", topic,
+		"stream", stream,
+		"consumer", name)
+
+	return nil
+}
+
+// fetchLoop pulls batches from a JetStream consumer until it is unsubscribed.
+// No fetch is issued while consumption is paused, so unacknowledged
+// messages stay on the server instead of piling up in the router
+func (s *SubscriptionManagerImpl) fetchLoop(topic string, sub *nats.Subscription) {
+	jsCfg := s.broker.connConfig.JetStream
+	// Validated at config load
+	fetchWait, _ := time.ParseDuration(jsCfg.FetchWait)
+
+	for sub.IsValid() {
+		if s.broker.flow != nil {
+			s.broker.flow.Wait()
+		}
+
+		msgs, err := sub.Fetch(jsCfg.FetchBatch, nats.MaxWait(fetchWait))
+		if err != nil {
+			if errors.Is(err, nats.ErrTimeout) {
+				continue
+			}
+			if !sub.IsValid() {
+				return
+			}
+			s.broker.logger.Error("jetstream fetch failed",
+				"topic", topic,
+				"error", err)
+			time.Sleep(fetchWait)
+			continue
+		}
+
+		for _, msg := range msgs {
+			// Messages fetched as the router shuts down are left for redelivery
+			if err := s.handleMessage(topic, msg); errors.Is(err, rule.ErrProcessorClosed) {
+				if err := msg.Nak(); err != nil {
+					s.broker.logger.Debug("failed to nak jetstream message",
+						"topic", topic,
+						"error", err)
+				}
+				continue
+			}
+			if err := msg.Ack(); err != nil {
+				s.broker.logger.Debug("failed to ack jetstream message",
+					"topic", topic,
+					"error", err)
+			}
+		}
+	}
+}
+
 // Unsubscribe removes subscriptions for provided topics
 func (s *SubscriptionManagerImpl) Unsubscribe(topics []string) error {
 	s.mu.Lock()
@@ -163,52 +272,42 @@ func (s *SubscriptionManagerImpl) IsSubscribed() bool {
 	return s.subscribed
 }
 
-// handleMessage processes a received NATS message
-func (s *SubscriptionManagerImpl) handleMessage(originalTopic string, msg *nats.Msg) {
+// handleMessage queues a received NATS message for the processor worker pool
+// and returns the error of messages that were not queued
+func (s *SubscriptionManagerImpl) handleMessage(originalTopic string, msg *nats.Msg) error {
 	// Update statistics
 	atomic.AddUint64(&s.broker.stats.MessagesReceived, 1)
 
 	s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
 		m.IncMessagesTotal("received")
+		m.IncConnectionMessages(s.broker.name, "received")
 	})
 
-	s.broker.logger.Debug("processing message",
+	s.broker.logger.Debug("queueing message",
 		"topic", originalTopic,
 		"subject", msg.Subject,
 		"payloadSize", len(msg.Data))
 
-	// Process the message with the rule processor
-	// Using the original MQTT-format topic
-	actions, err := s.broker.processor.Process(originalTopic, msg.Data)
+	// Continue the sender's trace when the message carries trace context
+	ctx := context.Background()
+	if msg.Header != nil {
+		ctx = tracing.Extract(ctx, headerCarrier(msg.Header))
+	}
+	ctx, span := tracing.StartReceive(ctx, "nats", s.broker.name, originalTopic, len(msg.Data))
+
+	// Rules are indexed by the original MQTT-format topic, which differs
+	// from the received subject on wildcard subscriptions
+	var opts rule.SubmitOptions
+	if source := ToMQTTTopic(msg.Subject); source != originalTopic {
+		opts.Source = source
+	}
+	err := s.broker.processor.SubmitWith(ctx, originalTopic, msg.Data, opts)
+	tracing.End(span, err)
 	if err != nil {
-		atomic.AddUint64(&s.broker.stats.Errors, 1)
-		s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-			m.IncMessagesTotal("error")
-		})
-		s.broker.logger.Error("failed to process message",
+		s.broker.logger.Debug("message not queued",
 			"error", err,
 			"topic", originalTopic)
-		return
-	}
-
-	s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-		m.IncMessagesTotal("processed")
-	})
-
-	// Publish resulting actions
-	for _, action := range actions {
-		if err := s.pub.PublishAction(action); err != nil {
-			s.broker.logger.Error("failed to publish action",
-				"error", err,
-				"topic", action.Topic)
-		}
+		return err
 	}
-
-	// Update queue metrics if enabled
-	s.broker.safeMetricsUpdate(func(m *metrics.Metrics) {
-		m.SetMessageQueueDepth(float64(len(s.broker.processor.GetJobChannel())))
-		m.SetProcessingBacklog(float64(
-			atomic.LoadUint64(&s.broker.stats.MessagesReceived) -
-				atomic.LoadUint64(&s.broker.stats.MessagesPublished)))
-	})
+	return nil
 }
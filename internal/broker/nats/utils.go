@@ -45,3 +45,14 @@ func NormalizeSubject(subject string) string {
 	)
 	return replacer.Replace(subject)
 }
+
+// ConsumerName derives a JetStream durable consumer name for a subject.
+// Consumer names cannot contain '.', '*' or '>', so those are replaced
+func ConsumerName(prefix, subject string) string {
+	replacer := strings.NewReplacer(
+		".", "_",
+		"*", "any",
+		">", "all",
+	)
+	return prefix + "_" + replacer.Replace(subject)
+}
@@ -0,0 +1,197 @@
+package broker
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// ErrOutboundClosed is returned when an action is enqueued after Close
+var ErrOutboundClosed = errors.New("outbound queue is closed")
+
+// connectPollInterval is how often the sender checks a lost connection
+const connectPollInterval = 100 * time.Millisecond
+
+// OutboundQueue is a bounded queue of actions awaiting publication on one
+// connection. A single sender publishes them in order, waiting while the
+// connection is down, and reports the queue depth to the flow controller.
+type OutboundQueue struct {
+    name      string
+    queue     chan *rule.Action
+    publish   func(action *rule.Action) error
+    connected func() bool
+    flow      *FlowController
+    logger    *logger.Logger
+    metrics   *metrics.Metrics
+
+    done      chan struct{}
+    stopped   chan struct{}
+    closeOnce sync.Once
+    // abandon stops the sender after a timed out drain
+    abandon atomic.Bool
+}
+
+// NewOutboundQueue creates an outbound queue for the named connection. The
+// flow controller is optional.
+func NewOutboundQueue(name string, size int, publish func(action *rule.Action) error, connected func() bool, flow *FlowController, log *logger.Logger, metricsService *metrics.Metrics) *OutboundQueue {
+    if size < 1 {
+        size = 1
+    }
+
+    return &OutboundQueue{
+        name:      name,
+        queue:     make(chan *rule.Action, size),
+        publish:   publish,
+        connected: connected,
+        flow:      flow,
+        logger:    log,
+        metrics:   metricsService,
+        done:      make(chan struct{}),
+        stopped:   make(chan struct{}),
+    }
+}
+
+// Start launches the sender goroutine
+func (q *OutboundQueue) Start() {
+    go q.run()
+}
+
+// Enqueue adds an action to the queue, blocking while the queue is full
+func (q *OutboundQueue) Enqueue(action *rule.Action) error {
+    select {
+    case <-q.done:
+        return ErrOutboundClosed
+    default:
+    }
+
+    select {
+    case q.queue <- action:
+    case <-q.done:
+        return ErrOutboundClosed
+    }
+
+    q.report()
+    return nil
+}
+
+// Depth returns the number of actions waiting to be published
+func (q *OutboundQueue) Depth() int {
+    return len(q.queue)
+}
+
+// Close stops the sender after it has attempted to publish every queued action
+func (q *OutboundQueue) Close() {
+    q.closeOnce.Do(func() {
+        close(q.done)
+    })
+    <-q.stopped
+}
+
+// Drain stops accepting actions and publishes the queued ones until ctx is
+// done. Either way it returns once the sender has stopped, after finishing
+// the action in hand. It returns how many queued actions were published and
+// how many were abandoned.
+func (q *OutboundQueue) Drain(ctx context.Context) (drained, abandoned int) {
+    queued := q.Depth()
+    q.closeOnce.Do(func() {
+        close(q.done)
+    })
+
+    select {
+    case <-q.stopped:
+        return queued, 0
+    case <-ctx.Done():
+    }
+
+    // Once abandon is set nothing left in the queue is published
+    q.abandon.Store(true)
+    abandoned = q.Depth()
+    if abandoned > queued {
+        abandoned = queued
+    }
+    <-q.stopped
+    return queued - abandoned, abandoned
+}
+
+// run publishes queued actions in order until the queue is closed
+func (q *OutboundQueue) run() {
+    defer close(q.stopped)
+
+    for {
+        select {
+        case action := <-q.queue:
+            q.waitConnected()
+            // Actions taken after a drain deadline are abandoned
+            if q.abandon.Load() {
+                return
+            }
+            q.send(action)
+        case <-q.done:
+            q.drain()
+            return
+        }
+    }
+}
+
+// waitConnected blocks while the connection is down, unless the queue closes
+func (q *OutboundQueue) waitConnected() {
+    if q.connected() {
+        return
+    }
+
+    q.logger.Info("outbound queue waiting for connection",
+        "connection", q.name,
+        "depth", q.Depth())
+
+    ticker := time.NewTicker(connectPollInterval)
+    defer ticker.Stop()
+
+    for !q.connected() {
+        select {
+        case <-q.done:
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// drain publishes whatever remains in the queue at close, unless a drain
+// deadline has passed
+func (q *OutboundQueue) drain() {
+    for !q.abandon.Load() {
+        select {
+        case action := <-q.queue:
+            q.send(action)
+        default:
+            return
+        }
+    }
+}
+
+// send publishes a single action and reports the new depth
+func (q *OutboundQueue) send(action *rule.Action) {
+    if err := q.publish(action); err != nil {
+        q.logger.Error("failed to publish queued action",
+            "connection", q.name,
+            "topic", action.Topic,
+            "error", err)
+    }
+    q.report()
+}
+
+// report updates the flow controller and metrics with the current depth
+func (q *OutboundQueue) report() {
+    depth := q.Depth()
+    if q.flow != nil {
+        q.flow.Update(q.name, depth)
+    }
+    if q.metrics != nil {
+        q.metrics.SetOutboundQueueDepth(q.name, float64(depth))
+    }
+}
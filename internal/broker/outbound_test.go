@@ -0,0 +1,144 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+// gatedPublisher records published topics and blocks until released
+type gatedPublisher struct {
+	gate      chan struct{}
+	published []string
+	mu        sync.Mutex
+}
+
+func (p *gatedPublisher) publish(action *rule.Action) error {
+	<-p.gate
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, action.Topic)
+	return nil
+}
+
+func (p *gatedPublisher) topics() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.published...)
+}
+
+func newTestOutboundQueue(t *testing.T, size int, connected func() bool, flow *FlowController) (*OutboundQueue, *gatedPublisher) {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	pub := &gatedPublisher{gate: make(chan struct{})}
+	q := NewOutboundQueue("test", size, pub.publish, connected, flow, &logger.Logger{Logger: zapLogger}, nil)
+	q.Start()
+	return q, pub
+}
+
+func alwaysConnected() bool { return true }
+
+func TestOutboundQueue_PublishesInOrder(t *testing.T) {
+	q, pub := newTestOutboundQueue(t, 10, alwaysConnected, nil)
+	close(pub.gate)
+
+	for _, topic := range []string{"a", "b", "c"} {
+		require.NoError(t, q.Enqueue(&rule.Action{Topic: topic}))
+	}
+
+	q.Close()
+	assert.Equal(t, []string{"a", "b", "c"}, pub.topics())
+
+	assert.ErrorIs(t, q.Enqueue(&rule.Action{Topic: "d"}), ErrOutboundClosed)
+}
+
+func TestOutboundQueue_Backpressure(t *testing.T) {
+	flow := newTestFlowController(t, 4, 1)
+	q, pub := newTestOutboundQueue(t, 5, alwaysConnected, flow)
+
+	// The sender holds the first action while the rest pile up
+	for i := 0; i < 5; i++ {
+		require.NoError(t, q.Enqueue(&rule.Action{Topic: "out"}))
+	}
+	assert.Eventually(t, flow.Paused, time.Second, 5*time.Millisecond)
+
+	close(pub.gate)
+	assert.Eventually(t, func() bool { return !flow.Paused() }, time.Second, 5*time.Millisecond)
+
+	q.Close()
+	assert.Len(t, pub.topics(), 5)
+}
+
+func TestOutboundQueue_WaitsForConnection(t *testing.T) {
+	var connected atomic.Bool
+	q, pub := newTestOutboundQueue(t, 10, connected.Load, nil)
+	close(pub.gate)
+
+	require.NoError(t, q.Enqueue(&rule.Action{Topic: "a"}))
+	time.Sleep(2 * connectPollInterval)
+	assert.Empty(t, pub.topics(), "nothing should be sent while disconnected")
+
+	connected.Store(true)
+	assert.Eventually(t, func() bool { return len(pub.topics()) == 1 }, time.Second, 10*time.Millisecond)
+
+	q.Close()
+}
+
+func TestOutboundQueue_Drain(t *testing.T) {
+	enqueue := func(t *testing.T, q *OutboundQueue) {
+		for _, topic := range []string{"a", "b", "c"} {
+			require.NoError(t, q.Enqueue(&rule.Action{Topic: topic}))
+		}
+		// The sender holds the first action at the gate
+		require.Eventually(t, func() bool { return q.Depth() == 2 }, time.Second, 5*time.Millisecond)
+	}
+
+	t.Run("publishes queued actions", func(t *testing.T) {
+		q, pub := newTestOutboundQueue(t, 5, alwaysConnected, nil)
+		enqueue(t, q)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(pub.gate)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained, abandoned := q.Drain(ctx)
+		assert.Equal(t, 2, drained)
+		assert.Zero(t, abandoned)
+		assert.Equal(t, []string{"a", "b", "c"}, pub.topics())
+		assert.ErrorIs(t, q.Enqueue(&rule.Action{Topic: "d"}), ErrOutboundClosed)
+	})
+
+	t.Run("abandons queued actions at the deadline", func(t *testing.T) {
+		q, pub := newTestOutboundQueue(t, 5, alwaysConnected, nil)
+		enqueue(t, q)
+
+		// The action in hand is released after the deadline
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(pub.gate)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		drained, abandoned := q.Drain(ctx)
+		assert.Zero(t, drained)
+		assert.Equal(t, 2, abandoned)
+
+		// Drain returns once the sender has stopped
+		assert.Equal(t, []string{"a"}, pub.topics())
+		q.Close()
+	})
+}
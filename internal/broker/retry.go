@@ -0,0 +1,311 @@
+package broker
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math/rand"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/rule"
+)
+
+// drainPollInterval is how often Drain checks for pending retries
+const drainPollInterval = 50 * time.Millisecond
+
+// RetryPolicy controls how failed publishes are retried
+type RetryPolicy struct {
+    // MaxAttempts is the total number of attempts including the first;
+    // 1 or less disables retries
+    MaxAttempts    int
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    // Jitter randomizes each backoff by up to this fraction in either direction
+    Jitter float64
+}
+
+// Backoff returns the delay before the given retry, counting from 1. The
+// delay doubles with every retry up to MaxBackoff before jitter is applied.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+    delay := p.InitialBackoff
+    for i := 1; i < retry && delay < p.MaxBackoff; i++ {
+        delay *= 2
+    }
+    if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+        delay = p.MaxBackoff
+    }
+
+    if p.Jitter > 0 {
+        delay += time.Duration(float64(delay) * p.Jitter * (2*rand.Float64() - 1))
+    }
+
+    return delay
+}
+
+// WithOverrides returns the policy with the set fields of an action's retry
+// policy applied. Durations are validated when rules are loaded.
+func (p RetryPolicy) WithOverrides(override *rule.RetryPolicy) RetryPolicy {
+    if override == nil {
+        return p
+    }
+
+    if override.MaxAttempts > 0 {
+        p.MaxAttempts = override.MaxAttempts
+    }
+    if d, err := time.ParseDuration(override.InitialBackoff); err == nil {
+        p.InitialBackoff = d
+    }
+    if d, err := time.ParseDuration(override.MaxBackoff); err == nil {
+        p.MaxBackoff = d
+    }
+    if override.Jitter > 0 {
+        p.Jitter = override.Jitter
+    }
+
+    return p
+}
+
+// ActionError reports a failed publish of a single action within a batch
+type ActionError struct {
+    Action *rule.Action
+    Err    error
+}
+
+func (e *ActionError) Error() string {
+    return fmt.Sprintf("topic %s: %v", e.Action.Topic, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+    return e.Err
+}
+
+// FailedActions returns the actions of a batch that failed to publish. When
+// the error reports every failure as an ActionError only those actions are
+// returned; any other error fails the whole batch.
+func FailedActions(batch []*rule.Action, err error) []*rule.Action {
+    if err == nil {
+        return nil
+    }
+
+    errs := []error{err}
+    if joined, ok := err.(interface{ Unwrap() []error }); ok {
+        errs = joined.Unwrap()
+    }
+
+    failed := make([]*rule.Action, 0, len(errs))
+    for _, e := range errs {
+        var actionErr *ActionError
+        if !errors.As(e, &actionErr) {
+            return batch
+        }
+        failed = append(failed, actionErr.Action)
+    }
+
+    return failed
+}
+
+// Retrier republishes failed actions after an exponential backoff. Retries
+// run on timers so a failing action never holds up the messages behind it.
+type Retrier struct {
+    name    string
+    policy  RetryPolicy
+    publish func(action *rule.Action) error
+    logger  *logger.Logger
+    metrics *metrics.Metrics
+
+    onGiveUp FailureHandler
+
+    pending map[uint64]*pendingRetry
+    nextID  uint64
+    closed bool
+    mu     sync.Mutex
+
+    // inflight counts retries that are publishing, which Close waits for
+    inflight sync.WaitGroup
+
+    retries  uint64
+    failures uint64
+}
+
+// pendingRetry is a scheduled retry and the failure that caused it
+type pendingRetry struct {
+    timer  *time.Timer
+    action *rule.Action
+    err    error
+}
+
+// RetryStats holds retry counters for a connection
+type RetryStats struct {
+    Retries  uint64
+    Failures uint64
+    Pending  int
+}
+
+// NewRetrier creates a retrier for the named connection. publish is called
+// for every retry and should not retry on its own.
+func NewRetrier(name string, policy RetryPolicy, publish func(action *rule.Action) error, log *logger.Logger, metricsService *metrics.Metrics) *Retrier {
+    return &Retrier{
+        name:    name,
+        policy:  policy,
+        publish: publish,
+        logger:  log,
+        metrics: metricsService,
+        pending: make(map[uint64]*pendingRetry),
+    }
+}
+
+// SetGiveUpHandler sets the handler for actions that will not be retried
+// again, typically a dead-letter sink. It must be called before the first retry.
+func (r *Retrier) SetGiveUpHandler(handler FailureHandler) {
+    r.onGiveUp = handler
+}
+
+// Retry takes ownership of an action whose first publish attempt failed
+func (r *Retrier) Retry(action *rule.Action, err error) {
+    r.schedule(action, r.policy.WithOverrides(action.Retry), 1, err)
+}
+
+// schedule retries an action that has failed the given number of attempts,
+// or gives up once the policy is exhausted
+func (r *Retrier) schedule(action *rule.Action, policy RetryPolicy, attempts int, err error) {
+    if attempts >= policy.MaxAttempts {
+        r.giveUp(action, attempts, err)
+        return
+    }
+
+    r.mu.Lock()
+    if r.closed {
+        r.mu.Unlock()
+        r.giveUp(action, attempts, err)
+        return
+    }
+
+    id := r.nextID
+    r.nextID++
+    delay := policy.Backoff(attempts)
+    retry := &pendingRetry{action: action, err: err}
+    r.pending[id] = retry
+    retry.timer = time.AfterFunc(delay, func() {
+        r.mu.Lock()
+        if r.closed {
+            r.mu.Unlock()
+            r.giveUp(action, attempts, err)
+            return
+        }
+        r.inflight.Add(1)
+        r.mu.Unlock()
+        defer r.inflight.Done()
+
+        atomic.AddUint64(&r.retries, 1)
+        if r.metrics != nil {
+            r.metrics.IncPublishRetries(r.name)
+        }
+
+        if err := r.publish(action); err != nil {
+            r.schedule(action, policy, attempts+1, err)
+        }
+
+        // The retry stays pending while it publishes so that Drain waits for it
+        r.mu.Lock()
+        delete(r.pending, id)
+        r.mu.Unlock()
+    })
+    r.mu.Unlock()
+
+    r.logger.Debug("scheduled publish retry",
+        "connection", r.name,
+        "topic", action.Topic,
+        "attempt", attempts+1,
+        "delay", delay,
+        "error", err)
+}
+
+// giveUp records an action that will not be attempted again
+func (r *Retrier) giveUp(action *rule.Action, attempts int, err error) {
+    atomic.AddUint64(&r.failures, 1)
+    if r.metrics != nil {
+        r.metrics.IncPublishFailures(r.name)
+        r.metrics.IncRulePublishErrors(action.RuleID)
+    }
+
+    r.logger.Error("giving up on action",
+        "connection", r.name,
+        "topic", action.Topic,
+        "attempts", attempts,
+        "error", err)
+
+    if r.onGiveUp != nil {
+        r.onGiveUp(action, err)
+    }
+}
+
+// Stats returns the retry counters
+func (r *Retrier) Stats() RetryStats {
+    r.mu.Lock()
+    pending := len(r.pending)
+    r.mu.Unlock()
+
+    return RetryStats{
+        Retries:  atomic.LoadUint64(&r.retries),
+        Failures: atomic.LoadUint64(&r.failures),
+        Pending:  pending,
+    }
+}
+
+// Drain waits until ctx is done for pending retries to complete, then closes
+// the retrier. It returns how many pending retries completed, by publishing
+// or giving up, and how many were abandoned.
+func (r *Retrier) Drain(ctx context.Context) (drained, abandoned int) {
+    pending := r.Stats().Pending
+
+    ticker := time.NewTicker(drainPollInterval)
+    defer ticker.Stop()
+
+wait:
+    for r.Stats().Pending > 0 {
+        select {
+        case <-ctx.Done():
+            break wait
+        case <-ticker.C:
+        }
+    }
+
+    abandoned = r.Close()
+    if abandoned > pending {
+        return 0, abandoned
+    }
+    return pending - abandoned, abandoned
+}
+
+// Close cancels pending retries, waits for the retries that are publishing
+// and returns how many were abandoned. Abandoned actions are given up on,
+// and actions that fail after Close are not retried.
+func (r *Retrier) Close() int {
+    r.mu.Lock()
+    r.closed = true
+
+    var abandoned []*pendingRetry
+    for id, retry := range r.pending {
+        if retry.timer.Stop() {
+            abandoned = append(abandoned, retry)
+        }
+        delete(r.pending, id)
+    }
+    r.mu.Unlock()
+
+    if len(abandoned) > 0 {
+        r.logger.Info("abandoning pending publish retries",
+            "connection", r.name,
+            "count", len(abandoned))
+    }
+    for _, retry := range abandoned {
+        r.giveUp(retry.action, 0, retry.err)
+    }
+    r.inflight.Wait()
+
+    return len(abandoned)
+}
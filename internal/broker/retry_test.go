@@ -0,0 +1,253 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+func newTestRetrier(t *testing.T, policy RetryPolicy, publish func(*rule.Action) error) *Retrier {
+	t.Helper()
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	return NewRetrier("test", policy, publish, &logger.Logger{Logger: zapLogger}, nil)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 400*time.Millisecond, policy.Backoff(3))
+	assert.Equal(t, 800*time.Millisecond, policy.Backoff(4))
+	assert.Equal(t, time.Second, policy.Backoff(5))
+	assert.Equal(t, time.Second, policy.Backoff(50))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(2)
+		assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+		assert.LessOrEqual(t, delay, 300*time.Millisecond)
+	}
+}
+
+func TestRetryPolicy_WithOverrides(t *testing.T) {
+	global := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Jitter:         0.2,
+	}
+
+	assert.Equal(t, global, global.WithOverrides(nil))
+
+	merged := global.WithOverrides(&rule.RetryPolicy{
+		MaxAttempts: 8,
+		MaxBackoff:  "1m",
+	})
+	assert.Equal(t, RetryPolicy{
+		MaxAttempts:    8,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Minute,
+		Jitter:         0.2,
+	}, merged)
+}
+
+func TestFailedActions(t *testing.T) {
+	a := &rule.Action{Topic: "a"}
+	b := &rule.Action{Topic: "b"}
+	c := &rule.Action{Topic: "c"}
+	batch := []*rule.Action{a, b, c}
+
+	assert.Nil(t, FailedActions(batch, nil))
+
+	err := errors.Join(
+		&ActionError{Action: a, Err: errors.New("rejected")},
+		&ActionError{Action: c, Err: errors.New("rejected")},
+	)
+	assert.Equal(t, []*rule.Action{a, c}, FailedActions(batch, err))
+
+	// An error that does not name its action fails the whole batch
+	err = errors.Join(
+		&ActionError{Action: a, Err: errors.New("rejected")},
+		fmt.Errorf("failed to flush batch: %w", errors.New("timeout")),
+	)
+	assert.Equal(t, batch, FailedActions(batch, err))
+	assert.Equal(t, batch, FailedActions(batch, errors.New("not connected")))
+}
+
+func TestRetrier_SucceedsAfterFailures(t *testing.T) {
+	var calls atomic.Int32
+	done := make(chan struct{})
+	r := newTestRetrier(t, RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+		func(action *rule.Action) error {
+			if calls.Add(1) < 3 {
+				return errors.New("unavailable")
+			}
+			close(done)
+			return nil
+		})
+
+	r.Retry(&rule.Action{Topic: "out"}, errors.New("unavailable"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("action was not republished")
+	}
+
+	assert.Eventually(t, func() bool { return r.Stats().Retries == 3 }, time.Second, time.Millisecond)
+	assert.Zero(t, r.Stats().Failures)
+}
+
+func TestRetrier_GivesUp(t *testing.T) {
+	var calls atomic.Int32
+	r := newTestRetrier(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		func(action *rule.Action) error {
+			calls.Add(1)
+			return errors.New("unavailable")
+		})
+
+	r.Retry(&rule.Action{Topic: "out"}, errors.New("unavailable"))
+
+	assert.Eventually(t, func() bool { return r.Stats().Failures == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load(), "first attempt plus two retries")
+	assert.Equal(t, uint64(2), r.Stats().Retries)
+}
+
+func TestRetrier_ActionOverride(t *testing.T) {
+	r := newTestRetrier(t, RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		func(action *rule.Action) error {
+			return errors.New("unavailable")
+		})
+
+	// Retries are disabled for this action only
+	r.Retry(&rule.Action{Topic: "out", Retry: &rule.RetryPolicy{MaxAttempts: 1}}, errors.New("unavailable"))
+
+	stats := r.Stats()
+	assert.Equal(t, uint64(1), stats.Failures)
+	assert.Zero(t, stats.Retries)
+}
+
+func TestRetrier_DoesNotBlock(t *testing.T) {
+	var mu sync.Mutex
+	var published []string
+	r := newTestRetrier(t, RetryPolicy{MaxAttempts: 2, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 50 * time.Millisecond},
+		func(action *rule.Action) error {
+			mu.Lock()
+			defer mu.Unlock()
+			published = append(published, action.Topic)
+			return nil
+		})
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		r.Retry(&rule.Action{Topic: fmt.Sprintf("out/%d", i)}, errors.New("unavailable"))
+	}
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "Retry must return without waiting for the backoff")
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(published) == 10
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRetrier_Close(t *testing.T) {
+	r := newTestRetrier(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+		func(action *rule.Action) error { return nil })
+
+	r.Retry(&rule.Action{Topic: "a"}, errors.New("unavailable"))
+	r.Retry(&rule.Action{Topic: "b"}, errors.New("unavailable"))
+	assert.Equal(t, 2, r.Stats().Pending)
+
+	assert.Equal(t, 2, r.Close())
+	assert.Equal(t, uint64(2), r.Stats().Failures)
+
+	// Failures after close are not retried
+	r.Retry(&rule.Action{Topic: "c"}, errors.New("unavailable"))
+	assert.Zero(t, r.Stats().Pending)
+	assert.Equal(t, uint64(3), r.Stats().Failures)
+}
+
+func TestRetrier_Drain(t *testing.T) {
+	t.Run("waits for pending retries", func(t *testing.T) {
+		var published atomic.Int32
+		r := newTestRetrier(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second},
+			func(action *rule.Action) error {
+				published.Add(1)
+				return nil
+			})
+
+		r.Retry(&rule.Action{Topic: "a"}, errors.New("unavailable"))
+		r.Retry(&rule.Action{Topic: "b"}, errors.New("unavailable"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained, abandoned := r.Drain(ctx)
+		assert.Equal(t, 2, drained)
+		assert.Zero(t, abandoned)
+		assert.Equal(t, int32(2), published.Load())
+	})
+
+	t.Run("waits for a retry that is publishing", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		r := newTestRetrier(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Second},
+			func(action *rule.Action) error {
+				close(started)
+				<-release
+				return nil
+			})
+
+		r.Retry(&rule.Action{Topic: "a"}, errors.New("unavailable"))
+		<-started
+
+		done := make(chan int, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			drained, _ := r.Drain(ctx)
+			done <- drained
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("drain returned while the retry was publishing")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		close(release)
+		assert.Equal(t, 1, <-done)
+	})
+
+	t.Run("abandons pending retries at the deadline", func(t *testing.T) {
+		r := newTestRetrier(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+			func(action *rule.Action) error { return nil })
+
+		r.Retry(&rule.Action{Topic: "a"}, errors.New("unavailable"))
+		r.Retry(&rule.Action{Topic: "b"}, errors.New("unavailable"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		drained, abandoned := r.Drain(ctx)
+		assert.Zero(t, drained)
+		assert.Equal(t, 2, abandoned)
+		assert.Equal(t, uint64(2), r.Stats().Failures)
+	})
+}
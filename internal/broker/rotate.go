@@ -0,0 +1,145 @@
+package broker
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+
+    "mqtt-mux-router/internal/logger"
+)
+
+// errFileClosed is returned when writing to a closed rotating file
+var errFileClosed = errors.New("file is closed")
+
+// rotatingFile appends to a local file, rotating it to path.1, path.2, ...
+// once it reaches maxSize and keeping at most maxFiles rotated files.
+type rotatingFile struct {
+    path     string
+    kind     string // Names the file in errors and logs, such as dead-letter
+    maxSize  int64
+    maxFiles int
+    logger   *logger.Logger
+
+    file *os.File
+    size int64
+    mu   sync.Mutex
+}
+
+// openRotatingFile opens, or creates, the file at path and its directory. A
+// maxSize of 0 disables rotation.
+func openRotatingFile(path, kind string, maxSize int64, maxFiles int, log *logger.Logger) (*rotatingFile, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, fmt.Errorf("failed to create %s directory: %w", kind, err)
+    }
+
+    f := &rotatingFile{
+        path:     path,
+        kind:     kind,
+        maxSize:  maxSize,
+        maxFiles: maxFiles,
+        logger:   log,
+    }
+    if err := f.open(); err != nil {
+        return nil, err
+    }
+
+    return f, nil
+}
+
+// Write appends data, rotating the file first when data would take it past
+// maxSize. A failed rotation is logged and data is written to the current file.
+func (f *rotatingFile) Write(data []byte) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.file == nil {
+        return errFileClosed
+    }
+
+    if f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+        if err := f.rotate(); err != nil {
+            f.logger.Error(fmt.Sprintf("failed to rotate %s file", f.kind),
+                "path", f.path,
+                "error", err)
+            if f.file == nil {
+                return err
+            }
+        }
+    }
+
+    n, err := f.file.Write(data)
+    f.size += int64(n)
+    return err
+}
+
+// Close closes the file; later writes fail with errFileClosed
+func (f *rotatingFile) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.file == nil {
+        return nil
+    }
+    err := f.file.Close()
+    f.file = nil
+    return err
+}
+
+// open opens the current file for appending; callers must hold f.mu or own f
+func (f *rotatingFile) open() error {
+    file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open %s file: %w", f.kind, err)
+    }
+
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("failed to stat %s file: %w", f.kind, err)
+    }
+
+    f.file = file
+    f.size = info.Size()
+    return nil
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and starts
+// a new current file; callers must hold f.mu. When the files cannot be
+// shifted the current file is reopened, so that writes keep appending to it.
+func (f *rotatingFile) rotate() error {
+    err := f.file.Close()
+    f.file = nil
+    if err == nil {
+        err = f.shift()
+    }
+    if err == nil {
+        f.logger.Info(fmt.Sprintf("rotated %s file", f.kind), "path", f.path)
+    }
+
+    if openErr := f.open(); openErr != nil {
+        return errors.Join(err, openErr)
+    }
+    return err
+}
+
+// shift renames path to path.1, path.1 to path.2 and so on, dropping the
+// oldest file, or removes path when no rotated files are kept
+func (f *rotatingFile) shift() error {
+    if f.maxFiles < 1 {
+        if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        return nil
+    }
+
+    os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+    for i := f.maxFiles - 1; i >= 1; i-- {
+        from := fmt.Sprintf("%s.%d", f.path, i)
+        if err := os.Rename(from, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    return os.Rename(f.path, f.path+".1")
+}
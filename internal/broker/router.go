@@ -0,0 +1,279 @@
+package broker
+
+import (
+    "context"
+    "fmt"
+    "sync"
+
+    "mqtt-mux-router/internal/logger"
+    "mqtt-mux-router/internal/rule"
+)
+
+// Router combines several named broker connections into a single Broker.
+// Rules are started on the connection named by Rule.Broker and actions are
+// published on the connection named by Action.Broker. Actions of other
+// kinds are delivered by the target registered for their kind.
+type Router struct {
+    logger      *logger.Logger
+    brokers     map[string]Broker
+    names       []string
+    defaultName string
+    targets     map[string]ActionTarget
+    mu          sync.RWMutex
+}
+
+// NewRouter creates an empty router; the first connection added becomes the default
+func NewRouter(log *logger.Logger) *Router {
+    return &Router{
+        logger:  log,
+        brokers: make(map[string]Broker),
+        targets: make(map[string]ActionTarget),
+    }
+}
+
+// Add registers a broker under the given connection name
+func (r *Router) Add(name string, b Broker) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if _, exists := r.brokers[name]; exists {
+        return fmt.Errorf("connection %s already registered", name)
+    }
+
+    r.brokers[name] = b
+    r.names = append(r.names, name)
+    if r.defaultName == "" {
+        r.defaultName = name
+    }
+
+    r.logger.Info("registered broker connection",
+        "connection", name,
+        "default", r.defaultName == name)
+
+    return nil
+}
+
+// AddTarget registers the target delivering actions of the given kind
+func (r *Router) AddTarget(kind string, target ActionTarget) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if _, exists := r.targets[kind]; exists {
+        return fmt.Errorf("target for %s actions already registered", kind)
+    }
+    r.targets[kind] = target
+
+    r.logger.Info("registered action target", "kind", kind)
+    return nil
+}
+
+// Target returns the target registered for the given action kind
+func (r *Router) Target(kind string) (ActionTarget, bool) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    target, exists := r.targets[kind]
+    return target, exists
+}
+
+// Start implements Broker by starting each connection with the rules bound to it
+func (r *Router) Start(ctx context.Context, rules []rule.Rule) error {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    if len(r.brokers) == 0 {
+        return fmt.Errorf("no broker connections configured")
+    }
+
+    rulesByConn := make(map[string][]rule.Rule, len(r.brokers))
+    for i, rl := range rules {
+        source := r.resolve(rl.Broker)
+        if _, exists := r.brokers[source]; !exists {
+            return fmt.Errorf("rule %d on topic %s references unknown source connection: %s", i, rl.Topic, rl.Broker)
+        }
+        if err := r.checkTarget(rl.Action); err != nil {
+            return fmt.Errorf("rule %d on topic %s: %w", i, rl.Topic, err)
+        }
+        if rl.Missing != nil {
+            if err := r.checkTarget(rl.Missing.Recovery); err != nil {
+                return fmt.Errorf("rule %d on topic %s: recovery %w", i, rl.Topic, err)
+            }
+        }
+        if rl.Action != nil && rl.Action.Broker != "" {
+            if _, exists := r.brokers[rl.Action.Broker]; !exists {
+                return fmt.Errorf("rule %d on topic %s references unknown target connection: %s", i, rl.Topic, rl.Action.Broker)
+            }
+        }
+        if rl.Missing != nil && rl.Missing.Recovery != nil && rl.Missing.Recovery.Broker != "" {
+            if _, exists := r.brokers[rl.Missing.Recovery.Broker]; !exists {
+                return fmt.Errorf("rule %d on topic %s references unknown recovery target connection: %s", i, rl.Topic, rl.Missing.Recovery.Broker)
+            }
+        }
+        rulesByConn[source] = append(rulesByConn[source], rl)
+    }
+
+    for _, name := range r.names {
+        connRules := rulesByConn[name]
+        r.logger.Info("starting broker connection",
+            "connection", name,
+            "ruleCount", len(connRules))
+
+        if err := r.brokers[name].Start(ctx, connRules); err != nil {
+            return fmt.Errorf("failed to start connection %s: %w", name, err)
+        }
+    }
+
+    return nil
+}
+
+// checkTarget returns an error when no target delivers the action's kind
+func (r *Router) checkTarget(action *rule.Action) error {
+    if action == nil || action.Kind() == rule.ActionPublish {
+        return nil
+    }
+    if _, exists := r.targets[action.Kind()]; !exists {
+        return fmt.Errorf("action kind %s has no target", action.Kind())
+    }
+    return nil
+}
+
+// Close implements Broker by closing every connection, then the action targets
+func (r *Router) Close() {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    for _, name := range r.names {
+        r.logger.Info("closing broker connection", "connection", name)
+        r.brokers[name].Close()
+    }
+    for kind, target := range r.targets {
+        r.logger.Info("closing action target", "kind", kind)
+        target.Close()
+    }
+}
+
+// Shutdown drains every connection before closing it: all subscriptions are
+// stopped first, then the processing queues are drained, then the outbound
+// queues and retries, so that actions routed between connections are still
+// published, and finally the action targets. Work left when ctx is done is
+// abandoned.
+func (r *Router) Shutdown(ctx context.Context) DrainStats {
+    r.mu.RLock()
+    drainers := make([]Drainer, 0, len(r.names))
+    for _, name := range r.names {
+        if d, ok := r.brokers[name].(Drainer); ok {
+            drainers = append(drainers, d)
+        }
+    }
+    targets := make([]ActionTarget, 0, len(r.targets))
+    for _, target := range r.targets {
+        targets = append(targets, target)
+    }
+    r.mu.RUnlock()
+
+    for _, d := range drainers {
+        d.StopConsuming()
+    }
+
+    var processing, outbound DrainStats
+    for _, d := range drainers {
+        processing.Add(d.DrainProcessing(ctx))
+    }
+    for _, d := range drainers {
+        outbound.Add(d.DrainOutbound(ctx))
+    }
+    for _, target := range targets {
+        outbound.Add(target.Drain(ctx))
+    }
+
+    r.logger.Info("drained broker connections",
+        "messagesDrained", processing.Drained,
+        "messagesAbandoned", processing.Abandoned,
+        "actionsDrained", outbound.Drained,
+        "actionsAbandoned", outbound.Abandoned)
+
+    r.Close()
+
+    total := processing
+    total.Add(outbound)
+    return total
+}
+
+// GetStats implements Broker by summing the stats of every connection
+func (r *Router) GetStats() BrokerStats {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    total := BrokerStats{
+        Connections: make(map[string]ConnectionStats, len(r.names)),
+    }
+    for _, name := range r.names {
+        stats := r.brokers[name].GetStats()
+        total.MessagesReceived += stats.MessagesReceived
+        total.MessagesPublished += stats.MessagesPublished
+        total.Errors += stats.Errors
+        if stats.LastReconnect.After(total.LastReconnect) {
+            total.LastReconnect = stats.LastReconnect
+        }
+        for connName, connStats := range stats.Connections {
+            total.Connections[connName] = connStats
+        }
+    }
+    return total
+}
+
+// ProcessorReports returns the processor report of every connection that
+// has one, keyed by connection name
+func (r *Router) ProcessorReports() map[string]rule.ProcessorReport {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    reports := make(map[string]rule.ProcessorReport, len(r.names))
+    for _, name := range r.names {
+        if reporter, ok := r.brokers[name].(ProcessorReporter); ok {
+            reports[name] = reporter.ProcessorReport()
+        }
+    }
+    return reports
+}
+
+// PublishAction implements Broker by routing the action to its target connection
+func (r *Router) PublishAction(action *rule.Action) error {
+    return r.RouteAction(action)
+}
+
+// RouteAction implements ActionRouter
+func (r *Router) RouteAction(action *rule.Action) error {
+    if action == nil {
+        return fmt.Errorf("action cannot be nil")
+    }
+
+    if kind := action.Kind(); kind != rule.ActionPublish {
+        r.mu.RLock()
+        target, exists := r.targets[kind]
+        r.mu.RUnlock()
+
+        if !exists {
+            return fmt.Errorf("no target for %s actions", kind)
+        }
+        return target.Deliver(action)
+    }
+
+    r.mu.RLock()
+    target, exists := r.brokers[r.resolve(action.Broker)]
+    r.mu.RUnlock()
+
+    if !exists {
+        return fmt.Errorf("unknown target connection: %s", action.Broker)
+    }
+
+    return target.PublishAction(action)
+}
+
+// resolve maps an empty connection name to the default connection
+func (r *Router) resolve(name string) string {
+    if name == "" {
+        return r.defaultName
+    }
+    return name
+}
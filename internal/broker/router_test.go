@@ -0,0 +1,268 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mqtt-mux-router/internal/logger"
+	"mqtt-mux-router/internal/rule"
+)
+
+// fakeBroker records the rules it was started with and the actions it published
+type fakeBroker struct {
+	rules     []rule.Rule
+	published []*rule.Action
+	stats     BrokerStats
+	closed    bool
+	mu        sync.Mutex
+}
+
+func (f *fakeBroker) Start(ctx context.Context, rules []rule.Rule) error {
+	f.rules = rules
+	return nil
+}
+
+func (f *fakeBroker) Close() { f.closed = true }
+
+func (f *fakeBroker) GetStats() BrokerStats { return f.stats }
+
+func (f *fakeBroker) PublishAction(action *rule.Action) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, action)
+	return nil
+}
+
+// drainingBroker records the shutdown phases it goes through in a shared log
+type drainingBroker struct {
+	fakeBroker
+	name  string
+	stats DrainStats
+	log   *[]string
+}
+
+func (d *drainingBroker) StopConsuming() { *d.log = append(*d.log, "stop "+d.name) }
+
+func (d *drainingBroker) DrainProcessing(ctx context.Context) DrainStats {
+	*d.log = append(*d.log, "processing "+d.name)
+	return DrainStats{Drained: d.stats.Drained}
+}
+
+func (d *drainingBroker) DrainOutbound(ctx context.Context) DrainStats {
+	*d.log = append(*d.log, "outbound "+d.name)
+	return DrainStats{Abandoned: d.stats.Abandoned}
+}
+
+func (d *drainingBroker) Close() { *d.log = append(*d.log, "close "+d.name) }
+
+func setupTestRouter(t *testing.T) (*Router, *fakeBroker, *fakeBroker) {
+	t.Helper()
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	r := NewRouter(&logger.Logger{Logger: zapLogger})
+	field, core := &fakeBroker{}, &fakeBroker{}
+	require.NoError(t, r.Add("field-mqtt", field))
+	require.NoError(t, r.Add("nats-core", core))
+
+	return r, field, core
+}
+
+func TestRouter_Add(t *testing.T) {
+	r, _, _ := setupTestRouter(t)
+
+	err := r.Add("field-mqtt", &fakeBroker{})
+	assert.Error(t, err)
+	assert.Equal(t, "field-mqtt", r.defaultName)
+}
+
+func TestRouter_Start(t *testing.T) {
+	t.Run("partitions rules by source connection", func(t *testing.T) {
+		r, field, core := setupTestRouter(t)
+
+		rules := []rule.Rule{
+			{Topic: "sensors/temperature", Action: &rule.Action{Topic: "alerts/temperature", Broker: "nats-core"}},
+			{Topic: "sensors/humidity", Broker: "field-mqtt", Action: &rule.Action{Topic: "alerts/humidity"}},
+			{Topic: "commands/door", Broker: "nats-core", Action: &rule.Action{Topic: "door1/control", Broker: "field-mqtt"}},
+		}
+
+		require.NoError(t, r.Start(context.Background(), rules))
+		assert.Len(t, field.rules, 2)
+		assert.Len(t, core.rules, 1)
+		assert.Equal(t, "commands/door", core.rules[0].Topic)
+	})
+
+	t.Run("unknown source connection", func(t *testing.T) {
+		r, _, _ := setupTestRouter(t)
+
+		err := r.Start(context.Background(), []rule.Rule{
+			{Topic: "sensors/temperature", Broker: "missing", Action: &rule.Action{Topic: "alerts"}},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown source connection")
+	})
+
+	t.Run("unknown target connection", func(t *testing.T) {
+		r, _, _ := setupTestRouter(t)
+
+		err := r.Start(context.Background(), []rule.Rule{
+			{Topic: "sensors/temperature", Action: &rule.Action{Topic: "alerts", Broker: "missing"}},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown target connection")
+	})
+
+	t.Run("unknown recovery target connection", func(t *testing.T) {
+		r, _, _ := setupTestRouter(t)
+
+		err := r.Start(context.Background(), []rule.Rule{
+			{
+				Topic:   "sensors/temperature",
+				Action:  &rule.Action{Topic: "alerts"},
+				Missing: &rule.Missing{Timeout: "1m", Recovery: &rule.Action{Topic: "recovered", Broker: "missing"}},
+			},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown recovery target connection")
+	})
+}
+
+func TestRouter_RouteAction(t *testing.T) {
+	r, field, core := setupTestRouter(t)
+
+	require.NoError(t, r.RouteAction(&rule.Action{Topic: "alerts/a", Broker: "nats-core"}))
+	require.NoError(t, r.RouteAction(&rule.Action{Topic: "alerts/b"}))
+	assert.Error(t, r.RouteAction(&rule.Action{Topic: "alerts/c", Broker: "missing"}))
+	assert.Error(t, r.RouteAction(nil))
+
+	require.Len(t, core.published, 1)
+	assert.Equal(t, "alerts/a", core.published[0].Topic)
+	require.Len(t, field.published, 1)
+	assert.Equal(t, "alerts/b", field.published[0].Topic)
+}
+
+func TestRouter_GetStatsAndClose(t *testing.T) {
+	r, field, core := setupTestRouter(t)
+	field.stats = BrokerStats{
+		MessagesReceived:  3,
+		MessagesPublished: 1,
+		Errors:            1,
+		Connections: map[string]ConnectionStats{
+			"field-mqtt": {Type: "mqtt", Connected: true, MessagesReceived: 3},
+		},
+	}
+	core.stats = BrokerStats{
+		MessagesReceived:  2,
+		MessagesPublished: 4,
+		Connections: map[string]ConnectionStats{
+			"nats-core": {Type: "nats", MessagesReceived: 2},
+		},
+	}
+
+	stats := r.GetStats()
+	assert.Equal(t, uint64(5), stats.MessagesReceived)
+	assert.Equal(t, uint64(5), stats.MessagesPublished)
+	assert.Equal(t, uint64(1), stats.Errors)
+	require.Len(t, stats.Connections, 2)
+	assert.True(t, stats.Connections["field-mqtt"].Connected)
+	assert.Equal(t, "nats", stats.Connections["nats-core"].Type)
+
+	r.Close()
+	assert.True(t, field.closed)
+	assert.True(t, core.closed)
+}
+
+func TestRouter_Shutdown(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	var phases []string
+	r := NewRouter(&logger.Logger{Logger: zapLogger})
+	plain := &fakeBroker{}
+	require.NoError(t, r.Add("field-mqtt", &drainingBroker{name: "field-mqtt", stats: DrainStats{Drained: 3, Abandoned: 1}, log: &phases}))
+	require.NoError(t, r.Add("legacy", plain))
+	require.NoError(t, r.Add("nats-core", &drainingBroker{name: "nats-core", stats: DrainStats{Drained: 2}, log: &phases}))
+
+	stats := r.Shutdown(context.Background())
+	assert.Equal(t, DrainStats{Drained: 5, Abandoned: 1}, stats)
+
+	// Every connection finishes a phase before any starts the next, so
+	// actions routed between connections are still published
+	assert.Equal(t, []string{
+		"stop field-mqtt", "stop nats-core",
+		"processing field-mqtt", "processing nats-core",
+		"outbound field-mqtt", "outbound nats-core",
+		"close field-mqtt", "close nats-core",
+	}, phases)
+	assert.True(t, plain.closed)
+}
+
+// fakeTarget records the actions delivered to it
+type fakeTarget struct {
+	delivered []*rule.Action
+	drained   bool
+	closed    bool
+}
+
+func (f *fakeTarget) Deliver(action *rule.Action) error {
+	f.delivered = append(f.delivered, action)
+	return nil
+}
+
+func (f *fakeTarget) Drain(ctx context.Context) DrainStats {
+	f.drained = true
+	return DrainStats{Drained: len(f.delivered)}
+}
+
+func (f *fakeTarget) Close() { f.closed = true }
+
+func TestRouter_ActionTargets(t *testing.T) {
+	r, field, _ := setupTestRouter(t)
+	webhook := &rule.Action{Payload: "{}", HTTP: &rule.HTTPAction{URL: "https://hooks.example.com"}}
+	rules := []rule.Rule{{Topic: "sensors/temperature", Action: webhook}}
+
+	err := r.Start(context.Background(), rules)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "action kind http has no target")
+	assert.Error(t, r.RouteAction(webhook))
+
+	target := &fakeTarget{}
+	require.NoError(t, r.AddTarget(rule.ActionHTTP, target))
+	assert.Error(t, r.AddTarget(rule.ActionHTTP, &fakeTarget{}))
+	registered, ok := r.Target(rule.ActionHTTP)
+	require.True(t, ok)
+	assert.Same(t, target, registered)
+	require.NoError(t, r.Start(context.Background(), rules))
+
+	require.NoError(t, r.RouteAction(webhook))
+	require.Len(t, target.delivered, 1)
+	assert.Empty(t, field.published)
+
+	stats := r.Shutdown(context.Background())
+	assert.Equal(t, 1, stats.Drained)
+	assert.True(t, target.drained)
+	assert.True(t, target.closed)
+}
+
+// reportingBroker has a processor report
+type reportingBroker struct {
+	fakeBroker
+	report rule.ProcessorReport
+}
+
+func (b *reportingBroker) ProcessorReport() rule.ProcessorReport { return b.report }
+
+func TestRouter_ProcessorReports(t *testing.T) {
+	r, _, _ := setupTestRouter(t)
+	reporting := &reportingBroker{report: rule.ProcessorReport{QueueDepth: 4}}
+	require.NoError(t, r.Add("plant-a", reporting))
+
+	reports := r.ProcessorReports()
+	assert.Len(t, reports, 1)
+	assert.Equal(t, 4, reports["plant-a"].QueueDepth)
+}
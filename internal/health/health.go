@@ -0,0 +1,172 @@
+//file: internal/health/health.go
+
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"mqtt-mux-router/internal/broker"
+)
+
+// Endpoint paths served by RegisterHandlers
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Check and report statuses
+const (
+	StatusPass        = "pass"
+	StatusFail        = "fail"
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// StatsProvider reports broker statistics; implemented by broker.Router
+type StatsProvider interface {
+	GetStats() broker.BrokerStats
+}
+
+// Check is the outcome of a single readiness check
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Report is the JSON body returned by the health endpoints
+type Report struct {
+	Status string  `json:"status"`
+	Uptime string  `json:"uptime"`
+	Checks []Check `json:"checks,omitempty"`
+}
+
+// Checker derives liveness and readiness from the broker statistics
+type Checker struct {
+	stats         StatsProvider
+	maxQueueDepth int
+	startTime     time.Time
+}
+
+// NewChecker creates a checker. A connection whose processing queue holds
+// maxQueueDepth or more messages is not ready; 0 disables the queue check.
+func NewChecker(stats StatsProvider, maxQueueDepth int) *Checker {
+	return &Checker{
+		stats:         stats,
+		maxQueueDepth: maxQueueDepth,
+		startTime:     time.Now(),
+	}
+}
+
+// Live reports liveness. The router is live as long as it can serve the
+// request; broker problems are left to readiness so that a broker outage
+// does not restart every router.
+func (c *Checker) Live() Report {
+	return Report{
+		Status: StatusOK,
+		Uptime: c.uptime(),
+	}
+}
+
+// Ready runs the readiness checks. Every connection must be connected and
+// below the queue threshold, connections with rules must be subscribed, and
+// rules must have been loaded.
+func (c *Checker) Ready() Report {
+	stats := c.stats.GetStats()
+
+	names := make([]string, 0, len(stats.Connections))
+	for name := range stats.Connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var checks []Check
+	if len(names) == 0 {
+		checks = append(checks, newCheck("connections", false, "no broker connections"))
+	}
+
+	loaded := len(names) > 0
+	rules := 0
+	for _, name := range names {
+		conn := stats.Connections[name]
+
+		checks = append(checks, newCheck("connected:"+name, conn.Connected,
+			"not connected to broker"))
+
+		// Connections that only publish have nothing to subscribe to
+		if conn.Rules > 0 {
+			checks = append(checks, newCheck("subscribed:"+name, conn.Subscribed,
+				"subscriptions are not active"))
+		}
+
+		if c.maxQueueDepth > 0 {
+			checks = append(checks, newCheck("queue:"+name, conn.QueueDepth < c.maxQueueDepth,
+				fmt.Sprintf("queue depth %d reached threshold %d", conn.QueueDepth, c.maxQueueDepth)))
+		}
+
+		loaded = loaded && conn.RulesLoaded
+		rules += conn.Rules
+	}
+
+	if loaded {
+		checks = append(checks, newCheck("rules", rules > 0, "no rules loaded"))
+	} else {
+		checks = append(checks, newCheck("rules", false, "rules are not loaded"))
+	}
+
+	report := Report{
+		Status: StatusOK,
+		Uptime: c.uptime(),
+		Checks: checks,
+	}
+	for _, check := range checks {
+		if check.Status == StatusFail {
+			report.Status = StatusUnavailable
+			break
+		}
+	}
+
+	return report
+}
+
+// RegisterHandlers adds the liveness and readiness endpoints to mux
+func (c *Checker) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(LivenessPath, c.ServeLiveness)
+	mux.HandleFunc(ReadinessPath, c.ServeReadiness)
+}
+
+// ServeLiveness serves the liveness report
+func (c *Checker) ServeLiveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Live())
+}
+
+// ServeReadiness serves the readiness report, with 503 when not ready
+func (c *Checker) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Ready())
+}
+
+func (c *Checker) uptime() string {
+	return time.Since(c.startTime).Round(time.Second).String()
+}
+
+// newCheck builds a check, including the message only when it fails
+func newCheck(name string, pass bool, message string) Check {
+	if pass {
+		return Check{Name: name, Status: StatusPass}
+	}
+	return Check{Name: name, Status: StatusFail, Message: message}
+}
+
+// writeReport writes a report as JSON, with 503 when it is not ok
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,165 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mqtt-mux-router/internal/broker"
+)
+
+// staticStats returns fixed broker statistics
+type staticStats struct {
+	stats broker.BrokerStats
+}
+
+func (s *staticStats) GetStats() broker.BrokerStats { return s.stats }
+
+func readyConnections() map[string]broker.ConnectionStats {
+	return map[string]broker.ConnectionStats{
+		"field-mqtt": {Connected: true, Subscribed: true, RulesLoaded: true, Rules: 3, QueueDepth: 10},
+		// Publish-only connections have no subscriptions
+		"nats-core": {Connected: true, RulesLoaded: true},
+	}
+}
+
+func serve(t *testing.T, checker *Checker, path string) (int, Report) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	checker.RegisterHandlers(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var report Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func failing(report Report) []string {
+	var names []string
+	for _, check := range report.Checks {
+		if check.Status == StatusFail {
+			names = append(names, check.Name)
+		}
+	}
+	return names
+}
+
+func TestLiveness(t *testing.T) {
+	// Liveness does not depend on the brokers
+	checker := NewChecker(&staticStats{}, 100)
+
+	code, report := serve(t, checker, LivenessPath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.NotEmpty(t, report.Uptime)
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(conns map[string]broker.ConnectionStats)
+		failing []string
+	}{
+		{
+			name:   "ready",
+			modify: func(conns map[string]broker.ConnectionStats) {},
+		},
+		{
+			name: "disconnected",
+			modify: func(conns map[string]broker.ConnectionStats) {
+				conn := conns["nats-core"]
+				conn.Connected = false
+				conns["nats-core"] = conn
+			},
+			failing: []string{"connected:nats-core"},
+		},
+		{
+			name: "not subscribed",
+			modify: func(conns map[string]broker.ConnectionStats) {
+				conn := conns["field-mqtt"]
+				conn.Subscribed = false
+				conns["field-mqtt"] = conn
+			},
+			failing: []string{"subscribed:field-mqtt"},
+		},
+		{
+			name: "queue at threshold",
+			modify: func(conns map[string]broker.ConnectionStats) {
+				conn := conns["field-mqtt"]
+				conn.QueueDepth = 100
+				conns["field-mqtt"] = conn
+			},
+			failing: []string{"queue:field-mqtt"},
+		},
+		{
+			name: "not started",
+			modify: func(conns map[string]broker.ConnectionStats) {
+				for name, conn := range conns {
+					conn.RulesLoaded = false
+					conn.Rules = 0
+					conns[name] = conn
+				}
+			},
+			failing: []string{"rules"},
+		},
+		{
+			name: "no rules",
+			modify: func(conns map[string]broker.ConnectionStats) {
+				conn := conns["field-mqtt"]
+				conn.Rules = 0
+				conn.Subscribed = false
+				conns["field-mqtt"] = conn
+			},
+			failing: []string{"rules"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns := readyConnections()
+			tt.modify(conns)
+			checker := NewChecker(&staticStats{stats: broker.BrokerStats{Connections: conns}}, 100)
+
+			code, report := serve(t, checker, ReadinessPath)
+			assert.Equal(t, tt.failing, failing(report))
+			if len(tt.failing) == 0 {
+				assert.Equal(t, http.StatusOK, code)
+				assert.Equal(t, StatusOK, report.Status)
+				return
+			}
+
+			assert.Equal(t, http.StatusServiceUnavailable, code)
+			assert.Equal(t, StatusUnavailable, report.Status)
+			for _, check := range report.Checks {
+				if check.Status == StatusFail {
+					assert.NotEmpty(t, check.Message, check.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestReadiness_NoConnections(t *testing.T) {
+	checker := NewChecker(&staticStats{}, 0)
+
+	report := checker.Ready()
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, []string{"connections", "rules"}, failing(report))
+}
+
+func TestReadiness_QueueCheckDisabled(t *testing.T) {
+	conns := readyConnections()
+	conn := conns["field-mqtt"]
+	conn.QueueDepth = 1 << 20
+	conns["field-mqtt"] = conn
+
+	checker := NewChecker(&staticStats{stats: broker.BrokerStats{Connections: conns}}, 0)
+	assert.Equal(t, StatusOK, checker.Ready().Status)
+}
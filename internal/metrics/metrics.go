@@ -3,9 +3,20 @@
 package metrics
 
 import (
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// DefaultMaxRuleLabels is the default number of distinct rule IDs that get
+// their own per-rule series
+const DefaultMaxRuleLabels = 100
+
+// OtherRuleLabel is the rule label used for rules without an ID and for rules
+// beyond the label limit, keeping per-rule series bounded
+const OtherRuleLabel = "_other"
+
 // Metrics holds all prometheus metrics for the application
 type Metrics struct {
 	// Message metrics
@@ -17,12 +28,52 @@ type Metrics struct {
 	ruleMatchesTotal prometheus.Counter
 	rulesActive      prometheus.Gauge
 
-	// MQTT metrics
+	// Per-rule metrics, labelled by rule ID up to maxRuleLabels
+	ruleEvaluationsTotal    *prometheus.CounterVec
+	ruleMatchesByRuleTotal  *prometheus.CounterVec
+	ruleTemplateErrorsTotal *prometheus.CounterVec
+	rulePublishErrorsTotal  *prometheus.CounterVec
+	ruleSuppressedTotal     *prometheus.CounterVec
+	ruleEvaluationDuration  *prometheus.HistogramVec
+
+	// Connection metrics, labelled by broker type and connection name
+	connectionStatus *prometheus.GaugeVec
+	reconnectsTotal  *prometheus.CounterVec
+
+	// Deprecated unlabelled connection metrics, kept for one release
 	mqttConnectionStatus prometheus.Gauge
-	mqttReconnectsTotal prometheus.Counter
+	mqttReconnectsTotal  prometheus.Counter
+
+	// Latency metrics
+	decodeDuration           prometheus.Histogram
+	evaluationDuration       prometheus.Histogram
+	publishDuration          *prometheus.HistogramVec
+	receiveToPublishDuration *prometheus.HistogramVec
+
+	// Per-connection metrics
+	connectionMessagesTotal *prometheus.CounterVec
+	connectionRulesActive   *prometheus.GaugeVec
 
 	// Action metrics
-	actionsTotal *prometheus.CounterVec
+	actionsTotal         *prometheus.CounterVec
+	publishRetriesTotal  *prometheus.CounterVec
+	publishFailuresTotal *prometheus.CounterVec
+	deadLettersTotal     *prometheus.CounterVec
+	deadLettersDropped   prometheus.Counter
+
+	// Outbound queue and backpressure metrics
+	outboundQueueDepth       *prometheus.GaugeVec
+	consumptionPaused        prometheus.Gauge
+	backpressurePausesTotal  prometheus.Counter
+	backpressureWaitTimeouts *prometheus.CounterVec
+
+	// Batch publishing metrics
+	publishBatchSize     *prometheus.HistogramVec
+	publishFlushDuration *prometheus.HistogramVec
+
+	// HTTP action metrics, labelled by endpoint (scheme and host)
+	httpRequestsTotal   *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
 
 	// Template metrics
 	templateOpsTotal *prometheus.CounterVec
@@ -31,6 +82,22 @@ type Metrics struct {
 	processGoroutines  prometheus.Gauge
 	processMemoryBytes prometheus.Gauge
 	workerPoolActive   prometheus.Gauge
+
+	// Active rule counts per connection, summed into rulesActive
+	connectionRules map[string]float64
+	// Processor queue depths, worker counts and backlogs per connection,
+	// summed into messageQueueDepth, workerPoolActive and processingBacklog
+	connectionQueueDepth map[string]float64
+	connectionWorkers    map[string]float64
+	connectionBacklog    map[string]float64
+	// Connections whose consumption is paused, reported by consumptionPaused
+	pausedConnections map[string]struct{}
+	mu                sync.Mutex
+
+	// Rule IDs that have been given their own label
+	ruleLabels    map[string]struct{}
+	maxRuleLabels int
+	ruleLabelsMu  sync.RWMutex
 }
 
 // NewMetrics creates and registers all prometheus metrics
@@ -52,7 +119,7 @@ func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
 		processingBacklog: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "message_processing_backlog",
-				Help: "Difference between received and processed messages",
+				Help: "Messages waiting to be processed and actions waiting to be published",
 			},
 		),
 		ruleMatchesTotal: prometheus.NewCounter(
@@ -67,18 +134,119 @@ func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
 				Help: "Current number of active rules",
 			},
 		),
+		ruleEvaluationsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rule_evaluations_total",
+				Help: "Total number of times each rule was evaluated against a message",
+			},
+			[]string{"rule"},
+		),
+		ruleMatchesByRuleTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rule_matches_by_rule_total",
+				Help: "Total number of matches per rule",
+			},
+			[]string{"rule"},
+		),
+		ruleTemplateErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rule_template_errors_total",
+				Help: "Total number of action template errors per rule",
+			},
+			[]string{"rule"},
+		),
+		rulePublishErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rule_publish_errors_total",
+				Help: "Total number of actions per rule that failed to publish after all attempts",
+			},
+			[]string{"rule"},
+		),
+		ruleSuppressedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rule_suppressed_total",
+				Help: "Total number of matched rule actions suppressed per rule by reason",
+			},
+			[]string{"rule", "reason"},
+		),
+		ruleEvaluationDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "rule_evaluation_duration_seconds",
+				Help:    "Time taken to evaluate a rule's conditions against a message",
+				Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+			},
+			[]string{"rule"},
+		),
+		connectionStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "broker_connection_status",
+				Help: "Current broker connection status (0/1) per connection",
+			},
+			[]string{"broker_type", "connection"},
+		),
+		reconnectsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "broker_reconnects_total",
+				Help: "Total number of broker reconnection attempts per connection",
+			},
+			[]string{"broker_type", "connection"},
+		),
 		mqttConnectionStatus: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "mqtt_connection_status",
-				Help: "Current MQTT connection status (0/1)",
+				Help: "Deprecated: use broker_connection_status. Status (0/1) of the last MQTT connection to change state",
 			},
 		),
 		mqttReconnectsTotal: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "mqtt_reconnects_total",
-				Help: "Total number of MQTT reconnection attempts",
+				Help: "Deprecated: use broker_reconnects_total. Total number of reconnection attempts across MQTT connections",
+			},
+		),
+		decodeDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "message_decode_duration_seconds",
+				Help:    "Time taken to decode a message payload",
+				Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+			},
+		),
+		evaluationDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "message_evaluation_duration_seconds",
+				Help:    "Time taken to evaluate all rules matching a message",
+				Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
 			},
 		),
+		publishDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "publish_duration_seconds",
+				Help:    "Time taken to publish an action per connection",
+				Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+			},
+			[]string{"connection"},
+		),
+		receiveToPublishDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "message_receive_to_publish_seconds",
+				Help:    "Time from receiving a message to publishing an action it triggered, per publishing connection",
+				Buckets: prometheus.ExponentialBuckets(0.0001, 2, 18),
+			},
+			[]string{"connection"},
+		),
+		connectionMessagesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "connection_messages_total",
+				Help: "Total number of messages per broker connection by status",
+			},
+			[]string{"connection", "status"},
+		),
+		connectionRulesActive: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connection_rules_active",
+				Help: "Current number of active rules per broker connection",
+			},
+			[]string{"connection"},
+		),
 		actionsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "actions_total",
@@ -86,6 +254,90 @@ func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
 			},
 			[]string{"status"},
 		),
+		publishRetriesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "publish_retries_total",
+				Help: "Total number of publish retry attempts per connection",
+			},
+			[]string{"connection"},
+		),
+		publishFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "publish_failures_total",
+				Help: "Total number of actions that failed to publish after all attempts per connection",
+			},
+			[]string{"connection"},
+		),
+		deadLettersTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dead_letters_total",
+				Help: "Total number of dead letters by failure stage",
+			},
+			[]string{"stage"},
+		),
+		deadLettersDropped: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dead_letters_dropped_total",
+				Help: "Total number of dead letters dropped because the dead-letter topic could not keep up",
+			},
+		),
+		outboundQueueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "outbound_queue_depth",
+				Help: "Current number of actions waiting to be published per connection",
+			},
+			[]string{"connection"},
+		),
+		consumptionPaused: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "consumption_paused",
+				Help: "Whether message consumption is paused by backpressure on any connection (0/1)",
+			},
+		),
+		backpressurePausesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "backpressure_pauses_total",
+				Help: "Total number of times consumption was paused by backpressure",
+			},
+		),
+		backpressureWaitTimeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backpressure_wait_timeouts_total",
+				Help: "Total number of messages accepted after waiting too long for paused consumption to resume per connection",
+			},
+			[]string{"connection"},
+		),
+		publishBatchSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "publish_batch_size",
+				Help:    "Number of actions per published batch",
+				Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+			},
+			[]string{"connection"},
+		),
+		publishFlushDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "publish_batch_flush_seconds",
+				Help:    "Time taken to publish a batch of actions",
+				Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+			},
+			[]string{"connection"},
+		),
+		httpRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_action_requests_total",
+				Help: "Total number of HTTP action requests per endpoint by status class",
+			},
+			[]string{"endpoint", "status"},
+		),
+		httpRequestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_action_duration_seconds",
+				Help:    "Time taken by HTTP action requests per endpoint",
+				Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+			},
+			[]string{"endpoint"},
+		),
 		templateOpsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "template_operations_total",
@@ -111,6 +363,13 @@ func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
 				Help: "Current number of active workers",
 			},
 		),
+		connectionRules:      make(map[string]float64),
+		connectionQueueDepth: make(map[string]float64),
+		connectionWorkers:    make(map[string]float64),
+		connectionBacklog:    make(map[string]float64),
+		pausedConnections:    make(map[string]struct{}),
+		ruleLabels:           make(map[string]struct{}),
+		maxRuleLabels:        DefaultMaxRuleLabels,
 	}
 
 	// Register all metrics
@@ -120,9 +379,35 @@ func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
 		m.processingBacklog,
 		m.ruleMatchesTotal,
 		m.rulesActive,
+		m.ruleEvaluationsTotal,
+		m.ruleMatchesByRuleTotal,
+		m.ruleTemplateErrorsTotal,
+		m.rulePublishErrorsTotal,
+		m.ruleSuppressedTotal,
+		m.ruleEvaluationDuration,
+		m.connectionStatus,
+		m.reconnectsTotal,
 		m.mqttConnectionStatus,
 		m.mqttReconnectsTotal,
+		m.decodeDuration,
+		m.evaluationDuration,
+		m.publishDuration,
+		m.receiveToPublishDuration,
+		m.connectionMessagesTotal,
+		m.connectionRulesActive,
 		m.actionsTotal,
+		m.publishRetriesTotal,
+		m.publishFailuresTotal,
+		m.deadLettersTotal,
+		m.deadLettersDropped,
+		m.outboundQueueDepth,
+		m.consumptionPaused,
+		m.backpressurePausesTotal,
+		m.backpressureWaitTimeouts,
+		m.publishBatchSize,
+		m.publishFlushDuration,
+		m.httpRequestsTotal,
+		m.httpRequestDuration,
 		m.templateOpsTotal,
 		m.processGoroutines,
 		m.processMemoryBytes,
@@ -143,14 +428,24 @@ func (m *Metrics) IncMessagesTotal(status string) {
 	m.messagesTotal.WithLabelValues(status).Inc()
 }
 
-// SetMessageQueueDepth sets the current message queue depth
-func (m *Metrics) SetMessageQueueDepth(depth float64) {
-	m.messageQueueDepth.Set(depth)
+// SetMessageQueueDepth sets the processing queue depth of a connection and
+// updates the overall queue depth gauge
+func (m *Metrics) SetMessageQueueDepth(connection string, depth float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connectionQueueDepth[connection] = depth
+	m.messageQueueDepth.Set(sum(m.connectionQueueDepth))
 }
 
-// SetProcessingBacklog sets the current processing backlog
-func (m *Metrics) SetProcessingBacklog(backlog float64) {
-	m.processingBacklog.Set(backlog)
+// SetProcessingBacklog sets the processing backlog of a connection and
+// updates the overall backlog gauge
+func (m *Metrics) SetProcessingBacklog(connection string, backlog float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connectionBacklog[connection] = backlog
+	m.processingBacklog.Set(sum(m.connectionBacklog))
 }
 
 // IncRuleMatches increments the rule matches counter
@@ -163,7 +458,110 @@ func (m *Metrics) SetRulesActive(count float64) {
 	m.rulesActive.Set(count)
 }
 
+// SetMaxRuleLabels sets how many distinct rule IDs get their own per-rule
+// series. Rules seen after the limit is reached share the OtherRuleLabel series.
+// Rules that already have a label keep it.
+func (m *Metrics) SetMaxRuleLabels(max int) {
+	m.ruleLabelsMu.Lock()
+	defer m.ruleLabelsMu.Unlock()
+	m.maxRuleLabels = max
+}
+
+// ruleLabel returns the label for a rule ID, assigning a new label while the
+// limit allows. Labels are never released, so reloading rules with new IDs
+// eventually moves them to the OtherRuleLabel series until restart.
+func (m *Metrics) ruleLabel(ruleID string) string {
+	if ruleID == "" {
+		return OtherRuleLabel
+	}
+
+	m.ruleLabelsMu.RLock()
+	_, ok := m.ruleLabels[ruleID]
+	m.ruleLabelsMu.RUnlock()
+	if ok {
+		return ruleID
+	}
+
+	m.ruleLabelsMu.Lock()
+	defer m.ruleLabelsMu.Unlock()
+	if _, ok := m.ruleLabels[ruleID]; ok {
+		return ruleID
+	}
+	if len(m.ruleLabels) >= m.maxRuleLabels {
+		return OtherRuleLabel
+	}
+	m.ruleLabels[ruleID] = struct{}{}
+	return ruleID
+}
+
+// ObserveRuleEvaluation records a rule evaluation, whether it matched and how
+// long its conditions took to evaluate
+func (m *Metrics) ObserveRuleEvaluation(ruleID string, matched bool, duration time.Duration) {
+	label := m.ruleLabel(ruleID)
+	m.ruleEvaluationsTotal.WithLabelValues(label).Inc()
+	m.ruleEvaluationDuration.WithLabelValues(label).Observe(duration.Seconds())
+	if matched {
+		m.ruleMatchesByRuleTotal.WithLabelValues(label).Inc()
+	}
+}
+
+// IncRuleTemplateErrors increments the template error counter for a rule
+func (m *Metrics) IncRuleTemplateErrors(ruleID string) {
+	m.ruleTemplateErrorsTotal.WithLabelValues(m.ruleLabel(ruleID)).Inc()
+}
+
+// IncRulePublishErrors increments the final publish failure counter for a rule
+func (m *Metrics) IncRulePublishErrors(ruleID string) {
+	m.rulePublishErrorsTotal.WithLabelValues(m.ruleLabel(ruleID)).Inc()
+}
+
+// IncRuleSuppressed increments the suppressed action counter for a rule and
+// the reason it was suppressed
+func (m *Metrics) IncRuleSuppressed(ruleID, reason string) {
+	m.ruleSuppressedTotal.WithLabelValues(m.ruleLabel(ruleID), reason).Inc()
+}
+
+// SetConnectionRulesActive sets the number of active rules for a connection
+// and updates the overall active rules gauge
+func (m *Metrics) SetConnectionRulesActive(connection string, count float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connectionRules[connection] = count
+	m.connectionRulesActive.WithLabelValues(connection).Set(count)
+	m.rulesActive.Set(sum(m.connectionRules))
+}
+
+// IncConnectionMessages increments the per-connection messages counter for a given status
+func (m *Metrics) IncConnectionMessages(connection, status string) {
+	m.connectionMessagesTotal.WithLabelValues(connection, status).Inc()
+}
+
+// SetConnectionStatus sets the status of a broker connection. MQTT
+// connections also update the deprecated mqtt_connection_status gauge
+func (m *Metrics) SetConnectionStatus(brokerType, connection string, connected bool) {
+	status := 0.0
+	if connected {
+		status = 1
+	}
+	m.connectionStatus.WithLabelValues(brokerType, connection).Set(status)
+	if brokerType == "mqtt" {
+		m.mqttConnectionStatus.Set(status)
+	}
+}
+
+// IncReconnects increments the reconnects counter of a broker connection.
+// MQTT connections also update the deprecated mqtt_reconnects_total counter
+func (m *Metrics) IncReconnects(brokerType, connection string) {
+	m.reconnectsTotal.WithLabelValues(brokerType, connection).Inc()
+	if brokerType == "mqtt" {
+		m.mqttReconnectsTotal.Inc()
+	}
+}
+
 // SetMQTTConnectionStatus sets the MQTT connection status
+//
+// Deprecated: use SetConnectionStatus.
 func (m *Metrics) SetMQTTConnectionStatus(connected bool) {
 	if connected {
 		m.mqttConnectionStatus.Set(1)
@@ -173,15 +571,106 @@ func (m *Metrics) SetMQTTConnectionStatus(connected bool) {
 }
 
 // IncMQTTReconnects increments the MQTT reconnects counter
+//
+// Deprecated: use IncReconnects.
 func (m *Metrics) IncMQTTReconnects() {
 	m.mqttReconnectsTotal.Inc()
 }
 
+// ObserveDecode records the time taken to decode a message payload
+func (m *Metrics) ObserveDecode(duration time.Duration) {
+	m.decodeDuration.Observe(duration.Seconds())
+}
+
+// ObserveEvaluation records the time taken to evaluate the rules for a message
+func (m *Metrics) ObserveEvaluation(duration time.Duration) {
+	m.evaluationDuration.Observe(duration.Seconds())
+}
+
+// ObservePublish records the time taken to publish an action
+func (m *Metrics) ObservePublish(connection string, duration time.Duration) {
+	m.publishDuration.WithLabelValues(connection).Observe(duration.Seconds())
+}
+
+// ObserveReceiveToPublish records the latency from receiving a message to
+// publishing one of its actions. Actions without a receive time are ignored.
+func (m *Metrics) ObserveReceiveToPublish(connection string, receivedAt time.Time) {
+	if receivedAt.IsZero() {
+		return
+	}
+	m.receiveToPublishDuration.WithLabelValues(connection).Observe(time.Since(receivedAt).Seconds())
+}
+
 // IncActionsTotal increments the actions counter for a given status
 func (m *Metrics) IncActionsTotal(status string) {
 	m.actionsTotal.WithLabelValues(status).Inc()
 }
 
+// IncPublishRetries increments the publish retry counter for a connection
+func (m *Metrics) IncPublishRetries(connection string) {
+	m.publishRetriesTotal.WithLabelValues(connection).Inc()
+}
+
+// IncPublishFailures increments the final publish failure counter for a connection
+func (m *Metrics) IncPublishFailures(connection string) {
+	m.publishFailuresTotal.WithLabelValues(connection).Inc()
+}
+
+// IncDeadLetters increments the dead letter counter for a failure stage
+func (m *Metrics) IncDeadLetters(stage string) {
+	m.deadLettersTotal.WithLabelValues(stage).Inc()
+}
+
+// IncDeadLettersDropped increments the counter of dead letters dropped before publication
+func (m *Metrics) IncDeadLettersDropped() {
+	m.deadLettersDropped.Inc()
+}
+
+// SetOutboundQueueDepth sets the outbound queue depth for a connection
+func (m *Metrics) SetOutboundQueueDepth(connection string, depth float64) {
+	m.outboundQueueDepth.WithLabelValues(connection).Set(depth)
+}
+
+// SetConsumptionPaused sets whether consumption is paused on a connection,
+// counting each pause
+func (m *Metrics) SetConsumptionPaused(connection string, paused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if paused {
+		m.pausedConnections[connection] = struct{}{}
+		m.backpressurePausesTotal.Inc()
+	} else {
+		delete(m.pausedConnections, connection)
+	}
+
+	if len(m.pausedConnections) > 0 {
+		m.consumptionPaused.Set(1)
+	} else {
+		m.consumptionPaused.Set(0)
+	}
+}
+
+// IncBackpressureWaitTimeouts counts a message accepted on a connection
+// before paused consumption resumed
+func (m *Metrics) IncBackpressureWaitTimeouts(connection string) {
+	m.backpressureWaitTimeouts.WithLabelValues(connection).Inc()
+}
+
+// ObservePublishBatch records the size and flush latency of a published batch
+func (m *Metrics) ObservePublishBatch(connection string, size int, duration time.Duration) {
+	m.publishBatchSize.WithLabelValues(connection).Observe(float64(size))
+	m.publishFlushDuration.WithLabelValues(connection).Observe(duration.Seconds())
+}
+
+// ObserveHTTPRequest records an HTTP action request and its latency. status
+// is the response status class, such as 2xx, or error when no response was
+// received.
+func (m *Metrics) ObserveHTTPRequest(endpoint, status string, duration time.Duration) {
+	m.httpRequestsTotal.WithLabelValues(endpoint, status).Inc()
+	m.httpRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
 // IncTemplateOpsTotal increments the template operations counter for a given status
 func (m *Metrics) IncTemplateOpsTotal(status string) {
 	m.templateOpsTotal.WithLabelValues(status).Inc()
@@ -193,7 +682,21 @@ func (m *Metrics) SetProcessMetrics(goroutines, memoryBytes float64) {
 	m.processMemoryBytes.Set(memoryBytes)
 }
 
-// SetWorkerPoolActive sets the number of active workers
-func (m *Metrics) SetWorkerPoolActive(count float64) {
-	m.workerPoolActive.Set(count)
+// SetWorkerPoolActive sets the number of active workers of a connection and
+// updates the overall active workers gauge
+func (m *Metrics) SetWorkerPoolActive(connection string, count float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connectionWorkers[connection] = count
+	m.workerPoolActive.Set(sum(m.connectionWorkers))
+}
+
+// sum returns the total of the per-connection values
+func sum(values map[string]float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total
 }
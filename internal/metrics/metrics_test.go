@@ -2,8 +2,10 @@ package metrics
 
 import (
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,3 +43,152 @@ func TestMetricsIncrementCounters(t *testing.T) {
 	m.IncActionsTotal("success")
 	m.IncActionsTotal("error")
 }
+
+func TestMetricsConnectionRulesActive(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.SetConnectionRulesActive("plant-a", 3)
+	m.SetConnectionRulesActive("plant-b", 2)
+	assert.Equal(t, 5.0, testutil.ToFloat64(m.rulesActive))
+
+	// Replacing a connection's count must not double count
+	m.SetConnectionRulesActive("plant-a", 1)
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.rulesActive))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.connectionRulesActive.WithLabelValues("plant-b")))
+
+	m.IncConnectionMessages("plant-a", "received")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.connectionMessagesTotal.WithLabelValues("plant-a", "received")))
+}
+
+func TestMetricsProcessorGaugesSumConnections(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.SetWorkerPoolActive("plant-a", 4)
+	m.SetWorkerPoolActive("plant-b", 2)
+	assert.Equal(t, 6.0, testutil.ToFloat64(m.workerPoolActive))
+
+	m.SetMessageQueueDepth("plant-a", 10)
+	m.SetMessageQueueDepth("plant-b", 5)
+	m.SetMessageQueueDepth("plant-a", 3)
+	assert.Equal(t, 8.0, testutil.ToFloat64(m.messageQueueDepth))
+
+	m.SetProcessingBacklog("plant-a", 12)
+	m.SetProcessingBacklog("plant-b", 7)
+	m.SetProcessingBacklog("plant-b", 0)
+	assert.Equal(t, 12.0, testutil.ToFloat64(m.processingBacklog))
+}
+
+func TestMetricsConsumptionPaused(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.SetConsumptionPaused("plant-a", true)
+	m.SetConsumptionPaused("plant-b", true)
+	m.SetConsumptionPaused("plant-a", false)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.consumptionPaused), "paused while any connection is paused")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.backpressurePausesTotal))
+
+	m.SetConsumptionPaused("plant-b", false)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.consumptionPaused))
+}
+
+func TestMetricsPerRule(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.ObserveRuleEvaluation("high-temp", true, time.Millisecond)
+	m.ObserveRuleEvaluation("high-temp", false, time.Millisecond)
+	m.IncRuleTemplateErrors("high-temp")
+	m.IncRulePublishErrors("high-temp")
+	m.IncRuleSuppressed("high-temp", "throttle")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.ruleEvaluationsTotal.WithLabelValues("high-temp")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.ruleMatchesByRuleTotal.WithLabelValues("high-temp")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.ruleTemplateErrorsTotal.WithLabelValues("high-temp")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.rulePublishErrorsTotal.WithLabelValues("high-temp")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.ruleSuppressedTotal.WithLabelValues("high-temp", "throttle")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.ruleEvaluationDuration))
+}
+
+func TestMetricsRuleLabelLimit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+	m.SetMaxRuleLabels(2)
+
+	m.ObserveRuleEvaluation("a", true, 0)
+	m.ObserveRuleEvaluation("b", true, 0)
+	m.ObserveRuleEvaluation("c", true, 0)
+	m.ObserveRuleEvaluation("d", true, 0)
+	m.ObserveRuleEvaluation("", true, 0)
+
+	// Labelled rules keep their series once the limit is reached
+	m.ObserveRuleEvaluation("a", true, 0)
+
+	assert.Equal(t, 3, testutil.CollectAndCount(m.ruleEvaluationsTotal))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.ruleEvaluationsTotal.WithLabelValues("a")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.ruleEvaluationsTotal.WithLabelValues("b")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.ruleEvaluationsTotal.WithLabelValues(OtherRuleLabel)))
+}
+
+func TestMetricsConnectionStatusByBroker(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.SetConnectionStatus("mqtt", "plant-a", true)
+	m.SetConnectionStatus("nats", "cloud", false)
+	m.SetConnectionStatus("kafka", "events", false)
+	m.IncReconnects("mqtt", "plant-a")
+	m.IncReconnects("nats", "cloud")
+	m.IncReconnects("nats", "cloud")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.connectionStatus.WithLabelValues("mqtt", "plant-a")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.connectionStatus.WithLabelValues("nats", "cloud")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.reconnectsTotal.WithLabelValues("mqtt", "plant-a")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.reconnectsTotal.WithLabelValues("nats", "cloud")))
+
+	// The deprecated aliases only follow MQTT connections
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.mqttConnectionStatus))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.mqttReconnectsTotal))
+}
+
+func TestMetricsLatencyHistograms(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.ObserveDecode(time.Microsecond)
+	m.ObserveEvaluation(time.Microsecond)
+	m.ObservePublish("plant-a", time.Millisecond)
+	m.ObserveReceiveToPublish("plant-a", time.Now().Add(-time.Millisecond))
+
+	// Actions without a receive time, such as replayed dead letters, are skipped
+	m.ObserveReceiveToPublish("cloud", time.Time{})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(m.decodeDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.evaluationDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.publishDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.receiveToPublishDuration))
+}
+
+func TestMetricsHTTPRequests(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg)
+	assert.NoError(t, err)
+
+	m.ObserveHTTPRequest("https://alerts.example.com", "2xx", time.Millisecond)
+	m.ObserveHTTPRequest("https://alerts.example.com", "2xx", time.Millisecond)
+	m.ObserveHTTPRequest("https://alerts.example.com", "5xx", time.Millisecond)
+	m.ObserveHTTPRequest("http://localhost:8080", "error", time.Second)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequestsTotal.WithLabelValues("https://alerts.example.com", "2xx")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequestsTotal.WithLabelValues("http://localhost:8080", "error")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.httpRequestDuration))
+}
@@ -74,7 +74,13 @@ func (p *Processor) evaluateConditions(conditions *Conditions, msg map[string]in
 }
 
 func (p *Processor) evaluateCondition(cond *Condition, msg map[string]interface{}) bool {
-    value, ok := msg[cond.Field]
+    var value interface{}
+    var ok bool
+    if cond.lookup != nil {
+        value, ok = p.evaluateLookup(cond.lookup, msg)
+    } else {
+        value, ok = p.lookupField(msg, cond.Field)
+    }
     if !ok {
         p.logger.Debug("field not found in message",
             "field", cond.Field,
@@ -100,6 +106,8 @@ func (p *Processor) evaluateCondition(cond *Condition, msg map[string]interface{
         result = value != nil
     case "contains":
         result = strings.Contains(fmt.Sprint(value), fmt.Sprint(cond.Value))
+    case "changed", "crossesAbove", "crossesBelow":
+        result = p.evaluateChange(cond, value, msg)
     default:
         p.logger.Error("unknown operator", "operator", cond.Operator)
         return false
@@ -119,9 +127,12 @@ func (p *Processor) compareNumeric(a, b interface{}, op string) bool {
     var numA, numB float64
     var err error
 
+    // YAML rules decode whole numbers as int
     switch v := a.(type) {
     case float64:
         numA = v
+    case int:
+        numA = float64(v)
     case string:
         numA, err = strconv.ParseFloat(v, 64)
         if err != nil {
@@ -140,6 +151,8 @@ func (p *Processor) compareNumeric(a, b interface{}, op string) bool {
     switch v := b.(type) {
     case float64:
         numB = v
+    case int:
+        numB = float64(v)
     case string:
         numB, err = strconv.ParseFloat(v, 64)
         if err != nil {
@@ -169,6 +182,41 @@ func (p *Processor) compareNumeric(a, b interface{}, op string) bool {
     }
 }
 
+// lookupField returns a message field by name, falling back to a dot
+// separated path into nested objects such as previous.temperature. A lookup
+// expression reads a reference table instead.
+func (p *Processor) lookupField(msg map[string]interface{}, field string) (interface{}, bool) {
+    if isLookup(field) {
+        return p.lookupValue(field, msg)
+    }
+    if value, ok := msg[field]; ok {
+        return value, true
+    }
+    if !strings.Contains(field, ".") {
+        return nil, false
+    }
+    value, err := p.getValueFromPath(msg, strings.Split(field, "."))
+    if err != nil {
+        return nil, false
+    }
+    return value, true
+}
+
+// withValue returns the message values with value added under name, such as
+// the previous message or a window's aggregates, leaving the message values
+// untouched. A nil value adds nothing.
+func withValue(values map[string]interface{}, name string, value map[string]interface{}) map[string]interface{} {
+    if value == nil {
+        return values
+    }
+    merged := make(map[string]interface{}, len(values)+1)
+    for k, v := range values {
+        merged[k] = v
+    }
+    merged[name] = value
+    return merged
+}
+
 func getMapKeys(m map[string]interface{}) []string {
     keys := make([]string, 0, len(m))
     for k := range m {
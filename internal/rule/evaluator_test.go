@@ -261,6 +261,13 @@ func TestCompareNumeric(t *testing.T) {
 			op:   "gt",
 			want: true,
 		},
+		{
+			name: "int from yaml rule",
+			a:    25.5,
+			b:    25,
+			op:   "gt",
+			want: true,
+		},
 		{
 			name: "mixed type comparison",
 			a:    25.5,
@@ -119,3 +119,30 @@ func (idx *RuleIndex) GetStats() IndexStats {
 
     return stats
 }
+
+// IndexSummary describes the indexed rules and the lookup counters
+type IndexSummary struct {
+    Topics      int       `json:"topics"`
+    Rules       int       `json:"rules"`
+    Lookups     uint64    `json:"lookups"`
+    Matches     uint64    `json:"matches"` // Lookups that found at least one rule
+    LastUpdated time.Time `json:"lastUpdated"`
+}
+
+// Summary returns the index size and lookup counters
+func (idx *RuleIndex) Summary() IndexSummary {
+    idx.mu.RLock()
+    defer idx.mu.RUnlock()
+
+    summary := IndexSummary{
+        Topics:      len(idx.exactMatches),
+        Lookups:     atomic.LoadUint64(&idx.stats.lookups),
+        Matches:     atomic.LoadUint64(&idx.stats.matches),
+        LastUpdated: idx.stats.lastUpdated,
+    }
+    for _, rules := range idx.exactMatches {
+        summary.Rules += len(rules)
+    }
+
+    return summary
+}
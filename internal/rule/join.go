@@ -0,0 +1,126 @@
+//file: internal/rule/join.go
+
+package rule
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// stateField is the name under which the latest messages of a rule's state
+// sources are available to conditions and templates, e.g.
+// state.alarm/armed.armed
+const stateField = "state"
+
+// defaultStateTTL is how long the latest message of a state source is kept
+// without a new message
+const defaultStateTTL = 24 * time.Hour
+
+// prepare parses and checks a state source
+func (s *StateSource) prepare() error {
+    if s.Topic == "" {
+        return fmt.Errorf("state topic cannot be empty")
+    }
+    // Latest messages are kept per exact topic
+    if strings.ContainsAny(s.Topic, "+#") {
+        return fmt.Errorf("state topic %s cannot contain wildcards", s.Topic)
+    }
+
+    var err error
+    if s.ttl, err = parseOptionalDuration("state ttl", s.TTL); err != nil {
+        return err
+    }
+    if s.ttl == 0 {
+        s.ttl = defaultStateTTL
+    }
+    return nil
+}
+
+// prepareState checks the rule's state sources
+func (r *Rule) prepareState() error {
+    for i := range r.State {
+        source := &r.State[i]
+        if err := source.prepare(); err != nil {
+            return err
+        }
+        if source.Key != "" && r.Key == "" {
+            return fmt.Errorf("state topic %s has a key, so the rule needs a key to select it", source.Topic)
+        }
+    }
+    return nil
+}
+
+// stateSources returns the state sources of rules indexed by their topic.
+// Rules sharing a topic and key template share the latest message, kept for
+// the longest TTL among them.
+func stateSources(rules []Rule) map[string][]*StateSource {
+    sources := make(map[string][]*StateSource)
+    for i := range rules {
+        for j := range rules[i].State {
+            source := &rules[i].State[j]
+            shared := false
+            for _, existing := range sources[source.Topic] {
+                if existing.Key == source.Key {
+                    if source.ttl > existing.ttl {
+                        existing.ttl = source.ttl
+                    }
+                    shared = true
+                    break
+                }
+            }
+            if !shared {
+                copied := *source
+                sources[source.Topic] = append(sources[source.Topic], &copied)
+            }
+        }
+    }
+    return sources
+}
+
+// latestKey is the state key of the latest message of a state source
+func latestKey(topic, keyTemplate, key string) string {
+    return stateField + "\x00" + topic + "\x00" + keyTemplate + "\x00" + key
+}
+
+// recordState keeps a message as the latest message of the state sources on
+// its topic
+func (p *Processor) recordState(msg *ProcessingMessage, sources []*StateSource, now time.Time) {
+    for _, source := range sources {
+        var key string
+        if source.Key != "" {
+            key, _ = p.processTemplate(source.Key, msg.Values)
+        }
+        // The message payload is pooled, so keep a copy
+        p.state.Set(latestKey(source.Topic, source.Key, key), append([]byte(nil), msg.Payload...), source.ttl, now)
+    }
+}
+
+// joinState returns the latest message of each of the rule's state sources
+// by topic. Keyed sources are selected by the rule's rendered key; sources
+// without a message are left out.
+func (p *Processor) joinState(rule *Rule, key string, now time.Time) map[string]interface{} {
+    state := make(map[string]interface{}, len(rule.State))
+    for _, source := range rule.State {
+        var sourceKey string
+        if source.Key != "" {
+            sourceKey = renderedKey(key)
+        }
+
+        payload, ok := p.state.Get(latestKey(source.Topic, source.Key, sourceKey), now)
+        if !ok {
+            continue
+        }
+        var values map[string]interface{}
+        if err := json.Unmarshal(payload, &values); err != nil {
+            p.logger.Debug("failed to decode state message",
+                "rule", rule.ID,
+                "topic", source.Topic,
+                "error", err)
+            continue
+        }
+        state[source.Topic] = values
+    }
+    return state
+}
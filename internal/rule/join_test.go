@@ -0,0 +1,112 @@
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func processTopic(t *testing.T, setup *testSetup, topic, payload string) []*Action {
+	t.Helper()
+	actions, err := setup.processor.Process(topic, []byte(payload))
+	require.NoError(t, err)
+	return actions
+}
+
+func TestJoin_Topic(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	require.NoError(t, setup.processor.LoadRules([]Rule{{
+		ID:    "door-while-armed",
+		Topic: "door1/control",
+		State: []StateSource{{Topic: "alarm/armed"}},
+		Conditions: &Conditions{
+			Operator: "and",
+			Items: []Condition{
+				{Field: "open", Operator: "eq", Value: true},
+				{Field: "state.alarm/armed.armed", Operator: "eq", Value: true},
+			},
+		},
+		Action: &Action{
+			Topic:   "alerts/intrusion",
+			Payload: `{"door":"door1","zone":"${state.alarm/armed.zone}"}`,
+		},
+	}}))
+
+	// The router subscribes to state topics without rules of their own
+	assert.ElementsMatch(t, []string{"door1/control", "alarm/armed"}, setup.processor.GetTopics())
+
+	assert.Empty(t, processTopic(t, setup, "door1/control", `{"open": true}`), "no alarm state yet")
+
+	assert.Empty(t, processTopic(t, setup, "alarm/armed", `{"armed": true, "zone": "north"}`))
+	actions := processTopic(t, setup, "door1/control", `{"open": true}`)
+	require.Len(t, actions, 1)
+	assert.Equal(t, `{"door":"door1","zone":"north"}`, actions[0].Payload)
+
+	// The latest state message wins
+	processTopic(t, setup, "alarm/armed", `{"armed": false, "zone": "north"}`)
+	assert.Empty(t, processTopic(t, setup, "door1/control", `{"open": true}`))
+}
+
+func TestJoin_Keyed(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	require.NoError(t, setup.processor.LoadRules([]Rule{{
+		ID:    "occupied-and-hot",
+		Topic: "sensors/temperature",
+		Key:   "${room}",
+		State: []StateSource{{Topic: "sensors/occupancy", Key: "${room}", TTL: "10m"}},
+		Conditions: &Conditions{
+			Operator: "and",
+			Items: []Condition{
+				{Field: "temperature", Operator: "gt", Value: 25.0},
+				{Field: "state.sensors/occupancy.people", Operator: "gt", Value: 0.0},
+			},
+		},
+		Action: &Action{
+			Topic:   "alerts/${room}",
+			Payload: `{"people":${state.sensors/occupancy.people}}`,
+		},
+	}}))
+
+	start := time.Now()
+	setup.processor.now = func() time.Time { return start }
+	processTopic(t, setup, "sensors/occupancy", `{"room": "a", "people": 3}`)
+	processTopic(t, setup, "sensors/occupancy", `{"room": "b", "people": 0}`)
+
+	actions := processTopic(t, setup, "sensors/temperature", `{"room": "a", "temperature": 30}`)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "alerts/a", actions[0].Topic)
+	assert.Equal(t, `{"people":3}`, actions[0].Payload)
+
+	assert.Empty(t, processTopic(t, setup, "sensors/temperature", `{"room": "b", "temperature": 30}`))
+	assert.Empty(t, processTopic(t, setup, "sensors/temperature", `{"room": "c", "temperature": 30}`))
+
+	// State expires after its TTL
+	setup.processor.now = func() time.Time { return start.Add(10 * time.Minute) }
+	assert.Empty(t, processTopic(t, setup, "sensors/temperature", `{"room": "a", "temperature": 30}`))
+}
+
+func TestStateSources(t *testing.T) {
+	rules := []Rule{
+		{Key: "${room}", State: []StateSource{{Topic: "a", TTL: "1m"}, {Topic: "b", Key: "${room}"}}},
+		{State: []StateSource{{Topic: "a", TTL: "1h"}}},
+	}
+	for i := range rules {
+		require.NoError(t, rules[i].prepare())
+	}
+
+	sources := stateSources(rules)
+	require.Len(t, sources["a"], 1, "rules share a topic with the same key template")
+	assert.Equal(t, time.Hour, sources["a"][0].ttl)
+	require.Len(t, sources["b"], 1)
+	assert.Equal(t, defaultStateTTL, sources["b"][0].ttl)
+
+	assert.ErrorContains(t, (&Rule{State: []StateSource{{}}}).prepare(), "state topic cannot be empty")
+	assert.ErrorContains(t, (&Rule{State: []StateSource{{Topic: "site/#"}}}).prepare(), "cannot contain wildcards")
+	assert.ErrorContains(t, (&Rule{State: []StateSource{{Topic: "a", Key: "${room}"}}}).prepare(), "needs a key")
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"mqtt-mux-router/internal/logger"
@@ -33,6 +34,7 @@ func (l *RulesLoader) LoadFromDirectory(path string) ([]Rule, error) {
 	l.logger.Debug("loading rules from directory", "path", path)
 
 	var rules []Rule
+	root := path
 
 	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -82,6 +84,9 @@ func (l *RulesLoader) LoadFromDirectory(path string) ([]Rule, error) {
 
 		// Validate rules before adding them
 		for i, rule := range ruleSet {
+			if rule.ID == "" {
+				rule.ID = defaultRuleID(root, path, i)
+			}
 			if err := validateRule(&rule); err != nil {
 				l.logger.Error("invalid rule configuration",
 					"path", path,
@@ -99,12 +104,30 @@ func (l *RulesLoader) LoadFromDirectory(path string) ([]Rule, error) {
 		return nil, fmt.Errorf("failed to load rules: %w", err)
 	}
 
+	seen := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if _, exists := seen[rule.ID]; exists {
+			return nil, fmt.Errorf("failed to load rules: duplicate rule id %q", rule.ID)
+		}
+		seen[rule.ID] = struct{}{}
+	}
+
 	l.logger.Info("rules loaded successfully",
 		"totalRules", len(rules))
 
 	return rules, nil
 }
 
+// defaultRuleID identifies a rule without an explicit ID by its file path
+// relative to the rules directory and its position in the file
+func defaultRuleID(root, path string, index int) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	return fmt.Sprintf("%s#%d", filepath.ToSlash(rel), index)
+}
+
 // validateRule performs basic validation of rule configuration
 func validateRule(rule *Rule) error {
 	if rule == nil {
@@ -119,8 +142,8 @@ func validateRule(rule *Rule) error {
 		return fmt.Errorf("rule action cannot be nil")
 	}
 
-	if rule.Action.Topic == "" {
-		return fmt.Errorf("action topic cannot be empty")
+	if err := validateAction(rule.Action); err != nil {
+		return err
 	}
 
 	if rule.Conditions != nil {
@@ -129,6 +152,177 @@ func validateRule(rule *Rule) error {
 		}
 	}
 
+	if rule.Missing != nil && rule.Missing.Recovery != nil {
+		if err := validateAction(rule.Missing.Recovery); err != nil {
+			return fmt.Errorf("invalid recovery action: %w", err)
+		}
+	}
+
+	if rule.Window != nil && rule.Window.Filter != nil {
+		if err := validateConditions(rule.Window.Filter); err != nil {
+			return fmt.Errorf("invalid window filter: %w", err)
+		}
+	}
+
+	if err := rule.prepare(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// prepare parses and checks the options of the rule's stateful features:
+// suppression, change detection, lookups, windows, state sources and
+// missing messages
+func (r *Rule) prepare() error {
+	if err := r.prepareSuppression(); err != nil {
+		return err
+	}
+	if err := r.preparePrevious(); err != nil {
+		return err
+	}
+
+	if r.Conditions != nil {
+		if err := r.Conditions.prepareLookups(); err != nil {
+			return err
+		}
+	}
+
+	if r.Window != nil {
+		if err := r.Window.prepare(); err != nil {
+			return err
+		}
+		if r.Window.Filter != nil {
+			if err := r.Window.Filter.prepareLookups(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.prepareState(); err != nil {
+		return err
+	}
+
+	if r.Missing != nil {
+		if r.Window != nil {
+			return fmt.Errorf("missing rules cannot have a window")
+		}
+		if r.suppresses() {
+			return fmt.Errorf("debounce, throttle and rateLimit do not apply to missing rules")
+		}
+		if err := r.Missing.prepare(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseOptionalDuration parses a positive duration, returning 0 for an empty value
+func parseOptionalDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration: %q", name, value)
+	}
+	return d, nil
+}
+
+// validateAction checks an action's target and retry policy
+func validateAction(action *Action) error {
+	kinds := 0
+	for _, set := range []bool{action.HTTP != nil, action.File != nil, action.Log != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return fmt.Errorf("only one of http, file and log can be set")
+	}
+
+	switch action.Kind() {
+	case ActionHTTP:
+		if err := validateHTTPAction(action.HTTP); err != nil {
+			return fmt.Errorf("invalid http action: %w", err)
+		}
+	case ActionFile:
+		if action.File.Path == "" {
+			return fmt.Errorf("invalid file action: path cannot be empty")
+		}
+	case ActionLog:
+		switch action.Log.Level {
+		case "", "debug", "info", "error":
+		default:
+			return fmt.Errorf("invalid log action: unsupported level: %s", action.Log.Level)
+		}
+	default:
+		if action.Topic == "" {
+			return fmt.Errorf("action topic cannot be empty")
+		}
+	}
+
+	if action.Retry != nil {
+		if err := validateRetryPolicy(action.Retry); err != nil {
+			return fmt.Errorf("invalid retry policy: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateHTTPAction checks the request options of an http action
+func validateHTTPAction(action *HTTPAction) error {
+	if action.URL == "" {
+		return fmt.Errorf("url cannot be empty")
+	}
+
+	switch strings.ToUpper(action.Method) {
+	case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return fmt.Errorf("unsupported method: %s", action.Method)
+	}
+
+	if action.Timeout != "" {
+		if d, err := time.ParseDuration(action.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("timeout must be a positive duration: %q", action.Timeout)
+		}
+	}
+
+	for _, status := range action.ExpectStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid expected status: %d", status)
+		}
+	}
+
+	if tls := action.TLS; tls != nil && (tls.CertFile == "") != (tls.KeyFile == "") {
+		return fmt.Errorf("tls certFile and keyFile must be set together")
+	}
+	return nil
+}
+
+// validateRetryPolicy checks the values of an action retry policy
+func validateRetryPolicy(policy *RetryPolicy) error {
+	if policy.MaxAttempts < 0 {
+		return fmt.Errorf("maxAttempts cannot be negative")
+	}
+
+	for name, value := range map[string]string{
+		"initialBackoff": policy.InitialBackoff,
+		"maxBackoff":     policy.MaxBackoff,
+	} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("%s must be a positive duration: %q", name, value)
+		}
+	}
+
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+
 	return nil
 }
 
@@ -147,9 +341,17 @@ func validateConditions(conditions *Conditions) error {
 		if condition.Field == "" {
 			return fmt.Errorf("condition field cannot be empty")
 		}
+		if isLookup(condition.Field) {
+			if _, err := parseLookup(condition.Field); err != nil {
+				return err
+			}
+		}
 		if !isValidOperator(condition.Operator) {
 			return fmt.Errorf("invalid condition operator: %s", condition.Operator)
 		}
+		if (condition.Operator == "crossesAbove" || condition.Operator == "crossesBelow") && condition.Value == nil {
+			return fmt.Errorf("condition operator %s requires a value", condition.Operator)
+		}
 	}
 
 	// Recursively validate nested condition groups
@@ -165,14 +367,17 @@ func validateConditions(conditions *Conditions) error {
 // isValidOperator checks if the operator is supported
 func isValidOperator(op string) bool {
 	validOperators := map[string]bool{
-		"eq":       true,
-		"neq":      true,
-		"gt":       true,
-		"lt":       true,
-		"gte":      true,
-		"lte":      true,
-		"exists":   true,
-		"contains": true,
+		"eq":           true,
+		"neq":          true,
+		"gt":           true,
+		"lt":           true,
+		"gte":          true,
+		"lte":          true,
+		"exists":       true,
+		"contains":     true,
+		"changed":      true,
+		"crossesAbove": true,
+		"crossesBelow": true,
 	}
 	return validOperators[op]
 }
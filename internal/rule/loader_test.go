@@ -131,6 +131,177 @@ func TestValidateRule(t *testing.T) {
 			wantError: true,
 			errorMsg:  "action topic cannot be empty",
 		},
+		{
+			name: "valid retry policy",
+			rule: &Rule{
+				Topic: "test/topic",
+				Action: &Action{
+					Topic: "test/action",
+					Retry: &RetryPolicy{
+						MaxAttempts:    5,
+						InitialBackoff: "200ms",
+						MaxBackoff:     "30s",
+						Jitter:         0.5,
+					},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name: "invalid retry backoff",
+			rule: &Rule{
+				Topic: "test/topic",
+				Action: &Action{
+					Topic: "test/action",
+					Retry: &RetryPolicy{InitialBackoff: "soon"},
+				},
+			},
+			wantError: true,
+			errorMsg:  "initialBackoff must be a positive duration",
+		},
+		{
+			name: "invalid retry jitter",
+			rule: &Rule{
+				Topic: "test/topic",
+				Action: &Action{
+					Topic: "test/action",
+					Retry: &RetryPolicy{Jitter: 1.5},
+				},
+			},
+			wantError: true,
+			errorMsg:  "jitter must be between 0 and 1",
+		},
+		{
+			name: "valid http action",
+			rule: &Rule{
+				Topic: "test/topic",
+				Action: &Action{
+					Payload: "test payload",
+					HTTP: &HTTPAction{
+						Method:       "put",
+						URL:          "https://hooks.example.com/${deviceId}",
+						Timeout:      "5s",
+						ExpectStatus: []int{200, 202},
+						TLS:          &HTTPTLS{CertFile: "client.pem", KeyFile: "client.key"},
+					},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name: "http action without url",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{HTTP: &HTTPAction{Method: "POST"}},
+			},
+			wantError: true,
+			errorMsg:  "url cannot be empty",
+		},
+		{
+			name: "http action with invalid method",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{HTTP: &HTTPAction{Method: "FETCH", URL: "https://hooks.example.com"}},
+			},
+			wantError: true,
+			errorMsg:  "unsupported method",
+		},
+		{
+			name: "http action with invalid timeout",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{HTTP: &HTTPAction{URL: "https://hooks.example.com", Timeout: "soon"}},
+			},
+			wantError: true,
+			errorMsg:  "timeout must be a positive duration",
+		},
+		{
+			name: "http action with invalid status",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{HTTP: &HTTPAction{URL: "https://hooks.example.com", ExpectStatus: []int{2000}}},
+			},
+			wantError: true,
+			errorMsg:  "invalid expected status",
+		},
+		{
+			name: "http action with certificate but no key",
+			rule: &Rule{
+				Topic: "test/topic",
+				Action: &Action{HTTP: &HTTPAction{
+					URL: "https://hooks.example.com",
+					TLS: &HTTPTLS{CertFile: "client.pem"},
+				}},
+			},
+			wantError: true,
+			errorMsg:  "certFile and keyFile must be set together",
+		},
+		{
+			name: "valid file action",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{Payload: "test payload", File: &FileAction{Path: "audit.jsonl"}},
+			},
+			wantError: false,
+		},
+		{
+			name: "file action without path",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{File: &FileAction{}},
+			},
+			wantError: true,
+			errorMsg:  "path cannot be empty",
+		},
+		{
+			name: "valid log action",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{Payload: "test payload", Log: &LogAction{Level: "debug"}},
+			},
+			wantError: false,
+		},
+		{
+			name: "log action with invalid level",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{Log: &LogAction{Level: "warn"}},
+			},
+			wantError: true,
+			errorMsg:  "unsupported level",
+		},
+		{
+			name: "action with several targets",
+			rule: &Rule{
+				Topic: "test/topic",
+				Action: &Action{
+					File: &FileAction{Path: "audit.jsonl"},
+					Log:  &LogAction{},
+				},
+			},
+			wantError: true,
+			errorMsg:  "only one of http, file and log can be set",
+		},
+		{
+			name: "invalid http recovery action",
+			rule: &Rule{
+				Topic:   "test/topic",
+				Action:  &Action{Topic: "test/action"},
+				Missing: &Missing{Timeout: "1m", Recovery: &Action{HTTP: &HTTPAction{}}},
+			},
+			wantError: true,
+			errorMsg:  "invalid recovery action",
+		},
+		{
+			name: "wildcard state topic",
+			rule: &Rule{
+				Topic:  "test/topic",
+				Action: &Action{Topic: "test/action"},
+				State:  []StateSource{{Topic: "alarm/+"}},
+			},
+			wantError: true,
+			errorMsg:  "state topic alarm/+ cannot contain wildcards",
+		},
 	}
 
 	for _, tt := range tests {
@@ -453,6 +624,35 @@ func TestValidateConditions(t *testing.T) {
 			wantError: true,
 			errorMsg:  "condition field cannot be empty",
 		},
+		{
+			name: "valid lookup field",
+			conditions: &Conditions{
+				Operator: "and",
+				Items: []Condition{
+					{
+						Field:    "lookup('devices', deviceId).site",
+						Operator: "eq",
+						Value:    "north",
+					},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name: "invalid lookup field",
+			conditions: &Conditions{
+				Operator: "and",
+				Items: []Condition{
+					{
+						Field:    "lookup(devices, deviceId).site",
+						Operator: "eq",
+						Value:    "north",
+					},
+				},
+			},
+			wantError: true,
+			errorMsg:  "invalid lookup",
+		},
 	}
 
 	for _, tt := range tests {
@@ -656,6 +856,43 @@ func TestLoadFromDirectory(t *testing.T) {
 	}
 }
 
+func TestLoadFromDirectory_RuleIDs(t *testing.T) {
+	loader := NewRulesLoader(setupTestLogger(t))
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "site"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site", "alerts.yaml"), []byte(`
+- topic: sensors/temperature
+  action:
+    topic: alerts/temperature
+    payload: "hot"
+- id: humidity-alert
+  topic: sensors/humidity
+  action:
+    topic: alerts/humidity
+    payload: "wet"
+`), 0644))
+
+	rules, err := loader.LoadFromDirectory(dir)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "site/alerts.yaml#0", rules[0].ID)
+	assert.Equal(t, "humidity-alert", rules[1].ID)
+
+	// Explicit IDs must be unique across files
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "more.yaml"), []byte(`
+- id: humidity-alert
+  topic: sensors/other
+  action:
+    topic: alerts/other
+    payload: "dup"
+`), 0644))
+
+	_, err = loader.LoadFromDirectory(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate rule id "humidity-alert"`)
+}
+
 func TestLoadFromDirectory_AdditionalCases(t *testing.T) {
 	// Create a temporary directory for test files
 	tmpDir, err := os.MkdirTemp("", "rules-test-*")
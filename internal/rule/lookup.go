@@ -0,0 +1,300 @@
+//file: internal/rule/lookup.go
+
+package rule
+
+import (
+    "bytes"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "gopkg.in/yaml.v3"
+    "mqtt-mux-router/internal/logger"
+)
+
+// LookupReloadInterval is how often lookup tables are checked for changes
+const LookupReloadInterval = 5 * time.Second
+
+// lookupPattern matches a lookup expression, lookup('table', key), followed
+// by an optional path into the row. The key is a message field or a quoted
+// literal.
+var lookupPattern = regexp.MustCompile(`^lookup\(\s*'([^']+)'\s*,\s*(?:'([^']*)'|([^\s',()]+))\s*\)(?:\.(.+))?$`)
+
+// lookupExpr is a parsed lookup expression
+type lookupExpr struct {
+    table   string
+    key     string // Literal key, or the field holding the key
+    literal bool
+    path    []string // Path into the row, empty for the whole row
+}
+
+// isLookup reports whether a condition field or template variable is a
+// lookup expression
+func isLookup(expr string) bool {
+    return strings.HasPrefix(expr, "lookup(")
+}
+
+// parseLookup parses a lookup expression
+func parseLookup(expr string) (*lookupExpr, error) {
+    m := lookupPattern.FindStringSubmatch(expr)
+    if m == nil {
+        return nil, fmt.Errorf("invalid lookup %q, expected lookup('table', field)", expr)
+    }
+
+    lookup := &lookupExpr{table: m[1], key: m[3]}
+    if m[3] == "" {
+        lookup.key, lookup.literal = m[2], true
+    }
+    if m[4] != "" {
+        lookup.path = strings.Split(m[4], ".")
+    }
+    return lookup, nil
+}
+
+// prepareLookups parses the lookup expressions of the condition fields, so
+// that they are not parsed again for every message
+func (c *Conditions) prepareLookups() error {
+    for i := range c.Items {
+        cond := &c.Items[i]
+        if !isLookup(cond.Field) {
+            continue
+        }
+        lookup, err := parseLookup(cond.Field)
+        if err != nil {
+            return err
+        }
+        cond.lookup = lookup
+    }
+    for i := range c.Groups {
+        if err := c.Groups[i].prepareLookups(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// LookupTable holds the rows of a reference table by key
+type LookupTable map[string]map[string]interface{}
+
+// Lookups holds the reference tables that rules read with lookup(), loaded
+// from the CSV, JSON and YAML files of a directory. Each file is a table
+// named after the file without its extension.
+type Lookups struct {
+    dir    string
+    logger *logger.Logger
+
+    tables      map[string]LookupTable
+    fingerprint string
+    mu          sync.RWMutex
+}
+
+// NewLookups creates an empty set of lookup tables for a directory
+func NewLookups(dir string, log *logger.Logger) *Lookups {
+    return &Lookups{
+        dir:    dir,
+        logger: log,
+        tables: make(map[string]LookupTable),
+    }
+}
+
+// Load reads every table in the directory, replacing the loaded tables. A
+// missing directory has no tables. On error the loaded tables are kept.
+func (l *Lookups) Load() error {
+    files, fingerprint, err := l.scan()
+    if err != nil {
+        return err
+    }
+
+    tables := make(map[string]LookupTable, len(files))
+    for _, path := range files {
+        name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+        if _, exists := tables[name]; exists {
+            return fmt.Errorf("duplicate lookup table %q in %s", name, l.dir)
+        }
+
+        table, err := loadLookupTable(path)
+        if err != nil {
+            return err
+        }
+        tables[name] = table
+    }
+
+    l.mu.Lock()
+    l.tables = tables
+    l.fingerprint = fingerprint
+    l.mu.Unlock()
+
+    l.logger.Info("lookup tables loaded",
+        "dir", l.dir,
+        "count", len(tables))
+    return nil
+}
+
+// Get returns a table's row for a key
+func (l *Lookups) Get(table, key string) (map[string]interface{}, bool) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    row, ok := l.tables[table][key]
+    return row, ok
+}
+
+// Watch reloads the tables every interval when a file was added, removed or
+// changed, until ctx is done. A table that fails to load is logged and the
+// previous tables stay in use.
+func (l *Lookups) Watch(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        _, fingerprint, err := l.scan()
+        if err != nil {
+            l.logger.Error("failed to scan lookup tables", "dir", l.dir, "error", err)
+            continue
+        }
+
+        l.mu.RLock()
+        changed := fingerprint != l.fingerprint
+        l.mu.RUnlock()
+        if !changed {
+            continue
+        }
+
+        if err := l.Load(); err != nil {
+            l.logger.Error("failed to reload lookup tables", "dir", l.dir, "error", err)
+            // Retry only once the files change again
+            l.mu.Lock()
+            l.fingerprint = fingerprint
+            l.mu.Unlock()
+        }
+    }
+}
+
+// scan returns the table files of the directory and a fingerprint of their
+// names, sizes and modification times
+func (l *Lookups) scan() ([]string, string, error) {
+    entries, err := os.ReadDir(l.dir)
+    if os.IsNotExist(err) {
+        return nil, "", nil
+    }
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to read lookup directory %s: %w", l.dir, err)
+    }
+
+    var files []string
+    var fingerprint strings.Builder
+    for _, entry := range entries {
+        ext := strings.ToLower(filepath.Ext(entry.Name()))
+        if entry.IsDir() || (ext != ".csv" && ext != ".json" && ext != ".yaml" && ext != ".yml") {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            return nil, "", fmt.Errorf("failed to read lookup table %s: %w", entry.Name(), err)
+        }
+        files = append(files, filepath.Join(l.dir, entry.Name()))
+        fmt.Fprintf(&fingerprint, "%s|%d|%d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+    }
+    sort.Strings(files)
+    return files, fingerprint.String(), nil
+}
+
+// loadLookupTable reads a table file. A CSV file has a header row and is
+// keyed by its first column; a JSON or YAML file is an object of rows by key.
+func loadLookupTable(path string) (LookupTable, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read lookup table %s: %w", path, err)
+    }
+
+    table := make(LookupTable)
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".csv":
+        records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse lookup table %s: %w", path, err)
+        }
+        if len(records) == 0 {
+            return table, nil
+        }
+        header := records[0]
+        for _, record := range records[1:] {
+            row := make(map[string]interface{}, len(header))
+            for i, column := range header {
+                row[column] = record[i]
+            }
+            table[record[0]] = row
+        }
+    case ".json":
+        err = json.Unmarshal(data, &table)
+    default:
+        err = yaml.Unmarshal(data, &table)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse lookup table %s: %w", path, err)
+    }
+    return table, nil
+}
+
+// lookupValue evaluates a lookup expression against the message values. It
+// returns false when the expression is invalid, the key field is missing or
+// the table has no row or field for it.
+func (p *Processor) lookupValue(expr string, values map[string]interface{}) (interface{}, bool) {
+    if p.lookups == nil {
+        return nil, false
+    }
+
+    lookup, err := parseLookup(expr)
+    if err != nil {
+        p.logger.Debug("invalid lookup", "error", err)
+        return nil, false
+    }
+    return p.evaluateLookup(lookup, values)
+}
+
+// evaluateLookup evaluates a parsed lookup expression against the message
+// values
+func (p *Processor) evaluateLookup(lookup *lookupExpr, values map[string]interface{}) (interface{}, bool) {
+    if p.lookups == nil {
+        return nil, false
+    }
+
+    key := lookup.key
+    if !lookup.literal {
+        value, ok := p.lookupField(values, lookup.key)
+        if !ok {
+            return nil, false
+        }
+        key = p.convertToString(value)
+    }
+
+    row, ok := p.lookups.Get(lookup.table, key)
+    if !ok {
+        p.logger.Debug("lookup key not found",
+            "table", lookup.table,
+            "key", key)
+        return nil, false
+    }
+    if len(lookup.path) == 0 {
+        return row, true
+    }
+    value, err := p.getValueFromPath(row, lookup.path)
+    if err != nil {
+        return nil, false
+    }
+    return value, true
+}
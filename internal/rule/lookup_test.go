@@ -0,0 +1,188 @@
+package rule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLookups writes lookup table files into a new directory
+func writeLookups(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestParseLookup(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    *lookupExpr
+		wantErr bool
+	}{
+		{
+			expr: "lookup('devices', deviceId).site",
+			want: &lookupExpr{table: "devices", key: "deviceId", path: []string{"site"}},
+		},
+		{
+			expr: "lookup('devices',device.id).owner.email",
+			want: &lookupExpr{table: "devices", key: "device.id", path: []string{"owner", "email"}},
+		},
+		{
+			expr: "lookup('devices', 'dev-1')",
+			want: &lookupExpr{table: "devices", key: "dev-1", literal: true},
+		},
+		{expr: "lookup(devices, deviceId)", wantErr: true},
+		{expr: "lookup('devices')", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseLookup(tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRule_PrepareParsesLookups(t *testing.T) {
+	rule := &Rule{
+		Topic: "sensors/temperature",
+		Conditions: &Conditions{
+			Operator: "and",
+			Items:    []Condition{{Field: "temperature", Operator: "gt", Value: 25}},
+			Groups: []Conditions{{
+				Operator: "or",
+				Items:    []Condition{{Field: "lookup('devices', deviceId).site", Operator: "eq", Value: "north"}},
+			}},
+		},
+		Action: &Action{Topic: "alerts"},
+	}
+	require.NoError(t, rule.prepare())
+
+	assert.Nil(t, rule.Conditions.Items[0].lookup)
+	lookup := rule.Conditions.Groups[0].Items[0].lookup
+	require.NotNil(t, lookup, "lookups are parsed once when the rule is prepared")
+	assert.Equal(t, &lookupExpr{table: "devices", key: "deviceId", path: []string{"site"}}, lookup)
+
+	rule.Conditions.Items[0].Field = "lookup(devices)"
+	assert.ErrorContains(t, rule.prepare(), "invalid lookup")
+}
+
+func TestLookups_Load(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	dir := writeLookups(t, map[string]string{
+		"devices.csv": "deviceId,site,model\ndev-1,north,TH-100\ndev-2,south,TH-200\n",
+		"sites.json":  `{"north": {"region": "eu", "floors": 3}}`,
+		"owners.yaml": "dev-1:\n  name: Alice\n  team: facilities\n",
+		"notes.txt":   "not a table",
+	})
+	lookups := NewLookups(dir, setup.logger)
+	require.NoError(t, lookups.Load())
+
+	row, ok := lookups.Get("devices", "dev-2")
+	require.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"deviceId": "dev-2", "site": "south", "model": "TH-200"}, row)
+
+	row, ok = lookups.Get("sites", "north")
+	require.True(t, ok)
+	assert.Equal(t, 3.0, row["floors"])
+
+	row, ok = lookups.Get("owners", "dev-1")
+	require.True(t, ok)
+	assert.Equal(t, "Alice", row["name"])
+
+	_, ok = lookups.Get("devices", "dev-3")
+	assert.False(t, ok)
+	_, ok = lookups.Get("notes", "not a table")
+	assert.False(t, ok)
+
+	// A missing directory has no tables
+	assert.NoError(t, NewLookups(filepath.Join(dir, "missing"), setup.logger).Load())
+
+	// Two files cannot define the same table
+	dir = writeLookups(t, map[string]string{
+		"devices.csv":  "deviceId,site\n",
+		"devices.yaml": "{}\n",
+	})
+	assert.ErrorContains(t, NewLookups(dir, setup.logger).Load(), "duplicate lookup table")
+}
+
+func TestLookups_Watch(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	dir := writeLookups(t, map[string]string{
+		"devices.csv": "deviceId,site\ndev-1,north\n",
+	})
+	lookups := NewLookups(dir, setup.logger)
+	require.NoError(t, lookups.Load())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lookups.Watch(ctx, 10*time.Millisecond)
+
+	site := func() interface{} {
+		row, _ := lookups.Get("devices", "dev-1")
+		return row["site"]
+	}
+
+	path := filepath.Join(dir, "devices.csv")
+	require.NoError(t, os.WriteFile(path, []byte("deviceId,site\ndev-1,south-east\n"), 0644))
+	assert.Eventually(t, func() bool { return site() == "south-east" }, time.Second, 10*time.Millisecond)
+
+	// A table that fails to load keeps the previous tables in use
+	require.NoError(t, os.WriteFile(path, []byte("deviceId,site\ndev-1,west,extra\n"), 0644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "south-east", site())
+}
+
+func TestProcess_Lookup(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	dir := writeLookups(t, map[string]string{
+		"devices.csv": "deviceId,site,owner\ndev-1,north,alice\ndev-2,south,bob\n",
+	})
+	setup.processor.lookups = NewLookups(dir, setup.logger)
+	require.NoError(t, setup.processor.lookups.Load())
+
+	require.NoError(t, setup.processor.LoadRules([]Rule{{
+		Topic: "sensors/temperature",
+		Conditions: &Conditions{
+			Operator: "and",
+			Items:    []Condition{{Field: "lookup('devices', deviceId).site", Operator: "eq", Value: "north"}},
+		},
+		Action: &Action{
+			Topic:   "alerts/${lookup('devices', deviceId).site}",
+			Payload: `{"owner":"${lookup('devices', deviceId).owner}","model":"${lookup('devices', deviceId).model}"}`,
+		},
+	}}))
+
+	actions, err := setup.processor.Process("sensors/temperature", []byte(`{"deviceId": "dev-1"}`))
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "alerts/north", actions[0].Topic)
+	assert.Equal(t, `{"owner":"alice","model":"${lookup('devices', deviceId).model}"}`, actions[0].Payload,
+		"missing columns are left unrendered")
+
+	for _, payload := range []string{`{"deviceId": "dev-2"}`, `{"deviceId": "dev-3"}`, `{}`} {
+		actions, err = setup.processor.Process("sensors/temperature", []byte(payload))
+		require.NoError(t, err)
+		assert.Empty(t, actions, payload)
+	}
+}
@@ -0,0 +1,169 @@
+//file: internal/rule/missing.go
+
+package rule
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "go.opentelemetry.io/otel/trace"
+    "mqtt-mux-router/internal/tracing"
+)
+
+// missingField is the name under which a missing key's details are available
+// to the rule's action templates, e.g. missing.lastSeen
+const missingField = "missing"
+
+// timerMissing is the timer kind of missing rules
+const timerMissing = "missing"
+
+// defaultMissingForget is how long a missing key is remembered so that its
+// recovery can fire
+const defaultMissingForget = 24 * time.Hour
+
+// Flags of a key's missing state
+const (
+    missingFired     = 1 << 0 // The missing action fired
+    missingNeverSeen = 1 << 1 // Watched since the rule loaded, no message yet
+)
+
+// prepare parses and checks the missing options
+func (m *Missing) prepare() error {
+    var err error
+    if m.timeout, err = parseOptionalDuration("missing timeout", m.Timeout); err != nil {
+        return err
+    }
+    if m.timeout == 0 {
+        return fmt.Errorf("missing timeout is required")
+    }
+
+    if m.forget, err = parseOptionalDuration("missing forget", m.Forget); err != nil {
+        return err
+    }
+    if m.forget == 0 {
+        m.forget = defaultMissingForget
+    }
+    return nil
+}
+
+// seen records a message for a missing rule's key, moving its timer, and
+// fires the recovery action when the key was missing
+func (p *Processor) seen(ctx context.Context, span trace.Span, msg *ProcessingMessage, rule *Rule, key string) {
+    m := rule.Missing
+    now := p.now()
+
+    values := msg.Values
+    if len(rule.State) > 0 {
+        values = withValue(values, stateField, p.joinState(rule, key, now))
+    }
+    if rule.Conditions != nil && !p.evaluateConditions(rule.Conditions, values) {
+        return
+    }
+
+    var lastSeen time.Time
+    var flags byte
+    recovered := false
+    p.state.Update(missingField+"\x00"+key, now, func(state []byte, ok bool) ([]byte, time.Duration) {
+        if ok && state[8]&missingFired != 0 {
+            recovered, lastSeen, flags = true, decodeTime(state), state[8]
+        }
+        return append(appendTime(make([]byte, 0, 9), now), 0), m.timeout + m.forget
+    })
+    p.timers.Schedule(timerMissing, ruleScope(rule), key, now.Add(m.timeout))
+
+    if !recovered || m.Recovery == nil {
+        return
+    }
+
+    values = withValue(values, missingField, missingValues(rule, key, lastSeen, flags, now))
+    action, err := p.fireAction(ctx, span, rule, m.Recovery, key, values, true)
+    if err != nil {
+        p.reportFailure(msg, rule.ID, StageTemplate, err)
+        return
+    }
+    if action != nil {
+        action.ReceivedAt = msg.ReceivedAt
+        action.SourceTopic = msg.SourceTopic()
+        msg.Actions = append(msg.Actions, action)
+    }
+}
+
+// watchTopic starts the timer of a missing rule keyed by its topic when the
+// topic has no state yet, so that a topic that never sends a message is also
+// reported missing
+func (p *Processor) watchTopic(rule *Rule, now time.Time) {
+    key := ruleScope(rule) + "\x00" + rule.Topic
+    m := rule.Missing
+
+    watch := false
+    p.state.Update(missingField+"\x00"+key, now, func(state []byte, ok bool) ([]byte, time.Duration) {
+        if ok {
+            return state, decodeTime(state).Add(m.timeout + m.forget).Sub(now)
+        }
+        watch = true
+        return append(appendTime(make([]byte, 0, 9), now), missingNeverSeen), m.timeout + m.forget
+    })
+    if watch {
+        p.timers.Schedule(timerMissing, ruleScope(rule), key, now.Add(m.timeout))
+    }
+}
+
+// fireMissing fires a missing rule's action when its key has had no message
+// for the timeout. It fires once until messages resume.
+func (p *Processor) fireMissing(rule *Rule, key string, now time.Time) {
+    m := rule.Missing
+
+    var lastSeen time.Time
+    var flags byte
+    fire := false
+    p.state.Update(missingField+"\x00"+key, now, func(state []byte, ok bool) ([]byte, time.Duration) {
+        if !ok {
+            return nil, 0
+        }
+        lastSeen, flags = decodeTime(state), state[8]
+        expires := lastSeen.Add(m.timeout + m.forget).Sub(now)
+        if flags&missingFired != 0 || now.Before(lastSeen.Add(m.timeout)) {
+            return state, expires
+        }
+        fire = true
+        return append(appendTime(make([]byte, 0, 9), lastSeen), flags|missingFired), expires
+    })
+    if !fire {
+        return
+    }
+
+    ctx, span := tracing.Start(context.Background(), tracing.SpanEvaluate, tracing.AttrRuleID.String(rule.ID))
+    defer span.End()
+
+    values := map[string]interface{}{
+        missingField: missingValues(rule, key, lastSeen, flags, now),
+    }
+    if len(rule.State) > 0 {
+        values[stateField] = p.joinState(rule, key, now)
+    }
+    action, err := p.fireAction(ctx, span, rule, rule.Action, key, values, true)
+    if err != nil {
+        p.reportTimerFailure(rule, err)
+        return
+    }
+    if action != nil {
+        p.emit(action)
+    }
+}
+
+// missingValues returns the template values of a missing key: the rendered
+// key, the time of its last message, empty if it never sent one, the
+// timeout and how long it has been silent in seconds
+func missingValues(rule *Rule, key string, lastSeen time.Time, flags byte, now time.Time) map[string]interface{} {
+    values := map[string]interface{}{
+        "key":      renderedKey(key),
+        "lastSeen": "",
+        "timeout":  rule.Missing.Timeout,
+        "duration": now.Sub(lastSeen).Seconds(),
+    }
+    if flags&missingNeverSeen == 0 {
+        values["lastSeen"] = lastSeen.UTC().Format(time.RFC3339)
+    }
+    return values
+}
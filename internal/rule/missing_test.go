@@ -0,0 +1,140 @@
+package rule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func missingRule() Rule {
+	return Rule{
+		ID:    "device-silent",
+		Topic: "sensors/temperature",
+		Key:   "${deviceId}",
+		Missing: &Missing{
+			Timeout: "5m",
+			Recovery: &Action{
+				Topic:   "alerts/recovered",
+				Payload: `{"device":"${deviceId}","lastSeen":"${missing.lastSeen}","silent":${missing.duration}}`,
+			},
+		},
+		Action: &Action{
+			Topic:   "alerts/silent",
+			Payload: `{"device":"${missing.key}","lastSeen":"${missing.lastSeen}","timeout":"${missing.timeout}"}`,
+		},
+	}
+}
+
+func TestMissing_Key(t *testing.T) {
+	setup := newTimedSetup(t, missingRule())
+
+	assert.Empty(t, setup.process(t, 0, reading("dev-1", 20)))
+	assert.Empty(t, setup.process(t, 0, reading("dev-2", 20)))
+	assert.Empty(t, setup.process(t, 3*time.Minute, reading("dev-1", 20)))
+
+	assert.Empty(t, setup.fireTimers(5*time.Minute-time.Second))
+	emitted := setup.fireTimers(5 * time.Minute)
+	require.Len(t, emitted, 1)
+	assert.Equal(t, "device-silent", emitted[0].RuleID)
+	assert.JSONEq(t, fmt.Sprintf(`{"device":"dev-2","lastSeen":%q,"timeout":"5m"}`, setup.timestamp(0)), emitted[0].Payload)
+
+	// A missing key fires once
+	emitted = setup.fireTimers(8 * time.Minute)
+	require.Len(t, emitted, 1)
+	assert.Contains(t, emitted[0].Payload, `"device":"dev-1"`)
+	assert.Empty(t, setup.fireTimers(time.Hour))
+
+	// The first message after the key went missing fires the recovery
+	actions := setup.process(t, 10*time.Minute, reading("dev-2", 21))
+	require.Len(t, actions, 1)
+	assert.Equal(t, "alerts/recovered", actions[0].Topic)
+	assert.JSONEq(t, fmt.Sprintf(`{"device":"dev-2","lastSeen":%q,"silent":600}`, setup.timestamp(0)), actions[0].Payload)
+	assert.Empty(t, setup.process(t, 11*time.Minute, reading("dev-2", 21)))
+
+	// and restarts its timer
+	emitted = setup.fireTimers(16 * time.Minute)
+	require.Len(t, emitted, 1)
+	assert.Contains(t, emitted[0].Payload, fmt.Sprintf(`"lastSeen":%q`, setup.timestamp(11*time.Minute)))
+}
+
+func TestMissing_Conditions(t *testing.T) {
+	rule := missingRule()
+	rule.Conditions = singleCondition("temperature", "gt", -50.0)
+	setup := newTimedSetup(t, rule)
+
+	setup.process(t, 0, reading("dev-1", 20))
+	// Readings that fail the conditions do not count as a sign of life
+	setup.process(t, 4*time.Minute, reading("dev-1", -100))
+	assert.Len(t, setup.fireTimers(5*time.Minute), 1)
+}
+
+func TestMissing_TopicNeverSeen(t *testing.T) {
+	rule := missingRule()
+	rule.Key = ""
+	setup := newTimedSetup(t, rule)
+
+	// Topics are watched from the time the rule loads
+	emitted := setup.fireTimers(5 * time.Minute)
+	require.Len(t, emitted, 1)
+	assert.JSONEq(t, `{"device":"sensors/temperature","lastSeen":"","timeout":"5m"}`, emitted[0].Payload)
+
+	// Reloading the rule keeps the topic's state
+	require.NoError(t, setup.processor.LoadRules([]Rule{rule}))
+	assert.Empty(t, setup.fireTimers(time.Hour))
+
+	actions := setup.process(t, time.Hour, reading("dev-1", 20))
+	require.Len(t, actions, 1)
+	assert.Contains(t, actions[0].Payload, `"lastSeen":""`)
+}
+
+func TestMissing_ManyKeys(t *testing.T) {
+	rule := missingRule()
+	rule.Missing.Recovery = nil
+	setup := newTimedSetup(t, rule)
+
+	const keys = 20000
+	for i := 0; i < keys; i++ {
+		setup.process(t, time.Duration(i)*time.Millisecond, fmt.Sprintf(`{"deviceId":"dev-%d"}`, i))
+	}
+	assert.Equal(t, keys, setup.processor.timers.Len())
+
+	// Half the devices report again
+	for i := 0; i < keys; i += 2 {
+		setup.process(t, time.Minute, fmt.Sprintf(`{"deviceId":"dev-%d"}`, i))
+	}
+	assert.Len(t, setup.fireTimers(5*time.Minute+keys*time.Millisecond), keys/2)
+	assert.Len(t, setup.fireTimers(6*time.Minute), keys/2)
+	assert.Equal(t, 0, setup.processor.timers.Len())
+}
+
+func TestMissingPrepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr string
+	}{
+		{name: "valid", rule: Rule{Missing: &Missing{Timeout: "1m", Forget: "1h"}}},
+		{name: "missing timeout", rule: Rule{Missing: &Missing{}}, wantErr: "missing timeout is required"},
+		{name: "invalid forget", rule: Rule{Missing: &Missing{Timeout: "1m", Forget: "later"}}, wantErr: "missing forget"},
+		{name: "with window", rule: Rule{Missing: &Missing{Timeout: "1m"}, Window: &Window{Type: WindowTumbling, Size: "1m"}}, wantErr: "window"},
+		{name: "with throttle", rule: Rule{Missing: &Missing{Timeout: "1m"}, Throttle: "1m"}, wantErr: "do not apply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.prepare()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	missing := &Missing{Timeout: "1m"}
+	require.NoError(t, missing.prepare())
+	assert.Equal(t, defaultMissingForget, missing.forget)
+}
@@ -5,7 +5,9 @@ package rule
 import (
     "sync"
     "sync/atomic"
+    "time"
 
+    "go.opentelemetry.io/otel/trace"
     "mqtt-mux-router/internal/logger"
 )
 
@@ -15,6 +17,32 @@ type ProcessingMessage struct {
     Values  map[string]interface{}
     Rules   []*Rule
     Actions []*Action
+
+    // Source is the topic the message arrived on when it differs from the
+    // rule topic in Topic
+    Source string
+
+    // ReceivedAt is when the message entered the processor, carried onto
+    // its actions for receive-to-publish latency
+    ReceivedAt time.Time
+
+    // SpanContext is the receive span of the message, the parent of its
+    // decode and evaluate spans
+    SpanContext trace.SpanContext
+
+    // decoded is set when Values already holds the decoded payload
+    decoded bool
+
+    // done is the submitter's SubmitOptions.Done
+    done func(handled bool)
+}
+
+// SourceTopic returns the topic the message arrived on
+func (m *ProcessingMessage) SourceTopic() string {
+    if m.Source != "" {
+        return m.Source
+    }
+    return m.Topic
 }
 
 type MessagePool struct {
@@ -80,12 +108,17 @@ func (p *MessagePool) Put(msg *ProcessingMessage) {
 
     // Clear message data before returning to pool
     msg.Topic = ""
+    msg.Source = ""
     msg.Payload = msg.Payload[:0]
     for k := range msg.Values {
         delete(msg.Values, k)
     }
     msg.Rules = msg.Rules[:0]
     msg.Actions = msg.Actions[:0]
+    msg.ReceivedAt = time.Time{}
+    msg.SpanContext = trace.SpanContext{}
+    msg.decoded = false
+    msg.done = nil
 
     p.pool.Put(msg)
 }
@@ -148,6 +181,36 @@ func (p *ResultPool) Put(result *ProcessingResult) {
     p.pool.Put(result)
 }
 
+// PoolStats reports how well a pool reuses its objects
+type PoolStats struct {
+    Gets    uint64  `json:"gets"`
+    Puts    uint64  `json:"puts"`
+    Misses  uint64  `json:"misses"`
+    HitRate float64 `json:"hitRate"` // Fraction of gets served without allocating
+}
+
+// Stats returns the message pool statistics
+func (p *MessagePool) Stats() PoolStats {
+    return newPoolStats(&p.stats)
+}
+
+// Stats returns the result pool statistics
+func (p *ResultPool) Stats() PoolStats {
+    return newPoolStats(&p.stats)
+}
+
+func newPoolStats(stats *poolStats) PoolStats {
+    ps := PoolStats{
+        Gets:   atomic.LoadUint64(&stats.gets),
+        Puts:   atomic.LoadUint64(&stats.puts),
+        Misses: atomic.LoadUint64(&stats.misses),
+    }
+    if ps.Gets > 0 && ps.Misses <= ps.Gets {
+        ps.HitRate = float64(ps.Gets-ps.Misses) / float64(ps.Gets)
+    }
+    return ps
+}
+
 // GetPoolStats returns current statistics for either pool type
 func getPoolStats(stats *poolStats) map[string]uint64 {
     return map[string]uint64{
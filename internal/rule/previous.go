@@ -0,0 +1,130 @@
+//file: internal/rule/previous.go
+
+package rule
+
+import (
+    "encoding/json"
+    "math"
+    "reflect"
+    "strings"
+    "time"
+)
+
+// previousField is the name under which the previous message of a key is
+// available to conditions and templates, e.g. previous.temperature
+const previousField = "previous"
+
+// defaultPreviousTTL is how long the previous message of a key is kept
+// without a new message
+const defaultPreviousTTL = time.Hour
+
+// Change operators compare a field with its value in the previous message
+var changeOperators = map[string]bool{
+    "changed":      true,
+    "crossesAbove": true,
+    "crossesBelow": true,
+}
+
+// preparePrevious parses the rule's previousTTL and records whether the rule
+// needs the previous message of its key
+func (r *Rule) preparePrevious() error {
+    var err error
+    if r.previousTTL, err = parseOptionalDuration("previousTTL", r.PreviousTTL); err != nil {
+        return err
+    }
+    if r.previousTTL == 0 {
+        r.previousTTL = defaultPreviousTTL
+    }
+    r.usePrevious = r.usesPrevious()
+    return nil
+}
+
+// usesPrevious reports whether the rule's conditions or action reference the
+// previous message, directly or through a change operator
+func (r *Rule) usesPrevious() bool {
+    if r.Action != nil {
+        ref := "${" + previousField + "."
+        if strings.Contains(r.Action.Topic, ref) || strings.Contains(r.Action.Payload, ref) {
+            return true
+        }
+    }
+    return conditionsUsePrevious(r.Conditions)
+}
+
+func conditionsUsePrevious(conditions *Conditions) bool {
+    if conditions == nil {
+        return false
+    }
+    for _, cond := range conditions.Items {
+        if changeOperators[cond.Operator] || strings.HasPrefix(cond.Field, previousField+".") {
+            return true
+        }
+    }
+    for i := range conditions.Groups {
+        if conditionsUsePrevious(&conditions.Groups[i]) {
+            return true
+        }
+    }
+    return false
+}
+
+// swapPrevious stores the payload of a message as the previous message of
+// its key and returns the values of the message it replaces, if any
+func (p *Processor) swapPrevious(rule *Rule, key string, msg *ProcessingMessage, now time.Time) map[string]interface{} {
+    var previous []byte
+    p.state.Update(previousField+"\x00"+key, now, func(value []byte, ok bool) ([]byte, time.Duration) {
+        if ok {
+            previous = value
+        }
+        // The message payload is pooled, so keep a copy
+        return append([]byte(nil), msg.Payload...), rule.previousTTL
+    })
+    if previous == nil {
+        return nil
+    }
+
+    var values map[string]interface{}
+    if err := json.Unmarshal(previous, &values); err != nil {
+        p.logger.Debug("failed to decode previous message",
+            "rule", rule.ID,
+            "error", err)
+        return nil
+    }
+    return values
+}
+
+// evaluateChange evaluates a change operator for a field's current and
+// previous value. Without a previous value nothing has changed or crossed.
+func (p *Processor) evaluateChange(cond *Condition, value interface{}, msg map[string]interface{}) bool {
+    previous, ok := p.lookupField(msg, previousField+"."+cond.Field)
+    if !ok {
+        return false
+    }
+
+    switch cond.Operator {
+    case "changed":
+        return p.valueChanged(value, previous, cond.Value)
+    case "crossesAbove":
+        return p.compareNumeric(previous, cond.Value, "lte") && p.compareNumeric(value, cond.Value, "gt")
+    case "crossesBelow":
+        return p.compareNumeric(previous, cond.Value, "gte") && p.compareNumeric(value, cond.Value, "lt")
+    default:
+        return false
+    }
+}
+
+// valueChanged compares a value with its previous value. A numeric
+// threshold ignores numeric changes smaller than it.
+func (p *Processor) valueChanged(value, previous, threshold interface{}) bool {
+    if threshold, ok := threshold.(int); ok {
+        return p.valueChanged(value, previous, float64(threshold))
+    }
+    if delta, ok := threshold.(float64); ok {
+        current, currentOK := value.(float64)
+        last, lastOK := previous.(float64)
+        if currentOK && lastOK {
+            return math.Abs(current-last) >= delta
+        }
+    }
+    return !reflect.DeepEqual(value, previous)
+}
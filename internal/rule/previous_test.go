@@ -0,0 +1,126 @@
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newChangeSetup loads a single rule on sensors/temperature keyed by device
+// with the given conditions; see newReadingSetup
+func newChangeSetup(t *testing.T, conditions *Conditions, payload string) (*testSetup, func(offset time.Duration, device string, temperature float64) []*Action) {
+	t.Helper()
+
+	return newReadingSetup(t, Rule{
+		ID:          "temperature-change",
+		Topic:       "sensors/temperature",
+		Key:         "${deviceId}",
+		PreviousTTL: "10m",
+		Conditions:  conditions,
+		Action: &Action{
+			Topic:   "alerts/temperature",
+			Payload: payload,
+		},
+	})
+}
+
+func singleCondition(field, operator string, value interface{}) *Conditions {
+	return &Conditions{
+		Operator: "and",
+		Items:    []Condition{{Field: field, Operator: operator, Value: value}},
+	}
+}
+
+func TestChange_Changed(t *testing.T) {
+	_, process := newChangeSetup(t, singleCondition("temperature", "changed", nil), `{"temp":${temperature}}`)
+
+	assert.Empty(t, process(0, "dev-1", 20), "the first message has nothing to compare with")
+	assert.Empty(t, process(time.Second, "dev-1", 20))
+	assert.Len(t, process(2*time.Second, "dev-1", 21), 1)
+	assert.Empty(t, process(3*time.Second, "dev-1", 21))
+}
+
+func TestChange_ChangedThreshold(t *testing.T) {
+	// Whole numbers decode as int from YAML rules
+	_, process := newChangeSetup(t, singleCondition("temperature", "changed", 2), `{"temp":${temperature}}`)
+
+	assert.Empty(t, process(0, "dev-1", 20))
+	assert.Empty(t, process(time.Second, "dev-1", 21.5), "changes below the threshold are ignored")
+	assert.Len(t, process(2*time.Second, "dev-1", 23.5), 1)
+}
+
+func TestChange_Crossing(t *testing.T) {
+	conditions := &Conditions{
+		Operator: "or",
+		Items: []Condition{
+			{Field: "temperature", Operator: "crossesAbove", Value: 30},
+			{Field: "temperature", Operator: "crossesBelow", Value: 10.0},
+		},
+	}
+	_, process := newChangeSetup(t, conditions,
+		`{"device":"${deviceId}","from":${previous.temperature},"to":${temperature}}`)
+
+	assert.Empty(t, process(0, "dev-1", 25))
+	assert.Empty(t, process(time.Second, "dev-1", 30), "reaching the threshold is not crossing it")
+
+	actions := process(2*time.Second, "dev-1", 31)
+	require.Len(t, actions, 1)
+	assert.JSONEq(t, `{"device":"dev-1","from":30,"to":31}`, actions[0].Payload)
+
+	assert.Empty(t, process(3*time.Second, "dev-1", 35), "the level staying above fires nothing")
+	assert.Empty(t, process(4*time.Second, "dev-1", 12))
+	assert.Len(t, process(5*time.Second, "dev-1", 9), 1)
+}
+
+func TestChange_PreviousField(t *testing.T) {
+	// A five degree jump between readings
+	conditions := &Conditions{
+		Operator: "and",
+		Items: []Condition{
+			{Field: "previous.temperature", Operator: "lt", Value: 20.0},
+			{Field: "temperature", Operator: "gte", Value: 25.0},
+		},
+	}
+	_, process := newChangeSetup(t, conditions, `{"from":${previous.temperature}}`)
+
+	assert.Empty(t, process(0, "dev-1", 25))
+	assert.Empty(t, process(time.Second, "dev-1", 15))
+	actions := process(2*time.Second, "dev-1", 26)
+	require.Len(t, actions, 1)
+	assert.Equal(t, `{"from":15}`, actions[0].Payload)
+}
+
+func TestChange_PerKeyAndExpiry(t *testing.T) {
+	setup, process := newChangeSetup(t, singleCondition("temperature", "crossesAbove", 30.0), `{}`)
+	state := setup.processor.state
+
+	process(0, "dev-1", 25)
+	assert.Empty(t, process(time.Second, "dev-2", 35), "dev-2 has no previous reading")
+	assert.Len(t, process(2*time.Second, "dev-1", 35), 1)
+	assert.Equal(t, 2, state.Len(), "one previous message per key")
+
+	// The previous reading expires after the rule's previousTTL
+	process(3*time.Second, "dev-1", 25)
+	assert.Empty(t, process(11*time.Minute, "dev-1", 35))
+	assert.Equal(t, 1, state.Len(), "expired keys are swept")
+}
+
+func TestRulePrepare_Previous(t *testing.T) {
+	rule := &Rule{Conditions: singleCondition("temperature", "changed", nil)}
+	require.NoError(t, rule.prepare())
+	assert.True(t, rule.usePrevious)
+	assert.Equal(t, defaultPreviousTTL, rule.previousTTL)
+
+	rule = &Rule{Action: &Action{Payload: `{"from":${previous.temperature}}`}, PreviousTTL: "5m"}
+	require.NoError(t, rule.prepare())
+	assert.True(t, rule.usePrevious)
+	assert.Equal(t, 5*time.Minute, rule.previousTTL)
+
+	rule = &Rule{Conditions: singleCondition("temperature", "gt", 25.0)}
+	require.NoError(t, rule.prepare())
+	assert.False(t, rule.usePrevious, "rules without change detection keep no previous message")
+
+	assert.ErrorContains(t, (&Rule{PreviousTTL: "never"}).prepare(), "previousTTL")
+}
@@ -3,88 +3,390 @@
 package rule
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
+    "hash/fnv"
     "regexp"
     "strconv"
     "strings"
     "sync"
     "sync/atomic"
+    "time"
 
     "github.com/google/uuid"
+    "go.opentelemetry.io/otel/trace"
     "mqtt-mux-router/internal/logger"
     "mqtt-mux-router/internal/metrics"
+    "mqtt-mux-router/internal/tracing"
+)
+
+// Queue overflow policies applied by Submit when the job channel is full
+const (
+    OverflowBlock      = "block"
+    OverflowDropNewest = "drop_newest"
+    OverflowDropOldest = "drop_oldest"
+)
+
+// Ordering keys used to shard messages across workers
+const (
+    OrderingNone  = "none"
+    OrderingTopic = "topic"
+    OrderingField = "field"
+)
+
+var (
+    // ErrQueueFull is returned by Submit when a message is dropped by the drop_newest policy
+    ErrQueueFull = errors.New("processing queue is full")
+    // ErrProcessorClosed is returned by Submit after Close has been called
+    ErrProcessorClosed = errors.New("processor is closed")
 )
 
 type ProcessorConfig struct {
-    Workers    int
-    QueueSize  int
-    BatchSize  int
+    // Name identifies the processor's connection in metrics
+    Name string
+
+    Workers        int
+    QueueSize      int
+    BatchSize      int
+    OverflowPolicy string
+
+    // OrderingKey shards messages so that messages with the same key are
+    // handled by the same worker in arrival order: none, topic or field.
+    // OrderingField is the payload field (dot separated path) for field.
+    OrderingKey   string
+    OrderingField string
+
+    // State holds the state of stateful rules; nil keeps it in memory. The
+    // processor closes it when it stops.
+    State StateStore
+
+    // Lookups holds the reference tables rules read with lookup() (optional)
+    Lookups *Lookups
 }
 
+// ResultHandler receives the outcome of every message processed by the worker
+// pool. The result and its message return to their pools when the handler returns,
+// so handlers must not keep references to them.
+type ResultHandler func(result *ProcessingResult)
+
+// Stages at which a message or action can fail
+const (
+    StageDecode   = "decode"
+    StageTemplate = "template"
+    StagePublish  = "publish"
+)
+
+// Failure describes a message that could not be processed, or an action that
+// could not be published. Payload is owned by the failure.
+type Failure struct {
+    Topic   string
+    Payload []byte
+    RuleID  string
+    Stage   string
+    Err     error
+    Time    time.Time
+}
+
+// FailureHandler receives every decode and template failure
+type FailureHandler func(failure *Failure)
+
+// ActionHandler receives actions fired by a timer rather than a message,
+// such as the action of a tumbling window when it closes
+type ActionHandler func(action *Action)
+
 type Processor struct {
-    index      *RuleIndex
-    msgPool    *MessagePool
-    resultPool *ResultPool
-    workers    int
-    jobChan    chan *ProcessingMessage
-    logger     *logger.Logger
-    metrics    *metrics.Metrics
-    stats      ProcessorStats
-    wg         sync.WaitGroup
+    name           string
+    index          *RuleIndex
+    msgPool        *MessagePool
+    resultPool     *ResultPool
+    workers        int
+    overflowPolicy string
+    jobChan        chan *ProcessingMessage
+    handler        ResultHandler
+    onFailure      FailureHandler
+    onAction       ActionHandler
+
+    // Per-worker queues used instead of jobChan when ordering is enabled
+    shards        []chan *ProcessingMessage
+    orderingKey   string
+    orderingField []string
+
+    logger         *logger.Logger
+    metrics        *metrics.Metrics
+    stats          ProcessorStats
+    wg             sync.WaitGroup
+
+    // ruleStats holds the counters of every loaded rule with an ID
+    ruleStats   map[string]*RuleStats
+    ruleStatsMu sync.RWMutex
+
+    // state holds per-key rule state, such as debounce and throttle times
+    state     StateStore
+    stateOnce sync.Once
+    now       func() time.Time
+
+    // timers fire per-key deadlines of the rules in timedRules, keyed by
+    // rule scope, until timersDone is closed
+    timers     *timerQueue
+    timedRules map[string]*Rule
+    timedMu    sync.RWMutex
+    timersDone chan struct{}
+
+    // lookups holds the reference tables of lookup(), nil without any
+    lookups *Lookups
+
+    // sources holds the state sources of the loaded rules by topic
+    sources   map[string][]*StateSource
+    sourcesMu sync.RWMutex
+
+    // closeMu guards jobChan against sends after Close. closing is closed
+    // before Close takes closeMu, so that senders waiting on a full queue
+    // give up instead of holding it.
+    closeMu     sync.RWMutex
+    closed      bool
+    closing     chan struct{}
+    closingOnce sync.Once
+    // discard is set when a drain times out
+    discard int32
 }
 
 type ProcessorStats struct {
-    Processed uint64
-    Matched   uint64
-    Errors    uint64
+    Processed uint64 `json:"processed"`
+    Matched   uint64 `json:"matched"`
+    Errors    uint64 `json:"errors"`
+    Dropped   uint64 `json:"dropped"`
+}
+
+// RuleStats counts the outcomes of evaluating a single rule
+type RuleStats struct {
+    Evaluations    uint64 `json:"evaluations"`
+    Matches        uint64 `json:"matches"`
+    TemplateErrors uint64 `json:"templateErrors"`
+    Suppressed     uint64 `json:"suppressed"`
+}
+
+// ProcessorReport is a point-in-time view of a processor, its rule index and
+// pools, and the per-rule counters keyed by rule ID
+type ProcessorReport struct {
+    Stats       ProcessorStats       `json:"stats"`
+    QueueDepth  int                  `json:"queueDepth"`
+    Index       IndexSummary         `json:"index"`
+    MessagePool PoolStats            `json:"messagePool"`
+    ResultPool  PoolStats            `json:"resultPool"`
+    Rules       map[string]RuleStats `json:"rules"`
+    StateKeys   int                  `json:"stateKeys"`
+    Timers      int                  `json:"timers"`
 }
 
-func NewProcessor(cfg ProcessorConfig, log *logger.Logger, metrics *metrics.Metrics) *Processor {
+func NewProcessor(cfg ProcessorConfig, log *logger.Logger, metricsService *metrics.Metrics) *Processor {
     if cfg.Workers <= 0 {
         cfg.Workers = 1
     }
     if cfg.QueueSize <= 0 {
         cfg.QueueSize = 1000
     }
+    if cfg.OverflowPolicy == "" {
+        cfg.OverflowPolicy = OverflowBlock
+    }
+    if cfg.OrderingKey == "" {
+        cfg.OrderingKey = OrderingNone
+    }
+    if cfg.State == nil {
+        cfg.State = NewMemoryStateStore()
+    }
 
     p := &Processor{
-        index:      NewRuleIndex(log),
-        msgPool:    NewMessagePool(log),
-        resultPool: NewResultPool(log),
-        workers:    cfg.Workers,
-        jobChan:    make(chan *ProcessingMessage, cfg.QueueSize),
-        logger:     log,
-        metrics:    metrics,
+        name:           cfg.Name,
+        index:          NewRuleIndex(log),
+        msgPool:        NewMessagePool(log),
+        resultPool:     NewResultPool(log),
+        workers:        cfg.Workers,
+        overflowPolicy: cfg.OverflowPolicy,
+        jobChan:        make(chan *ProcessingMessage, cfg.QueueSize),
+        orderingKey:    cfg.OrderingKey,
+        logger:         log,
+        metrics:        metricsService,
+        ruleStats:      make(map[string]*RuleStats),
+        state:          cfg.State,
+        lookups:        cfg.Lookups,
+        now:            time.Now,
+        timers:         newTimerQueue(),
+        timedRules:     make(map[string]*Rule),
+        timersDone:     make(chan struct{}),
+        closing:        make(chan struct{}),
+    }
+
+    if cfg.OrderingKey != OrderingNone {
+        // Split the queue capacity across one queue per worker
+        shardSize := cfg.QueueSize / cfg.Workers
+        if shardSize < 1 {
+            shardSize = 1
+        }
+        p.shards = make([]chan *ProcessingMessage, cfg.Workers)
+        for i := range p.shards {
+            p.shards[i] = make(chan *ProcessingMessage, shardSize)
+        }
+        if cfg.OrderingKey == OrderingField {
+            p.orderingField = strings.Split(cfg.OrderingField, ".")
+        }
     }
 
     p.logger.Info("initializing processor",
         "workers", cfg.Workers,
         "queueSize", cfg.QueueSize,
-        "batchSize", cfg.BatchSize)
+        "batchSize", cfg.BatchSize,
+        "overflowPolicy", cfg.OverflowPolicy,
+        "orderingKey", cfg.OrderingKey,
+        "orderingField", cfg.OrderingField)
 
-    p.metrics.SetWorkerPoolActive(float64(cfg.Workers))
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.SetWorkerPoolActive(cfg.Name, float64(cfg.Workers))
+    })
     p.startWorkers()
+    p.wg.Add(1)
+    go p.runTimers()
     return p
 }
 
+// SetResultHandler sets the handler that receives worker pool results. It must
+// be called before the first message is submitted.
+func (p *Processor) SetResultHandler(handler ResultHandler) {
+    p.handler = handler
+}
+
+// SetFailureHandler sets the handler that receives decode and template
+// failures, typically a dead-letter sink. It must be called before the first
+// message is processed.
+func (p *Processor) SetFailureHandler(handler FailureHandler) {
+    p.onFailure = handler
+}
+
+// SetActionHandler sets the handler that receives actions fired by timers.
+// It must be called before rules are loaded.
+func (p *Processor) SetActionHandler(handler ActionHandler) {
+    p.onAction = handler
+}
+
+// emit hands an action fired by a timer to the action handler
+func (p *Processor) emit(action *Action) {
+    if p.onAction == nil {
+        p.logger.Debug("no action handler for timer action",
+            "rule", action.RuleID,
+            "topic", action.Topic)
+        return
+    }
+    p.onAction(action)
+}
+
+// reportFailure hands a failure to the failure handler, copying the pooled payload
+func (p *Processor) reportFailure(msg *ProcessingMessage, ruleID, stage string, err error) {
+    if p.onFailure == nil {
+        return
+    }
+
+    p.onFailure(&Failure{
+        Topic:   msg.Topic,
+        Payload: append([]byte(nil), msg.Payload...),
+        RuleID:  ruleID,
+        Stage:   stage,
+        Err:     err,
+        Time:    time.Now(),
+    })
+}
+
+// reportTimerFailure hands a failure of a timer action, which has no
+// message, to the failure handler
+func (p *Processor) reportTimerFailure(rule *Rule, err error) {
+    if p.onFailure == nil {
+        return
+    }
+
+    p.onFailure(&Failure{
+        Topic:  rule.Topic,
+        RuleID: rule.ID,
+        Stage:  StageTemplate,
+        Err:    err,
+        Time:   time.Now(),
+    })
+}
+
 func (p *Processor) LoadRules(rules []Rule) error {
     p.logger.Info("loading rules into processor", "ruleCount", len(rules))
 
+    for i := range rules {
+        if err := rules[i].prepare(); err != nil {
+            return fmt.Errorf("invalid rule %q: %w", rules[i].ID, err)
+        }
+    }
+
     p.index.Clear()
 
+    timedRules := make(map[string]*Rule)
     for i := range rules {
         rule := &rules[i]
         p.index.Add(rule)
+        if rule.timed() {
+            timedRules[ruleScope(rule)] = rule
+        }
     }
 
-    p.metrics.SetRulesActive(float64(len(rules)))
+    // Timers of removed rules are dropped when they fire
+    p.timedMu.Lock()
+    p.timedRules = timedRules
+    p.timedMu.Unlock()
+
+    p.sourcesMu.Lock()
+    p.sources = stateSources(rules)
+    p.sourcesMu.Unlock()
+
+    // Timers are not persisted, so rebuild them from the state of the
+    // timed rules, which a persistent store may have restored
+    p.restoreTimers(timedRules, p.now())
+    for _, rule := range timedRules {
+        if rule.Missing != nil && rule.Key == "" {
+            p.watchTopic(rule, p.now())
+        }
+    }
+
+    // Counters survive reloads for rules that keep their ID
+    p.ruleStatsMu.Lock()
+    ruleStats := make(map[string]*RuleStats, len(rules))
+    for _, rule := range rules {
+        if rule.ID == "" {
+            continue
+        }
+        if counters, exists := p.ruleStats[rule.ID]; exists {
+            ruleStats[rule.ID] = counters
+        } else {
+            ruleStats[rule.ID] = &RuleStats{}
+        }
+    }
+    p.ruleStats = ruleStats
+    p.ruleStatsMu.Unlock()
+
     p.logger.Info("rules loaded successfully", "count", len(rules))
     return nil
 }
 
+// GetTopics returns the topics to subscribe to: the topics of the loaded
+// rules and of their state sources
 func (p *Processor) GetTopics() []string {
     topics := p.index.GetTopics()
+    indexed := make(map[string]bool, len(topics))
+    for _, topic := range topics {
+        indexed[topic] = true
+    }
+
+    p.sourcesMu.RLock()
+    for topic := range p.sources {
+        if !indexed[topic] {
+            topics = append(topics, topic)
+        }
+    }
+    p.sourcesMu.RUnlock()
+
     p.logger.Debug("retrieved topics from index", "topicCount", len(topics))
     return topics
 }
@@ -93,6 +395,7 @@ func (p *Processor) GetJobChannel() chan *ProcessingMessage {
     return p.jobChan
 }
 
+// Process evaluates a message synchronously and returns the resulting actions
 func (p *Processor) Process(topic string, payload []byte) ([]*Action, error) {
     p.logger.Debug("processing message",
         "topic", topic,
@@ -101,59 +404,366 @@ func (p *Processor) Process(topic string, payload []byte) ([]*Action, error) {
     msg := p.msgPool.Get()
     msg.Topic = topic
     msg.Payload = payload
+    msg.ReceivedAt = time.Now()
+    defer func() {
+        // The payload belongs to the caller and must not be reused by the pool
+        msg.Payload = nil
+        p.msgPool.Put(msg)
+    }()
+
+    if err := p.evaluate(msg); err != nil {
+        return nil, err
+    }
 
-    msg.Rules = p.index.Find(topic)
     if len(msg.Rules) == 0 {
-        p.logger.Debug("no matching rules found for topic", "topic", topic)
-        p.msgPool.Put(msg)
         return nil, nil
     }
 
-    if err := json.Unmarshal(payload, &msg.Values); err != nil {
-        p.msgPool.Put(msg)
-        atomic.AddUint64(&p.stats.Errors, 1)
-        p.metrics.IncMessagesTotal("error")
-        p.logger.Error("failed to unmarshal message",
-            "error", err,
-            "topic", topic)
-        return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+    actions := make([]*Action, len(msg.Actions))
+    copy(actions, msg.Actions)
+    return actions, nil
+}
+
+// Submit queues a message for the worker pool, applying the overflow policy
+// when the queue is full. The payload is copied so callers may reuse it.
+func (p *Processor) Submit(topic string, payload []byte) error {
+    return p.SubmitContext(context.Background(), topic, payload)
+}
+
+// SubmitContext is Submit for a message whose receive span is in ctx. The
+// message's decode and evaluate spans become children of that span.
+func (p *Processor) SubmitContext(ctx context.Context, topic string, payload []byte) error {
+    return p.SubmitWith(ctx, topic, payload, SubmitOptions{})
+}
+
+// SubmitOptions holds the optional details of a message queued with SubmitWith
+type SubmitOptions struct {
+    // Source is the topic the message arrived on when it differs from the
+    // rule topic it is matched by, such as the subject received on a NATS
+    // wildcard subscription
+    Source string
+
+    // Done is called once the processor is finished with the message, with
+    // handled set when its result handler has returned and unset when the
+    // message was dropped or discarded instead
+    Done func(handled bool)
+}
+
+// SubmitWith is SubmitContext for a message with optional details
+func (p *Processor) SubmitWith(ctx context.Context, topic string, payload []byte, opts SubmitOptions) error {
+    msg := p.msgPool.Get()
+    msg.Topic = topic
+    msg.Source = opts.Source
+    msg.done = opts.Done
+    msg.Payload = append(msg.Payload[:0], payload...)
+    msg.ReceivedAt = time.Now()
+    msg.SpanContext = trace.SpanContextFromContext(ctx)
+
+    p.closeMu.RLock()
+    defer p.closeMu.RUnlock()
+
+    if p.closed {
+        p.discardMessage(msg)
+        return ErrProcessorClosed
     }
 
-    for _, rule := range msg.Rules {
-        if rule.Conditions == nil || p.evaluateConditions(rule.Conditions, msg.Values) {
-            action, err := p.processActionTemplate(rule.Action, msg.Values)
-            if err != nil {
-                p.metrics.IncTemplateOpsTotal("error")
-                p.logger.Error("failed to process action template",
-                    "error", err,
-                    "topic", rule.Topic)
-                continue
+    if err := p.enqueue(p.queueFor(msg), msg); err != nil {
+        return err
+    }
+
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.SetMessageQueueDepth(p.name, float64(p.QueueDepth()))
+    })
+
+    return nil
+}
+
+// enqueue places a message on a queue according to the overflow policy
+func (p *Processor) enqueue(queue chan *ProcessingMessage, msg *ProcessingMessage) error {
+    switch p.overflowPolicy {
+    case OverflowDropNewest:
+        select {
+        case queue <- msg:
+        default:
+            p.recordDrop(msg.Topic)
+            p.discardMessage(msg)
+            return ErrQueueFull
+        }
+    case OverflowDropOldest:
+        for enqueued := false; !enqueued; {
+            select {
+            case queue <- msg:
+                enqueued = true
+            default:
+                // Make room by discarding the oldest queued message
+                select {
+                case oldest := <-queue:
+                    p.recordDrop(oldest.Topic)
+                    p.discardMessage(oldest)
+                default:
+                }
             }
-            p.metrics.IncTemplateOpsTotal("success")
-            p.metrics.IncRuleMatches()
-            msg.Actions = append(msg.Actions, action)
+        }
+    default:
+        select {
+        case queue <- msg:
+        case <-p.closing:
+            p.discardMessage(msg)
+            return ErrProcessorClosed
         }
     }
 
-    actions := make([]*Action, len(msg.Actions))
-    copy(actions, msg.Actions)
+    return nil
+}
+
+// queueFor selects the queue for a message. Without ordering all workers share
+// jobChan; with ordering the message key is hashed to a single worker's queue.
+func (p *Processor) queueFor(msg *ProcessingMessage) chan *ProcessingMessage {
+    if len(p.shards) == 0 {
+        return p.jobChan
+    }
+
+    h := fnv.New32a()
+    h.Write([]byte(p.orderingKeyFor(msg)))
+    return p.shards[h.Sum32()%uint32(len(p.shards))]
+}
+
+// orderingKeyFor returns the ordering key for a message. Field ordering decodes
+// the payload up front and falls back to the topic when the field is missing.
+func (p *Processor) orderingKeyFor(msg *ProcessingMessage) string {
+    if p.orderingKey != OrderingField {
+        return msg.Topic
+    }
+
+    if err := p.decode(msg); err != nil {
+        // Leave decoding to the worker so the error is reported there
+        return msg.Topic
+    }
+    msg.decoded = true
+
+    value, err := p.getValueFromPath(msg.Values, p.orderingField)
+    if err != nil {
+        p.logger.Debug("ordering field not found, using topic",
+            "field", strings.Join(p.orderingField, "."),
+            "topic", msg.Topic)
+        return msg.Topic
+    }
+
+    return p.convertToString(value)
+}
+
+// recordDrop accounts for a message discarded by the overflow policy
+func (p *Processor) recordDrop(topic string) {
+    atomic.AddUint64(&p.stats.Dropped, 1)
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.IncMessagesTotal("dropped")
+    })
+    p.logger.Debug("dropped message due to full processing queue",
+        "topic", topic,
+        "policy", p.overflowPolicy)
+}
+
+// decode unmarshals a message payload into its values, recording the time taken
+func (p *Processor) decode(msg *ProcessingMessage) error {
+    ctx := trace.ContextWithSpanContext(context.Background(), msg.SpanContext)
+    _, span := tracing.Start(ctx, tracing.SpanDecode)
+
+    start := time.Now()
+    err := json.Unmarshal(msg.Payload, &msg.Values)
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.ObserveDecode(time.Since(start))
+    })
+
+    tracing.End(span, err)
+    return err
+}
+
+// evaluate matches a message against the rule index and fills msg.Actions
+func (p *Processor) evaluate(msg *ProcessingMessage) error {
+    msg.Rules = p.index.Find(msg.Topic)
+    p.sourcesMu.RLock()
+    sources := p.sources[msg.Topic]
+    p.sourcesMu.RUnlock()
+    if len(msg.Rules) == 0 && len(sources) == 0 {
+        p.logger.Debug("no matching rules found for topic", "topic", msg.Topic)
+        return nil
+    }
+
+    // Field ordering may already have decoded the payload
+    if !msg.decoded {
+        if err := p.decode(msg); err != nil {
+            atomic.AddUint64(&p.stats.Errors, 1)
+            p.safeMetricsUpdate(func(m *metrics.Metrics) {
+                m.IncMessagesTotal("error")
+            })
+            p.logger.Error("failed to unmarshal message",
+                "error", err,
+                "topic", msg.Topic)
+            err = fmt.Errorf("failed to unmarshal message: %w", err)
+            p.reportFailure(msg, "", StageDecode, err)
+            return err
+        }
+    }
+
+    if len(sources) > 0 {
+        p.recordState(msg, sources, p.now())
+    }
+
+    evalStart := time.Now()
+    ctx := trace.ContextWithSpanContext(context.Background(), msg.SpanContext)
+    for _, rule := range msg.Rules {
+        p.evaluateRule(ctx, msg, rule)
+    }
+
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.ObserveEvaluation(time.Since(evalStart))
+    })
 
     atomic.AddUint64(&p.stats.Processed, 1)
-    if len(actions) > 0 {
+    if len(msg.Actions) > 0 {
         atomic.AddUint64(&p.stats.Matched, 1)
         p.logger.Debug("message processing complete",
-            "topic", topic,
-            "matchedActions", len(actions))
+            "topic", msg.Topic,
+            "matchedActions", len(msg.Actions))
     }
 
-    p.msgPool.Put(msg)
-    return actions, nil
+    return nil
+}
+
+// evaluateRule evaluates one rule against a decoded message, appending the
+// rendered action to msg.Actions when the rule matches
+func (p *Processor) evaluateRule(ctx context.Context, msg *ProcessingMessage, rule *Rule) {
+    ctx, span := tracing.Start(ctx, tracing.SpanEvaluate, tracing.AttrRuleID.String(rule.ID))
+    defer span.End()
+
+    var key string
+    if rule.suppresses() || rule.usePrevious || rule.Window != nil || rule.Missing != nil || len(rule.State) > 0 {
+        key = p.stateKey(rule, msg)
+    }
+
+    if rule.Missing != nil {
+        p.seen(ctx, span, msg, rule, key)
+        return
+    }
+
+    values := msg.Values
+    if window := rule.Window; window != nil {
+        sample, ok := p.windowSample(window, msg.Values)
+        if !ok {
+            return
+        }
+        // Tumbling windows are evaluated when they close
+        if window.Type == WindowTumbling {
+            p.addToTumbling(rule, key, sample, p.now())
+            return
+        }
+        values = withValue(values, windowField, p.addToSliding(rule, key, sample, p.now()))
+    }
+    if rule.usePrevious {
+        values = withValue(values, previousField, p.swapPrevious(rule, key, msg, p.now()))
+    }
+    if len(rule.State) > 0 {
+        values = withValue(values, stateField, p.joinState(rule, key, p.now()))
+    }
+
+    action, err := p.fireRule(ctx, span, rule, key, values)
+    if err != nil {
+        p.reportFailure(msg, rule.ID, StageTemplate, err)
+        return
+    }
+    if action == nil {
+        return
+    }
+    action.ReceivedAt = msg.ReceivedAt
+    action.SourceTopic = msg.SourceTopic()
+    msg.Actions = append(msg.Actions, action)
+}
+
+// fireRule evaluates a rule's conditions against values and fires its action
+// with fireAction
+func (p *Processor) fireRule(ctx context.Context, span trace.Span, rule *Rule, key string, values map[string]interface{}) (*Action, error) {
+    start := time.Now()
+    matched := rule.Conditions == nil || p.evaluateConditions(rule.Conditions, values)
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.ObserveRuleEvaluation(rule.ID, matched, time.Since(start))
+    })
+
+    return p.fireAction(ctx, span, rule, rule.Action, key, values, matched)
+}
+
+// fireAction applies a rule's suppression for key and, when the rule matched,
+// renders one of its actions. It returns a nil action when the rule does not
+// fire, and an error when the action fails to render.
+func (p *Processor) fireAction(ctx context.Context, span trace.Span, rule *Rule, template *Action, key string, values map[string]interface{}, matched bool) (*Action, error) {
+    counters := p.ruleCounters(rule.ID)
+    if counters != nil {
+        atomic.AddUint64(&counters.Evaluations, 1)
+    }
+    span.SetAttributes(tracing.AttrRuleMatched.Bool(matched))
+
+    // Messages that do not match still reach suppression to reset debounce
+    var suppressed string
+    if rule.suppresses() {
+        suppressed = p.suppress(rule, key, matched, p.now())
+    }
+
+    if !matched {
+        return nil, nil
+    }
+    if counters != nil {
+        atomic.AddUint64(&counters.Matches, 1)
+    }
+
+    if suppressed != "" {
+        if counters != nil {
+            atomic.AddUint64(&counters.Suppressed, 1)
+        }
+        p.safeMetricsUpdate(func(m *metrics.Metrics) {
+            m.IncRuleSuppressed(rule.ID, suppressed)
+        })
+        span.SetAttributes(tracing.AttrRuleSuppressed.String(suppressed))
+        p.logger.Debug("rule action suppressed",
+            "rule", rule.ID,
+            "key", key,
+            "reason", suppressed)
+        return nil, nil
+    }
+
+    _, renderSpan := tracing.Start(ctx, tracing.SpanRender)
+    action, err := p.processActionTemplate(template, values)
+    tracing.End(renderSpan, err)
+    if err != nil {
+        if counters != nil {
+            atomic.AddUint64(&counters.TemplateErrors, 1)
+        }
+        p.safeMetricsUpdate(func(m *metrics.Metrics) {
+            m.IncTemplateOpsTotal("error")
+            m.IncRuleTemplateErrors(rule.ID)
+        })
+        p.logger.Error("failed to process action template",
+            "error", err,
+            "rule", rule.ID,
+            "topic", rule.Topic)
+        return nil, err
+    }
+    action.RuleID = rule.ID
+    // Timer-fired actions have no message; message paths set the topic it arrived on
+    action.SourceTopic = rule.Topic
+    action.SpanContext = span.SpanContext()
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.IncTemplateOpsTotal("success")
+        m.IncRuleMatches()
+    })
+    return action, nil
 }
 
 func (p *Processor) processActionTemplate(action *Action, msg map[string]interface{}) (*Action, error) {
     processedAction := &Action{
         Topic:   action.Topic,
         Payload: action.Payload,
+        Broker:  action.Broker,
+        Retry:   action.Retry,
+        File:    action.File,
     }
 
     if strings.Contains(action.Topic, "${") {
@@ -170,9 +780,81 @@ func (p *Processor) processActionTemplate(action *Action, msg map[string]interfa
     }
     processedAction.Payload = payload
 
+    if action.HTTP != nil {
+        processedAction.HTTP, err = p.processHTTPTemplate(action.HTTP, msg)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    if action.Kafka != nil {
+        processedAction.Kafka, err = p.processKafkaTemplate(action.Kafka, msg)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    if action.Log != nil {
+        processed := *action.Log
+        processed.Message, err = p.processTemplate(action.Log.Message, msg)
+        if err != nil {
+            return nil, fmt.Errorf("failed to process log message template: %w", err)
+        }
+        processedAction.Log = &processed
+    }
+
     return processedAction, nil
 }
 
+// processKafkaTemplate renders the key and header values of a Kafka record
+func (p *Processor) processKafkaTemplate(action *KafkaAction, msg map[string]interface{}) (*KafkaAction, error) {
+    key, err := p.processTemplate(action.Key, msg)
+    if err != nil {
+        return nil, fmt.Errorf("failed to process kafka key template: %w", err)
+    }
+    processed := &KafkaAction{Key: key}
+
+    headers, err := p.processHeaderTemplates(action.Headers, msg)
+    if err != nil {
+        return nil, err
+    }
+    processed.Headers = headers
+    return processed, nil
+}
+
+// processHeaderTemplates renders the values of a set of headers
+func (p *Processor) processHeaderTemplates(headers map[string]string, msg map[string]interface{}) (map[string]string, error) {
+    if len(headers) == 0 {
+        return nil, nil
+    }
+    rendered := make(map[string]string, len(headers))
+    for name, value := range headers {
+        r, err := p.processTemplate(value, msg)
+        if err != nil {
+            return nil, fmt.Errorf("failed to process header %s template: %w", name, err)
+        }
+        rendered[name] = r
+    }
+    return rendered, nil
+}
+
+// processHTTPTemplate renders the URL and header values of an http action
+func (p *Processor) processHTTPTemplate(action *HTTPAction, msg map[string]interface{}) (*HTTPAction, error) {
+    processed := *action
+
+    url, err := p.processTemplate(action.URL, msg)
+    if err != nil {
+        return nil, fmt.Errorf("failed to process url template: %w", err)
+    }
+    processed.URL = url
+
+    processed.Headers, err = p.processHeaderTemplates(action.Headers, msg)
+    if err != nil {
+        return nil, err
+    }
+    return &processed, nil
+}
+
 func (p *Processor) processTemplate(template string, data map[string]interface{}) (string, error) {
     p.logger.Debug("processing template",
         "template", template,
@@ -207,7 +889,15 @@ func (p *Processor) processTemplate(template string, data map[string]interface{}
     // Handle variable substitutions
     varPattern := regexp.MustCompile(`\${([^}]+)}`)
     result = varPattern.ReplaceAllStringFunc(result, func(match string) string {
-        path := strings.Split(match[2:len(match)-1], ".") // remove ${ and }
+        expr := match[2 : len(match)-1] // remove ${ and }
+        if isLookup(expr) {
+            value, ok := p.lookupValue(expr, data)
+            if !ok {
+                return match
+            }
+            return p.convertToString(value)
+        }
+        path := strings.Split(expr, ".")
 
         value, err := p.getValueFromPath(data, path)
         if err != nil {
@@ -282,15 +972,24 @@ func (p *Processor) startWorkers() {
         "workerCount", p.workers)
 
     for i := 0; i < p.workers; i++ {
+        queue := p.jobChan
+        if len(p.shards) > 0 {
+            queue = p.shards[i]
+        }
         p.wg.Add(1)
-        go p.worker()
+        go p.worker(queue)
     }
 }
 
-func (p *Processor) worker() {
+func (p *Processor) worker(queue chan *ProcessingMessage) {
     defer p.wg.Done()
 
-    for msg := range p.jobChan {
+    for msg := range queue {
+        // Messages left after a timed out drain are discarded
+        if atomic.LoadInt32(&p.discard) == 1 {
+            p.discardMessage(msg)
+            continue
+        }
         p.processMessage(msg)
     }
 }
@@ -298,18 +997,69 @@ func (p *Processor) worker() {
 func (p *Processor) processMessage(msg *ProcessingMessage) {
     defer p.msgPool.Put(msg)
 
-    if err := json.Unmarshal(msg.Payload, &msg.Values); err != nil {
-        atomic.AddUint64(&p.stats.Errors, 1)
-        p.metrics.IncMessagesTotal("error")
-        p.logger.Error("failed to unmarshal message",
-            "error", err,
-            "topic", msg.Topic)
-        return
+    p.safeMetricsUpdate(func(m *metrics.Metrics) {
+        m.SetMessageQueueDepth(p.name, float64(p.QueueDepth()))
+    })
+
+    err := p.evaluate(msg)
+    if p.handler != nil {
+        result := p.resultPool.Get()
+        result.Message = msg
+        result.Error = err
+        p.handler(result)
+        p.resultPool.Put(result)
     }
 
-    atomic.AddUint64(&p.stats.Processed, 1)
-    if len(msg.Actions) > 0 {
-        atomic.AddUint64(&p.stats.Matched, 1)
+    if msg.done != nil {
+        msg.done(true)
+    }
+}
+
+// discardMessage returns a message that was not processed to the pool
+func (p *Processor) discardMessage(msg *ProcessingMessage) {
+    if msg.done != nil {
+        msg.done(false)
+    }
+    p.msgPool.Put(msg)
+}
+
+// runTimers fires due timers every timerResolution until the processor stops
+func (p *Processor) runTimers() {
+    defer p.wg.Done()
+
+    ticker := time.NewTicker(timerResolution)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-p.timersDone:
+            return
+        case now := <-ticker.C:
+            p.fireTimers(now)
+        }
+    }
+}
+
+// fireTimers fires the timers due at now
+func (p *Processor) fireTimers(now time.Time) {
+    for _, t := range p.timers.Due(now) {
+        p.timedMu.RLock()
+        rule := p.timedRules[t.scope]
+        p.timedMu.RUnlock()
+        if rule == nil {
+            continue
+        }
+
+        switch t.kind {
+        case timerWindow:
+            if rule.Window != nil && rule.Window.Type == WindowTumbling {
+                p.closeDueWindow(rule, t.key, now)
+            }
+        case timerMissing:
+            if rule.Missing != nil {
+                p.fireMissing(rule, t.key, now)
+            }
+        }
     }
 }
 
@@ -318,18 +1068,152 @@ func (p *Processor) GetStats() ProcessorStats {
         Processed: atomic.LoadUint64(&p.stats.Processed),
         Matched:   atomic.LoadUint64(&p.stats.Matched),
         Errors:    atomic.LoadUint64(&p.stats.Errors),
+        Dropped:   atomic.LoadUint64(&p.stats.Dropped),
     }
 
     p.logger.Debug("processor stats retrieved",
         "processed", stats.Processed,
         "matched", stats.Matched,
-        "errors", stats.Errors)
+        "errors", stats.Errors,
+        "dropped", stats.Dropped)
 
     return stats
 }
 
+// ruleCounters returns the counters of a loaded rule, or nil for rules
+// without an ID
+func (p *Processor) ruleCounters(id string) *RuleStats {
+    if id == "" {
+        return nil
+    }
+
+    p.ruleStatsMu.RLock()
+    defer p.ruleStatsMu.RUnlock()
+    return p.ruleStats[id]
+}
+
+// Report returns the processor, index, pool and per-rule statistics
+func (p *Processor) Report() ProcessorReport {
+    report := ProcessorReport{
+        Stats: ProcessorStats{
+            Processed: atomic.LoadUint64(&p.stats.Processed),
+            Matched:   atomic.LoadUint64(&p.stats.Matched),
+            Errors:    atomic.LoadUint64(&p.stats.Errors),
+            Dropped:   atomic.LoadUint64(&p.stats.Dropped),
+        },
+        QueueDepth:  p.QueueDepth(),
+        Index:       p.index.Summary(),
+        MessagePool: p.msgPool.Stats(),
+        ResultPool:  p.resultPool.Stats(),
+        StateKeys:   p.state.Len(),
+        Timers:      p.timers.Len(),
+    }
+
+    p.ruleStatsMu.RLock()
+    report.Rules = make(map[string]RuleStats, len(p.ruleStats))
+    for id, counters := range p.ruleStats {
+        report.Rules[id] = RuleStats{
+            Evaluations:    atomic.LoadUint64(&counters.Evaluations),
+            Matches:        atomic.LoadUint64(&counters.Matches),
+            TemplateErrors: atomic.LoadUint64(&counters.TemplateErrors),
+            Suppressed:     atomic.LoadUint64(&counters.Suppressed),
+        }
+    }
+    p.ruleStatsMu.RUnlock()
+
+    return report
+}
+
+// QueueDepth returns the number of messages waiting for a worker
+func (p *Processor) QueueDepth() int {
+    depth := len(p.jobChan)
+    for _, shard := range p.shards {
+        depth += len(shard)
+    }
+    return depth
+}
+
+// Close stops accepting messages, waits for queued messages to be processed
+// and closes the state store
 func (p *Processor) Close() {
+    p.stop()
+    p.wg.Wait()
+    p.closeState()
+}
+
+// Drain stops accepting messages and waits until ctx is done for queued
+// messages to be processed. It returns how many queued messages were
+// processed and how many were abandoned; abandoned messages are discarded.
+// The state store is closed either way, without the abandoned messages, but
+// only once the workers have finished the messages in hand.
+func (p *Processor) Drain(ctx context.Context) (drained, abandoned int) {
+    queued := p.stop()
+
+    done := make(chan struct{})
+    go func() {
+        p.wg.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        p.closeState()
+        return queued, 0
+    case <-ctx.Done():
+    }
+
+    atomic.StoreInt32(&p.discard, 1)
+    // Workers may still be evaluating; Close waits for them as well
+    go func() {
+        <-done
+        p.closeState()
+    }()
+    abandoned = p.QueueDepth()
+    if abandoned > queued {
+        abandoned = queued
+    }
+    p.logger.Info("processor drain timed out",
+        "drained", queued-abandoned,
+        "abandoned", abandoned)
+    return queued - abandoned, abandoned
+}
+
+// stop closes the queues so the workers exit once they are empty and returns
+// the number of messages still queued
+func (p *Processor) stop() int {
+    p.closingOnce.Do(func() {
+        close(p.closing)
+    })
+
+    p.closeMu.Lock()
+    defer p.closeMu.Unlock()
+
+    if p.closed {
+        return 0
+    }
     p.logger.Info("shutting down processor")
+    p.closed = true
+    queued := p.QueueDepth()
     close(p.jobChan)
-    p.wg.Wait()
+    for _, shard := range p.shards {
+        close(shard)
+    }
+    close(p.timersDone)
+    return queued
+}
+
+// closeState closes the state store once
+func (p *Processor) closeState() {
+    p.stateOnce.Do(func() {
+        if err := p.state.Close(); err != nil {
+            p.logger.Error("failed to close state store", "error", err)
+        }
+    })
+}
+
+// safeMetricsUpdate safely updates metrics if they are enabled
+func (p *Processor) safeMetricsUpdate(fn func(*metrics.Metrics)) {
+    if p.metrics != nil {
+        fn(p.metrics)
+    }
 }
@@ -1,17 +1,23 @@
 package rule
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"mqtt-mux-router/config"
 	"mqtt-mux-router/internal/logger"
 	"mqtt-mux-router/internal/metrics"
+	"mqtt-mux-router/internal/tracing"
 )
 
 // Using mocks from mocks_test.go
@@ -60,6 +66,27 @@ func newTestSetup(t *testing.T) *testSetup {
 	}
 }
 
+// newReadingSetup loads a single rule on sensors/temperature and returns a
+// function that processes a device's temperature reading at an offset from a
+// fixed start time, returning the rendered actions
+func newReadingSetup(t *testing.T, rule Rule) (*testSetup, func(offset time.Duration, device string, temperature float64) []*Action) {
+	t.Helper()
+
+	setup := newTestSetup(t)
+	t.Cleanup(setup.cleanup)
+	require.NoError(t, setup.processor.LoadRules([]Rule{rule}))
+
+	start := time.Now()
+	process := func(offset time.Duration, device string, temperature float64) []*Action {
+		setup.processor.now = func() time.Time { return start.Add(offset) }
+		payload := fmt.Sprintf(`{"deviceId": %q, "temperature": %v}`, device, temperature)
+		actions, err := setup.processor.Process("sensors/temperature", []byte(payload))
+		require.NoError(t, err)
+		return actions
+	}
+	return setup, process
+}
+
 // getTestRules returns a set of test rules
 func getTestRules() []Rule {
 	return []Rule{
@@ -415,6 +442,133 @@ func TestProcessTemplate(t *testing.T) {
 	}
 }
 
+func TestProcess_HTTPAction(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	spec := &HTTPAction{
+		URL:     "https://hooks.example.com/devices/${deviceId}",
+		Headers: map[string]string{"X-Device": "${deviceId}", "Authorization": "Bearer token"},
+		Timeout: "5s",
+	}
+	require.NoError(t, setup.processor.LoadRules([]Rule{{
+		Topic:  "sensors/temperature",
+		Action: &Action{Payload: `{"temp":${temperature}}`, HTTP: spec},
+	}}))
+
+	actions, err := setup.processor.Process("sensors/temperature", []byte(`{"deviceId": "dev-1", "temperature": 30}`))
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+
+	action := actions[0]
+	assert.Equal(t, ActionHTTP, action.Kind())
+	assert.Equal(t, `{"temp":30}`, action.Payload)
+	assert.Equal(t, "https://hooks.example.com/devices/dev-1", action.HTTP.URL)
+	assert.Equal(t, map[string]string{"X-Device": "dev-1", "Authorization": "Bearer token"}, action.HTTP.Headers)
+	assert.Equal(t, "5s", action.HTTP.Timeout)
+
+	// The rule's templates are left untouched
+	assert.Equal(t, "https://hooks.example.com/devices/${deviceId}", spec.URL)
+	assert.Equal(t, "${deviceId}", spec.Headers["X-Device"])
+}
+
+func TestProcess_KafkaAction(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	spec := &KafkaAction{
+		Key:     "${deviceId}",
+		Headers: map[string]string{"device": "${deviceId}", "source": "router"},
+	}
+	require.NoError(t, setup.processor.LoadRules([]Rule{{
+		Topic:  "sensors/temperature",
+		Action: &Action{Topic: "alerts/${deviceId}", Payload: `{"temp":${temperature}}`, Kafka: spec},
+	}}))
+
+	actions, err := setup.processor.Process("sensors/temperature", []byte(`{"deviceId": "dev-1", "temperature": 30}`))
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+
+	action := actions[0]
+	assert.Equal(t, ActionPublish, action.Kind())
+	assert.Equal(t, "alerts/dev-1", action.Topic)
+	assert.Equal(t, "dev-1", action.Kafka.Key)
+	assert.Equal(t, map[string]string{"device": "dev-1", "source": "router"}, action.Kafka.Headers)
+	assert.Equal(t, "sensors/temperature", action.SourceTopic)
+
+	// The rule's templates are left untouched
+	assert.Equal(t, "${deviceId}", spec.Key)
+	assert.Equal(t, "${deviceId}", spec.Headers["device"])
+}
+
+func TestSubmitWith_SourceTopic(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	require.NoError(t, setup.processor.LoadRules([]Rule{{
+		Topic:  "sensors/+/temp",
+		Action: &Action{Topic: "alerts/${deviceId}", Payload: `{"temp":${temperature}}`, Kafka: &KafkaAction{}},
+	}}))
+
+	results := make(chan string, 2)
+	setup.processor.SetResultHandler(func(result *ProcessingResult) {
+		require.NoError(t, result.Error)
+		require.Len(t, result.Message.Actions, 1)
+		results <- result.Message.Actions[0].SourceTopic
+	})
+
+	// Messages of a wildcard subscription are matched by the rule's topic
+	// and carry the topic they arrived on
+	payload := []byte(`{"deviceId": "dev-1", "temperature": 30}`)
+	require.NoError(t, setup.processor.SubmitWith(context.Background(), "sensors/+/temp", payload, SubmitOptions{Source: "sensors/dev-1/temp"}))
+	require.NoError(t, setup.processor.Submit("sensors/+/temp", payload))
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case topic := <-results:
+			got = append(got, topic)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for worker results")
+		}
+	}
+	assert.ElementsMatch(t, []string{"sensors/dev-1/temp", "sensors/+/temp"}, got)
+}
+
+func TestProcess_FileAndLogActions(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	require.NoError(t, setup.processor.LoadRules([]Rule{
+		{
+			Topic:  "sensors/temperature",
+			Action: &Action{Topic: "audit/${deviceId}", Payload: `{"temp":${temperature}}`, File: &FileAction{Path: "audit.jsonl"}},
+		},
+		{
+			Topic:  "sensors/temperature",
+			Action: &Action{Payload: `{"temp":${temperature}}`, Log: &LogAction{Level: "debug", Message: "reading from ${deviceId}"}},
+		},
+	}))
+
+	actions, err := setup.processor.Process("sensors/temperature", []byte(`{"deviceId": "dev-1", "temperature": 30}`))
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+
+	for _, action := range actions {
+		switch action.Kind() {
+		case ActionFile:
+			assert.Equal(t, "audit/dev-1", action.Topic)
+			assert.Equal(t, "audit.jsonl", action.File.Path)
+		case ActionLog:
+			assert.Equal(t, "debug", action.Log.Level)
+			assert.Equal(t, "reading from dev-1", action.Log.Message)
+		default:
+			t.Fatalf("unexpected action kind %s", action.Kind())
+		}
+		assert.Equal(t, `{"temp":30}`, action.Payload)
+	}
+}
+
 func TestGetValueFromPath(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -678,3 +832,527 @@ func TestProcessMessagePool(t *testing.T) {
 	assert.Empty(t, msg2.Values, "pooled message should have empty values")
 	assert.Empty(t, msg2.Rules, "pooled message should have empty rules")
 }
+
+// newBlockingProcessor returns a single worker processor whose result handler
+// blocks until release is closed, with the first message already in the worker
+func newBlockingProcessor(t *testing.T, policy string) (*Processor, chan *Action, chan struct{}) {
+	t.Helper()
+
+	setup := newTestSetup(t)
+	setup.cleanup()
+
+	proc := NewProcessor(ProcessorConfig{
+		Workers:        1,
+		QueueSize:      2,
+		OverflowPolicy: policy,
+	}, setup.logger, setup.metrics)
+	require.NoError(t, proc.LoadRules(getTestRules()))
+
+	results := make(chan *Action, 10)
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	proc.SetResultHandler(func(result *ProcessingResult) {
+		entered <- struct{}{}
+		<-release
+		for _, action := range result.Message.Actions {
+			results <- action
+		}
+	})
+
+	require.NoError(t, proc.Submit("sensors/temperature", []byte(`{"temperature": 31}`)))
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not pick up first message")
+	}
+
+	return proc, results, release
+}
+
+func TestSubmit(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+	require.NoError(t, setup.processor.LoadRules(getTestRules()))
+
+	results := make(chan string, 1)
+	setup.processor.SetResultHandler(func(result *ProcessingResult) {
+		require.NoError(t, result.Error)
+		require.Len(t, result.Message.Actions, 1)
+		results <- result.Message.Actions[0].Payload
+	})
+
+	payload := []byte(`{"temperature": 30.0}`)
+	require.NoError(t, setup.processor.Submit("sensors/temperature", payload))
+
+	// The processor must have copied the payload
+	copy(payload, []byte(`{"temperature": 10.0}`))
+
+	select {
+	case got := <-results:
+		assert.Equal(t, `{"alert":true,"temp":30}`, got)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for worker result")
+	}
+}
+
+func TestSubmitWith_Done(t *testing.T) {
+	proc, _, release := newBlockingProcessor(t, OverflowDropOldest)
+
+	outcomes := make(chan string, 3)
+	submit := func(name string) {
+		require.NoError(t, proc.SubmitWith(context.Background(), "sensors/temperature", []byte(`{"temperature": 32}`), SubmitOptions{
+			Done: func(handled bool) {
+				outcomes <- fmt.Sprintf("%s:%v", name, handled)
+			},
+		}))
+	}
+
+	// The queue holds two messages, so the third drops the oldest
+	submit("a")
+	submit("b")
+	submit("c")
+	assert.Equal(t, "a:false", <-outcomes)
+
+	close(release)
+	proc.Close()
+	close(outcomes)
+
+	var handled []string
+	for outcome := range outcomes {
+		handled = append(handled, outcome)
+	}
+	assert.Equal(t, []string{"b:true", "c:true"}, handled)
+}
+
+func TestSubmit_OverflowPolicies(t *testing.T) {
+	payloadFor := func(temp int) []byte {
+		return []byte(fmt.Sprintf(`{"temperature": %d}`, temp))
+	}
+
+	t.Run("drop newest", func(t *testing.T) {
+		proc, results, release := newBlockingProcessor(t, OverflowDropNewest)
+
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(32)))
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(33)))
+		assert.ErrorIs(t, proc.Submit("sensors/temperature", payloadFor(34)), ErrQueueFull)
+
+		close(release)
+		proc.Close()
+		close(results)
+
+		var temps []string
+		for action := range results {
+			temps = append(temps, action.Payload)
+		}
+		assert.Equal(t, []string{
+			`{"alert":true,"temp":31}`,
+			`{"alert":true,"temp":32}`,
+			`{"alert":true,"temp":33}`,
+		}, temps)
+		assert.Equal(t, uint64(1), proc.GetStats().Dropped)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		proc, results, release := newBlockingProcessor(t, OverflowDropOldest)
+
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(32)))
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(33)))
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(34)))
+
+		close(release)
+		proc.Close()
+		close(results)
+
+		var temps []string
+		for action := range results {
+			temps = append(temps, action.Payload)
+		}
+		assert.Equal(t, []string{
+			`{"alert":true,"temp":31}`,
+			`{"alert":true,"temp":33}`,
+			`{"alert":true,"temp":34}`,
+		}, temps)
+		assert.Equal(t, uint64(1), proc.GetStats().Dropped)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		proc, results, release := newBlockingProcessor(t, OverflowBlock)
+
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(32)))
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(33)))
+
+		submitted := make(chan error, 1)
+		go func() {
+			submitted <- proc.Submit("sensors/temperature", payloadFor(34))
+		}()
+
+		select {
+		case <-submitted:
+			t.Fatal("submit should block while the queue is full")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(t, <-submitted)
+		proc.Close()
+		close(results)
+
+		count := 0
+		for range results {
+			count++
+		}
+		assert.Equal(t, 4, count)
+		assert.Equal(t, uint64(0), proc.GetStats().Dropped)
+	})
+
+	t.Run("block gives up on close", func(t *testing.T) {
+		proc, results, release := newBlockingProcessor(t, OverflowBlock)
+
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(32)))
+		require.NoError(t, proc.Submit("sensors/temperature", payloadFor(33)))
+
+		submitted := make(chan error, 1)
+		go func() {
+			submitted <- proc.Submit("sensors/temperature", payloadFor(34))
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		closed := make(chan struct{})
+		go func() {
+			proc.Close()
+			close(closed)
+		}()
+
+		// The waiting submit must not hold up Close
+		select {
+		case err := <-submitted:
+			assert.ErrorIs(t, err, ErrProcessorClosed)
+		case <-time.After(time.Second):
+			t.Fatal("submit kept waiting after close")
+		}
+
+		close(release)
+		<-closed
+		close(results)
+
+		count := 0
+		for range results {
+			count++
+		}
+		assert.Equal(t, 3, count)
+	})
+}
+
+func TestSubmit_AfterClose(t *testing.T) {
+	setup := newTestSetup(t)
+	setup.cleanup()
+
+	err := setup.processor.Submit("sensors/temperature", []byte(`{}`))
+	assert.ErrorIs(t, err, ErrProcessorClosed)
+}
+
+func TestProcessorDrain(t *testing.T) {
+	t.Run("processes queued messages", func(t *testing.T) {
+		proc, results, release := newBlockingProcessor(t, OverflowBlock)
+		require.NoError(t, proc.Submit("sensors/temperature", []byte(`{"temperature": 32}`)))
+		require.NoError(t, proc.Submit("sensors/temperature", []byte(`{"temperature": 33}`)))
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained, abandoned := proc.Drain(ctx)
+		assert.Equal(t, 2, drained)
+		assert.Zero(t, abandoned)
+		assert.Len(t, results, 3)
+
+		err := proc.Submit("sensors/temperature", []byte(`{}`))
+		assert.ErrorIs(t, err, ErrProcessorClosed)
+	})
+
+	t.Run("abandons queued messages at the deadline", func(t *testing.T) {
+		proc, results, release := newBlockingProcessor(t, OverflowBlock)
+		require.NoError(t, proc.Submit("sensors/temperature", []byte(`{"temperature": 32}`)))
+		require.NoError(t, proc.Submit("sensors/temperature", []byte(`{"temperature": 33}`)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		drained, abandoned := proc.Drain(ctx)
+		assert.Zero(t, drained)
+		assert.Equal(t, 2, abandoned)
+
+		// Only the message already in the worker completes
+		close(release)
+		proc.Close()
+		assert.Len(t, results, 1)
+	})
+}
+
+// closeRecordingStore records when the store it wraps is closed
+type closeRecordingStore struct {
+	StateStore
+	closed chan struct{}
+}
+
+func (s *closeRecordingStore) Close() error {
+	close(s.closed)
+	return s.StateStore.Close()
+}
+
+func TestProcessorDrain_ClosesStateAfterWorkers(t *testing.T) {
+	setup := newTestSetup(t)
+	setup.cleanup()
+
+	store := &closeRecordingStore{StateStore: NewMemoryStateStore(), closed: make(chan struct{})}
+	proc := NewProcessor(ProcessorConfig{Workers: 1, QueueSize: 2, State: store}, setup.logger, nil)
+	require.NoError(t, proc.LoadRules(getTestRules()))
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	proc.SetResultHandler(func(result *ProcessingResult) {
+		close(entered)
+		<-release
+	})
+	require.NoError(t, proc.Submit("sensors/temperature", []byte(`{"temperature": 31}`)))
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	proc.Drain(ctx)
+
+	select {
+	case <-store.closed:
+		t.Fatal("state store closed while a worker was busy")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-store.closed:
+	case <-time.After(time.Second):
+		t.Fatal("state store not closed once the worker finished")
+	}
+}
+
+func TestProcessorReport(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	rules := getTestRules()
+	rules[0].ID = "temperature"
+	rules[1].ID = "humidity"
+	rules = append(rules, Rule{
+		ID:     "unconditional",
+		Topic:  "sensors/temperature",
+		Action: &Action{Topic: "readings/temperature", Payload: "${temperature}"},
+	})
+	require.NoError(t, setup.processor.LoadRules(rules))
+
+	for _, payload := range []string{`{"temperature": 31}`, `{"temperature": 20}`, `{"temperature": 40}`} {
+		_, err := setup.processor.Process("sensors/temperature", []byte(payload))
+		require.NoError(t, err)
+	}
+	_, err := setup.processor.Process("sensors/humidity", []byte(`{"humidity": 50, "temperature": 20}`))
+	require.NoError(t, err)
+	_, err = setup.processor.Process("sensors/unknown", []byte(`{}`))
+	require.NoError(t, err)
+
+	report := setup.processor.Report()
+	assert.Equal(t, RuleStats{Evaluations: 3, Matches: 2}, report.Rules["temperature"])
+	assert.Equal(t, RuleStats{Evaluations: 1}, report.Rules["humidity"])
+	assert.Equal(t, RuleStats{Evaluations: 3, Matches: 3}, report.Rules["unconditional"])
+	assert.Equal(t, uint64(4), report.Stats.Processed)
+
+	assert.Equal(t, 2, report.Index.Topics)
+	assert.Equal(t, 3, report.Index.Rules)
+	assert.Equal(t, uint64(5), report.Index.Lookups)
+	assert.Equal(t, uint64(4), report.Index.Matches)
+
+	assert.Equal(t, uint64(5), report.MessagePool.Gets)
+	assert.InDelta(t, float64(report.MessagePool.Gets-report.MessagePool.Misses)/5, report.MessagePool.HitRate, 0.001)
+
+	t.Run("counters survive reloads", func(t *testing.T) {
+		require.NoError(t, setup.processor.LoadRules(rules[:1]))
+		report := setup.processor.Report()
+		assert.Equal(t, RuleStats{Evaluations: 3, Matches: 2}, report.Rules["temperature"])
+		assert.NotContains(t, report.Rules, "humidity")
+	})
+}
+
+func TestSubmit_Ordering(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		field    string
+		topicFor func(device int) string
+		payload  func(device, seq int) string
+	}{
+		{
+			name:     "topic",
+			key:      OrderingTopic,
+			topicFor: func(device int) string { return fmt.Sprintf("devices/%d", device) },
+			payload:  func(device, seq int) string { return fmt.Sprintf(`{"seq": %d}`, seq) },
+		},
+		{
+			name:     "payload field",
+			key:      OrderingField,
+			field:    "device.id",
+			topicFor: func(device int) string { return "devices/all" },
+			payload: func(device, seq int) string {
+				return fmt.Sprintf(`{"device": {"id": "d%d"}, "seq": %d}`, device, seq)
+			},
+		},
+	}
+
+	const devices, perDevice = 8, 50
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup := newTestSetup(t)
+			setup.cleanup()
+
+			proc := NewProcessor(ProcessorConfig{
+				Workers:       4,
+				QueueSize:     400,
+				OrderingKey:   tt.key,
+				OrderingField: tt.field,
+			}, setup.logger, setup.metrics)
+			assert.Len(t, proc.shards, 4)
+
+			var rules []Rule
+			topics := make(map[string]bool)
+			for d := 0; d < devices; d++ {
+				topic := tt.topicFor(d)
+				if topics[topic] {
+					continue
+				}
+				topics[topic] = true
+				rules = append(rules, Rule{
+					Topic:  topic,
+					Action: &Action{Topic: "out", Payload: `${device.id}|${seq}`},
+				})
+			}
+			require.NoError(t, proc.LoadRules(rules))
+
+			var mu sync.Mutex
+			seen := make(map[string][]string)
+			proc.SetResultHandler(func(result *ProcessingResult) {
+				require.NoError(t, result.Error)
+				// Key results by topic and device so both cases share the check
+				for _, action := range result.Message.Actions {
+					parts := strings.SplitN(action.Payload, "|", 2)
+					key := result.Message.Topic + "/" + parts[0]
+					mu.Lock()
+					seen[key] = append(seen[key], parts[1])
+					mu.Unlock()
+				}
+			})
+
+			for seq := 0; seq < perDevice; seq++ {
+				for d := 0; d < devices; d++ {
+					require.NoError(t, proc.Submit(tt.topicFor(d), []byte(tt.payload(d, seq))))
+				}
+			}
+			proc.Close()
+
+			total := 0
+			for key, seqs := range seen {
+				for i, seq := range seqs {
+					if !assert.Equal(t, fmt.Sprint(i), seq, "out of order for %s", key) {
+						break
+					}
+				}
+				total += len(seqs)
+			}
+			assert.Equal(t, devices*perDevice, total)
+		})
+	}
+}
+
+func TestProcess_FailureHandler(t *testing.T) {
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	rules := getTestRules()
+	rules[0].ID = "temperature-alert"
+	require.NoError(t, setup.processor.LoadRules(rules))
+
+	var failures []*Failure
+	setup.processor.SetFailureHandler(func(failure *Failure) {
+		failures = append(failures, failure)
+	})
+
+	payload := []byte(`not json`)
+	_, err := setup.processor.Process("sensors/temperature", payload)
+	require.Error(t, err)
+
+	require.Len(t, failures, 1)
+	assert.Equal(t, "sensors/temperature", failures[0].Topic)
+	assert.Equal(t, StageDecode, failures[0].Stage)
+	assert.Empty(t, failures[0].RuleID)
+	assert.ErrorIs(t, failures[0].Err, err)
+	assert.False(t, failures[0].Time.IsZero())
+
+	// The failure keeps its own copy of the payload
+	payload[0] = 'X'
+	assert.Equal(t, []byte(`not json`), failures[0].Payload)
+
+	// Matched actions carry the ID of the rule that rendered them and the
+	// receive time of the triggering message
+	before := time.Now()
+	actions, err := setup.processor.Process("sensors/temperature", []byte(`{"temperature": 30}`))
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "temperature-alert", actions[0].RuleID)
+	assert.False(t, actions[0].ReceivedAt.Before(before))
+	assert.Len(t, failures, 1)
+}
+
+func TestEvaluate_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	// The router's tracer binds to the first provider installed, so this is
+	// the only test in the package that installs one
+	otel.SetTracerProvider(provider)
+
+	setup := newTestSetup(t)
+	defer setup.cleanup()
+
+	rules := getTestRules()
+	rules[0].ID = "temperature-alert"
+	require.NoError(t, setup.processor.LoadRules(rules))
+
+	_, receive := provider.Tracer("test").Start(context.Background(), "receive")
+
+	msg := setup.processor.msgPool.Get()
+	defer setup.processor.msgPool.Put(msg)
+	msg.Topic = "sensors/temperature"
+	msg.Payload = []byte(`{"temperature": 30.0}`)
+	msg.SpanContext = receive.SpanContext()
+
+	require.NoError(t, setup.processor.evaluate(msg))
+	receive.End()
+	require.Len(t, msg.Actions, 1)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		assert.Equal(t, receive.SpanContext().TraceID(), span.SpanContext().TraceID(),
+			"span %s should belong to the message trace", span.Name())
+	}
+	require.Contains(t, spans, tracing.SpanDecode)
+	require.Contains(t, spans, tracing.SpanEvaluate)
+	require.Contains(t, spans, tracing.SpanRender)
+
+	evaluate := spans[tracing.SpanEvaluate]
+	assert.Equal(t, receive.SpanContext().SpanID(), evaluate.Parent().SpanID())
+	assert.Equal(t, evaluate.SpanContext().SpanID(), spans[tracing.SpanRender].Parent().SpanID())
+	assert.Contains(t, evaluate.Attributes(), tracing.AttrRuleID.String("temperature-alert"))
+	assert.Contains(t, evaluate.Attributes(), tracing.AttrRuleMatched.Bool(true))
+
+	// The action's publish span will be a child of the rule's evaluate span
+	assert.Equal(t, evaluate.SpanContext(), msg.Actions[0].SpanContext)
+}
@@ -0,0 +1,236 @@
+//file: internal/rule/state.go
+
+package rule
+
+import (
+    "encoding/gob"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// stateSweepInterval is how often expired state is swept on write
+const stateSweepInterval = time.Minute
+
+// StateStore holds per-key rule state. Every entry has a TTL so the store
+// stays bounded by the keys that were active recently; expired entries are
+// hidden on read and swept periodically on write. Values are opaque bytes so
+// that stateful rules own their encoding.
+//
+// A store belongs to a single processor, which closes it when it stops. It
+// is not shared between router instances. Storage errors are logged by the
+// store rather than returned, since rule state is best effort.
+type StateStore interface {
+    // Get returns the value of a key that has not expired at now
+    Get(key string, now time.Time) ([]byte, bool)
+    // Set stores a value that expires ttl after now
+    Set(key string, value []byte, ttl time.Duration, now time.Time)
+    // Delete removes a key
+    Delete(key string)
+    // Update atomically replaces the value of a key. fn receives the current
+    // value, if any, and returns the new value and its TTL; a nil value
+    // deletes the key. fn must not call the store.
+    Update(key string, now time.Time, fn func(value []byte, ok bool) ([]byte, time.Duration))
+    // Range calls fn for every key with the prefix that has not expired at
+    // now, until fn returns false. fn must not call the store.
+    Range(prefix string, now time.Time, fn func(key string, value []byte) bool)
+    // Len returns the number of entries, including expired ones not yet swept
+    Len() int
+    // Close releases the store, persisting it if it is persistent
+    Close() error
+}
+
+// stateEntry is a state value and the time it expires
+type stateEntry struct {
+    value   []byte
+    expires time.Time
+}
+
+// MemoryStateStore keeps rule state in memory. With a snapshot path, state
+// is restored from the snapshot when the store is opened and saved to it
+// when the store is closed, so it survives a graceful restart but not a
+// crash.
+type MemoryStateStore struct {
+    entries   map[string]stateEntry
+    nextSweep time.Time
+    path      string
+    mu        sync.Mutex
+}
+
+// NewMemoryStateStore returns an empty in-memory store without a snapshot
+func NewMemoryStateStore() *MemoryStateStore {
+    return &MemoryStateStore{
+        entries: make(map[string]stateEntry),
+    }
+}
+
+// OpenMemoryStateStore returns an in-memory store restored from the snapshot
+// at path, which is written back when the store is closed. A missing
+// snapshot starts an empty store.
+func OpenMemoryStateStore(path string) (*MemoryStateStore, error) {
+    s := NewMemoryStateStore()
+    s.path = path
+
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return s, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to open state snapshot: %w", err)
+    }
+    defer file.Close()
+
+    var snapshot []stateSnapshotEntry
+    if err := gob.NewDecoder(file).Decode(&snapshot); err != nil {
+        return nil, fmt.Errorf("failed to read state snapshot %s: %w", path, err)
+    }
+
+    now := time.Now()
+    for _, entry := range snapshot {
+        if now.Before(entry.Expires) {
+            s.entries[entry.Key] = stateEntry{value: entry.Value, expires: entry.Expires}
+        }
+    }
+    return s, nil
+}
+
+// stateSnapshotEntry is the encoding of an entry in a state snapshot
+type stateSnapshotEntry struct {
+    Key     string
+    Value   []byte
+    Expires time.Time
+}
+
+// Get returns the value of a key that has not expired at now
+func (s *MemoryStateStore) Get(key string, now time.Time) ([]byte, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    entry, ok := s.entries[key]
+    if !ok || !now.Before(entry.expires) {
+        return nil, false
+    }
+    return entry.value, true
+}
+
+// Set stores a value that expires ttl after now
+func (s *MemoryStateStore) Set(key string, value []byte, ttl time.Duration, now time.Time) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.entries[key] = stateEntry{value: value, expires: now.Add(ttl)}
+    s.sweep(now)
+}
+
+// Delete removes a key
+func (s *MemoryStateStore) Delete(key string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.entries, key)
+}
+
+// Update atomically replaces the value of a key. fn receives the current
+// value, if any, and returns the new value and its TTL; a nil value deletes
+// the key.
+func (s *MemoryStateStore) Update(key string, now time.Time, fn func(value []byte, ok bool) ([]byte, time.Duration)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    entry, ok := s.entries[key]
+    if ok && !now.Before(entry.expires) {
+        entry, ok = stateEntry{}, false
+    }
+
+    value, ttl := fn(entry.value, ok)
+    if value == nil {
+        delete(s.entries, key)
+        return
+    }
+    s.entries[key] = stateEntry{value: value, expires: now.Add(ttl)}
+    s.sweep(now)
+}
+
+// Range calls fn for every key with the prefix that has not expired at now,
+// in no particular order, until fn returns false
+func (s *MemoryStateStore) Range(prefix string, now time.Time, fn func(key string, value []byte) bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for key, entry := range s.entries {
+        if !strings.HasPrefix(key, prefix) || !now.Before(entry.expires) {
+            continue
+        }
+        if !fn(key, entry.value) {
+            return
+        }
+    }
+}
+
+// Len returns the number of entries, including expired ones not yet swept
+func (s *MemoryStateStore) Len() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return len(s.entries)
+}
+
+// Close saves the snapshot, if the store has one. The snapshot is written
+// to a temporary file first so a failed write keeps the previous snapshot.
+func (s *MemoryStateStore) Close() error {
+    if s.path == "" {
+        return nil
+    }
+
+    s.mu.Lock()
+    now := time.Now()
+    snapshot := make([]stateSnapshotEntry, 0, len(s.entries))
+    for key, entry := range s.entries {
+        if now.Before(entry.expires) {
+            snapshot = append(snapshot, stateSnapshotEntry{Key: key, Value: entry.value, Expires: entry.expires})
+        }
+    }
+    s.mu.Unlock()
+
+    if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+        return fmt.Errorf("failed to create state directory: %w", err)
+    }
+    tmp := s.path + ".tmp"
+    file, err := os.Create(tmp)
+    if err != nil {
+        return fmt.Errorf("failed to create state snapshot: %w", err)
+    }
+    if err := gob.NewEncoder(file).Encode(snapshot); err != nil {
+        file.Close()
+        return fmt.Errorf("failed to write state snapshot: %w", err)
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return fmt.Errorf("failed to write state snapshot: %w", err)
+    }
+    if err := file.Close(); err != nil {
+        return fmt.Errorf("failed to write state snapshot: %w", err)
+    }
+    if err := os.Rename(tmp, s.path); err != nil {
+        return fmt.Errorf("failed to replace state snapshot: %w", err)
+    }
+    return nil
+}
+
+// sweep removes expired entries at most once per stateSweepInterval. The
+// caller must hold mu.
+func (s *MemoryStateStore) sweep(now time.Time) {
+    if now.Before(s.nextSweep) {
+        return
+    }
+    s.nextSweep = now.Add(stateSweepInterval)
+
+    for key, entry := range s.entries {
+        if !now.Before(entry.expires) {
+            delete(s.entries, key)
+        }
+    }
+}
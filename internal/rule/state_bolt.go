@@ -0,0 +1,213 @@
+//file: internal/rule/state_bolt.go
+
+package rule
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+    "mqtt-mux-router/internal/logger"
+)
+
+// stateBucket is the bolt bucket holding rule state
+var stateBucket = []byte("state")
+
+// BoltStateStore keeps rule state in an embedded bolt database, so it
+// survives restarts. Each value is stored with its expiry time.
+//
+// Writes are not synced to disk individually, which keeps a write per
+// message affordable: state survives a crash of the router but may lose the
+// latest writes if the host crashes. Close syncs the database.
+type BoltStateStore struct {
+    db     *bolt.DB
+    logger *logger.Logger
+
+    // nextSweep is only used inside write transactions, which bolt runs
+    // one at a time
+    nextSweep time.Time
+}
+
+// OpenBoltStateStore opens or creates the bolt database at path. A database
+// can only be opened by one process at a time.
+func OpenBoltStateStore(path string, log *logger.Logger) (*BoltStateStore, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, fmt.Errorf("failed to create state directory: %w", err)
+    }
+
+    db, err := bolt.Open(path, 0600, &bolt.Options{
+        Timeout:        time.Second,
+        NoSync:         true,
+        NoFreelistSync: true,
+        FreelistType:   bolt.FreelistMapType,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to open state database %s: %w", path, err)
+    }
+
+    err = db.Update(func(tx *bolt.Tx) error {
+        _, err := tx.CreateBucketIfNotExists(stateBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to create state bucket: %w", err)
+    }
+
+    return &BoltStateStore{db: db, logger: log}, nil
+}
+
+// Get returns the value of a key that has not expired at now
+func (s *BoltStateStore) Get(key string, now time.Time) ([]byte, bool) {
+    var value []byte
+    var found bool
+    err := s.db.View(func(tx *bolt.Tx) error {
+        if v, ok := decodeBoltEntry(tx.Bucket(stateBucket).Get([]byte(key)), now); ok {
+            // Bolt values are only valid during the transaction
+            value, found = append([]byte(nil), v...), true
+        }
+        return nil
+    })
+    if err != nil {
+        s.logger.Error("failed to read state", "error", err)
+        return nil, false
+    }
+    return value, found
+}
+
+// Set stores a value that expires ttl after now
+func (s *BoltStateStore) Set(key string, value []byte, ttl time.Duration, now time.Time) {
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(stateBucket)
+        if err := bucket.Put([]byte(key), appendBoltEntry(value, now.Add(ttl))); err != nil {
+            return err
+        }
+        return s.sweep(bucket, now)
+    })
+    if err != nil {
+        s.logger.Error("failed to write state", "error", err)
+    }
+}
+
+// Delete removes a key
+func (s *BoltStateStore) Delete(key string) {
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(stateBucket).Delete([]byte(key))
+    })
+    if err != nil {
+        s.logger.Error("failed to delete state", "error", err)
+    }
+}
+
+// Update atomically replaces the value of a key. fn receives the current
+// value, if any, and returns the new value and its TTL; a nil value deletes
+// the key.
+func (s *BoltStateStore) Update(key string, now time.Time, fn func(value []byte, ok bool) ([]byte, time.Duration)) {
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(stateBucket)
+        current, ok := decodeBoltEntry(bucket.Get([]byte(key)), now)
+        if ok {
+            // fn may keep or return the value, which bolt reuses
+            current = append([]byte(nil), current...)
+        }
+
+        value, ttl := fn(current, ok)
+        if value == nil {
+            return bucket.Delete([]byte(key))
+        }
+        if err := bucket.Put([]byte(key), appendBoltEntry(value, now.Add(ttl))); err != nil {
+            return err
+        }
+        return s.sweep(bucket, now)
+    })
+    if err != nil {
+        s.logger.Error("failed to update state", "error", err)
+    }
+}
+
+// Range calls fn for every key with the prefix that has not expired at now,
+// in key order, until fn returns false
+func (s *BoltStateStore) Range(prefix string, now time.Time, fn func(key string, value []byte) bool) {
+    err := s.db.View(func(tx *bolt.Tx) error {
+        c := tx.Bucket(stateBucket).Cursor()
+        for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+            value, ok := decodeBoltEntry(v, now)
+            if !ok {
+                continue
+            }
+            if !fn(string(k), append([]byte(nil), value...)) {
+                return nil
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        s.logger.Error("failed to read state", "error", err)
+    }
+}
+
+// Len returns the number of entries, including expired ones not yet swept
+func (s *BoltStateStore) Len() int {
+    var n int
+    err := s.db.View(func(tx *bolt.Tx) error {
+        n = tx.Bucket(stateBucket).Stats().KeyN
+        return nil
+    })
+    if err != nil {
+        s.logger.Error("failed to read state", "error", err)
+    }
+    return n
+}
+
+// Close syncs and closes the database
+func (s *BoltStateStore) Close() error {
+    if err := s.db.Sync(); err != nil {
+        s.db.Close()
+        return fmt.Errorf("failed to sync state database: %w", err)
+    }
+    return s.db.Close()
+}
+
+// sweep removes expired entries at most once per stateSweepInterval. It
+// must be called inside a write transaction.
+func (s *BoltStateStore) sweep(bucket *bolt.Bucket, now time.Time) error {
+    if now.Before(s.nextSweep) {
+        return nil
+    }
+    s.nextSweep = now.Add(stateSweepInterval)
+
+    // Deleting while iterating can skip keys, so collect them first
+    var expired [][]byte
+    c := bucket.Cursor()
+    for k, v := c.First(); k != nil; k, v = c.Next() {
+        if _, ok := decodeBoltEntry(v, now); !ok {
+            expired = append(expired, append([]byte(nil), k...))
+        }
+    }
+    for _, k := range expired {
+        if err := bucket.Delete(k); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// appendBoltEntry encodes a value after its expiry time
+func appendBoltEntry(value []byte, expires time.Time) []byte {
+    return append(appendTime(make([]byte, 0, 8+len(value)), expires), value...)
+}
+
+// decodeBoltEntry returns the value of an encoded entry that has not
+// expired at now
+func decodeBoltEntry(entry []byte, now time.Time) ([]byte, bool) {
+    if len(entry) < 8 {
+        return nil, false
+    }
+    if !now.Before(decodeTime(entry)) {
+        return nil, false
+    }
+    return entry[8:], true
+}
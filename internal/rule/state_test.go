@@ -0,0 +1,191 @@
+package rule
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mqtt-mux-router/config"
+	"mqtt-mux-router/internal/logger"
+)
+
+// stateStores runs a test against every state store backend
+func stateStores(t *testing.T, test func(t *testing.T, store StateStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStateStore())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		store := openTestBoltStore(t, filepath.Join(t.TempDir(), "state.db"))
+		t.Cleanup(func() { store.Close() })
+		test(t, store)
+	})
+}
+
+func openTestBoltStore(t *testing.T, path string) *BoltStateStore {
+	t.Helper()
+
+	log, err := logger.NewLogger(&config.LogConfig{Level: "debug", OutputPath: "stdout", Encoding: "console"})
+	require.NoError(t, err)
+	store, err := OpenBoltStateStore(path, log)
+	require.NoError(t, err)
+	return store
+}
+
+func TestStateStore_Expiry(t *testing.T) {
+	stateStores(t, func(t *testing.T, store StateStore) {
+		now := time.Now()
+
+		store.Set("device-1", []byte("a"), time.Minute, now)
+
+		value, ok := store.Get("device-1", now.Add(30*time.Second))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("a"), value)
+
+		_, ok = store.Get("device-1", now.Add(time.Minute))
+		assert.False(t, ok, "entries expire after their TTL")
+
+		store.Set("device-2", []byte("b"), time.Minute, now)
+		store.Delete("device-2")
+		_, ok = store.Get("device-2", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestStateStore_Update(t *testing.T) {
+	stateStores(t, func(t *testing.T, store StateStore) {
+		now := time.Now()
+
+		increment := func(value []byte, ok bool) ([]byte, time.Duration) {
+			if !ok {
+				return []byte{1}, time.Minute
+			}
+			return []byte{value[0] + 1}, time.Minute
+		}
+
+		store.Update("counter", now, increment)
+		store.Update("counter", now, increment)
+		value, _ := store.Get("counter", now)
+		assert.Equal(t, []byte{2}, value)
+
+		// An expired value is not passed to the update
+		store.Update("counter", now.Add(2*time.Minute), increment)
+		value, _ = store.Get("counter", now.Add(2*time.Minute))
+		assert.Equal(t, []byte{1}, value)
+
+		// A nil value deletes the key
+		store.Update("counter", now, func([]byte, bool) ([]byte, time.Duration) {
+			return nil, 0
+		})
+		assert.Equal(t, 0, store.Len())
+	})
+}
+
+func TestStateStore_Sweep(t *testing.T) {
+	stateStores(t, func(t *testing.T, store StateStore) {
+		now := time.Now()
+
+		for _, key := range []string{"a", "b", "c"} {
+			store.Set(key, []byte(key), time.Second, now)
+		}
+		assert.Equal(t, 3, store.Len())
+
+		// Writes sweep expired entries once the sweep interval has passed
+		later := now.Add(stateSweepInterval)
+		store.Set("d", []byte("d"), time.Minute, later)
+		assert.Equal(t, 1, store.Len())
+	})
+}
+
+func TestStateStore_Range(t *testing.T) {
+	stateStores(t, func(t *testing.T, store StateStore) {
+		now := time.Now()
+
+		store.Set("window\x00rule\x00a", []byte("a"), time.Minute, now)
+		store.Set("window\x00rule\x00b", []byte("b"), time.Second, now)
+		store.Set("missing\x00rule\x00a", []byte("c"), time.Minute, now)
+
+		found := make(map[string]string)
+		store.Range("window\x00", now.Add(time.Second), func(key string, value []byte) bool {
+			found[key] = string(value)
+			return true
+		})
+		assert.Equal(t, map[string]string{"window\x00rule\x00a": "a"}, found,
+			"only unexpired keys with the prefix")
+
+		calls := 0
+		store.Range("", now, func(string, []byte) bool {
+			calls++
+			return false
+		})
+		assert.Equal(t, 1, calls, "returning false stops the range")
+	})
+}
+
+func TestMemoryStateStore_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "default.snapshot")
+	now := time.Now()
+
+	store, err := OpenMemoryStateStore(path)
+	require.NoError(t, err, "a missing snapshot starts an empty store")
+	store.Set("kept", []byte("a"), time.Hour, now)
+	store.Set("expired", []byte("b"), time.Hour, now.Add(-2*time.Hour))
+	require.NoError(t, store.Close())
+
+	restored, err := OpenMemoryStateStore(path)
+	require.NoError(t, err)
+	value, ok := restored.Get("kept", now)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), value)
+	assert.Equal(t, 1, restored.Len(), "expired entries are not restored")
+
+	// Stores without a snapshot path keep nothing
+	assert.NoError(t, NewMemoryStateStore().Close())
+}
+
+func TestBoltStateStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.db")
+	now := time.Now()
+
+	store := openTestBoltStore(t, path)
+	store.Set("kept", []byte("a"), time.Hour, now)
+	require.NoError(t, store.Close())
+
+	reopened := openTestBoltStore(t, path)
+	defer reopened.Close()
+	value, ok := reopened.Get("kept", now)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), value)
+}
+
+func TestProcessor_RestoresTimers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.db")
+	rule := Rule{
+		ID:         "readings-per-minute",
+		Topic:      "sensors/temperature",
+		Key:        "${deviceId}",
+		Window:     &Window{Type: WindowTumbling, Size: "1m"},
+		Conditions: singleCondition("window.count", "gt", 0),
+		Action:     &Action{Topic: "reports/${window.key}", Payload: `{"count":${window.count}}`},
+	}
+
+	before := newTimedSetup(t, rule)
+	before.processor.state = openTestBoltStore(t, path)
+	before.process(t, 10*time.Second, reading("dev-1", 20))
+	before.process(t, 20*time.Second, reading("dev-1", 21))
+	before.processor.Close()
+
+	// A restarted processor has no timers until its rules are loaded
+	after := newTimedSetup(t, rule)
+	after.processor.state = openTestBoltStore(t, path)
+	assert.Equal(t, 0, after.processor.timers.Len())
+	require.NoError(t, after.processor.LoadRules([]Rule{rule}))
+	assert.Equal(t, 1, after.processor.timers.Len())
+
+	actions := after.fireTimers(time.Minute)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "reports/dev-1", actions[0].Topic)
+	assert.JSONEq(t, `{"count":2}`, actions[0].Payload)
+}
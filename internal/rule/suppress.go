@@ -0,0 +1,193 @@
+//file: internal/rule/suppress.go
+
+package rule
+
+import (
+    "encoding/binary"
+    "fmt"
+    "math"
+    "time"
+)
+
+// Reasons a matched rule's action is suppressed
+const (
+    SuppressDebounce  = "debounce"
+    SuppressThrottle  = "throttle"
+    SuppressRateLimit = "rateLimit"
+)
+
+// debounceIdleFactor is how many debounce durations a key's debounce state
+// survives without a message before the debounce starts over
+const debounceIdleFactor = 2
+
+// defaultRateLimitPer is the refill period of a rate limit without one
+const defaultRateLimitPer = time.Second
+
+// prepareSuppression parses and checks the rule's debounce, throttle and
+// rate limit
+func (r *Rule) prepareSuppression() error {
+    var err error
+    if r.debounce, err = parseOptionalDuration("debounce", r.Debounce); err != nil {
+        return err
+    }
+    if r.throttle, err = parseOptionalDuration("throttle", r.Throttle); err != nil {
+        return err
+    }
+
+    if limit := r.RateLimit; limit != nil {
+        if limit.Rate <= 0 {
+            return fmt.Errorf("rateLimit rate must be greater than 0")
+        }
+        if limit.Burst < 0 {
+            return fmt.Errorf("rateLimit burst cannot be negative")
+        }
+        if limit.per, err = parseOptionalDuration("rateLimit per", limit.Per); err != nil {
+            return err
+        }
+        if limit.per == 0 {
+            limit.per = defaultRateLimitPer
+        }
+    }
+    return nil
+}
+
+// suppresses reports whether the rule has any suppression option
+func (r *Rule) suppresses() bool {
+    return r.debounce > 0 || r.throttle > 0 || r.RateLimit != nil
+}
+
+// burst returns the bucket size of a rate limit
+func (l *RateLimit) burst() float64 {
+    if l.Burst > 0 {
+        return float64(l.Burst)
+    }
+    return math.Ceil(l.Rate)
+}
+
+// stateKey renders the key a rule keeps state under for a message, scoped
+// to the rule so rules sharing a key template do not share state
+func (p *Processor) stateKey(rule *Rule, msg *ProcessingMessage) string {
+    key := msg.Topic
+    if rule.Key != "" {
+        key, _ = p.processTemplate(rule.Key, msg.Values)
+    }
+
+    return ruleScope(rule) + "\x00" + key
+}
+
+// ruleScope returns the rule ID, or the topic for rules without one
+func ruleScope(rule *Rule) string {
+    if rule.ID != "" {
+        return rule.ID
+    }
+    return rule.Topic
+}
+
+// suppress applies the rule's debounce, throttle and rate limit for a key,
+// in that order, and returns the reason the action is suppressed or "" when
+// it may fire. Debounce state is reset when the conditions do not match.
+func (p *Processor) suppress(rule *Rule, key string, matched bool, now time.Time) string {
+    if rule.debounce > 0 && !p.debounced(rule, key, matched, now) {
+        return SuppressDebounce
+    }
+    if !matched {
+        return ""
+    }
+    if rule.throttle > 0 && !p.throttled(rule, key, now) {
+        return SuppressThrottle
+    }
+    if rule.RateLimit != nil && !p.rateLimited(rule.RateLimit, key, now) {
+        return SuppressRateLimit
+    }
+    return ""
+}
+
+// debounced tracks how long the conditions have held for a key and reports
+// whether the rule fires. It fires once per episode, on the first message
+// after the conditions have held for the debounce duration.
+func (p *Processor) debounced(rule *Rule, key string, matched bool, now time.Time) bool {
+    key = SuppressDebounce + "\x00" + key
+    if !matched {
+        p.state.Delete(key)
+        return false
+    }
+
+    fire := false
+    p.state.Update(key, now, func(value []byte, ok bool) ([]byte, time.Duration) {
+        since, fired := now, false
+        if ok {
+            since, fired = decodeTime(value), value[8] == 1
+        }
+        if !fired && now.Sub(since) >= rule.debounce {
+            fire, fired = true, true
+        }
+
+        value = appendTime(make([]byte, 0, 9), since)
+        if fired {
+            value = append(value, 1)
+        } else {
+            value = append(value, 0)
+        }
+        return value, debounceIdleFactor * rule.debounce
+    })
+    return fire
+}
+
+// throttled allows one action per key per throttle window
+func (p *Processor) throttled(rule *Rule, key string, now time.Time) bool {
+    key = SuppressThrottle + "\x00" + key
+
+    allow := false
+    p.state.Update(key, now, func(value []byte, ok bool) ([]byte, time.Duration) {
+        if ok {
+            if remaining := decodeTime(value).Add(rule.throttle).Sub(now); remaining > 0 {
+                return value, remaining
+            }
+        }
+        allow = true
+        return appendTime(make([]byte, 0, 8), now), rule.throttle
+    })
+    return allow
+}
+
+// rateLimited takes a token from the key's bucket, refilling it for the time
+// since the last action
+func (p *Processor) rateLimited(limit *RateLimit, key string, now time.Time) bool {
+    key = SuppressRateLimit + "\x00" + key
+    burst := limit.burst()
+    perToken := float64(limit.per) / limit.Rate
+
+    allow := false
+    p.state.Update(key, now, func(value []byte, ok bool) ([]byte, time.Duration) {
+        tokens := burst
+        if ok {
+            last := decodeTime(value)
+            tokens = math.Float64frombits(binary.BigEndian.Uint64(value[8:]))
+            tokens = math.Min(burst, tokens+float64(now.Sub(last))/perToken)
+        }
+        if tokens >= 1 {
+            tokens--
+            allow = true
+        }
+
+        // A full bucket needs no state
+        refill := time.Duration((burst - tokens) * perToken)
+        if refill <= 0 {
+            return nil, 0
+        }
+        value = appendTime(make([]byte, 0, 16), now)
+        value = binary.BigEndian.AppendUint64(value, math.Float64bits(tokens))
+        return value, refill
+    })
+    return allow
+}
+
+// appendTime appends a time as big-endian Unix nanoseconds
+func appendTime(b []byte, t time.Time) []byte {
+    return binary.BigEndian.AppendUint64(b, uint64(t.UnixNano()))
+}
+
+// decodeTime decodes a time written by appendTime
+func decodeTime(b []byte) time.Time {
+    return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
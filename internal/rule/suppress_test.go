@@ -0,0 +1,126 @@
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSuppressionSetup loads a single temperature rule modified by configure
+// and returns a function that processes a reading at an offset from a fixed
+// start time, reporting whether the rule fired
+func newSuppressionSetup(t *testing.T, configure func(*Rule)) (*testSetup, func(offset time.Duration, device string, temperature float64) bool) {
+	t.Helper()
+
+	rule := getTestRules()[0]
+	rule.ID = "temperature-alert"
+	rule.Key = "${deviceId}"
+	configure(&rule)
+
+	setup, process := newReadingSetup(t, rule)
+	return setup, func(offset time.Duration, device string, temperature float64) bool {
+		return len(process(offset, device, temperature)) > 0
+	}
+}
+
+func TestSuppress_Debounce(t *testing.T) {
+	setup, process := newSuppressionSetup(t, func(r *Rule) {
+		r.Debounce = "30s"
+	})
+
+	assert.False(t, process(0, "dev-1", 30), "conditions have not held long enough")
+	assert.False(t, process(20*time.Second, "dev-1", 31))
+	assert.True(t, process(30*time.Second, "dev-1", 32), "fires once the conditions held for 30s")
+	assert.False(t, process(40*time.Second, "dev-1", 32), "fires once per episode")
+
+	// A reading below the threshold ends the episode
+	assert.False(t, process(50*time.Second, "dev-1", 20))
+	assert.False(t, process(60*time.Second, "dev-1", 30))
+	assert.True(t, process(90*time.Second, "dev-1", 30))
+
+	// Keys are debounced independently
+	assert.False(t, process(90*time.Second, "dev-2", 30))
+
+	// Debounce state expires after twice the duration without a message
+	assert.False(t, process(200*time.Second, "dev-2", 30))
+
+	stats := setup.processor.Report().Rules["temperature-alert"]
+	assert.Equal(t, uint64(8), stats.Matches)
+	assert.Equal(t, uint64(6), stats.Suppressed)
+}
+
+func TestSuppress_Throttle(t *testing.T) {
+	_, process := newSuppressionSetup(t, func(r *Rule) {
+		r.Throttle = "1m"
+	})
+
+	assert.True(t, process(0, "dev-1", 30))
+	assert.False(t, process(10*time.Second, "dev-1", 30))
+	assert.True(t, process(0, "dev-2", 30), "keys are throttled independently")
+	assert.False(t, process(59*time.Second, "dev-1", 30))
+	assert.True(t, process(time.Minute, "dev-1", 30))
+}
+
+func TestSuppress_RateLimit(t *testing.T) {
+	_, process := newSuppressionSetup(t, func(r *Rule) {
+		r.RateLimit = &RateLimit{Rate: 2, Per: "1m", Burst: 3}
+	})
+
+	// The bucket starts full
+	for i := 0; i < 3; i++ {
+		assert.True(t, process(0, "dev-1", 30), "burst %d", i)
+	}
+	assert.False(t, process(0, "dev-1", 30))
+
+	// Two tokens per minute, one every 30s
+	assert.False(t, process(29*time.Second, "dev-1", 30))
+	assert.True(t, process(30*time.Second, "dev-1", 30))
+	assert.False(t, process(31*time.Second, "dev-1", 30))
+
+	assert.True(t, process(0, "dev-2", 30), "keys have their own bucket")
+}
+
+func TestSuppress_DefaultKeyIsTopic(t *testing.T) {
+	_, process := newSuppressionSetup(t, func(r *Rule) {
+		r.Key = ""
+		r.Throttle = "1m"
+	})
+
+	assert.True(t, process(0, "dev-1", 30))
+	assert.False(t, process(0, "dev-2", 30), "all devices share the topic key")
+}
+
+func TestRulePrepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr string
+	}{
+		{name: "no options", rule: Rule{}},
+		{name: "valid", rule: Rule{Debounce: "10s", Throttle: "1m", RateLimit: &RateLimit{Rate: 5}}},
+		{name: "invalid debounce", rule: Rule{Debounce: "soon"}, wantErr: "debounce"},
+		{name: "negative throttle", rule: Rule{Throttle: "-1s"}, wantErr: "throttle"},
+		{name: "zero rate", rule: Rule{RateLimit: &RateLimit{}}, wantErr: "rate must be greater than 0"},
+		{name: "negative burst", rule: Rule{RateLimit: &RateLimit{Rate: 1, Burst: -1}}, wantErr: "burst"},
+		{name: "invalid per", rule: Rule{RateLimit: &RateLimit{Rate: 1, Per: "0s"}}, wantErr: "rateLimit per"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.prepare()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	// Rate limits default to a one second period and a burst of the rate
+	limit := &RateLimit{Rate: 2.5}
+	require.NoError(t, (&Rule{RateLimit: limit}).prepare())
+	assert.Equal(t, time.Second, limit.per)
+	assert.Equal(t, 3.0, limit.burst())
+}
@@ -0,0 +1,145 @@
+//file: internal/rule/timers.go
+
+package rule
+
+import (
+    "container/heap"
+    "strings"
+    "sync"
+    "time"
+)
+
+// timerResolution is how often due timers are fired
+const timerResolution = time.Second
+
+// timed reports whether the rule has per-key timers
+func (r *Rule) timed() bool {
+    return r.Missing != nil || (r.Window != nil && r.Window.Type == WindowTumbling)
+}
+
+// timer is a deadline for a rule and state key, such as the end of a
+// tumbling window or the timeout of a missing rule
+type timer struct {
+    at    time.Time
+    kind  string
+    scope string // Rule ID, or topic for rules without one
+    key   string // State key, see stateKey
+    index int
+}
+
+// timerHeap orders timers by deadline
+type timerHeap []*timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h timerHeap) Swap(i, j int) {
+    h[i], h[j] = h[j], h[i]
+    h[i].index = i
+    h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+    t := x.(*timer)
+    t.index = len(*h)
+    *h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+    old := *h
+    n := len(old)
+    t := old[n-1]
+    old[n-1] = nil
+    *h = old[:n-1]
+    return t
+}
+
+// timerQueue holds at most one timer per kind and state key. Scheduling,
+// moving and cancelling a timer are O(log n), so a timer can be moved on
+// every message.
+type timerQueue struct {
+    heap  timerHeap
+    byKey map[string]*timer
+    mu    sync.Mutex
+}
+
+func newTimerQueue() *timerQueue {
+    return &timerQueue{
+        byKey: make(map[string]*timer),
+    }
+}
+
+// Schedule sets the deadline of the timer for a kind and key, adding it if
+// it does not exist
+func (q *timerQueue) Schedule(kind, scope, key string, at time.Time) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    id := kind + "\x00" + key
+    if t, ok := q.byKey[id]; ok {
+        t.at = at
+        heap.Fix(&q.heap, t.index)
+        return
+    }
+
+    t := &timer{at: at, kind: kind, scope: scope, key: key}
+    heap.Push(&q.heap, t)
+    q.byKey[id] = t
+}
+
+// Cancel removes the timer for a kind and key
+func (q *timerQueue) Cancel(kind, key string) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    id := kind + "\x00" + key
+    if t, ok := q.byKey[id]; ok {
+        heap.Remove(&q.heap, t.index)
+        delete(q.byKey, id)
+    }
+}
+
+// Due removes and returns the timers due at now, earliest first
+func (q *timerQueue) Due(now time.Time) []*timer {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    var due []*timer
+    for len(q.heap) > 0 && !now.Before(q.heap[0].at) {
+        t := heap.Pop(&q.heap).(*timer)
+        delete(q.byKey, t.kind+"\x00"+t.key)
+        due = append(due, t)
+    }
+    return due
+}
+
+// Len returns the number of scheduled timers
+func (q *timerQueue) Len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    return len(q.heap)
+}
+
+// restoreTimers schedules the timers of the timed rules' state, which is
+// kept by the state store while timers are not: the end of open tumbling
+// windows and the timeout of keys that have not yet gone missing.
+// Scheduling an existing timer moves it to the same deadline.
+func (p *Processor) restoreTimers(timedRules map[string]*Rule, now time.Time) {
+    p.state.Range(windowField+"\x00", now, func(stateKey string, state []byte) bool {
+        key := strings.TrimPrefix(stateKey, windowField+"\x00")
+        rule := timedRules[keyScope(key)]
+        if rule != nil && rule.Window != nil && rule.Window.Type == WindowTumbling && len(state) >= 8 {
+            p.timers.Schedule(timerWindow, ruleScope(rule), key, decodeTime(state).Add(rule.Window.size))
+        }
+        return true
+    })
+
+    p.state.Range(missingField+"\x00", now, func(stateKey string, state []byte) bool {
+        key := strings.TrimPrefix(stateKey, missingField+"\x00")
+        rule := timedRules[keyScope(key)]
+        if rule != nil && rule.Missing != nil && len(state) == 9 && state[8]&missingFired == 0 {
+            p.timers.Schedule(timerMissing, ruleScope(rule), key, decodeTime(state).Add(rule.Missing.timeout))
+        }
+        return true
+    })
+}
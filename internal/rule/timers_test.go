@@ -0,0 +1,88 @@
+package rule
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// timedSetup processes messages for a single timed rule at offsets from a
+// fixed start time and collects the actions fired by timers
+type timedSetup struct {
+	*testSetup
+	start time.Time
+
+	mu      sync.Mutex
+	emitted []*Action
+}
+
+func newTimedSetup(t *testing.T, rule Rule) *timedSetup {
+	t.Helper()
+
+	setup := &timedSetup{
+		testSetup: newTestSetup(t),
+		// Aligned so tumbling windows start at offset 0, and far enough
+		// ahead that the processor's own timer loop leaves timers to the test
+		start: time.Now().UTC().Truncate(24 * time.Hour).Add(48 * time.Hour),
+	}
+	t.Cleanup(setup.cleanup)
+
+	setup.processor.SetActionHandler(func(action *Action) {
+		setup.mu.Lock()
+		defer setup.mu.Unlock()
+		setup.emitted = append(setup.emitted, action)
+	})
+	setup.processor.now = func() time.Time { return setup.start }
+	require.NoError(t, setup.processor.LoadRules([]Rule{rule}))
+	return setup
+}
+
+func (s *timedSetup) process(t *testing.T, offset time.Duration, payload string) []*Action {
+	s.processor.now = func() time.Time { return s.start.Add(offset) }
+	actions, err := s.processor.Process("sensors/temperature", []byte(payload))
+	require.NoError(t, err)
+	return actions
+}
+
+func (s *timedSetup) fireTimers(offset time.Duration) []*Action {
+	s.processor.fireTimers(s.start.Add(offset))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	emitted := s.emitted
+	s.emitted = nil
+	return emitted
+}
+
+// timestamp formats the time at an offset from the start like window and
+// missing template values
+func (s *timedSetup) timestamp(offset time.Duration) string {
+	return s.start.Add(offset).Format(time.RFC3339)
+}
+
+func TestTimerQueue(t *testing.T) {
+	queue := newTimerQueue()
+	now := time.Now()
+
+	queue.Schedule(timerWindow, "rule", "b", now.Add(2*time.Second))
+	queue.Schedule(timerWindow, "rule", "a", now.Add(time.Second))
+	queue.Schedule(timerWindow, "rule", "c", now.Add(3*time.Second))
+	assert.Equal(t, 3, queue.Len())
+
+	// Scheduling an existing timer moves it
+	queue.Schedule(timerWindow, "rule", "a", now.Add(4*time.Second))
+	assert.Equal(t, 3, queue.Len())
+
+	queue.Cancel(timerWindow, "c")
+	assert.Empty(t, queue.Due(now.Add(time.Second)))
+
+	due := queue.Due(now.Add(5 * time.Second))
+	if assert.Len(t, due, 2) {
+		assert.Equal(t, "b", due[0].key)
+		assert.Equal(t, "a", due[1].key)
+	}
+	assert.Equal(t, 0, queue.Len())
+}
@@ -1,10 +1,79 @@
 //file: internal/rule/types.go
 package rule
 
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
 type Rule struct {
-	Topic      string       `json:"topic" yaml:"topic"`
-	Conditions *Conditions  `json:"conditions" yaml:"conditions"`
-	Action     *Action      `json:"action" yaml:"action"`
+	ID          string        `json:"id,omitempty" yaml:"id,omitempty"` // Unique rule ID, defaults to <file>#<index>
+	Topic       string        `json:"topic" yaml:"topic"`
+	Broker      string        `json:"broker,omitempty" yaml:"broker,omitempty"` // Source connection name, empty for the default
+	Conditions  *Conditions   `json:"conditions" yaml:"conditions"`
+	Action      *Action       `json:"action" yaml:"action"`
+	Key         string        `json:"key,omitempty" yaml:"key,omitempty"`                 // State key template, defaults to the message topic
+	Debounce    string        `json:"debounce,omitempty" yaml:"debounce,omitempty"`       // Duration the conditions must hold before the rule fires
+	Throttle    string        `json:"throttle,omitempty" yaml:"throttle,omitempty"`       // Minimum duration between actions per key
+	RateLimit   *RateLimit    `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`     // Token bucket per key
+	PreviousTTL string        `json:"previousTTL,omitempty" yaml:"previousTTL,omitempty"` // How long the previous message per key is kept, defaults to 1h
+	Window      *Window       `json:"window,omitempty" yaml:"window,omitempty"`           // Aggregates messages per key over time
+	Missing     *Missing      `json:"missing,omitempty" yaml:"missing,omitempty"`         // Fires when a key stops receiving messages
+	State       []StateSource `json:"state,omitempty" yaml:"state,omitempty"`             // Auxiliary topics whose latest message the rule can use
+
+	// Parsed options, set when the rule is loaded into a processor
+	debounce    time.Duration
+	throttle    time.Duration
+	previousTTL time.Duration
+	usePrevious bool
+}
+
+// RateLimit is a token bucket per key holding up to Burst actions, refilled
+// at Rate actions per Per
+type RateLimit struct {
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Per   string  `json:"per,omitempty" yaml:"per,omitempty"`     // Duration string, defaults to 1s
+	Burst int     `json:"burst,omitempty" yaml:"burst,omitempty"` // Defaults to the rate rounded up
+
+	per time.Duration
+}
+
+// Window aggregates a message field per key over a tumbling or sliding
+// window. The aggregates are available to conditions and templates as
+// window.count, window.avg and so on.
+type Window struct {
+	Type   string      `json:"type" yaml:"type"`                         // tumbling or sliding
+	Size   string      `json:"size" yaml:"size"`                         // Duration string
+	Step   string      `json:"step,omitempty" yaml:"step,omitempty"`     // Sliding window resolution, defaults to size/60
+	Field  string      `json:"field,omitempty" yaml:"field,omitempty"`   // Numeric field to aggregate, empty to only count messages
+	Filter *Conditions `json:"filter,omitempty" yaml:"filter,omitempty"` // Messages that enter the window, defaults to all
+
+	size time.Duration
+	step time.Duration
+}
+
+// Missing makes a rule fire its action when no message arrives for a key
+// within Timeout, and its Recovery action when messages resume. The rule's
+// conditions select the messages that count.
+type Missing struct {
+	Timeout  string  `json:"timeout" yaml:"timeout"`                       // Duration string
+	Forget   string  `json:"forget,omitempty" yaml:"forget,omitempty"`     // How long a missing key is remembered, defaults to 24h
+	Recovery *Action `json:"recovery,omitempty" yaml:"recovery,omitempty"` // Fired by the first message after the key went missing
+
+	timeout time.Duration
+	forget  time.Duration
+}
+
+// StateSource is an auxiliary topic whose latest message is available to a
+// rule's conditions and templates as state.<topic>.<field>. With a Key, the
+// latest message is kept per key and the rule's key selects which one.
+type StateSource struct {
+	Topic string `json:"topic" yaml:"topic"`
+	Key   string `json:"key,omitempty" yaml:"key,omitempty"` // Key template rendered from the auxiliary message
+	TTL   string `json:"ttl,omitempty" yaml:"ttl,omitempty"` // How long the latest message is kept, defaults to 24h
+
+	ttl time.Duration
 }
 
 // Conditions represents a group of conditions with a logical operator
@@ -18,9 +87,92 @@ type Condition struct {
 	Field    string      `json:"field" yaml:"field"`
 	Operator string      `json:"operator" yaml:"operator"`
 	Value    interface{} `json:"value" yaml:"value"`
+
+	lookup *lookupExpr // Parsed lookup expression of Field, set by prepare
 }
 
 type Action struct {
-	Topic   string `json:"topic" yaml:"topic"`
-	Payload string `json:"payload" yaml:"payload"`
+	Topic       string            `json:"topic" yaml:"topic"`
+	Payload     string            `json:"payload" yaml:"payload"`
+	Broker      string            `json:"broker,omitempty" yaml:"broker,omitempty"` // Target connection name, empty for the rule's source
+	Retry       *RetryPolicy      `json:"retry,omitempty" yaml:"retry,omitempty"`   // Overrides the global publish retry policy
+	HTTP        *HTTPAction       `json:"http,omitempty" yaml:"http,omitempty"`     // Sends the payload as an HTTP request instead of publishing it
+	Kafka       *KafkaAction      `json:"kafka,omitempty" yaml:"kafka,omitempty"`   // Record options when publishing on a Kafka connection
+	File        *FileAction       `json:"file,omitempty" yaml:"file,omitempty"`     // Appends the payload to a local file instead of publishing it
+	Log         *LogAction        `json:"log,omitempty" yaml:"log,omitempty"`       // Writes the payload to the router log instead of publishing it
+	RuleID      string            `json:"-" yaml:"-"`                               // ID of the rule that rendered the action
+	ReceivedAt  time.Time         `json:"-" yaml:"-"`                               // When the triggering message was received
+	SpanContext trace.SpanContext `json:"-" yaml:"-"`                               // Span that rendered the action, parent of its publish span
+
+	SourceTopic      string `json:"-" yaml:"-"` // Topic of the triggering message, or of the rule for timer-fired actions
+	SourceConnection string `json:"-" yaml:"-"` // Connection the rule consumes from
+}
+
+// Action kinds, by how an action is delivered
+const (
+	ActionPublish = "publish"
+	ActionHTTP    = "http"
+	ActionFile    = "file"
+	ActionLog     = "log"
+)
+
+// Kind returns how the action is delivered
+func (a *Action) Kind() string {
+	switch {
+	case a.HTTP != nil:
+		return ActionHTTP
+	case a.File != nil:
+		return ActionFile
+	case a.Log != nil:
+		return ActionLog
+	}
+	return ActionPublish
+}
+
+// HTTPAction sends an action's payload as the body of an HTTP request. The
+// URL and header values are templates.
+type HTTPAction struct {
+	Method       string            `json:"method,omitempty" yaml:"method,omitempty"`             // Default POST
+	URL          string            `json:"url" yaml:"url"`                                       // Template
+	Headers      map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`           // Values are templates
+	Timeout      string            `json:"timeout,omitempty" yaml:"timeout,omitempty"`           // Duration string, default 10s
+	ExpectStatus []int             `json:"expectStatus,omitempty" yaml:"expectStatus,omitempty"` // Successful status codes, default any 2xx
+	TLS          *HTTPTLS          `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// HTTPTLS configures TLS for https URLs
+type HTTPTLS struct {
+	CAFile             string `json:"caFile,omitempty" yaml:"caFile,omitempty"`     // Trusted CAs, default the system roots
+	CertFile           string `json:"certFile,omitempty" yaml:"certFile,omitempty"` // Client certificate
+	KeyFile            string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty" yaml:"serverName,omitempty"`                 // Overrides the name the certificate is checked against
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"` // Skips certificate verification, for testing only
+}
+
+// KafkaAction sets the key and headers of the record an action produces on a
+// Kafka connection. The key and header values are templates.
+type KafkaAction struct {
+	Key     string            `json:"key,omitempty" yaml:"key,omitempty"`         // Partition key, empty for no key
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // Record headers
+}
+
+// FileAction appends an action's payload, with its topic and a timestamp, as
+// a JSON line to a local file
+type FileAction struct {
+	Path string `json:"path" yaml:"path"` // Relative paths are resolved against the file actions directory
+}
+
+// LogAction writes an action's payload to the router log
+type LogAction struct {
+	Level   string `json:"level,omitempty" yaml:"level,omitempty"`     // "debug", "info" or "error", default info
+	Message string `json:"message,omitempty" yaml:"message,omitempty"` // Log message, default "rule action"
+}
+
+// RetryPolicy controls how a failed publish is retried. Unset fields fall
+// back to the global retry settings.
+type RetryPolicy struct {
+	MaxAttempts    int     `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`       // Total attempts including the first
+	InitialBackoff string  `json:"initialBackoff,omitempty" yaml:"initialBackoff,omitempty"` // Duration string
+	MaxBackoff     string  `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"`         // Duration string
+	Jitter         float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`                 // Fraction of the backoff, 0 to 1
 }
@@ -0,0 +1,300 @@
+//file: internal/rule/window.go
+
+package rule
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+
+    "mqtt-mux-router/internal/tracing"
+)
+
+// Window types
+const (
+    WindowTumbling = "tumbling"
+    WindowSliding  = "sliding"
+)
+
+// windowField is the name under which a window's aggregates are available to
+// conditions and templates, e.g. window.avg
+const windowField = "window"
+
+// timerWindow is the timer kind closing tumbling windows
+const timerWindow = "window"
+
+// A sliding window is split into defaultWindowBuckets buckets unless a step
+// is set, and into at most maxWindowBuckets, which bounds its state per key
+const (
+    defaultWindowBuckets = 60
+    maxWindowBuckets     = 1000
+)
+
+// prepare parses and checks the window options
+func (w *Window) prepare() error {
+    if w.Type != WindowTumbling && w.Type != WindowSliding {
+        return fmt.Errorf("window type must be %s or %s: %q", WindowTumbling, WindowSliding, w.Type)
+    }
+
+    var err error
+    if w.size, err = parseOptionalDuration("window size", w.Size); err != nil {
+        return err
+    }
+    if w.size == 0 {
+        return fmt.Errorf("window size is required")
+    }
+
+    if w.Type == WindowTumbling {
+        if w.Step != "" {
+            return fmt.Errorf("window step only applies to sliding windows")
+        }
+        return nil
+    }
+
+    if w.step, err = parseOptionalDuration("window step", w.Step); err != nil {
+        return err
+    }
+    if w.step == 0 {
+        w.step = w.size / defaultWindowBuckets
+        if w.step <= 0 {
+            w.step = w.size
+        }
+    }
+    if w.step > w.size {
+        return fmt.Errorf("window step cannot be longer than its size")
+    }
+    if w.size/w.step > maxWindowBuckets {
+        return fmt.Errorf("window size cannot be more than %d steps", maxWindowBuckets)
+    }
+    return nil
+}
+
+// aggregate is the count, sum, minimum and maximum of the values in a window
+// or one of its buckets
+type aggregate struct {
+    count float64
+    sum   float64
+    min   float64
+    max   float64
+}
+
+// aggregateSize is the encoded size of an aggregate
+const aggregateSize = 32
+
+func (a *aggregate) add(value float64) {
+    if a.count == 0 {
+        a.min, a.max = value, value
+    }
+    a.count++
+    a.sum += value
+    a.min = math.Min(a.min, value)
+    a.max = math.Max(a.max, value)
+}
+
+func (a *aggregate) merge(b aggregate) {
+    if b.count == 0 {
+        return
+    }
+    if a.count == 0 {
+        *a = b
+        return
+    }
+    a.count += b.count
+    a.sum += b.sum
+    a.min = math.Min(a.min, b.min)
+    a.max = math.Max(a.max, b.max)
+}
+
+// values returns the aggregate as condition and template values. Windows
+// without a field only count messages.
+func (a aggregate) values(w *Window, key string, start, end time.Time) map[string]interface{} {
+    values := map[string]interface{}{
+        "key":   key,
+        "count": a.count,
+        "start": start.UTC().Format(time.RFC3339),
+        "end":   end.UTC().Format(time.RFC3339),
+    }
+    if w.Field != "" && a.count > 0 {
+        values["sum"] = a.sum
+        values["avg"] = a.sum / a.count
+        values["min"] = a.min
+        values["max"] = a.max
+    }
+    return values
+}
+
+func appendAggregate(b []byte, a aggregate) []byte {
+    for _, v := range []float64{a.count, a.sum, a.min, a.max} {
+        b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+    }
+    return b
+}
+
+func decodeAggregate(b []byte) aggregate {
+    value := func(i int) float64 {
+        return math.Float64frombits(binary.BigEndian.Uint64(b[i*8:]))
+    }
+    return aggregate{count: value(0), sum: value(1), min: value(2), max: value(3)}
+}
+
+// windowSample returns the value a message adds to a window, and false when
+// the message is filtered out or its field is missing or not a number
+func (p *Processor) windowSample(w *Window, values map[string]interface{}) (float64, bool) {
+    if w.Filter != nil && !p.evaluateConditions(w.Filter, values) {
+        return 0, false
+    }
+    if w.Field == "" {
+        return 0, true
+    }
+
+    value, ok := p.lookupField(values, w.Field)
+    if !ok {
+        return 0, false
+    }
+    switch v := value.(type) {
+    case float64:
+        return v, true
+    case int:
+        return float64(v), true
+    case string:
+        f, err := strconv.ParseFloat(v, 64)
+        return f, err == nil
+    default:
+        return 0, false
+    }
+}
+
+// addToSliding adds a value to the key's sliding window and returns the
+// window's values. The window is kept as one aggregate per step, and steps
+// that have slid out of the window are dropped.
+func (p *Processor) addToSliding(rule *Rule, key string, value float64, now time.Time) map[string]interface{} {
+    w := rule.Window
+    const bucketSize = 8 + aggregateSize
+    current := now.Truncate(w.step)
+    oldest := current.Add(w.step - w.size)
+
+    var total aggregate
+    p.state.Update(windowField+"\x00"+key, now, func(buckets []byte, ok bool) ([]byte, time.Duration) {
+        updated := make([]byte, 0, len(buckets)+bucketSize)
+        added := false
+        for ; len(buckets) >= bucketSize; buckets = buckets[bucketSize:] {
+            start := decodeTime(buckets)
+            if start.Before(oldest) {
+                continue
+            }
+            bucket := decodeAggregate(buckets[8:bucketSize])
+            if start.Equal(current) {
+                bucket.add(value)
+                added = true
+            }
+            total.merge(bucket)
+            updated = appendAggregate(appendTime(updated, start), bucket)
+        }
+        if !added {
+            var bucket aggregate
+            bucket.add(value)
+            total.merge(bucket)
+            updated = appendAggregate(appendTime(updated, current), bucket)
+        }
+        return updated, w.size
+    })
+
+    return total.values(w, renderedKey(key), oldest, current.Add(w.step))
+}
+
+// addToTumbling adds a value to the key's current tumbling window. Windows
+// are aligned to multiples of their size; a value for a later window closes
+// the previous one if its timer has not yet done so.
+func (p *Processor) addToTumbling(rule *Rule, key string, value float64, now time.Time) {
+    w := rule.Window
+    start := now.Truncate(w.size)
+
+    var closed *aggregate
+    var closedStart time.Time
+    opened := false
+    p.state.Update(windowField+"\x00"+key, now, func(state []byte, ok bool) ([]byte, time.Duration) {
+        var window aggregate
+        if ok {
+            if previous := decodeTime(state); previous.Before(start) {
+                last := decodeAggregate(state[8:])
+                closed, closedStart = &last, previous
+            } else {
+                // The open window, which late values also count towards
+                start, window = previous, decodeAggregate(state[8:])
+            }
+        }
+        opened = window.count == 0
+        window.add(value)
+        return appendAggregate(appendTime(make([]byte, 0, 8+aggregateSize), start), window), start.Add(2 * w.size).Sub(now)
+    })
+
+    if closed != nil {
+        p.closeWindow(rule, key, closedStart, *closed)
+    }
+    if opened {
+        p.timers.Schedule(timerWindow, ruleScope(rule), key, start.Add(w.size))
+    }
+}
+
+// closeDueWindow closes the key's tumbling window when its timer fires,
+// unless a message has already closed it and opened the next one
+func (p *Processor) closeDueWindow(rule *Rule, key string, now time.Time) {
+    w := rule.Window
+
+    var closed *aggregate
+    var start time.Time
+    p.state.Update(windowField+"\x00"+key, now, func(state []byte, ok bool) ([]byte, time.Duration) {
+        if !ok {
+            return nil, 0
+        }
+        start = decodeTime(state)
+        if now.Before(start.Add(w.size)) {
+            return state, start.Add(2 * w.size).Sub(now)
+        }
+        window := decodeAggregate(state[8:])
+        closed = &window
+        return nil, 0
+    })
+
+    if closed != nil {
+        p.closeWindow(rule, key, start, *closed)
+    }
+}
+
+// closeWindow evaluates a closed tumbling window and hands its action to the
+// action handler when the rule fires
+func (p *Processor) closeWindow(rule *Rule, key string, start time.Time, window aggregate) {
+    ctx, span := tracing.Start(context.Background(), tracing.SpanEvaluate, tracing.AttrRuleID.String(rule.ID))
+    defer span.End()
+
+    values := map[string]interface{}{
+        windowField: window.values(rule.Window, renderedKey(key), start, start.Add(rule.Window.size)),
+    }
+    if len(rule.State) > 0 {
+        values[stateField] = p.joinState(rule, key, p.now())
+    }
+    action, err := p.fireRule(ctx, span, rule, key, values)
+    if err != nil {
+        p.reportTimerFailure(rule, err)
+        return
+    }
+    if action != nil {
+        p.emit(action)
+    }
+}
+
+// renderedKey returns the rendered key template of a state key
+func renderedKey(key string) string {
+    _, rendered, _ := strings.Cut(key, "\x00")
+    return rendered
+}
+
+// keyScope returns the rule scope of a state key
+func keyScope(key string) string {
+    scope, _, _ := strings.Cut(key, "\x00")
+    return scope
+}
@@ -0,0 +1,190 @@
+package rule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reading(device string, temperature float64) string {
+	return fmt.Sprintf(`{"deviceId": %q, "temperature": %v}`, device, temperature)
+}
+
+func TestWindow_Sliding(t *testing.T) {
+	setup := newTimedSetup(t, Rule{
+		ID:    "average-temperature",
+		Topic: "sensors/temperature",
+		Key:   "${deviceId}",
+		Window: &Window{
+			Type:  WindowSliding,
+			Size:  "5m",
+			Field: "temperature",
+		},
+		Conditions: singleCondition("window.avg", "gt", 28),
+		Action: &Action{
+			Topic:   "alerts/${window.key}",
+			Payload: `{"avg":${window.avg},"min":${window.min},"max":${window.max},"count":${window.count}}`,
+		},
+	})
+
+	assert.Empty(t, setup.process(t, 0, reading("dev-1", 26)))
+	assert.Empty(t, setup.process(t, time.Minute, reading("dev-1", 28)))
+
+	actions := setup.process(t, 2*time.Minute, reading("dev-1", 33))
+	require.Len(t, actions, 1)
+	assert.Equal(t, "alerts/dev-1", actions[0].Topic)
+	assert.JSONEq(t, `{"avg":29,"min":26,"max":33,"count":3}`, actions[0].Payload)
+
+	// Devices have separate windows
+	assert.Empty(t, setup.process(t, 2*time.Minute, reading("dev-2", 20)))
+
+	// The first two readings slide out of the window
+	actions = setup.process(t, 6*time.Minute+30*time.Second, reading("dev-1", 20))
+	assert.Empty(t, actions, "average of 33 and 20")
+	actions = setup.process(t, 7*time.Minute+30*time.Second, reading("dev-1", 50))
+	require.Len(t, actions, 1)
+	assert.JSONEq(t, `{"avg":35,"min":20,"max":50,"count":2}`, actions[0].Payload)
+}
+
+func TestWindow_SlidingStateIsBounded(t *testing.T) {
+	setup := newTimedSetup(t, Rule{
+		ID:         "average-temperature",
+		Topic:      "sensors/temperature",
+		Window:     &Window{Type: WindowSliding, Size: "1m", Step: "10s", Field: "temperature"},
+		Conditions: singleCondition("window.count", "gt", 1000),
+		Action:     &Action{Topic: "alerts/temperature", Payload: "{}"},
+	})
+
+	for i := 0; i < 600; i++ {
+		setup.process(t, time.Duration(i)*time.Second, reading("dev-1", 20))
+	}
+
+	// One bucket per step in the window
+	state, ok := setup.processor.state.Get(windowField+"\x00average-temperature\x00sensors/temperature", setup.start.Add(599*time.Second))
+	require.True(t, ok)
+	assert.Equal(t, 6*(8+aggregateSize), len(state))
+
+	// The window expires a window size after the last message
+	setup.processor.state.Set("sweep", []byte{1}, time.Hour, setup.start.Add(11*time.Minute))
+	assert.Equal(t, 1, setup.processor.state.Len())
+}
+
+func TestWindow_Tumbling(t *testing.T) {
+	setup := newTimedSetup(t, Rule{
+		ID:    "door-open-burst",
+		Topic: "sensors/temperature",
+		Key:   "${deviceId}",
+		Window: &Window{
+			Type: WindowTumbling,
+			Size: "1m",
+			Filter: &Conditions{
+				Operator: "and",
+				Items:    []Condition{{Field: "temperature", Operator: "gt", Value: 25.0}},
+			},
+		},
+		Conditions: singleCondition("window.count", "gt", 10),
+		Action: &Action{
+			Topic:   "alerts/burst",
+			Payload: `{"device":"${window.key}","count":${window.count},"start":"${window.start}","end":"${window.end}"}`,
+		},
+	})
+
+	for i := 0; i < 11; i++ {
+		assert.Empty(t, setup.process(t, time.Duration(i)*time.Second, reading("dev-1", 30)),
+			"tumbling windows fire when they close")
+	}
+	// Filtered out
+	setup.process(t, 20*time.Second, reading("dev-1", 20))
+	for i := 0; i < 10; i++ {
+		setup.process(t, time.Duration(i)*time.Second, reading("dev-2", 30))
+	}
+	assert.Equal(t, 2, setup.processor.timers.Len())
+
+	assert.Empty(t, setup.fireTimers(59*time.Second))
+
+	emitted := setup.fireTimers(time.Minute)
+	require.Len(t, emitted, 1, "dev-2 counted 10")
+	assert.Equal(t, "door-open-burst", emitted[0].RuleID)
+	assert.JSONEq(t, fmt.Sprintf(`{"device":"dev-1","count":11,"start":%q,"end":%q}`, setup.timestamp(0), setup.timestamp(time.Minute)),
+		emitted[0].Payload)
+	assert.Equal(t, 0, setup.processor.timers.Len())
+	assert.Equal(t, 0, setup.processor.state.Len(), "closed windows hold no state")
+
+	stats := setup.processor.Report().Rules["door-open-burst"]
+	assert.Equal(t, uint64(2), stats.Evaluations)
+	assert.Equal(t, uint64(1), stats.Matches)
+}
+
+func TestWindow_TumblingClosedByLaterMessage(t *testing.T) {
+	setup := newTimedSetup(t, Rule{
+		ID:         "hourly-max",
+		Topic:      "sensors/temperature",
+		Window:     &Window{Type: WindowTumbling, Size: "1h", Field: "temperature"},
+		Conditions: singleCondition("window.max", "gte", 30.0),
+		Action:     &Action{Topic: "reports/max", Payload: `{"max":${window.max},"avg":${window.avg}}`},
+	})
+
+	setup.process(t, 10*time.Minute, reading("dev-1", 30))
+	setup.process(t, 20*time.Minute, reading("dev-1", 20))
+
+	// A message in the next window closes the previous one before its timer
+	setup.process(t, time.Hour, reading("dev-1", 10))
+	emitted := setup.fireTimers(time.Hour - time.Second)
+	require.Len(t, emitted, 1)
+	assert.JSONEq(t, `{"max":30,"avg":25}`, emitted[0].Payload)
+
+	// The old window's timer finds the next window open
+	assert.Empty(t, setup.fireTimers(time.Hour))
+	assert.Equal(t, 1, setup.processor.timers.Len())
+}
+
+func TestWindow_RemovedRuleTimers(t *testing.T) {
+	rule := Rule{
+		ID:         "hourly-count",
+		Topic:      "sensors/temperature",
+		Window:     &Window{Type: WindowTumbling, Size: "1h"},
+		Conditions: singleCondition("window.count", "gt", 0),
+		Action:     &Action{Topic: "reports/count", Payload: `${window.count}`},
+	}
+	setup := newTimedSetup(t, rule)
+	setup.process(t, 0, reading("dev-1", 30))
+
+	rule.ID = "renamed"
+	require.NoError(t, setup.processor.LoadRules([]Rule{rule}))
+	assert.Empty(t, setup.fireTimers(time.Hour), "timers of removed rules do not fire")
+}
+
+func TestWindowPrepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  Window
+		wantErr string
+	}{
+		{name: "tumbling", window: Window{Type: WindowTumbling, Size: "1m"}},
+		{name: "sliding", window: Window{Type: WindowSliding, Size: "5m", Step: "10s"}},
+		{name: "unknown type", window: Window{Type: "hopping", Size: "1m"}, wantErr: "window type"},
+		{name: "missing size", window: Window{Type: WindowTumbling}, wantErr: "window size is required"},
+		{name: "tumbling step", window: Window{Type: WindowTumbling, Size: "1m", Step: "1s"}, wantErr: "only applies to sliding"},
+		{name: "step too long", window: Window{Type: WindowSliding, Size: "1m", Step: "2m"}, wantErr: "cannot be longer"},
+		{name: "too many steps", window: Window{Type: WindowSliding, Size: "1h", Step: "1s"}, wantErr: "more than 1000 steps"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.window.prepare()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	// Sliding windows default to 60 steps
+	window := &Window{Type: WindowSliding, Size: "5m"}
+	require.NoError(t, window.prepare())
+	assert.Equal(t, 5*time.Second, window.step)
+}
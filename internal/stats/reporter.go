@@ -0,0 +1,122 @@
+//file: internal/stats/reporter.go
+
+package stats
+
+import (
+    "encoding/json"
+    "net/http"
+    "sync"
+    "time"
+
+    "mqtt-mux-router/internal/broker"
+    "mqtt-mux-router/internal/rule"
+)
+
+// Source provides the statistics combined by a Reporter; implemented by
+// broker.Router
+type Source interface {
+    GetStats() broker.BrokerStats
+    ProcessorReports() map[string]rule.ProcessorReport
+}
+
+// Report is the JSON document served by the stats endpoint
+type Report struct {
+    Totals      map[string]interface{}      `json:"totals"`
+    Connections map[string]ConnectionReport `json:"connections"`
+}
+
+// ConnectionReport combines the broker and processor statistics of a connection
+type ConnectionReport struct {
+    broker.ConnectionStats
+    Processor *rule.ProcessorReport `json:"processor,omitempty"`
+}
+
+// Reporter samples a Source into a StatsCollector at a fixed interval, so
+// that windowed rates can be computed, and serves the combined statistics
+type Reporter struct {
+    collector *StatsCollector
+    source    Source
+    interval  time.Duration
+    stopChan  chan struct{}
+    wg        sync.WaitGroup
+}
+
+// NewReporter creates a reporter sampling source every interval
+func NewReporter(source Source, interval time.Duration) *Reporter {
+    return &Reporter{
+        collector: NewStatsCollector(),
+        source:    source,
+        interval:  interval,
+        stopChan:  make(chan struct{}),
+    }
+}
+
+// Start takes a first sample and begins periodic sampling
+func (r *Reporter) Start() {
+    r.Sample()
+    r.wg.Add(1)
+    go r.run()
+}
+
+// Stop ends periodic sampling
+func (r *Reporter) Stop() {
+    close(r.stopChan)
+    r.wg.Wait()
+}
+
+func (r *Reporter) run() {
+    defer r.wg.Done()
+
+    ticker := time.NewTicker(r.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-r.stopChan:
+            return
+        case <-ticker.C:
+            r.Sample()
+        }
+    }
+}
+
+// Sample records the current totals of the source in the collector
+func (r *Reporter) Sample() {
+    stats := r.source.GetStats()
+
+    var processed, matched uint64
+    for _, report := range r.source.ProcessorReports() {
+        processed += report.Stats.Processed
+        matched += report.Stats.Matched
+    }
+
+    r.collector.Update(stats.MessagesReceived, processed, matched, stats.MessagesPublished, stats.Errors)
+}
+
+// Report returns the sampled totals and rates with the current statistics
+// of every connection
+func (r *Reporter) Report() Report {
+    stats := r.source.GetStats()
+    processors := r.source.ProcessorReports()
+
+    report := Report{
+        Totals:      r.collector.GetStats(),
+        Connections: make(map[string]ConnectionReport, len(stats.Connections)),
+    }
+    for name, connStats := range stats.Connections {
+        conn := ConnectionReport{ConnectionStats: connStats}
+        if processor, exists := processors[name]; exists {
+            conn.Processor = &processor
+        }
+        report.Connections[name] = conn
+    }
+
+    return report
+}
+
+// ServeHTTP serves the report as JSON
+func (r *Reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(r.Report())
+}
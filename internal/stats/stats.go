@@ -4,10 +4,15 @@ package stats
 
 import (
     "encoding/json"
+    "fmt"
+    "sync"
     "sync/atomic"
     "time"
 )
 
+// DefaultRateWindows are the sliding windows over which rates are reported
+var DefaultRateWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
 // StatsCollector manages application-wide statistics
 type StatsCollector struct {
     StartTime         time.Time
@@ -17,6 +22,27 @@ type StatsCollector struct {
     ActionsExecuted   uint64
     Errors           uint64
     LastUpdate       time.Time
+
+    // samples holds the counters recorded by Update for windowed rates,
+    // oldest first, covering the longest window
+    windows []time.Duration
+    samples []sample
+    mu      sync.RWMutex
+}
+
+// sample is a snapshot of the rate counters
+type sample struct {
+    at        time.Time
+    received  uint64
+    processed uint64
+    executed  uint64
+}
+
+// Rates holds per-second rates over a window
+type Rates struct {
+    Received  float64 `json:"received"`
+    Processed float64 `json:"processed"`
+    Executed  float64 `json:"executed"`
 }
 
 // NewStatsCollector creates a new stats collector
@@ -24,22 +50,96 @@ func NewStatsCollector() *StatsCollector {
     return &StatsCollector{
         StartTime:  time.Now(),
         LastUpdate: time.Now(),
+        windows:    DefaultRateWindows,
     }
 }
 
 // Update updates the stats with new values
 func (s *StatsCollector) Update(received, processed, matched, executed, errors uint64) {
+    s.record(time.Now(), received, processed, matched, executed, errors)
+}
+
+// record stores the counters and a rate sample taken at the given time
+func (s *StatsCollector) record(at time.Time, received, processed, matched, executed, errors uint64) {
     atomic.StoreUint64(&s.MessagesReceived, received)
     atomic.StoreUint64(&s.MessagesProcessed, processed)
     atomic.StoreUint64(&s.RulesMatched, matched)
     atomic.StoreUint64(&s.ActionsExecuted, executed)
     atomic.StoreUint64(&s.Errors, errors)
-    s.LastUpdate = time.Now()
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.LastUpdate = at
+    s.samples = append(s.samples, sample{
+        at:        at,
+        received:  received,
+        processed: processed,
+        executed:  executed,
+    })
+
+    // Keep one sample at or beyond the longest window as its base
+    longest := s.windows[len(s.windows)-1]
+    drop := 0
+    for drop+1 < len(s.samples) && !s.samples[drop+1].at.After(at.Add(-longest)) {
+        drop++
+    }
+    s.samples = s.samples[drop:]
+}
+
+// Rates returns the per-second rates over the given window. While less
+// history than the window is available, the rates cover what is available.
+func (s *StatsCollector) Rates(window time.Duration) Rates {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if len(s.samples) < 2 {
+        return Rates{}
+    }
+
+    last := s.samples[len(s.samples)-1]
+    base := s.samples[0]
+    for _, smp := range s.samples {
+        if smp.at.After(last.at.Add(-window)) {
+            break
+        }
+        base = smp
+    }
+
+    elapsed := last.at.Sub(base.at).Seconds()
+    if elapsed <= 0 {
+        return Rates{}
+    }
+
+    return Rates{
+        Received:  counterRate(base.received, last.received, elapsed),
+        Processed: counterRate(base.processed, last.processed, elapsed),
+        Executed:  counterRate(base.executed, last.executed, elapsed),
+    }
+}
+
+// counterRate returns the per-second increase of a counter, treating a
+// decrease as a reset
+func counterRate(from, to uint64, seconds float64) float64 {
+    if to < from {
+        return float64(to) / seconds
+    }
+    return float64(to-from) / seconds
 }
 
 // GetStats returns current statistics
 func (s *StatsCollector) GetStats() map[string]interface{} {
     uptime := time.Since(s.StartTime)
+
+    rates := make(map[string]Rates, len(s.windows))
+    for _, window := range s.windows {
+        rates[windowName(window)] = s.Rates(window)
+    }
+
+    s.mu.RLock()
+    lastUpdate := s.LastUpdate
+    s.mu.RUnlock()
+
     return map[string]interface{}{
         "uptime":            uptime.String(),
         "messages_received": atomic.LoadUint64(&s.MessagesReceived),
@@ -47,7 +147,8 @@ func (s *StatsCollector) GetStats() map[string]interface{} {
         "rules_matched":     atomic.LoadUint64(&s.RulesMatched),
         "actions_executed":  atomic.LoadUint64(&s.ActionsExecuted),
         "errors":           atomic.LoadUint64(&s.Errors),
-        "last_update":      s.LastUpdate,
+        "last_update":      lastUpdate,
+        "rates":            rates,
     }
 }
 
@@ -62,5 +163,19 @@ func (s *StatsCollector) CalculateRate() float64 {
     if uptime <= 0 {
         return 0
     }
-    return float64(s.MessagesProcessed) / uptime
+    return float64(atomic.LoadUint64(&s.MessagesProcessed)) / uptime
+}
+
+// windowName formats a window as a short key such as 1m or 15m
+func windowName(window time.Duration) string {
+    switch {
+    case window%time.Hour == 0:
+        return fmt.Sprintf("%dh", window/time.Hour)
+    case window%time.Minute == 0:
+        return fmt.Sprintf("%dm", window/time.Minute)
+    case window%time.Second == 0:
+        return fmt.Sprintf("%ds", window/time.Second)
+    default:
+        return window.String()
+    }
 }
@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mqtt-mux-router/internal/broker"
+	"mqtt-mux-router/internal/rule"
+)
+
+func TestStatsCollector_Rates(t *testing.T) {
+	s := NewStatsCollector()
+	start := time.Now()
+
+	// 10 received and 5 processed per second for 20 minutes, sampled every 30s
+	for i := 0; i <= 40; i++ {
+		n := uint64(i * 30)
+		s.record(start.Add(time.Duration(i)*30*time.Second), n*10, n*5, 0, n, 0)
+	}
+
+	rates := s.Rates(time.Minute)
+	assert.InDelta(t, 10, rates.Received, 0.001)
+	assert.InDelta(t, 5, rates.Processed, 0.001)
+	assert.InDelta(t, 1, rates.Executed, 0.001)
+
+	t.Run("older samples are trimmed", func(t *testing.T) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		assert.Equal(t, 31, len(s.samples)) // 15 minutes plus the base sample
+	})
+
+	t.Run("windows reflect recent changes", func(t *testing.T) {
+		last := s.samples[len(s.samples)-1]
+		// Traffic stops for two minutes
+		s.record(last.at.Add(time.Minute), last.received, last.processed, 0, last.executed, 0)
+		s.record(last.at.Add(2*time.Minute), last.received, last.processed, 0, last.executed, 0)
+
+		assert.Zero(t, s.Rates(time.Minute).Received)
+		assert.InDelta(t, 6, s.Rates(5*time.Minute).Received, 0.001)
+	})
+
+	t.Run("counter reset", func(t *testing.T) {
+		last := s.samples[len(s.samples)-1]
+		s.record(last.at.Add(time.Minute), 60, 0, 0, 0, 0)
+		assert.InDelta(t, 1, s.Rates(time.Minute).Received, 0.001)
+	})
+}
+
+func TestStatsCollector_PartialWindow(t *testing.T) {
+	s := NewStatsCollector()
+	assert.Equal(t, Rates{}, s.Rates(time.Minute))
+
+	start := time.Now()
+	s.record(start, 0, 0, 0, 0, 0)
+	s.record(start.Add(10*time.Second), 100, 0, 0, 0, 0)
+
+	// Less than the window is available, so the rate covers 10 seconds
+	assert.InDelta(t, 10, s.Rates(5*time.Minute).Received, 0.001)
+}
+
+func TestStatsCollector_GetStats(t *testing.T) {
+	s := NewStatsCollector()
+	s.Update(10, 8, 4, 3, 1)
+
+	stats := s.GetStats()
+	assert.Equal(t, uint64(10), stats["messages_received"])
+	assert.Equal(t, uint64(1), stats["errors"])
+
+	rates, ok := stats["rates"].(map[string]Rates)
+	require.True(t, ok)
+	assert.Contains(t, rates, "1m")
+	assert.Contains(t, rates, "5m")
+	assert.Contains(t, rates, "15m")
+
+	_, err := s.GetStatsJSON()
+	assert.NoError(t, err)
+}
+
+func TestWindowName(t *testing.T) {
+	assert.Equal(t, "30s", windowName(30*time.Second))
+	assert.Equal(t, "15m", windowName(15*time.Minute))
+	assert.Equal(t, "1h", windowName(time.Hour))
+	assert.Equal(t, "1.5s", windowName(1500*time.Millisecond))
+}
+
+// staticSource returns fixed statistics
+type staticSource struct {
+	stats   broker.BrokerStats
+	reports map[string]rule.ProcessorReport
+}
+
+func (s *staticSource) GetStats() broker.BrokerStats { return s.stats }
+
+func (s *staticSource) ProcessorReports() map[string]rule.ProcessorReport { return s.reports }
+
+func TestReporter(t *testing.T) {
+	source := &staticSource{
+		stats: broker.BrokerStats{
+			MessagesReceived:  12,
+			MessagesPublished: 7,
+			Connections: map[string]broker.ConnectionStats{
+				"field-mqtt": {Type: "mqtt", Connected: true, MessagesReceived: 12},
+				"nats-core":  {Type: "nats", Connected: true, MessagesPublished: 7},
+			},
+		},
+		reports: map[string]rule.ProcessorReport{
+			"field-mqtt": {
+				Stats: rule.ProcessorStats{Processed: 12, Matched: 7},
+				Rules: map[string]rule.RuleStats{
+					"temperature.yaml#0": {Evaluations: 12, Matches: 7},
+				},
+				MessagePool: rule.PoolStats{Gets: 12, Misses: 3, HitRate: 0.75},
+			},
+		},
+	}
+
+	r := NewReporter(source, time.Hour)
+	r.Start()
+	defer r.Stop()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body struct {
+		Totals      map[string]interface{} `json:"totals"`
+		Connections map[string]struct {
+			Type      string                `json:"type"`
+			Connected bool                  `json:"connected"`
+			Processor *rule.ProcessorReport `json:"processor"`
+		} `json:"connections"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+	assert.EqualValues(t, 12, body.Totals["messages_received"])
+	assert.EqualValues(t, 12, body.Totals["messages_processed"])
+	assert.EqualValues(t, 7, body.Totals["rules_matched"])
+	assert.Contains(t, body.Totals, "rates")
+
+	require.Contains(t, body.Connections, "field-mqtt")
+	field := body.Connections["field-mqtt"]
+	assert.Equal(t, "mqtt", field.Type)
+	require.NotNil(t, field.Processor)
+	assert.Equal(t, uint64(7), field.Processor.Rules["temperature.yaml#0"].Matches)
+	assert.Equal(t, 0.75, field.Processor.MessagePool.HitRate)
+
+	// Connections without a processor report only broker stats
+	assert.Nil(t, body.Connections["nats-core"].Processor)
+}
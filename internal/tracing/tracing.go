@@ -0,0 +1,142 @@
+//file: internal/tracing/tracing.go
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName identifies the router's spans
+const InstrumentationName = "mqtt-mux-router"
+
+// Span names. They are kept free of topics and rule IDs, which are recorded
+// as attributes, so tracing backends can group spans by operation.
+const (
+	SpanReceive  = "receive"
+	SpanDecode   = "decode"
+	SpanEvaluate = "evaluate"
+	SpanRender   = "render"
+	SpanPublish  = "publish"
+)
+
+// Span attribute keys
+const (
+	AttrMessagingSystem = attribute.Key("messaging.system")
+	AttrDestination     = attribute.Key("messaging.destination.name")
+	AttrPayloadSize     = attribute.Key("messaging.message.body.size")
+	AttrConnection      = attribute.Key("router.connection")
+	AttrRuleID          = attribute.Key("router.rule.id")
+	AttrRuleMatched     = attribute.Key("router.rule.matched")
+	AttrRuleSuppressed  = attribute.Key("router.rule.suppressed")
+)
+
+// The global tracer delegates to whichever provider Setup installs, so spans
+// cost next to nothing until tracing is enabled
+var tracer = otel.Tracer(InstrumentationName)
+
+// propagator carries W3C trace context and baggage across brokers
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Options configures the OTLP exporter and sampling
+type Options struct {
+	Endpoint    string  // OTLP/HTTP collector host:port
+	Insecure    bool    // Use plain HTTP instead of HTTPS
+	ServiceName string  // service.name resource attribute
+	SampleRatio float64 // Fraction of new traces to sample, 0 to 1
+}
+
+// Setup installs a tracer provider exporting spans over OTLP/HTTP. Traces
+// continued from an incoming message follow the sender's sampling decision.
+// The returned function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", opts.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartReceive starts the span of a message received from a broker. The
+// span continues any trace context extracted into ctx.
+func StartReceive(ctx context.Context, system, connection, topic string, size int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, SpanReceive,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			AttrMessagingSystem.String(system),
+			AttrConnection.String(connection),
+			AttrDestination.String(topic),
+			AttrPayloadSize.Int(size),
+		))
+}
+
+// StartPublish starts the span of an action published to a broker, as a
+// child of the span that rendered the action
+func StartPublish(parent trace.SpanContext, system, connection, topic string, size int) (context.Context, trace.Span) {
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+	return tracer.Start(ctx, SpanPublish,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			AttrMessagingSystem.String(system),
+			AttrConnection.String(connection),
+			AttrDestination.String(topic),
+			AttrPayloadSize.Int(size),
+		))
+}
+
+// Extract returns ctx with the trace context found in carrier
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return propagator.Extract(ctx, carrier)
+}
+
+// Inject writes the trace context of ctx into carrier. Nothing is written
+// when ctx holds no valid span context.
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	propagator.Inject(ctx, carrier)
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The package tracer binds to the first provider installed, so all tests
+// share one recorder
+var recorder = tracetest.NewSpanRecorder()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+}
+
+func endedSpan(t *testing.T, spanContext trace.SpanContext) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().SpanID() == spanContext.SpanID() {
+			return span
+		}
+	}
+	t.Fatalf("span %s not ended", spanContext.SpanID())
+	return nil
+}
+
+func TestPropagation(t *testing.T) {
+	// An upstream device publishes with trace context in its headers
+	incoming := propagation.MapCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+
+	ctx := Extract(context.Background(), incoming)
+	ctx, receive := StartReceive(ctx, "nats", "cloud", "sensors/temperature", 20)
+	receive.End()
+
+	span := endedSpan(t, receive.SpanContext())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Equal(t, trace.SpanKindConsumer, span.SpanKind())
+
+	// The publish span continues the trace and is injected into the action
+	pubCtx, publish := StartPublish(trace.SpanContextFromContext(ctx), "nats", "cloud", "alerts/temperature", 10)
+	outgoing := propagation.MapCarrier{}
+	Inject(pubCtx, outgoing)
+	End(publish, nil)
+
+	require.Contains(t, outgoing, "traceparent")
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+publish.SpanContext().SpanID().String()+"-01",
+		outgoing["traceparent"])
+	assert.Equal(t, receive.SpanContext().SpanID(), endedSpan(t, publish.SpanContext()).Parent().SpanID())
+}
+
+func TestInject_NoSpan(t *testing.T) {
+	carrier := propagation.MapCarrier{}
+	Inject(context.Background(), carrier)
+	assert.Empty(t, carrier)
+}
+
+func TestEnd_RecordsError(t *testing.T) {
+	_, span := Start(context.Background(), SpanRender)
+	End(span, errors.New("template failed"))
+
+	ended := endedSpan(t, span.SpanContext())
+	assert.Equal(t, codes.Error, ended.Status().Code)
+	assert.Equal(t, "template failed", ended.Status().Description)
+	require.Len(t, ended.Events(), 1)
+	assert.Equal(t, "exception", ended.Events()[0].Name)
+}